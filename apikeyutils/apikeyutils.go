@@ -0,0 +1,167 @@
+package apikeyutils
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash/crc32"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/qiuliaogit/commonutils/commonutils"
+)
+
+const (
+	apiKeyAlphabet       = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	API_KEY_ID_LEN       = 12 // 可公开的查找ID长度
+	API_KEY_SECRET_LEN   = 32 // 密钥部分长度，约190位随机数
+	API_KEY_CHECKSUM_LEN = 6  // 校验码长度
+	API_KEY_SCOPE_ALL    = "*"
+)
+
+var (
+	ErrAPIKeyFormat   = commonutils.NewError(commonutils.ERR_FAIL, "API key 格式错误")
+	ErrAPIKeyChecksum = commonutils.NewError(commonutils.ERR_FAIL, "API key 校验码错误")
+	ErrAPIKeyInvalid  = commonutils.NewError(commonutils.ERR_FAIL, "API key 无效")
+	ErrAPIKeyExpired  = commonutils.NewError(commonutils.ERR_FAIL, "API key 已过期")
+	ErrAPIKeyRevoked  = commonutils.NewError(commonutils.ERR_FAIL, "API key 已吊销")
+)
+
+/*
+API key 的信息，只保存摘要，不保存明文
+
+完整的 key 格式为 "前缀_查找ID_密钥校验码"，如 uk_live_0a1B2c3D4e5F_xxxx...xxxxAbC123
+*/
+type APIKeyInfo struct {
+	ID        string   `json:"id"`         // 查找ID，可以公开，用于日志和界面展示
+	Prefix    string   `json:"prefix"`     // 前缀，如 uk_live
+	Digest    string   `json:"digest"`     // 完整 key 的摘要
+	Name      string   `json:"name"`       // 备注名称
+	Scopes    []string `json:"scopes"`     // 权限范围，"*" 表示全部
+	CreatedAt int64    `json:"created_at"` // 创建时间 unix 秒
+	ExpiresAt int64    `json:"expires_at"` // 过期时间 unix 秒，0 表示不过期
+	Revoked   bool     `json:"revoked"`    // 是否已吊销
+}
+
+// 是否已过期
+func (i *APIKeyInfo) IsExpired() bool {
+	return i.ExpiresAt > 0 && time.Now().Unix() >= i.ExpiresAt
+}
+
+// 是否拥有某个权限
+func (i *APIKeyInfo) HasScope(paramScope string) bool {
+	return commonutils.IsInArray(API_KEY_SCOPE_ALL, i.Scopes) || commonutils.IsInArray(paramScope, i.Scopes)
+}
+
+// 可以展示给用户的掩码形式，如 uk_live_0a1B2c3D4e5F_****
+func (i *APIKeyInfo) Masked() string {
+	return i.Prefix + "_" + i.ID + "_****"
+}
+
+// 生成指定长度的随机字符串
+func randomString(paramLength int) (string, error) {
+	var sb strings.Builder
+	max := big.NewInt(int64(len(apiKeyAlphabet)))
+	for i := 0; i < paramLength; i++ {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		sb.WriteByte(apiKeyAlphabet[n.Int64()])
+	}
+	return sb.String(), nil
+}
+
+// 计算校验码：crc32 的 base62 表示，固定长度
+func checksum(paramBody string) string {
+	n := crc32.ChecksumIEEE([]byte(paramBody))
+	buf := make([]byte, API_KEY_CHECKSUM_LEN)
+	for i := len(buf) - 1; i >= 0; i-- {
+		buf[i] = apiKeyAlphabet[n%62]
+		n /= 62
+	}
+	return string(buf)
+}
+
+func isValidPrefix(paramPrefix string) bool {
+	if paramPrefix == "" || strings.HasPrefix(paramPrefix, "_") || strings.HasSuffix(paramPrefix, "_") {
+		return false
+	}
+	for _, c := range paramPrefix {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+生成一个新的 API key，返回完整的 key 和查找ID
+
+  - paramPrefix 前缀，只能包含小写字母、数字和"_"，如 uk_live、uk_test
+*/
+func GenerateAPIKey(paramPrefix string) (string, string, error) {
+	if !isValidPrefix(paramPrefix) {
+		return "", "", commonutils.NewError(commonutils.ERR_FAIL, "API key 前缀格式错误："+paramPrefix)
+	}
+	id, err := randomString(API_KEY_ID_LEN)
+	if err != nil {
+		return "", "", err
+	}
+	secret, err := randomString(API_KEY_SECRET_LEN)
+	if err != nil {
+		return "", "", err
+	}
+	body := paramPrefix + "_" + id + "_" + secret
+	return body + checksum(body), id, nil
+}
+
+/*
+解析 API key，校验格式和校验码，返回前缀和查找ID
+
+校验码可以在不查询存储的情况下过滤掉输错或伪造的 key
+*/
+func ParseAPIKey(paramKey string) (string, string, error) {
+	last := strings.LastIndexByte(paramKey, '_')
+	if last < 0 {
+		return "", "", ErrAPIKeyFormat
+	}
+	secretPart := paramKey[last+1:]
+	rest := paramKey[:last]
+	mid := strings.LastIndexByte(rest, '_')
+	if mid < 0 {
+		return "", "", ErrAPIKeyFormat
+	}
+	prefix, id := rest[:mid], rest[mid+1:]
+	if !isValidPrefix(prefix) || len(id) != API_KEY_ID_LEN || len(secretPart) != API_KEY_SECRET_LEN+API_KEY_CHECKSUM_LEN {
+		return "", "", ErrAPIKeyFormat
+	}
+	body := paramKey[:len(paramKey)-API_KEY_CHECKSUM_LEN]
+	if !hmac.Equal([]byte(checksum(body)), []byte(paramKey[len(body):])) {
+		return "", "", ErrAPIKeyChecksum
+	}
+	return prefix, id, nil
+}
+
+/*
+计算 API key 的摘要用于保存
+
+  - paramPepper 服务端保存的密钥，不为空时使用 HMAC-SHA256，为空时使用 SHA-256
+  - paramKey 完整的 API key
+*/
+func DigestAPIKey(paramPepper []byte, paramKey string) string {
+	if len(paramPepper) == 0 {
+		sum := sha256.Sum256([]byte(paramKey))
+		return hex.EncodeToString(sum[:])
+	}
+	mac := hmac.New(sha256.New, paramPepper)
+	mac.Write([]byte(paramKey))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// 以固定时间比较 API key 与保存的摘要
+func VerifyAPIKeyDigest(paramPepper []byte, paramKey string, paramDigest string) bool {
+	return hmac.Equal([]byte(DigestAPIKey(paramPepper, paramKey)), []byte(paramDigest))
+}
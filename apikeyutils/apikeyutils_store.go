@@ -0,0 +1,162 @@
+package apikeyutils
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	redis "github.com/go-redis/redis/v8"
+	redisv8 "github.com/qiuliaogit/utilsext/redis_utils_v8"
+)
+
+/*
+基于 RedisHSetUtils 的 API key 存储
+
+哈希的字段为查找ID，值为 APIKeyInfo 的JSON，只保存 key 的摘要
+*/
+type APIKeyStore struct {
+	hset   *redisv8.RedisHSetUtils
+	pepper []byte
+}
+
+/*
+创建一个 API key 存储
+
+  - paramCli
+  - paramHSetKey 保存 key 信息的哈希 key
+  - paramPepper 计算摘要使用的服务端密钥，为空时使用 SHA-256
+*/
+func CreateAPIKeyStore(paramCli *redis.Client, paramHSetKey string, paramPepper []byte) *APIKeyStore {
+	return &APIKeyStore{
+		hset:   redisv8.CreateHSetUtils(paramCli, paramHSetKey, 0, false),
+		pepper: paramPepper,
+	}
+}
+
+/*
+生成并保存一个新的 API key，返回完整的 key(只能在此时展示给用户)和 key 的信息
+
+  - paramPrefix 前缀，如 uk_live
+  - paramName 备注名称
+  - paramScopes 权限范围
+  - paramTTL 有效时长，<=0 表示不过期
+*/
+func (s *APIKeyStore) Create(ctx context.Context, paramPrefix string, paramName string, paramScopes []string, paramTTL time.Duration) (string, *APIKeyInfo, error) {
+	key, id, err := GenerateAPIKey(paramPrefix)
+	if err != nil {
+		return "", nil, err
+	}
+	now := time.Now()
+	info := &APIKeyInfo{
+		ID:        id,
+		Prefix:    paramPrefix,
+		Digest:    DigestAPIKey(s.pepper, key),
+		Name:      paramName,
+		Scopes:    paramScopes,
+		CreatedAt: now.Unix(),
+	}
+	if paramTTL > 0 {
+		info.ExpiresAt = now.Add(paramTTL).Unix()
+	}
+	if err := s.Save(ctx, info); err != nil {
+		return "", nil, err
+	}
+	return key, info, nil
+}
+
+// 保存 key 的信息
+func (s *APIKeyStore) Save(ctx context.Context, paramInfo *APIKeyInfo) error {
+	data, err := json.Marshal(paramInfo)
+	if err != nil {
+		return err
+	}
+	return s.hset.Set(ctx, paramInfo.ID, string(data)).Err()
+}
+
+// 按查找ID获取 key 的信息，不存在时返回 redis.Nil
+func (s *APIKeyStore) Get(ctx context.Context, paramID string) (*APIKeyInfo, error) {
+	data, err := s.hset.Get(ctx, paramID).Result()
+	if err != nil {
+		return nil, err
+	}
+	info := &APIKeyInfo{}
+	if err := json.Unmarshal([]byte(data), info); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// 获取所有 key 的信息
+func (s *APIKeyStore) List(ctx context.Context) ([]*APIKeyInfo, error) {
+	all, err := s.hset.GetAll(ctx).Result()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]*APIKeyInfo, 0, len(all))
+	for _, data := range all {
+		info := &APIKeyInfo{}
+		if err := json.Unmarshal([]byte(data), info); err != nil {
+			return nil, err
+		}
+		list = append(list, info)
+	}
+	return list, nil
+}
+
+/*
+校验完整的 API key，通过时返回 key 的信息
+
+  - paramKey 客户端传来的完整 key
+*/
+func (s *APIKeyStore) Verify(ctx context.Context, paramKey string) (*APIKeyInfo, error) {
+	prefix, id, err := ParseAPIKey(paramKey)
+	if err != nil {
+		return nil, err
+	}
+	info, err := s.Get(ctx, id)
+	if err == redis.Nil {
+		return nil, ErrAPIKeyInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+	if info.Prefix != prefix || !VerifyAPIKeyDigest(s.pepper, paramKey, info.Digest) {
+		return nil, ErrAPIKeyInvalid
+	}
+	if info.Revoked {
+		return nil, ErrAPIKeyRevoked
+	}
+	if info.IsExpired() {
+		return nil, ErrAPIKeyExpired
+	}
+	return info, nil
+}
+
+/*
+吊销 key，保留记录便于审计，key 不存在时返回 redis.Nil
+
+使用 WATCH/MULTI/EXEC 读取并写回，期间记录被并发修改时自动重试，不会丢失其他写入
+*/
+func (s *APIKeyStore) Revoke(ctx context.Context, paramID string) error {
+	_, err := s.hset.Update(ctx, paramID, redisv8.DefaultCASOptions(), func(paramOld string, paramExists bool) (string, error) {
+		if !paramExists {
+			return "", redis.Nil
+		}
+		info := &APIKeyInfo{}
+		if err := json.Unmarshal([]byte(paramOld), info); err != nil {
+			return "", err
+		}
+		info.Revoked = true
+		data, err := json.Marshal(info)
+		if err != nil {
+			return "", err
+		}
+		return string(data), nil
+	})
+	return err
+}
+
+// 删除 key 的记录
+func (s *APIKeyStore) Delete(ctx context.Context, paramID string) error {
+	return s.hset.Del(ctx, paramID).Err()
+}
@@ -0,0 +1,96 @@
+package apikeyutils
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	redis "github.com/go-redis/redis/v8"
+)
+
+func newTestStore(t *testing.T) *APIKeyStore {
+	mr := miniredis.RunT(t)
+	cli := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { cli.Close() })
+	return CreateAPIKeyStore(cli, "apikeys", []byte("pepper"))
+}
+
+func TestAPIKeyStoreCreateAndVerify(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t)
+
+	key, info, err := store.Create(ctx, "uk_live", "ci", []string{"read"}, 0)
+	if err != nil {
+		t.Fatalf("Create error: %v", err)
+	}
+	got, err := store.Get(ctx, info.ID)
+	if err != nil || got.Name != "ci" || got.Digest != info.Digest || got.Digest == key {
+		t.Fatalf("Get = %+v, %v", got, err)
+	}
+	verified, err := store.Verify(ctx, key)
+	if err != nil || verified.ID != info.ID || !verified.HasScope("read") {
+		t.Fatalf("Verify = %+v, %v", verified, err)
+	}
+	if list, err := store.List(ctx); err != nil || len(list) != 1 {
+		t.Fatalf("List = %v, %v", list, err)
+	}
+
+	// 改动一个字符在查询 redis 之前就被校验码拒绝
+	b := []byte(key)
+	pos := len(b) - 10
+	if b[pos] == 'a' {
+		b[pos] = 'b'
+	} else {
+		b[pos] = 'a'
+	}
+	if _, err := store.Verify(ctx, string(b)); err != ErrAPIKeyChecksum {
+		t.Errorf("Verify typo err = %v", err)
+	}
+	// 格式和校验码正确但不是签发的 key
+	forged, _, _ := GenerateAPIKey("uk_live")
+	if _, err := store.Verify(ctx, forged); err != ErrAPIKeyInvalid {
+		t.Errorf("Verify unknown err = %v", err)
+	}
+	if _, err := store.Verify(ctx, "uk_test"+key[len("uk_live"):]); err == nil {
+		t.Error("Verify should reject a different prefix")
+	}
+
+	expiredKey, expired, _ := store.Create(ctx, "uk_live", "tmp", nil, time.Hour)
+	expired.ExpiresAt = time.Now().Add(-time.Second).Unix()
+	store.Save(ctx, expired)
+	if _, err := store.Verify(ctx, expiredKey); err != ErrAPIKeyExpired {
+		t.Errorf("Verify expired err = %v", err)
+	}
+}
+
+func TestAPIKeyStoreRevoke(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t)
+
+	key, info, err := store.Create(ctx, "uk_live", "ci", []string{"*"}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Revoke(ctx, info.ID); err != nil {
+		t.Fatalf("Revoke error: %v", err)
+	}
+	if _, err := store.Verify(ctx, key); err != ErrAPIKeyRevoked {
+		t.Fatalf("Verify revoked err = %v", err)
+	}
+	// 吊销只修改状态，其他信息保留便于审计
+	got, _ := store.Get(ctx, info.ID)
+	if !got.Revoked || got.Name != "ci" || got.ExpiresAt != info.ExpiresAt || len(got.Scopes) != 1 {
+		t.Fatalf("revoked info = %+v", got)
+	}
+	if err := store.Revoke(ctx, "missing"); err != redis.Nil {
+		t.Errorf("Revoke missing err = %v", err)
+	}
+
+	if err := store.Delete(ctx, info.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Verify(ctx, key); err != ErrAPIKeyInvalid {
+		t.Errorf("Verify deleted err = %v", err)
+	}
+}
@@ -0,0 +1,78 @@
+package apikeyutils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGenerateAndParseAPIKey(t *testing.T) {
+	key, id, err := GenerateAPIKey("uk_live")
+	if err != nil {
+		t.Fatalf("GenerateAPIKey error: %v", err)
+	}
+	if !strings.HasPrefix(key, "uk_live_"+id+"_") {
+		t.Errorf("unexpected key format: %s", key)
+	}
+
+	prefix, parsedID, err := ParseAPIKey(key)
+	if err != nil {
+		t.Fatalf("ParseAPIKey error: %v", err)
+	}
+	if prefix != "uk_live" || parsedID != id {
+		t.Errorf("ParseAPIKey = %s, %s", prefix, parsedID)
+	}
+
+	// 修改一个字符后校验码不匹配
+	b := []byte(key)
+	pos := len("uk_live_") + API_KEY_ID_LEN + 5
+	if b[pos] == 'a' {
+		b[pos] = 'b'
+	} else {
+		b[pos] = 'a'
+	}
+	if _, _, err := ParseAPIKey(string(b)); err != ErrAPIKeyChecksum {
+		t.Errorf("ParseAPIKey typo = %v", err)
+	}
+	for _, bad := range []string{"", "uk_live", "uk_live_short_abc", "UK_x_" + id + "_" + key[len(key)-38:]} {
+		if _, _, err := ParseAPIKey(bad); err != ErrAPIKeyFormat {
+			t.Errorf("ParseAPIKey(%q) = %v", bad, err)
+		}
+	}
+
+	if _, _, err := GenerateAPIKey("Bad-Prefix"); err == nil {
+		t.Error("GenerateAPIKey should reject invalid prefix")
+	}
+}
+
+func TestDigestAPIKey(t *testing.T) {
+	key, _, _ := GenerateAPIKey("uk_test")
+	pepper := []byte("server-side-pepper")
+
+	digest := DigestAPIKey(pepper, key)
+	if strings.Contains(digest, key) || len(digest) != 64 {
+		t.Errorf("unexpected digest: %s", digest)
+	}
+	if !VerifyAPIKeyDigest(pepper, key, digest) {
+		t.Error("VerifyAPIKeyDigest failed for correct key")
+	}
+	if VerifyAPIKeyDigest(nil, key, digest) {
+		t.Error("VerifyAPIKeyDigest should fail with different pepper")
+	}
+	if !VerifyAPIKeyDigest(nil, key, DigestAPIKey(nil, key)) {
+		t.Error("VerifyAPIKeyDigest failed without pepper")
+	}
+}
+
+func TestAPIKeyInfoScope(t *testing.T) {
+	info := &APIKeyInfo{ID: "abc", Prefix: "uk_live", Scopes: []string{"orders:read"}}
+	if !info.HasScope("orders:read") || info.HasScope("orders:write") {
+		t.Error("HasScope mismatch")
+	}
+	info.Scopes = []string{API_KEY_SCOPE_ALL}
+	if !info.HasScope("orders:write") {
+		t.Error("HasScope should allow all with *")
+	}
+	if info.Masked() != "uk_live_abc_****" {
+		t.Errorf("Masked = %s", info.Masked())
+	}
+}
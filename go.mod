@@ -3,6 +3,7 @@ module github.com/qiuliaogit/utilsext
 go 1.22.12
 
 require (
+	github.com/alicebob/miniredis/v2 v2.37.0
 	github.com/go-redis/redis/v8 v8.11.5
 	github.com/qiuliaogit/commonutils v1.0.24
 	github.com/shopspring/decimal v1.4.0
@@ -12,4 +13,5 @@ require (
 require (
 	github.com/cespare/xxhash/v2 v2.1.2 // indirect
 	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
+	github.com/yuin/gopher-lua v1.1.1 // indirect
 )
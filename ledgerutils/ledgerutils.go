@@ -0,0 +1,161 @@
+package ledgerutils
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/qiuliaogit/commonutils/commonutils"
+	"github.com/qiuliaogit/utilsext/dec"
+	redisv8 "github.com/qiuliaogit/utilsext/redis_utils_v8"
+	"github.com/shopspring/decimal"
+)
+
+// 外部账户，充值从这里转入，提现转出到这里，余额允许为负
+const LEDGER_EXTERNAL_ACCOUNT = "@external"
+
+var (
+	ErrLedgerAccountNotFound = commonutils.NewError(commonutils.ERR_FAIL, "账户不存在")
+	ErrLedgerInsufficient    = commonutils.NewError(commonutils.ERR_FAIL, "余额不足")
+	ErrLedgerUnbalanced      = commonutils.NewError(commonutils.ERR_FAIL, "交易的借贷金额合计不为0")
+	ErrLedgerEmptyTx         = commonutils.NewError(commonutils.ERR_FAIL, "交易ID或分录为空")
+	ErrLedgerTxConflict      = commonutils.NewError(commonutils.ERR_FAIL, "交易ID已被其他内容不同的交易使用")
+	ErrLedgerInvalidAmount   = commonutils.NewError(commonutils.ERR_FAIL, "金额必须大于0")
+)
+
+// 交易中的一条分录，金额为正表示转入，为负表示转出
+type Posting struct {
+	Account string          `json:"account"`
+	Amount  decimal.Decimal `json:"amount"`
+}
+
+// 一笔复式记账的交易，所有分录的金额合计必须为0
+type Transaction struct {
+	ID       string    `json:"id"`
+	Postings []Posting `json:"postings"`
+	Memo     string    `json:"memo,omitempty"`
+	Time     int64     `json:"time"`
+}
+
+// 账户流水中的一条记录
+type JournalEntry struct {
+	TxID    string          `json:"tx_id"`
+	Amount  decimal.Decimal `json:"amount"`
+	Balance decimal.Decimal `json:"balance"`
+	Time    int64           `json:"time"`
+}
+
+/*
+合并同一账户的分录并检查交易是否平衡
+
+返回按账户名排序后的分录，金额为0的账户会被去掉
+*/
+func normalizePostings(paramPostings []Posting) ([]Posting, error) {
+	sums := make(map[string]decimal.Decimal, len(paramPostings))
+	total := dec.Zero()
+	for _, p := range paramPostings {
+		if p.Account == "" {
+			return nil, ErrLedgerEmptyTx
+		}
+		sums[p.Account] = sums[p.Account].Add(p.Amount)
+		total = total.Add(p.Amount)
+	}
+	if !total.IsZero() {
+		return nil, ErrLedgerUnbalanced
+	}
+	ret := make([]Posting, 0, len(sums))
+	for account, amount := range sums {
+		if amount.IsZero() {
+			continue
+		}
+		ret = append(ret, Posting{Account: account, Amount: amount})
+	}
+	if len(ret) == 0 {
+		return nil, ErrLedgerEmptyTx
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Account < ret[j].Account
+	})
+	return ret, nil
+}
+
+// 两笔交易的分录是否一致，用于判断重复提交的交易ID
+func samePostings(paramA, paramB []Posting) bool {
+	if len(paramA) != len(paramB) {
+		return false
+	}
+	for i := range paramA {
+		if paramA[i].Account != paramB[i].Account || !paramA[i].Amount.Equal(paramB[i].Amount) {
+			return false
+		}
+	}
+	return true
+}
+
+// 流水中记录的定点整数形式，由 Lua 脚本写入
+type journalRecord struct {
+	TxID    string `json:"tx_id"`
+	Amount  string `json:"amount"`
+	Balance string `json:"balance"`
+	Time    int64  `json:"time"`
+}
+
+// 解析脚本写入的流水记录
+func parseJournalEntry(paramData string, paramScale int32) (JournalEntry, error) {
+	var r journalRecord
+	if err := json.Unmarshal([]byte(paramData), &r); err != nil {
+		return JournalEntry{}, err
+	}
+	amount, err := redisv8.DecimalFromScaled(r.Amount, paramScale)
+	if err != nil {
+		return JournalEntry{}, err
+	}
+	balance, err := redisv8.DecimalFromScaled(r.Balance, paramScale)
+	if err != nil {
+		return JournalEntry{}, err
+	}
+	return JournalEntry{TxID: r.TxID, Amount: amount, Balance: balance, Time: r.Time}, nil
+}
+
+// 对账时发现的一条不一致的流水
+type JournalMismatch struct {
+	Index    int             `json:"index"`
+	TxID     string          `json:"tx_id"`
+	Expected decimal.Decimal `json:"expected"`
+	Recorded decimal.Decimal `json:"recorded"`
+}
+
+// 一个账户的对账结果
+type ReconcileResult struct {
+	Account    string            `json:"account"`
+	Stored     decimal.Decimal   `json:"stored"`
+	Replayed   decimal.Decimal   `json:"replayed"`
+	Entries    int               `json:"entries"`
+	Mismatches []JournalMismatch `json:"mismatches,omitempty"`
+}
+
+// 流水逐条一致并且重放结果等于保存的余额
+func (r *ReconcileResult) OK() bool {
+	return len(r.Mismatches) == 0 && r.Stored.Equal(r.Replayed)
+}
+
+/*
+按顺序重放流水，返回重放得到的余额和余额记录不一致的流水
+
+每条流水记录的余额应等于之前所有流水金额之和
+*/
+func ReplayJournal(paramEntries []JournalEntry) (decimal.Decimal, []JournalMismatch) {
+	balance := dec.Zero()
+	var mismatches []JournalMismatch
+	for i, e := range paramEntries {
+		balance = dec.Add(balance, e.Amount)
+		if !balance.Equal(e.Balance) {
+			mismatches = append(mismatches, JournalMismatch{
+				Index:    i,
+				TxID:     e.TxID,
+				Expected: balance,
+				Recorded: e.Balance,
+			})
+		}
+	}
+	return balance, mismatches
+}
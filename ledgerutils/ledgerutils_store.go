@@ -0,0 +1,366 @@
+package ledgerutils
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+
+	redis "github.com/go-redis/redis/v8"
+	"github.com/qiuliaogit/utilsext/dec"
+	redisv8 "github.com/qiuliaogit/utilsext/redis_utils_v8"
+	"github.com/shopspring/decimal"
+)
+
+/*
+基于Redis的复式记账账本
+
+所有 key 使用同一个 hash tag，可以在集群中用一个 Lua 脚本原子地完成记账：
+
+  - {name}:account 账户表，字段为账户名，值为 1 允许余额为负 / 0 不允许
+  - {name}:balance 余额表，字段为账户名，值为 金额 * 10^scale 的整数
+  - {name}:tx 交易表，字段为交易ID，值为交易的JSON，用于幂等
+  - {name}:journal:账户名 账户流水列表，对账时按顺序重放
+
+流水列表不会被截断，需要归档时先对账再整体迁移
+*/
+type Ledger struct {
+	cli      *redis.Client
+	prefix   string
+	scale    int32
+	accounts *redisv8.RedisHSetUtils
+	balances *redisv8.RedisDecimalHSetUtils
+	txs      *redisv8.RedisHSetUtils
+}
+
+/*
+创建一个账本
+
+  - paramCli
+  - paramName 账本名称，作为 key 的 hash tag
+  - paramScale 金额的小数位数，如 4
+*/
+func CreateLedger(paramCli *redis.Client, paramName string, paramScale int32) *Ledger {
+	prefix := "{" + paramName + "}"
+	return &Ledger{
+		cli:      paramCli,
+		prefix:   prefix,
+		scale:    paramScale,
+		accounts: redisv8.CreateHSetUtils(paramCli, prefix+":account", 0, false),
+		balances: redisv8.CreateDecimalHSetUtils(redisv8.CreateHSetUtils(paramCli, prefix+":balance", 0, false), paramScale),
+		txs:      redisv8.CreateHSetUtils(paramCli, prefix+":tx", 0, false),
+	}
+}
+
+// 账户流水的 key
+func (l *Ledger) journalKey(paramAccount string) string {
+	return l.prefix + ":journal:" + paramAccount
+}
+
+// 账户流水列表
+func (l *Ledger) journal(paramAccount string) *redisv8.RedisListUtils {
+	return redisv8.CreateListUtils(l.cli, l.journalKey(paramAccount), 0, false)
+}
+
+/*
+开户，账户已存在时不修改并返回 false
+
+  - paramAccount 账户名
+  - paramAllowNegative 是否允许余额为负，如平台的收支账户
+*/
+func (l *Ledger) OpenAccount(ctx context.Context, paramAccount string, paramAllowNegative bool) (bool, error) {
+	flag := "0"
+	if paramAllowNegative {
+		flag = "1"
+	}
+	return l.cli.HSetNX(ctx, l.accounts.HSetKey, paramAccount, flag).Result()
+}
+
+// 账户
+type LedgerAccount struct {
+	Account       string `json:"account"`
+	AllowNegative bool   `json:"allow_negative"` // 是否允许余额为负
+}
+
+// 所有账户，按账户名排序
+func (l *Ledger) Accounts(ctx context.Context) ([]LedgerAccount, error) {
+	all, err := l.accounts.GetAll(ctx).Result()
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]LedgerAccount, 0, len(all))
+	for account, flag := range all {
+		ret = append(ret, LedgerAccount{Account: account, AllowNegative: flag == "1"})
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Account < ret[j].Account
+	})
+	return ret, nil
+}
+
+// 账户余额，账户没有流水时返回0
+func (l *Ledger) Balance(ctx context.Context, paramAccount string) (decimal.Decimal, error) {
+	return l.balances.Get(ctx, paramAccount)
+}
+
+// 所有账户的余额
+func (l *Ledger) Balances(ctx context.Context) (map[string]decimal.Decimal, error) {
+	return l.balances.GetAll(ctx)
+}
+
+// 按交易ID获取交易，不存在时返回 redis.Nil
+func (l *Ledger) GetTx(ctx context.Context, paramTxID string) (*Transaction, error) {
+	data, err := l.txs.Get(ctx, paramTxID).Result()
+	if err != nil {
+		return nil, err
+	}
+	tx := &Transaction{}
+	if err := json.Unmarshal([]byte(data), tx); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// KEYS: 账户表, 余额表, 交易表, 各分录账户的流水
+// ARGV: 交易ID, 交易JSON, 时间, 各分录的账户, 各分录的金额
+var ledgerPostScript = redis.NewScript(`
+local existing = redis.call('HGET', KEYS[3], ARGV[1])
+if existing then
+	return {0, existing}
+end
+local n = #KEYS - 3
+for i = 1, n do
+	local account = ARGV[3 + i]
+	local flag = redis.call('HGET', KEYS[1], account)
+	if not flag then
+		return {-1, account}
+	end
+	if flag == '0' then
+		local balance = tonumber(redis.call('HGET', KEYS[2], account) or '0')
+		if balance + tonumber(ARGV[3 + n + i]) < 0 then
+			return {-2, account}
+		end
+	end
+end
+for i = 1, n do
+	local amount = ARGV[3 + n + i]
+	local balance = redis.call('HINCRBY', KEYS[2], ARGV[3 + i], amount)
+	redis.call('RPUSH', KEYS[3 + i], cjson.encode({
+		tx_id = ARGV[1],
+		amount = amount,
+		balance = string.format('%.0f', balance),
+		time = tonumber(ARGV[3])
+	}))
+end
+redis.call('HSET', KEYS[3], ARGV[1], ARGV[2])
+return {1, ARGV[2]}
+`)
+
+/*
+记一笔交易，所有分录在一个 Lua 脚本中原子地检查余额并入账
+
+  - paramTx 交易，ID 必填，Time 为0时使用当前时间
+
+同一账户的多条分录会被合并，返回保存的交易和本次是否入账。
+交易ID已存在时不重复入账，返回之前保存的交易，如果分录不同返回 ErrLedgerTxConflict。
+账户不存在返回 ErrLedgerAccountNotFound，不允许为负的账户余额不足返回 ErrLedgerInsufficient。
+*/
+func (l *Ledger) Post(ctx context.Context, paramTx *Transaction) (*Transaction, bool, error) {
+	if paramTx.ID == "" {
+		return nil, false, ErrLedgerEmptyTx
+	}
+	postings, err := normalizePostings(paramTx.Postings)
+	if err != nil {
+		return nil, false, err
+	}
+	tx := &Transaction{ID: paramTx.ID, Postings: postings, Memo: paramTx.Memo, Time: paramTx.Time}
+	if tx.Time == 0 {
+		tx.Time = time.Now().Unix()
+	}
+	data, err := json.Marshal(tx)
+	if err != nil {
+		return nil, false, err
+	}
+	keys := []string{l.accounts.HSetKey, l.prefix + ":balance", l.txs.HSetKey}
+	accounts := make([]interface{}, 0, len(postings))
+	amounts := make([]interface{}, 0, len(postings))
+	for _, p := range postings {
+		amount, err := redisv8.DecimalToScaled(p.Amount, l.scale)
+		if err != nil {
+			return nil, false, err
+		}
+		keys = append(keys, l.journalKey(p.Account))
+		accounts = append(accounts, p.Account)
+		amounts = append(amounts, amount)
+	}
+	args := append([]interface{}{tx.ID, string(data), tx.Time}, accounts...)
+	args = append(args, amounts...)
+	ret, err := ledgerPostScript.Run(ctx, l.cli, keys, args...).Slice()
+	if err != nil {
+		return nil, false, err
+	}
+	code, _ := ret[0].(int64)
+	switch code {
+	case 1:
+		return tx, true, nil
+	case 0:
+		stored := &Transaction{}
+		if err := json.Unmarshal([]byte(ret[1].(string)), stored); err != nil {
+			return nil, false, err
+		}
+		if !samePostings(stored.Postings, postings) {
+			return stored, false, ErrLedgerTxConflict
+		}
+		return stored, false, nil
+	case -1:
+		return nil, false, ErrLedgerAccountNotFound
+	default:
+		return nil, false, ErrLedgerInsufficient
+	}
+}
+
+/*
+从一个账户转账到另一个账户
+
+  - paramTxID 交易ID，重复提交时不会重复转账
+  - paramFrom 转出账户
+  - paramTo 转入账户
+  - paramAmount 金额，必须大于0
+  - paramMemo 备注
+*/
+func (l *Ledger) Transfer(ctx context.Context, paramTxID string, paramFrom string, paramTo string, paramAmount decimal.Decimal, paramMemo string) (*Transaction, bool, error) {
+	if !paramAmount.IsPositive() {
+		return nil, false, ErrLedgerInvalidAmount
+	}
+	return l.Post(ctx, &Transaction{
+		ID: paramTxID,
+		Postings: []Posting{
+			{Account: paramFrom, Amount: paramAmount.Neg()},
+			{Account: paramTo, Amount: paramAmount},
+		},
+		Memo: paramMemo,
+	})
+}
+
+// 充值，从外部账户转入
+func (l *Ledger) Deposit(ctx context.Context, paramTxID string, paramAccount string, paramAmount decimal.Decimal, paramMemo string) (*Transaction, bool, error) {
+	if _, err := l.OpenAccount(ctx, LEDGER_EXTERNAL_ACCOUNT, true); err != nil {
+		return nil, false, err
+	}
+	return l.Transfer(ctx, paramTxID, LEDGER_EXTERNAL_ACCOUNT, paramAccount, paramAmount, paramMemo)
+}
+
+// 提现，转出到外部账户
+func (l *Ledger) Withdraw(ctx context.Context, paramTxID string, paramAccount string, paramAmount decimal.Decimal, paramMemo string) (*Transaction, bool, error) {
+	if _, err := l.OpenAccount(ctx, LEDGER_EXTERNAL_ACCOUNT, true); err != nil {
+		return nil, false, err
+	}
+	return l.Transfer(ctx, paramTxID, paramAccount, LEDGER_EXTERNAL_ACCOUNT, paramAmount, paramMemo)
+}
+
+/*
+获取账户的流水
+
+  - paramStart 开始位置，0为最早的一条
+  - paramStop 结束位置，-1为最新的一条
+*/
+func (l *Ledger) Journal(ctx context.Context, paramAccount string, paramStart int64, paramStop int64) ([]JournalEntry, error) {
+	list, err := l.journal(paramAccount).Range(ctx, paramStart, paramStop).Result()
+	if err != nil {
+		return nil, err
+	}
+	return l.parseJournal(list)
+}
+
+// 解析流水列表
+func (l *Ledger) parseJournal(paramList []string) ([]JournalEntry, error) {
+	ret := make([]JournalEntry, 0, len(paramList))
+	for _, data := range paramList {
+		e, err := parseJournalEntry(data, l.scale)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, e)
+	}
+	return ret, nil
+}
+
+/*
+对账：在同一个事务中读取账户流水和余额，用 dec 重放流水并和保存的余额比较
+*/
+func (l *Ledger) Reconcile(ctx context.Context, paramAccount string) (*ReconcileResult, error) {
+	var listCmd *redis.StringSliceCmd
+	var balanceCmd *redis.StringCmd
+	_, err := l.cli.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		listCmd = pipe.LRange(ctx, l.journalKey(paramAccount), 0, -1)
+		balanceCmd = pipe.HGet(ctx, l.prefix+":balance", paramAccount)
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+	if err := listCmd.Err(); err != nil {
+		return nil, err
+	}
+	if err := balanceCmd.Err(); err != nil && err != redis.Nil {
+		return nil, err
+	}
+	stored, err := redisv8.DecimalFromScaled(balanceCmd.Val(), l.scale)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := l.parseJournal(listCmd.Val())
+	if err != nil {
+		return nil, err
+	}
+	replayed, mismatches := ReplayJournal(entries)
+	return &ReconcileResult{
+		Account:    paramAccount,
+		Stored:     stored,
+		Replayed:   replayed,
+		Entries:    len(entries),
+		Mismatches: mismatches,
+	}, nil
+}
+
+// 整个账本的对账结果
+type LedgerReconcileReport struct {
+	Accounts []*ReconcileResult `json:"accounts"` // 按账户名排序
+	// 所有账户余额之和，复式记账下应为0
+	Total decimal.Decimal `json:"total"`
+}
+
+// 所有账户都对得上并且余额合计为0
+func (r *LedgerReconcileReport) OK() bool {
+	if !r.Total.IsZero() {
+		return false
+	}
+	for _, a := range r.Accounts {
+		if !a.OK() {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+对所有账户对账，并检查所有账户的余额合计是否为0
+
+各账户分别读取，对账期间有交易时合计可能短暂不为0，需要在没有交易时执行或重试
+*/
+func (l *Ledger) ReconcileAll(ctx context.Context) (*LedgerReconcileReport, error) {
+	accounts, err := l.Accounts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	report := &LedgerReconcileReport{Total: dec.Zero()}
+	for _, account := range accounts {
+		r, err := l.Reconcile(ctx, account.Account)
+		if err != nil {
+			return nil, err
+		}
+		report.Accounts = append(report.Accounts, r)
+		report.Total = dec.Add(report.Total, r.Stored)
+	}
+	return report, nil
+}
@@ -0,0 +1,238 @@
+package ledgerutils
+
+import (
+	"context"
+	"sort"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	redis "github.com/go-redis/redis/v8"
+	"github.com/qiuliaogit/utilsext/dec"
+)
+
+func TestNormalizePostings(t *testing.T) {
+	postings, err := normalizePostings([]Posting{
+		{Account: "b", Amount: dec.D("1.5")},
+		{Account: "a", Amount: dec.D("-2")},
+		{Account: "b", Amount: dec.D("0.5")},
+		{Account: "c", Amount: dec.D("0")},
+	})
+	if err != nil {
+		t.Fatalf("normalizePostings error: %v", err)
+	}
+	if len(postings) != 2 || postings[0].Account != "a" || postings[1].Account != "b" {
+		t.Fatalf("unexpected postings: %+v", postings)
+	}
+	if !postings[1].Amount.Equal(dec.D(2)) {
+		t.Errorf("merged amount = %s", postings[1].Amount)
+	}
+
+	if _, err := normalizePostings([]Posting{{Account: "a", Amount: dec.D(1)}}); err != ErrLedgerUnbalanced {
+		t.Errorf("unbalanced error = %v", err)
+	}
+	if _, err := normalizePostings([]Posting{{Account: "a", Amount: dec.D(1)}, {Account: "a", Amount: dec.D(-1)}}); err != ErrLedgerEmptyTx {
+		t.Errorf("empty error = %v", err)
+	}
+}
+
+func TestSamePostings(t *testing.T) {
+	a := []Posting{{Account: "a", Amount: dec.D("-1.00")}, {Account: "b", Amount: dec.D("1")}}
+	b := []Posting{{Account: "a", Amount: dec.D("-1")}, {Account: "b", Amount: dec.D("1.0")}}
+	if !samePostings(a, b) {
+		t.Error("expected same postings")
+	}
+	b[1].Amount = dec.D(2)
+	if samePostings(a, b) {
+		t.Error("expected different postings")
+	}
+}
+
+func TestParseJournalEntry(t *testing.T) {
+	e, err := parseJournalEntry(`{"tx_id":"t1","amount":"-12345","balance":"100000","time":1700000000}`, 4)
+	if err != nil {
+		t.Fatalf("parseJournalEntry error: %v", err)
+	}
+	if e.TxID != "t1" || !e.Amount.Equal(dec.D("-1.2345")) || !e.Balance.Equal(dec.D(10)) || e.Time != 1700000000 {
+		t.Errorf("unexpected entry: %+v", e)
+	}
+}
+
+func TestReplayJournal(t *testing.T) {
+	entries := []JournalEntry{
+		{TxID: "t1", Amount: dec.D("10.10"), Balance: dec.D("10.1")},
+		{TxID: "t2", Amount: dec.D("-0.20"), Balance: dec.D("9.9")},
+		{TxID: "t3", Amount: dec.D("0.01"), Balance: dec.D("9.92")},
+	}
+	balance, mismatches := ReplayJournal(entries)
+	if !balance.Equal(dec.D("9.91")) {
+		t.Errorf("replayed balance = %s", balance)
+	}
+	if len(mismatches) != 1 || mismatches[0].TxID != "t3" || !mismatches[0].Expected.Equal(dec.D("9.91")) {
+		t.Errorf("unexpected mismatches: %+v", mismatches)
+	}
+
+	r := &ReconcileResult{Stored: dec.D("9.91"), Replayed: balance}
+	if !r.OK() {
+		t.Error("expected reconcile ok without mismatches")
+	}
+	r.Mismatches = mismatches
+	if r.OK() {
+		t.Error("expected reconcile not ok with mismatches")
+	}
+}
+
+func newTestLedger(t *testing.T) *Ledger {
+	mr := miniredis.RunT(t)
+	cli := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { cli.Close() })
+	return CreateLedger(cli, "shop", 4)
+}
+
+func TestLedgerReconcileAll(t *testing.T) {
+	ctx := context.Background()
+	ledger := newTestLedger(t)
+
+	for _, account := range []string{"carol", "alice", "bob"} {
+		if _, err := ledger.OpenAccount(ctx, account, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, _, err := ledger.Deposit(ctx, "tx1", "bob", dec.D("10"), ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ledger.Transfer(ctx, "tx2", "bob", "alice", dec.D("2.5"), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	accounts, err := ledger.Accounts(ctx)
+	if err != nil || len(accounts) != 4 {
+		t.Fatalf("Accounts = %v, %v", accounts, err)
+	}
+	if !sort.SliceIsSorted(accounts, func(i, j int) bool { return accounts[i].Account < accounts[j].Account }) {
+		t.Fatalf("accounts not sorted: %v", accounts)
+	}
+
+	report, err := ledger.ReconcileAll(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.OK() {
+		t.Fatalf("report not OK: %+v", report)
+	}
+	for i, r := range report.Accounts {
+		if r.Account != accounts[i].Account {
+			t.Fatalf("report[%d] = %s, want %s", i, r.Account, accounts[i].Account)
+		}
+	}
+}
+
+func TestLedgerTransfer(t *testing.T) {
+	ctx := context.Background()
+	ledger := newTestLedger(t)
+	ledger.OpenAccount(ctx, "alice", false)
+	ledger.OpenAccount(ctx, "bob", false)
+
+	if _, ok, err := ledger.Deposit(ctx, "tx1", "alice", dec.D("10"), "top up"); err != nil || !ok {
+		t.Fatalf("Deposit = %v, %v", ok, err)
+	}
+	tx, ok, err := ledger.Transfer(ctx, "tx2", "alice", "bob", dec.D("3.25"), "")
+	if err != nil || !ok || len(tx.Postings) != 2 {
+		t.Fatalf("Transfer = %+v, %v, %v", tx, ok, err)
+	}
+	if _, ok, err := ledger.Withdraw(ctx, "tx3", "bob", dec.D("1"), ""); err != nil || !ok {
+		t.Fatalf("Withdraw = %v, %v", ok, err)
+	}
+	balances, err := ledger.Balances(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"alice": "6.75", "bob": "2.25", LEDGER_EXTERNAL_ACCOUNT: "-9"}
+	for account, amount := range want {
+		if !balances[account].Equal(dec.D(amount)) {
+			t.Errorf("balance %s = %s, want %s", account, balances[account], amount)
+		}
+	}
+	entries, err := ledger.Journal(ctx, "bob", 0, -1)
+	if err != nil || len(entries) != 2 || entries[1].TxID != "tx3" || !entries[1].Balance.Equal(dec.D("2.25")) {
+		t.Fatalf("Journal = %+v, %v", entries, err)
+	}
+	if _, _, err := ledger.Transfer(ctx, "tx4", "alice", "bob", dec.D("0"), ""); err != ErrLedgerInvalidAmount {
+		t.Errorf("zero amount err = %v", err)
+	}
+}
+
+func TestLedgerInsufficient(t *testing.T) {
+	ctx := context.Background()
+	ledger := newTestLedger(t)
+	ledger.OpenAccount(ctx, "alice", false)
+	ledger.OpenAccount(ctx, "bob", false)
+	ledger.Deposit(ctx, "tx1", "alice", dec.D("1"), "")
+
+	if _, ok, err := ledger.Transfer(ctx, "tx2", "alice", "bob", dec.D("1.0001"), ""); err != ErrLedgerInsufficient || ok {
+		t.Fatalf("Transfer = %v, %v", ok, err)
+	}
+	// 检查失败时所有分录都不入账
+	if b, _ := ledger.Balance(ctx, "alice"); !b.Equal(dec.D(1)) {
+		t.Errorf("alice balance = %s", b)
+	}
+	if b, _ := ledger.Balance(ctx, "bob"); !b.IsZero() {
+		t.Errorf("bob balance = %s", b)
+	}
+	if entries, _ := ledger.Journal(ctx, "bob", 0, -1); len(entries) != 0 {
+		t.Errorf("bob journal = %+v", entries)
+	}
+	if _, err := ledger.GetTx(ctx, "tx2"); err != redis.Nil {
+		t.Errorf("GetTx err = %v", err)
+	}
+	// 交易没有保存，同一个ID余额足够后可以重新提交
+	if _, ok, err := ledger.Transfer(ctx, "tx2", "alice", "bob", dec.D("1"), ""); err != nil || !ok {
+		t.Fatalf("retry Transfer = %v, %v", ok, err)
+	}
+}
+
+func TestLedgerIdempotentTx(t *testing.T) {
+	ctx := context.Background()
+	ledger := newTestLedger(t)
+	ledger.OpenAccount(ctx, "alice", false)
+	ledger.OpenAccount(ctx, "bob", false)
+	ledger.Deposit(ctx, "tx1", "alice", dec.D("10"), "")
+
+	first, ok, err := ledger.Transfer(ctx, "tx2", "alice", "bob", dec.D("4"), "")
+	if err != nil || !ok {
+		t.Fatalf("Transfer = %v, %v", ok, err)
+	}
+	replayed, ok, err := ledger.Transfer(ctx, "tx2", "alice", "bob", dec.D("4.00"), "")
+	if err != nil || ok || replayed.Time != first.Time {
+		t.Fatalf("replay = %+v, %v, %v", replayed, ok, err)
+	}
+	if b, _ := ledger.Balance(ctx, "bob"); !b.Equal(dec.D(4)) {
+		t.Errorf("bob balance = %s", b)
+	}
+	if entries, _ := ledger.Journal(ctx, "bob", 0, -1); len(entries) != 1 {
+		t.Errorf("bob journal = %+v", entries)
+	}
+
+	stored, ok, err := ledger.Transfer(ctx, "tx2", "alice", "bob", dec.D("5"), "")
+	if err != ErrLedgerTxConflict || ok || !stored.Postings[1].Amount.Equal(dec.D(4)) {
+		t.Fatalf("conflict = %+v, %v, %v", stored, ok, err)
+	}
+	if b, _ := ledger.Balance(ctx, "bob"); !b.Equal(dec.D(4)) {
+		t.Errorf("bob balance after conflict = %s", b)
+	}
+}
+
+func TestLedgerAccountNotFound(t *testing.T) {
+	ctx := context.Background()
+	ledger := newTestLedger(t)
+	ledger.OpenAccount(ctx, "alice", true)
+
+	if _, ok, err := ledger.Transfer(ctx, "tx1", "alice", "nobody", dec.D("1"), ""); err != ErrLedgerAccountNotFound || ok {
+		t.Fatalf("Transfer = %v, %v", ok, err)
+	}
+	if b, _ := ledger.Balance(ctx, "alice"); !b.IsZero() {
+		t.Errorf("alice balance = %s", b)
+	}
+	if _, err := ledger.GetTx(ctx, "tx1"); err != redis.Nil {
+		t.Errorf("GetTx err = %v", err)
+	}
+}
@@ -0,0 +1,277 @@
+package otputils
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"hash"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/qiuliaogit/commonutils/commonutils"
+)
+
+// 一次性密码(OTP)使用的哈希算法
+type Algorithm string
+
+const (
+	ALGORITHM_SHA1   Algorithm = "SHA1"
+	ALGORITHM_SHA256 Algorithm = "SHA256"
+	ALGORITHM_SHA512 Algorithm = "SHA512"
+)
+
+var (
+	ErrOTPSecret    = commonutils.NewError(commonutils.ERR_FAIL, "OTP密钥格式错误")
+	ErrOTPAlgorithm = commonutils.NewError(commonutils.ERR_FAIL, "不支持的OTP算法")
+	ErrOTPDigits    = commonutils.NewError(commonutils.ERR_FAIL, "OTP位数必须在6到10之间")
+)
+
+var secretEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// OTP 配置
+type OTPConfig struct {
+	Digits    int       // 验证码位数 6~10
+	Period    int       // TOTP 的时间步长 单位秒
+	Algorithm Algorithm // 哈希算法
+	Skew      int       // 验证时允许前后偏移的步数(TOTP为时间步，HOTP为向后查找的计数器数量)，负数按0处理
+}
+
+// 默认配置：6位、30秒、SHA1、允许前后偏移1个时间步，兼容主流身份验证器
+func DefaultOTPConfig() OTPConfig {
+	return OTPConfig{
+		Digits:    6,
+		Period:    30,
+		Algorithm: ALGORITHM_SHA1,
+		Skew:      1,
+	}
+}
+
+func (c OTPConfig) hashFunc() (func() hash.Hash, error) {
+	switch Algorithm(strings.ToUpper(string(c.Algorithm))) {
+	case ALGORITHM_SHA1, "":
+		return sha1.New, nil
+	case ALGORITHM_SHA256:
+		return sha256.New, nil
+	case ALGORITHM_SHA512:
+		return sha512.New, nil
+	default:
+		return nil, ErrOTPAlgorithm
+	}
+}
+
+func (c OTPConfig) period() int64 {
+	if c.Period <= 0 {
+		return 30
+	}
+	return int64(c.Period)
+}
+
+// 允许偏移的步数，负数按0处理
+func (c OTPConfig) skew() int {
+	if c.Skew < 0 {
+		return 0
+	}
+	return c.Skew
+}
+
+/*
+生成一个随机的OTP密钥，返回 base32 编码(无填充)
+
+  - paramSize 密钥字节数，<=0 时使用20字节(160位，RFC 4226 推荐)
+*/
+func GenerateSecret(paramSize int) (string, error) {
+	if paramSize <= 0 {
+		paramSize = 20
+	}
+	buf := make([]byte, paramSize)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return secretEncoding.EncodeToString(buf), nil
+}
+
+// 解码 base32 密钥，忽略空格、大小写和填充
+func DecodeSecret(paramSecret string) ([]byte, error) {
+	s := strings.ToUpper(strings.ReplaceAll(paramSecret, " ", ""))
+	s = strings.TrimRight(s, "=")
+	key, err := secretEncoding.DecodeString(s)
+	if err != nil || len(key) == 0 {
+		return nil, ErrOTPSecret
+	}
+	return key, nil
+}
+
+// RFC 4226 的 HOTP 计算
+func hotp(paramKey []byte, paramCounter uint64, paramConfig OTPConfig) (string, error) {
+	h, err := paramConfig.hashFunc()
+	if err != nil {
+		return "", err
+	}
+	if paramConfig.Digits < 6 || paramConfig.Digits > 10 {
+		return "", ErrOTPDigits
+	}
+
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], paramCounter)
+	mac := hmac.New(h, paramKey)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	code := uint64(binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff)
+	var mod uint64 = 1
+	for i := 0; i < paramConfig.Digits; i++ {
+		mod *= 10
+	}
+	s := strconv.FormatUint(code%mod, 10)
+	if len(s) < paramConfig.Digits {
+		s = strings.Repeat("0", paramConfig.Digits-len(s)) + s
+	}
+	return s, nil
+}
+
+/*
+生成 HOTP 验证码
+
+  - paramSecret base32 编码的密钥
+  - paramCounter 计数器
+  - paramConfig 配置
+*/
+func GenerateHOTP(paramSecret string, paramCounter uint64, paramConfig OTPConfig) (string, error) {
+	key, err := DecodeSecret(paramSecret)
+	if err != nil {
+		return "", err
+	}
+	return hotp(key, paramCounter, paramConfig)
+}
+
+/*
+验证 HOTP 验证码，会在 [paramCounter, paramCounter+Skew] 范围内查找
+
+返回匹配的计数器，调用方需要把计数器保存为 返回值+1，防止重复使用
+*/
+func VerifyHOTP(paramSecret string, paramCode string, paramCounter uint64, paramConfig OTPConfig) (uint64, bool, error) {
+	key, err := DecodeSecret(paramSecret)
+	if err != nil {
+		return 0, false, err
+	}
+	for i := 0; i <= paramConfig.skew(); i++ {
+		counter := paramCounter + uint64(i)
+		code, err := hotp(key, counter, paramConfig)
+		if err != nil {
+			return 0, false, err
+		}
+		if subtle.ConstantTimeCompare([]byte(code), []byte(paramCode)) == 1 {
+			return counter, true, nil
+		}
+	}
+	return 0, false, nil
+}
+
+// 计算时间对应的 TOTP 时间步
+func TimeStep(paramTime time.Time, paramConfig OTPConfig) int64 {
+	return paramTime.Unix() / paramConfig.period()
+}
+
+/*
+生成 TOTP 验证码
+
+  - paramSecret base32 编码的密钥
+  - paramTime 时间，一般为 time.Now()
+  - paramConfig 配置
+*/
+func GenerateTOTP(paramSecret string, paramTime time.Time, paramConfig OTPConfig) (string, error) {
+	key, err := DecodeSecret(paramSecret)
+	if err != nil {
+		return "", err
+	}
+	return hotp(key, uint64(TimeStep(paramTime, paramConfig)), paramConfig)
+}
+
+/*
+验证 TOTP 验证码，允许前后偏移 Skew 个时间步
+
+返回匹配的时间步，可用于防止同一个验证码被重复使用
+*/
+func VerifyTOTP(paramSecret string, paramCode string, paramTime time.Time, paramConfig OTPConfig) (int64, bool, error) {
+	key, err := DecodeSecret(paramSecret)
+	if err != nil {
+		return 0, false, err
+	}
+	step := TimeStep(paramTime, paramConfig)
+	matched, ok := int64(0), false
+	// 遍历整个窗口，不提前返回，避免通过耗时推测偏移量
+	for i := -paramConfig.skew(); i <= paramConfig.skew(); i++ {
+		s := step + int64(i)
+		if s < 0 {
+			continue
+		}
+		code, err := hotp(key, uint64(s), paramConfig)
+		if err != nil {
+			return 0, false, err
+		}
+		if subtle.ConstantTimeCompare([]byte(code), []byte(paramCode)) == 1 && !ok {
+			matched, ok = s, true
+		}
+	}
+	return matched, ok, nil
+}
+
+/*
+生成身份验证器(Google Authenticator 等)使用的 otpauth://totp/ 地址，一般转成二维码给用户扫描
+
+  - paramIssuer 发行方，如应用名称
+  - paramAccount 账号名
+  - paramSecret base32 编码的密钥
+  - paramConfig 配置
+*/
+func TOTPURI(paramIssuer string, paramAccount string, paramSecret string, paramConfig OTPConfig) string {
+	return otpauthURI("totp", paramIssuer, paramAccount, paramSecret, paramConfig, func(v url.Values) {
+		v.Set("period", strconv.FormatInt(paramConfig.period(), 10))
+	})
+}
+
+/*
+生成身份验证器使用的 otpauth://hotp/ 地址
+
+  - paramCounter 初始计数器
+*/
+func HOTPURI(paramIssuer string, paramAccount string, paramSecret string, paramCounter uint64, paramConfig OTPConfig) string {
+	return otpauthURI("hotp", paramIssuer, paramAccount, paramSecret, paramConfig, func(v url.Values) {
+		v.Set("counter", strconv.FormatUint(paramCounter, 10))
+	})
+}
+
+func otpauthURI(paramType string, paramIssuer string, paramAccount string, paramSecret string, paramConfig OTPConfig, paramExtra func(url.Values)) string {
+	label := paramAccount
+	if paramIssuer != "" {
+		label = paramIssuer + ":" + paramAccount
+	}
+	v := url.Values{}
+	v.Set("secret", strings.TrimRight(strings.ToUpper(paramSecret), "="))
+	if paramIssuer != "" {
+		v.Set("issuer", paramIssuer)
+	}
+	algorithm := paramConfig.Algorithm
+	if algorithm == "" {
+		algorithm = ALGORITHM_SHA1
+	}
+	v.Set("algorithm", strings.ToUpper(string(algorithm)))
+	v.Set("digits", strconv.Itoa(paramConfig.Digits))
+	paramExtra(v)
+
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     paramType,
+		Path:     "/" + label,
+		RawQuery: strings.ReplaceAll(v.Encode(), "+", "%20"),
+	}
+	return u.String()
+}
@@ -0,0 +1,100 @@
+package otputils
+
+import (
+	"crypto/rand"
+	"strings"
+
+	"github.com/qiuliaogit/utilsext/pwdutils"
+)
+
+// 恢复码使用的字符，去掉了容易混淆的 0/o、1/l/i
+const recoveryCodeAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+
+/*
+生成一组一次性恢复码
+
+  - paramCount 恢复码数量
+  - paramLength 每个恢复码的字符数(不含分隔符)，<=0 时为10，每5个字符用"-"分隔
+
+返回明文恢复码(只展示给用户一次)和对应的哈希(使用 pwdutils.PasswordHash，保存到数据库)
+*/
+func GenerateRecoveryCodes(paramCount int, paramLength int) ([]string, []string, error) {
+	if paramLength <= 0 {
+		paramLength = 10
+	}
+	codes := make([]string, 0, paramCount)
+	hashes := make([]string, 0, paramCount)
+	for i := 0; i < paramCount; i++ {
+		code, err := randomRecoveryCode(paramLength)
+		if err != nil {
+			return nil, nil, err
+		}
+		hash, err := pwdutils.PasswordHash(NormalizeRecoveryCode(code))
+		if err != nil {
+			return nil, nil, err
+		}
+		codes = append(codes, code)
+		hashes = append(hashes, hash)
+	}
+	return codes, hashes, nil
+}
+
+func randomRecoveryCode(paramLength int) (string, error) {
+	buf := make([]byte, paramLength)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	n := byte(len(recoveryCodeAlphabet))
+	// 256 不是字符数的整数倍，丢弃超出范围的字节避免分布不均
+	limit := 256 - 256%int(n)
+	for i := 0; i < paramLength; {
+		for _, b := range buf {
+			if i >= paramLength {
+				break
+			}
+			if int(b) >= limit {
+				continue
+			}
+			if i > 0 && i%5 == 0 {
+				sb.WriteByte('-')
+			}
+			sb.WriteByte(recoveryCodeAlphabet[b%n])
+			i++
+		}
+		if i < paramLength {
+			if _, err := rand.Read(buf); err != nil {
+				return "", err
+			}
+		}
+	}
+	return sb.String(), nil
+}
+
+// 规范化用户输入的恢复码：去掉分隔符和空格，转小写
+func NormalizeRecoveryCode(paramCode string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToLower(strings.TrimSpace(paramCode)))
+}
+
+/*
+验证恢复码，返回匹配的哈希下标，没有匹配返回 -1
+
+调用方验证成功后需要删除对应下标的哈希，保证每个恢复码只能使用一次
+*/
+func VerifyRecoveryCode(paramHashes []string, paramCode string) int {
+	code := NormalizeRecoveryCode(paramCode)
+	if code == "" {
+		return -1
+	}
+	for i, hash := range paramHashes {
+		if pwdutils.PasswordVerify(hash, code) {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,77 @@
+package otputils
+
+import (
+	"context"
+	"time"
+
+	redis "github.com/go-redis/redis/v8"
+)
+
+/*
+基于Redis的 TOTP 防重放
+
+按 RFC 6238 §5.2，每个账号记录最后一次验证通过的时间步，
+之后该时间步以及更早的时间步的验证码都不能再使用，即使还在允许偏移的窗口内
+*/
+type OTPReplayGuard struct {
+	cli       *redis.Client
+	keyPrefix string
+}
+
+/*
+创建一个 TOTP 防重放工具
+
+  - paramCli
+  - paramKeyPrefix redis key 前缀
+*/
+func CreateOTPReplayGuard(paramCli *redis.Client, paramKeyPrefix string) *OTPReplayGuard {
+	return &OTPReplayGuard{
+		cli:       paramCli,
+		keyPrefix: paramKeyPrefix,
+	}
+}
+
+func (g *OTPReplayGuard) lastStepKey(paramAccount string) string {
+	return g.keyPrefix + paramAccount
+}
+
+// KEYS: 账号最后使用的时间步
+// ARGV: 时间步, 保留时间(毫秒)
+var otpMarkUsedScript = redis.NewScript(`
+local last = redis.call('GET', KEYS[1])
+if last and tonumber(ARGV[1]) <= tonumber(last) then
+	return 0
+end
+redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+return 1
+`)
+
+/*
+标记时间步为已使用，该时间步不晚于最后一次使用的时间步时返回 false
+
+  - paramAccount 账号
+  - paramStep VerifyTOTP 返回的时间步
+  - paramConfig 配置，用于计算记录的保留时间
+*/
+func (g *OTPReplayGuard) MarkUsed(ctx context.Context, paramAccount string, paramStep int64, paramConfig OTPConfig) (bool, error) {
+	// 保留到该时间步以及更早的时间步都不可能再通过验证为止
+	ttl := time.Duration(paramConfig.period()*int64(2*paramConfig.skew()+1)) * time.Second
+	ok, err := otpMarkUsedScript.Run(ctx, g.cli, []string{g.lastStepKey(paramAccount)}, paramStep, ttl.Milliseconds()).Int()
+	return ok == 1, err
+}
+
+/*
+验证 TOTP 验证码，并保证同一个验证码只能使用一次，使用过之后更早的验证码也不能再使用
+
+  - paramAccount 账号
+  - paramSecret base32 编码的密钥
+  - paramCode 用户输入的验证码
+  - paramConfig 配置
+*/
+func (g *OTPReplayGuard) VerifyTOTP(ctx context.Context, paramAccount string, paramSecret string, paramCode string, paramConfig OTPConfig) (bool, error) {
+	step, ok, err := VerifyTOTP(paramSecret, paramCode, time.Now(), paramConfig)
+	if err != nil || !ok {
+		return false, err
+	}
+	return g.MarkUsed(ctx, paramAccount, step, paramConfig)
+}
@@ -0,0 +1,74 @@
+package otputils
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	redis "github.com/go-redis/redis/v8"
+)
+
+func newTestReplayGuard(t *testing.T) (*OTPReplayGuard, *miniredis.Miniredis) {
+	mr := miniredis.RunT(t)
+	cli := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { cli.Close() })
+	return CreateOTPReplayGuard(cli, "otp:"), mr
+}
+
+func TestReplayGuardMarkUsed(t *testing.T) {
+	ctx := context.Background()
+	guard, mr := newTestReplayGuard(t)
+	cfg := DefaultOTPConfig()
+
+	if ok, err := guard.MarkUsed(ctx, "alice", 100, cfg); err != nil || !ok {
+		t.Fatalf("MarkUsed = %v, %v", ok, err)
+	}
+	if ok, _ := guard.MarkUsed(ctx, "alice", 100, cfg); ok {
+		t.Error("same step accepted twice")
+	}
+	// 使用过的时间步之前的验证码即使在偏移窗口内也不能再使用
+	if ok, _ := guard.MarkUsed(ctx, "alice", 99, cfg); ok {
+		t.Error("older step accepted")
+	}
+	if ok, _ := guard.MarkUsed(ctx, "alice", 101, cfg); !ok {
+		t.Error("newer step rejected")
+	}
+	if ok, _ := guard.MarkUsed(ctx, "bob", 99, cfg); !ok {
+		t.Error("other account rejected")
+	}
+	if ttl := mr.TTL("otp:alice"); ttl != 90*time.Second {
+		t.Errorf("ttl = %v", ttl)
+	}
+	// 负的偏移按0处理，保留一个时间步
+	cfg.Skew = -2
+	if ok, err := guard.MarkUsed(ctx, "carol", 100, cfg); err != nil || !ok {
+		t.Fatalf("negative skew MarkUsed = %v, %v", ok, err)
+	}
+	if ttl := mr.TTL("otp:carol"); ttl != 30*time.Second {
+		t.Errorf("negative skew ttl = %v", ttl)
+	}
+}
+
+func TestReplayGuardVerifyTOTP(t *testing.T) {
+	ctx := context.Background()
+	guard, _ := newTestReplayGuard(t)
+	cfg := DefaultOTPConfig()
+	secret, err := GenerateSecret(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	prev, _ := GenerateTOTP(secret, now.Add(-30*time.Second), cfg)
+	code, _ := GenerateTOTP(secret, now, cfg)
+
+	if ok, err := guard.VerifyTOTP(ctx, "alice", secret, code, cfg); err != nil || !ok {
+		t.Fatalf("VerifyTOTP = %v, %v", ok, err)
+	}
+	if ok, _ := guard.VerifyTOTP(ctx, "alice", secret, code, cfg); ok {
+		t.Error("code reused")
+	}
+	if ok, _ := guard.VerifyTOTP(ctx, "alice", secret, prev, cfg); ok {
+		t.Error("older code accepted after a newer one")
+	}
+}
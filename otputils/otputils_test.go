@@ -0,0 +1,114 @@
+package otputils
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testSecret(paramSeed string, paramSize int) string {
+	key := strings.Repeat(paramSeed, paramSize/len(paramSeed)+1)[:paramSize]
+	return base32.StdEncoding.EncodeToString([]byte(key))
+}
+
+// RFC 4226 附录D的测试数据
+func TestGenerateHOTP(t *testing.T) {
+	secret := testSecret("1234567890", 20)
+	want := []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+	for i, w := range want {
+		code, err := GenerateHOTP(secret, uint64(i), DefaultOTPConfig())
+		if err != nil {
+			t.Fatalf("GenerateHOTP error: %v", err)
+		}
+		if code != w {
+			t.Errorf("GenerateHOTP(%d) = %s, want %s", i, code, w)
+		}
+	}
+
+	cfg := DefaultOTPConfig()
+	cfg.Skew = 3
+	counter, ok, err := VerifyHOTP(secret, "969429", 1, cfg)
+	if err != nil || !ok || counter != 3 {
+		t.Errorf("VerifyHOTP = %d, %v, %v", counter, ok, err)
+	}
+	if _, ok, _ := VerifyHOTP(secret, "969429", 4, cfg); ok {
+		t.Error("VerifyHOTP should not accept a past counter")
+	}
+}
+
+// RFC 6238 附录B的测试数据
+func TestGenerateTOTP(t *testing.T) {
+	secrets := map[Algorithm]string{
+		ALGORITHM_SHA1:   testSecret("1234567890", 20),
+		ALGORITHM_SHA256: testSecret("1234567890", 32),
+		ALGORITHM_SHA512: testSecret("1234567890", 64),
+	}
+	cases := []struct {
+		unix int64
+		want map[Algorithm]string
+	}{
+		{59, map[Algorithm]string{ALGORITHM_SHA1: "94287082", ALGORITHM_SHA256: "46119246", ALGORITHM_SHA512: "90693936"}},
+		{1111111109, map[Algorithm]string{ALGORITHM_SHA1: "07081804", ALGORITHM_SHA256: "68084774", ALGORITHM_SHA512: "25091201"}},
+		{2000000000, map[Algorithm]string{ALGORITHM_SHA1: "69279037", ALGORITHM_SHA256: "90698825", ALGORITHM_SHA512: "38618901"}},
+	}
+	for _, c := range cases {
+		for algo, want := range c.want {
+			cfg := OTPConfig{Digits: 8, Period: 30, Algorithm: algo}
+			code, err := GenerateTOTP(secrets[algo], time.Unix(c.unix, 0), cfg)
+			if err != nil {
+				t.Fatalf("GenerateTOTP error: %v", err)
+			}
+			if code != want {
+				t.Errorf("GenerateTOTP(%d, %s) = %s, want %s", c.unix, algo, code, want)
+			}
+		}
+	}
+}
+
+func TestVerifyTOTPSkew(t *testing.T) {
+	secret, err := GenerateSecret(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := DefaultOTPConfig()
+	now := time.Unix(1700000000, 0)
+	prev, _ := GenerateTOTP(secret, now.Add(-30*time.Second), cfg)
+	if step, ok, _ := VerifyTOTP(secret, prev, now, cfg); !ok || step != TimeStep(now, cfg)-1 {
+		t.Errorf("VerifyTOTP previous step = %d, %v", step, ok)
+	}
+	old, _ := GenerateTOTP(secret, now.Add(-90*time.Second), cfg)
+	if _, ok, _ := VerifyTOTP(secret, old, now, cfg); ok {
+		t.Error("VerifyTOTP should reject codes outside the skew window")
+	}
+	if _, _, err := VerifyTOTP("not base32!", "123456", now, cfg); err != ErrOTPSecret {
+		t.Errorf("VerifyTOTP bad secret err = %v", err)
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri := TOTPURI("My App", "alice@example.com", "JBSWY3DPEHPK3PXP", DefaultOTPConfig())
+	want := "otpauth://totp/My%20App:alice@example.com?algorithm=SHA1&digits=6&issuer=My%20App&period=30&secret=JBSWY3DPEHPK3PXP"
+	if uri != want {
+		t.Errorf("TOTPURI = %s, want %s", uri, want)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, hashes, err := GenerateRecoveryCodes(3, 10)
+	if err != nil {
+		t.Fatalf("GenerateRecoveryCodes error: %v", err)
+	}
+	if len(codes) != 3 || len(hashes) != 3 {
+		t.Fatalf("got %d codes, %d hashes", len(codes), len(hashes))
+	}
+	if len(codes[0]) != 11 || codes[0][5] != '-' {
+		t.Errorf("unexpected code format: %s", codes[0])
+	}
+	if idx := VerifyRecoveryCode(hashes, strings.ToUpper(codes[1])); idx != 1 {
+		t.Errorf("VerifyRecoveryCode = %d, want 1", idx)
+	}
+	if idx := VerifyRecoveryCode(hashes, "aaaaa-aaaaa"); idx != -1 {
+		t.Errorf("VerifyRecoveryCode wrong code = %d, want -1", idx)
+	}
+}
@@ -0,0 +1,358 @@
+package pwdutils
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"math"
+	"os"
+	"strings"
+
+	"github.com/qiuliaogit/commonutils/commonutils"
+)
+
+// 泄露密码检查接口
+type BreachedChecker interface {
+	// 判断密码是否在泄露密码库中
+	IsBreached(paramPassword string) (bool, error)
+}
+
+// 计算密码的SHA-1，返回大写的十六进制字符串(HIBP文件使用的格式)
+func PasswordSHA1Hex(paramPassword string) string {
+	sum := sha1.Sum([]byte(paramPassword))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+/*
+基于本地HIBP格式文件的泄露密码检查
+
+文件每行的格式为 "SHA1大写十六进制:出现次数"，并按哈希值升序排列
+(即 Pwned Passwords 的 "ordered by hash" 版本)。
+查询时直接在文件上做二分查找，不会把文件加载到内存中，可以并发使用。
+*/
+type HIBPFileChecker struct {
+	file *os.File
+	size int64
+}
+
+/*
+打开一个HIBP格式的文件
+
+  - paramPath 文件路径
+*/
+func OpenHIBPFileChecker(paramPath string) (*HIBPFileChecker, error) {
+	f, err := os.Open(paramPath)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &HIBPFileChecker{file: f, size: info.Size()}, nil
+}
+
+// 关闭文件
+func (c *HIBPFileChecker) Close() error {
+	return c.file.Close()
+}
+
+// 判断密码是否在泄露密码库中
+func (c *HIBPFileChecker) IsBreached(paramPassword string) (bool, error) {
+	count, err := c.LookupHash(PasswordSHA1Hex(paramPassword))
+	return count > 0, err
+}
+
+/*
+按SHA-1查找出现次数，没有找到返回0
+
+  - paramSHA1Hex SHA-1的十六进制字符串，大小写均可
+*/
+func (c *HIBPFileChecker) LookupHash(paramSHA1Hex string) (int64, error) {
+	target := []byte(strings.ToUpper(paramSHA1Hex))
+
+	// 不变量：目标行(如果存在)的起始位置在 [lo, hi) 之间，lo 始终是某一行的起始位置
+	lo, hi := int64(0), c.size
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		start, err := c.lineStartFrom(mid)
+		if err != nil {
+			return 0, err
+		}
+		if start >= hi {
+			// [mid, hi) 之间没有新的行开始
+			hi = mid
+			continue
+		}
+		line, next, err := c.readLine(start)
+		if err != nil {
+			return 0, err
+		}
+		hash, count := splitHIBPLine(line)
+		switch bytes.Compare(hash, target) {
+		case 0:
+			return count, nil
+		case -1:
+			lo = next
+		default:
+			hi = start
+		}
+	}
+	return 0, nil
+}
+
+// 返回 >= paramPos 的第一个行起始位置
+func (c *HIBPFileChecker) lineStartFrom(paramPos int64) (int64, error) {
+	if paramPos == 0 {
+		return 0, nil
+	}
+	var buf [128]byte
+	pos := paramPos - 1
+	for pos < c.size {
+		n, err := c.file.ReadAt(buf[:], pos)
+		if n == 0 && err != nil {
+			return 0, err
+		}
+		if i := bytes.IndexByte(buf[:n], '\n'); i >= 0 {
+			return pos + int64(i) + 1, nil
+		}
+		pos += int64(n)
+	}
+	return c.size, nil
+}
+
+// 读取从 paramStart 开始的一行，返回行内容(不含换行符)和下一行的起始位置
+func (c *HIBPFileChecker) readLine(paramStart int64) ([]byte, int64, error) {
+	var line []byte
+	var buf [128]byte
+	pos := paramStart
+	for pos < c.size {
+		n, err := c.file.ReadAt(buf[:], pos)
+		if n == 0 && err != nil {
+			return nil, 0, err
+		}
+		if i := bytes.IndexByte(buf[:n], '\n'); i >= 0 {
+			line = append(line, buf[:i]...)
+			return bytes.TrimRight(line, "\r"), pos + int64(i) + 1, nil
+		}
+		line = append(line, buf[:n]...)
+		pos += int64(n)
+	}
+	return bytes.TrimRight(line, "\r"), c.size, nil
+}
+
+// 拆分 "HASH:COUNT" 格式的行，没有次数时按1计算
+func splitHIBPLine(paramLine []byte) ([]byte, int64) {
+	hash, countStr, found := bytes.Cut(paramLine, []byte{':'})
+	hash = bytes.ToUpper(bytes.TrimSpace(hash))
+	if !found {
+		return hash, 1
+	}
+	count, err := commonutils.Str2Int[int64](string(bytes.TrimSpace(countStr)))
+	if err != nil || count <= 0 {
+		count = 1
+	}
+	return hash, count
+}
+
+const bloomFilterMagic = "PWBF"
+
+/*
+布隆过滤器，用于紧凑地保存泄露密码的SHA-1集合
+
+存在一定的误判率(不在库中的密码被判定为泄露)，但不会漏判。
+位置由SHA-1本身派生(双重哈希)，所以不需要额外的哈希计算。
+*/
+type BloomFilter struct {
+	bits []uint64
+	m    uint64 // 位数
+	k    uint32 // 哈希函数个数
+}
+
+/*
+按预计元素数量和误判率创建布隆过滤器
+
+  - paramExpectedItems 预计的元素数量
+  - paramFalsePositiveRate 期望的误判率，如 0.001
+*/
+func NewBloomFilter(paramExpectedItems uint64, paramFalsePositiveRate float64) *BloomFilter {
+	if paramExpectedItems == 0 {
+		paramExpectedItems = 1
+	}
+	if paramFalsePositiveRate <= 0 || paramFalsePositiveRate >= 1 {
+		paramFalsePositiveRate = 0.001
+	}
+	n := float64(paramExpectedItems)
+	m := uint64(math.Ceil(-n * math.Log(paramFalsePositiveRate) / (math.Ln2 * math.Ln2)))
+	k := uint32(math.Round(float64(m) / n * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	return newBloomFilter(m, k)
+}
+
+func newBloomFilter(paramBits uint64, paramK uint32) *BloomFilter {
+	words := (paramBits + 63) / 64
+	return &BloomFilter{
+		bits: make([]uint64, words),
+		m:    words * 64,
+		k:    paramK,
+	}
+}
+
+// 由SHA-1计算第 i 个位置
+func (b *BloomFilter) locations(paramSum [sha1.Size]byte, paramFn func(uint64)) {
+	h1 := binary.BigEndian.Uint64(paramSum[0:8])
+	h2 := binary.BigEndian.Uint64(paramSum[8:16]) | 1
+	for i := uint32(0); i < b.k; i++ {
+		paramFn((h1 + uint64(i)*h2) % b.m)
+	}
+}
+
+// 加入一个SHA-1
+func (b *BloomFilter) AddSum(paramSum [sha1.Size]byte) {
+	b.locations(paramSum, func(pos uint64) {
+		b.bits[pos/64] |= 1 << (pos % 64)
+	})
+}
+
+// 判断SHA-1是否可能存在
+func (b *BloomFilter) TestSum(paramSum [sha1.Size]byte) bool {
+	ret := true
+	b.locations(paramSum, func(pos uint64) {
+		if b.bits[pos/64]&(1<<(pos%64)) == 0 {
+			ret = false
+		}
+	})
+	return ret
+}
+
+// 加入一个十六进制表示的SHA-1
+func (b *BloomFilter) AddHex(paramSHA1Hex string) error {
+	sum, err := parseSHA1Hex(paramSHA1Hex)
+	if err != nil {
+		return err
+	}
+	b.AddSum(sum)
+	return nil
+}
+
+// 加入一个明文密码
+func (b *BloomFilter) AddPassword(paramPassword string) {
+	b.AddSum(sha1.Sum([]byte(paramPassword)))
+}
+
+// 判断密码是否在泄露密码库中(可能误判)
+func (b *BloomFilter) IsBreached(paramPassword string) (bool, error) {
+	return b.TestSum(sha1.Sum([]byte(paramPassword))), nil
+}
+
+// 序列化到 paramWriter
+func (b *BloomFilter) WriteTo(paramWriter io.Writer) (int64, error) {
+	w := bufio.NewWriter(paramWriter)
+	var header [16]byte
+	copy(header[0:4], bloomFilterMagic)
+	binary.BigEndian.PutUint32(header[4:8], b.k)
+	binary.BigEndian.PutUint64(header[8:16], b.m)
+	if _, err := w.Write(header[:]); err != nil {
+		return 0, err
+	}
+	var word [8]byte
+	for _, v := range b.bits {
+		binary.BigEndian.PutUint64(word[:], v)
+		if _, err := w.Write(word[:]); err != nil {
+			return 0, err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return 0, err
+	}
+	return int64(len(header) + len(b.bits)*8), nil
+}
+
+// 从 paramReader 读取由 WriteTo 写出的布隆过滤器
+func ReadBloomFilter(paramReader io.Reader) (*BloomFilter, error) {
+	r := bufio.NewReader(paramReader)
+	var header [16]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	if string(header[0:4]) != bloomFilterMagic {
+		return nil, commonutils.NewError(commonutils.ERR_FAIL, "布隆过滤器文件格式错误")
+	}
+	k := binary.BigEndian.Uint32(header[4:8])
+	m := binary.BigEndian.Uint64(header[8:16])
+	if k == 0 || m == 0 || m%64 != 0 {
+		return nil, commonutils.NewError(commonutils.ERR_FAIL, "布隆过滤器文件参数错误")
+	}
+	b := newBloomFilter(m, k)
+	var word [8]byte
+	for i := range b.bits {
+		if _, err := io.ReadFull(r, word[:]); err != nil {
+			return nil, err
+		}
+		b.bits[i] = binary.BigEndian.Uint64(word[:])
+	}
+	return b, nil
+}
+
+/*
+从HIBP格式的文件构建布隆过滤器，文件会被读取两遍(统计行数、加入元素)
+
+  - paramPath 文件路径
+  - paramFalsePositiveRate 期望的误判率
+*/
+func BuildBloomFilterFromHIBPFile(paramPath string, paramFalsePositiveRate float64) (*BloomFilter, error) {
+	var count uint64
+	err := scanHIBPFile(paramPath, func([]byte) error {
+		count++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	b := NewBloomFilter(count, paramFalsePositiveRate)
+	err = scanHIBPFile(paramPath, func(hash []byte) error {
+		return b.AddHex(string(hash))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func scanHIBPFile(paramPath string, paramFn func([]byte) error) error {
+	f, err := os.Open(paramPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		hash, _ := splitHIBPLine(line)
+		if err := paramFn(hash); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func parseSHA1Hex(paramSHA1Hex string) ([sha1.Size]byte, error) {
+	var sum [sha1.Size]byte
+	if len(paramSHA1Hex) != sha1.Size*2 {
+		return sum, commonutils.NewError(commonutils.ERR_FAIL, "SHA-1格式错误："+paramSHA1Hex)
+	}
+	if _, err := hex.Decode(sum[:], []byte(paramSHA1Hex)); err != nil {
+		return sum, commonutils.NewError(commonutils.ERR_FAIL, "SHA-1格式错误："+paramSHA1Hex)
+	}
+	return sum, nil
+}
@@ -0,0 +1,115 @@
+package pwdutils
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// 生成一个按哈希排序的HIBP格式测试文件
+func writeHIBPFile(t *testing.T, paramPasswords []string) string {
+	t.Helper()
+	hashes := make([]string, 0, len(paramPasswords))
+	for _, p := range paramPasswords {
+		hashes = append(hashes, PasswordSHA1Hex(p))
+	}
+	sort.Strings(hashes)
+
+	var buf bytes.Buffer
+	for i, h := range hashes {
+		buf.WriteString(h + ":" + strconv.Itoa(i+1) + "\r\n")
+	}
+	path := filepath.Join(t.TempDir(), "pwned.txt")
+	if err := os.WriteFile(path, buf.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func testBreachedPasswords() []string {
+	list := []string{"password", "123456", "qwerty", "letmein", "Passw0rd"}
+	for i := 0; i < 200; i++ {
+		list = append(list, "leaked-"+strconv.Itoa(i))
+	}
+	return list
+}
+
+func TestHIBPFileChecker(t *testing.T) {
+	passwords := testBreachedPasswords()
+	checker, err := OpenHIBPFileChecker(writeHIBPFile(t, passwords))
+	if err != nil {
+		t.Fatalf("OpenHIBPFileChecker error: %v", err)
+	}
+	defer checker.Close()
+
+	for _, p := range passwords {
+		breached, err := checker.IsBreached(p)
+		if err != nil {
+			t.Fatalf("IsBreached(%q) error: %v", p, err)
+		}
+		if !breached {
+			t.Errorf("IsBreached(%q) = false, want true", p)
+		}
+	}
+	for _, p := range []string{"not-leaked", "mySecret123!", ""} {
+		breached, err := checker.IsBreached(p)
+		if err != nil {
+			t.Fatalf("IsBreached(%q) error: %v", p, err)
+		}
+		if breached {
+			t.Errorf("IsBreached(%q) = true, want false", p)
+		}
+	}
+
+	// 小写的哈希也能查到
+	count, err := checker.LookupHash(strings.ToLower(PasswordSHA1Hex("password")))
+	if err != nil || count <= 0 {
+		t.Errorf("LookupHash = %d, %v", count, err)
+	}
+}
+
+func TestBloomFilter(t *testing.T) {
+	passwords := testBreachedPasswords()
+	b, err := BuildBloomFilterFromHIBPFile(writeHIBPFile(t, passwords), 0.001)
+	if err != nil {
+		t.Fatalf("BuildBloomFilterFromHIBPFile error: %v", err)
+	}
+	for _, p := range passwords {
+		if ok, _ := b.IsBreached(p); !ok {
+			t.Errorf("IsBreached(%q) = false, want true", p)
+		}
+	}
+
+	// 序列化后再读取，结果保持一致
+	var buf bytes.Buffer
+	if _, err := b.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo error: %v", err)
+	}
+	loaded, err := ReadBloomFilter(&buf)
+	if err != nil {
+		t.Fatalf("ReadBloomFilter error: %v", err)
+	}
+	falsePositive := 0
+	for i := 0; i < 1000; i++ {
+		p := "clean-" + strconv.Itoa(i)
+		a, _ := b.IsBreached(p)
+		c, _ := loaded.IsBreached(p)
+		if a != c {
+			t.Fatalf("loaded filter differs for %q", p)
+		}
+		if a {
+			falsePositive++
+		}
+	}
+	if falsePositive > 20 {
+		t.Errorf("too many false positives: %d/1000", falsePositive)
+	}
+
+	if _, err := ReadBloomFilter(bytes.NewReader([]byte("bad data here..."))); err == nil {
+		t.Error("ReadBloomFilter should fail on bad data")
+	}
+}
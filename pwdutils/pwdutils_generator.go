@@ -0,0 +1,279 @@
+package pwdutils
+
+import (
+	"crypto/rand"
+	_ "embed"
+	"math/big"
+	"strings"
+
+	"github.com/qiuliaogit/commonutils/commonutils"
+)
+
+// 密码生成方式
+type PasswordGenerateMode int
+
+const (
+	GENERATE_MODE_RANDOM        PasswordGenerateMode = iota // 随机字符
+	GENERATE_MODE_PRONOUNCEABLE                             // 可读的音节组合
+	GENERATE_MODE_PASSPHRASE                                // diceware 风格的单词组合
+)
+
+const (
+	charsUpper     = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	charsLower     = "abcdefghijklmnopqrstuvwxyz"
+	charsDigit     = "0123456789"
+	charsSymbol    = "!@#$%^&*-_=+?"
+	charsAmbiguous = "0Oo1lI|"
+	charsConsonant = "bcdfghjkmnprstvwxz"
+	charsVowel     = "aeiuy"
+)
+
+// 生成失败时的最大重试次数(如生成结果恰好在泄露密码库中)
+const maxGenerateAttempts = 20
+
+// 内嵌的单词表，共 6^4 = 1296 个单词，每个单词约 10.3 位熵
+//
+//go:embed pwdutils_wordlist.txt
+var wordlistData string
+
+var passphraseWords = strings.Fields(wordlistData)
+
+// 密码生成器，随机数来自 crypto/rand
+type PasswordGenerator struct {
+	Mode             PasswordGenerateMode
+	Length           int             // 随机字符和音节方式的长度
+	Words            int             // 单词组合方式的单词数量
+	Separator        string          // 单词之间的分隔符
+	RequireUpper     bool            // 是否包含大写字母
+	RequireDigit     bool            // 是否包含数字
+	RequireSymbol    bool            // 是否包含特殊字符
+	ExcludeAmbiguous bool            // 是否排除容易混淆的字符 0/O/o、1/l/I
+	Policy           *PasswordPolicy // 可选，生成的密码必须通过该策略
+}
+
+// 默认的生成器：16位随机字符，包含大小写字母、数字和特殊字符，排除容易混淆的字符
+func DefaultPasswordGenerator() *PasswordGenerator {
+	return &PasswordGenerator{
+		Mode:             GENERATE_MODE_RANDOM,
+		Length:           16,
+		Words:            6,
+		Separator:        "-",
+		RequireUpper:     true,
+		RequireDigit:     true,
+		RequireSymbol:    true,
+		ExcludeAmbiguous: true,
+	}
+}
+
+/*
+按密码策略创建生成器，生成的密码一定满足该策略
+
+  - paramMode 生成方式
+  - paramPolicy 密码策略
+*/
+func NewPasswordGeneratorForPolicy(paramMode PasswordGenerateMode, paramPolicy *PasswordPolicy) *PasswordGenerator {
+	g := DefaultPasswordGenerator()
+	g.Mode = paramMode
+	g.RequireUpper = paramPolicy.RequireUpper
+	g.RequireDigit = paramPolicy.RequireDigit
+	g.RequireSymbol = paramPolicy.RequireSymbol
+	g.Policy = paramPolicy
+	if paramPolicy.MinLength > g.Length {
+		g.Length = paramPolicy.MinLength
+	}
+	if paramPolicy.MaxLength > 0 && g.Length > paramPolicy.MaxLength {
+		g.Length = paramPolicy.MaxLength
+	}
+	return g
+}
+
+// 生成一个密码
+func (g *PasswordGenerator) Generate() (string, error) {
+	for i := 0; i < maxGenerateAttempts; i++ {
+		var password string
+		var err error
+		switch g.Mode {
+		case GENERATE_MODE_PRONOUNCEABLE:
+			password, err = g.generatePronounceable()
+		case GENERATE_MODE_PASSPHRASE:
+			password, err = g.generatePassphrase()
+		default:
+			password, err = g.generateRandom()
+		}
+		if err != nil {
+			return "", err
+		}
+		if g.Policy == nil || g.Policy.Validate(password) == nil {
+			return password, nil
+		}
+	}
+	return "", commonutils.NewError(commonutils.ERR_FAIL, "无法生成满足策略的密码，请检查生成器和策略的配置")
+}
+
+func (g *PasswordGenerator) filter(paramChars string) string {
+	if !g.ExcludeAmbiguous {
+		return paramChars
+	}
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(charsAmbiguous, r) {
+			return -1
+		}
+		return r
+	}, paramChars)
+}
+
+// 随机字符：每个要求的字符类别至少一个，其余从所有类别中随机选择，最后打乱顺序
+func (g *PasswordGenerator) generateRandom() (string, error) {
+	classes := []string{g.filter(charsLower)}
+	if g.RequireUpper {
+		classes = append(classes, g.filter(charsUpper))
+	}
+	if g.RequireDigit {
+		classes = append(classes, g.filter(charsDigit))
+	}
+	if g.RequireSymbol {
+		classes = append(classes, g.filter(charsSymbol))
+	}
+	if g.Length < len(classes) {
+		return "", commonutils.NewError(commonutils.ERR_FAIL, "密码长度小于要求的字符类别数量")
+	}
+
+	all := strings.Join(classes, "")
+	buf := make([]byte, 0, g.Length)
+	for _, class := range classes {
+		c, err := randomChar(class)
+		if err != nil {
+			return "", err
+		}
+		buf = append(buf, c)
+	}
+	for len(buf) < g.Length {
+		c, err := randomChar(all)
+		if err != nil {
+			return "", err
+		}
+		buf = append(buf, c)
+	}
+	if err := shuffle(buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+// 音节方式：辅音+元音交替，首字母大写，末尾追加数字和特殊字符
+func (g *PasswordGenerator) generatePronounceable() (string, error) {
+	letters := g.Length
+	if g.RequireDigit {
+		letters--
+	}
+	if g.RequireSymbol {
+		letters--
+	}
+	if letters < 2 {
+		return "", commonutils.NewError(commonutils.ERR_FAIL, "密码长度太短，无法生成音节")
+	}
+
+	consonants, vowels := g.filter(charsConsonant), g.filter(charsVowel)
+	buf := make([]byte, 0, g.Length)
+	for i := 0; i < letters; i++ {
+		pool := consonants
+		if i%2 == 1 {
+			pool = vowels
+		}
+		c, err := randomChar(pool)
+		if err != nil {
+			return "", err
+		}
+		buf = append(buf, c)
+	}
+	if g.RequireUpper {
+		buf[0] = buf[0] - 'a' + 'A'
+	}
+	if g.RequireDigit {
+		c, err := randomChar(g.filter(charsDigit))
+		if err != nil {
+			return "", err
+		}
+		buf = append(buf, c)
+	}
+	if g.RequireSymbol {
+		c, err := randomChar(g.filter(charsSymbol))
+		if err != nil {
+			return "", err
+		}
+		buf = append(buf, c)
+	}
+	return string(buf), nil
+}
+
+// 单词组合方式：从内嵌单词表中随机选择单词，按要求首字母大写、追加数字
+func (g *PasswordGenerator) generatePassphrase() (string, error) {
+	count := g.Words
+	if count <= 0 {
+		count = 6
+	}
+	words := make([]string, 0, count)
+	length := 0
+	for len(words) < count || (g.Policy != nil && length < g.Policy.MinLength) {
+		n, err := randomInt(len(passphraseWords))
+		if err != nil {
+			return "", err
+		}
+		word := passphraseWords[n]
+		if g.RequireUpper {
+			word = strings.ToUpper(word[:1]) + word[1:]
+		}
+		words = append(words, word)
+		length += len(word) + len(g.Separator)
+	}
+
+	if g.RequireDigit {
+		n, err := randomInt(len(words))
+		if err != nil {
+			return "", err
+		}
+		c, err := randomChar(g.filter(charsDigit))
+		if err != nil {
+			return "", err
+		}
+		words[n] += string(c)
+	}
+	password := strings.Join(words, g.Separator)
+	if g.RequireSymbol && !strings.ContainsAny(g.Separator, charsSymbol) {
+		c, err := randomChar(g.filter(charsSymbol))
+		if err != nil {
+			return "", err
+		}
+		password += string(c)
+	}
+	return password, nil
+}
+
+// 返回 [0, paramMax) 之间均匀分布的随机数
+func randomInt(paramMax int) (int, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(paramMax)))
+	if err != nil {
+		return 0, err
+	}
+	return int(n.Int64()), nil
+}
+
+func randomChar(paramChars string) (byte, error) {
+	n, err := randomInt(len(paramChars))
+	if err != nil {
+		return 0, err
+	}
+	return paramChars[n], nil
+}
+
+// Fisher-Yates 洗牌
+func shuffle(paramBuf []byte) error {
+	for i := len(paramBuf) - 1; i > 0; i-- {
+		j, err := randomInt(i + 1)
+		if err != nil {
+			return err
+		}
+		paramBuf[i], paramBuf[j] = paramBuf[j], paramBuf[i]
+	}
+	return nil
+}
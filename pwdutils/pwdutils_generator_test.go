@@ -0,0 +1,62 @@
+package pwdutils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPasswordGeneratorRandom(t *testing.T) {
+	g := DefaultPasswordGenerator()
+	seen := make(map[string]bool)
+	for i := 0; i < 50; i++ {
+		p, err := g.Generate()
+		if err != nil {
+			t.Fatalf("Generate error: %v", err)
+		}
+		if len(p) != g.Length {
+			t.Errorf("len(%q) = %d, want %d", p, len(p), g.Length)
+		}
+		if strings.ContainsAny(p, charsAmbiguous) {
+			t.Errorf("password %q contains ambiguous characters", p)
+		}
+		if !strings.ContainsAny(p, charsUpper) || !strings.ContainsAny(p, charsDigit) || !strings.ContainsAny(p, charsSymbol) {
+			t.Errorf("password %q misses a required class", p)
+		}
+		seen[p] = true
+	}
+	if len(seen) != 50 {
+		t.Errorf("generated duplicate passwords: %d unique of 50", len(seen))
+	}
+}
+
+func TestPasswordGeneratorForPolicy(t *testing.T) {
+	policy := DefaultPasswordPolicy()
+	policy.RequireSymbol = true
+	policy.MinLength = 20
+	for _, mode := range []PasswordGenerateMode{GENERATE_MODE_RANDOM, GENERATE_MODE_PRONOUNCEABLE, GENERATE_MODE_PASSPHRASE} {
+		g := NewPasswordGeneratorForPolicy(mode, policy)
+		for i := 0; i < 20; i++ {
+			p, err := g.Generate()
+			if err != nil {
+				t.Fatalf("mode %d Generate error: %v", mode, err)
+			}
+			if err := policy.Validate(p); err != nil {
+				t.Errorf("mode %d password %q violates policy: %v", mode, p, err)
+			}
+		}
+	}
+}
+
+func TestPasswordGeneratorPassphrase(t *testing.T) {
+	if len(passphraseWords) != 1296 {
+		t.Fatalf("wordlist has %d words, want 1296", len(passphraseWords))
+	}
+	g := &PasswordGenerator{Mode: GENERATE_MODE_PASSPHRASE, Words: 5, Separator: " "}
+	p, err := g.Generate()
+	if err != nil {
+		t.Fatalf("Generate error: %v", err)
+	}
+	if n := len(strings.Fields(p)); n != 5 {
+		t.Errorf("passphrase %q has %d words, want 5", p, n)
+	}
+}
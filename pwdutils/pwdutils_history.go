@@ -0,0 +1,188 @@
+package pwdutils
+
+import (
+	"context"
+	"sync"
+
+	redis "github.com/go-redis/redis/v8"
+	"github.com/qiuliaogit/commonutils/commonutils"
+	redisv8 "github.com/qiuliaogit/utilsext/redis_utils_v8"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrPasswordReused        = commonutils.NewError(commonutils.ERR_FAIL, "不能使用最近用过的密码")
+	ErrPasswordHistoryBudget = commonutils.NewError(commonutils.ERR_FAIL, "历史密码检查超出计算量限制")
+)
+
+// 历史密码的存储接口，哈希按从新到旧的顺序保存
+type PasswordHistoryStore interface {
+	// 获取最近的 paramLimit 个密码哈希，从新到旧
+	List(ctx context.Context, paramUserID string, paramLimit int) ([]string, error)
+	// 加入一个密码哈希，只保留最近的 paramLimit 个
+	Push(ctx context.Context, paramUserID string, paramHash string, paramLimit int) error
+}
+
+/*
+历史密码检查，禁止重复使用最近 N 个密码
+
+bcrypt 比较的耗时与 2^cost 成正比，检查 N 个哈希的总耗时可能很长，
+所以按 2^cost 累计计算量，超过最大计算量时停止检查并返回 ErrPasswordHistoryBudget。
+默认的最大计算量为 size * 2^bcrypt.DefaultCost，是固定的上限，不随保存的哈希变化，
+提高 bcrypt cost 后需要用 SetMaxWork 调大。
+*/
+type PasswordHistory struct {
+	store   PasswordHistoryStore
+	size    int
+	maxWork int64
+}
+
+/*
+创建历史密码检查
+
+  - paramStore 存储
+  - paramSize 保留和检查最近多少个密码
+*/
+func NewPasswordHistory(paramStore PasswordHistoryStore, paramSize int) *PasswordHistory {
+	if paramSize <= 0 {
+		paramSize = 5
+	}
+	return &PasswordHistory{
+		store:   paramStore,
+		size:    paramSize,
+		maxWork: int64(paramSize) << bcrypt.DefaultCost,
+	}
+}
+
+/*
+设置检查的最大计算量，单位为 2^cost，默认为 size * 2^bcrypt.DefaultCost
+
+  - paramMaxWork 最大计算量，<=0 表示不限制
+*/
+func (h *PasswordHistory) SetMaxWork(paramMaxWork int64) *PasswordHistory {
+	h.maxWork = paramMaxWork
+	return h
+}
+
+/*
+检查密码是否与最近使用过的密码相同，相同时返回 ErrPasswordReused
+
+  - paramUserID 用户ID
+  - paramPassword 新密码明文
+*/
+func (h *PasswordHistory) Check(ctx context.Context, paramUserID string, paramPassword string) error {
+	hashes, err := h.store.List(ctx, paramUserID, h.size)
+	if err != nil {
+		return err
+	}
+	if len(hashes) > h.size {
+		hashes = hashes[:h.size]
+	}
+
+	maxWork := h.maxWork
+	var work int64
+	for _, hash := range hashes {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		cost, err := bcrypt.Cost([]byte(hash))
+		if err != nil {
+			// 不是有效的bcrypt哈希，无法比较
+			continue
+		}
+		work += int64(1) << cost
+		if maxWork > 0 && work > maxWork {
+			return ErrPasswordHistoryBudget
+		}
+		if PasswordVerify(hash, paramPassword) {
+			return ErrPasswordReused
+		}
+	}
+	return nil
+}
+
+/*
+记录新的密码哈希，一般在修改密码成功后调用
+
+  - paramUserID 用户ID
+  - paramHash PasswordHash 生成的哈希
+*/
+func (h *PasswordHistory) Record(ctx context.Context, paramUserID string, paramHash string) error {
+	return h.store.Push(ctx, paramUserID, paramHash, h.size)
+}
+
+// 基于内存的历史密码存储，用于测试或单机场景
+type MemoryPasswordHistoryStore struct {
+	mu   sync.Mutex
+	data map[string][]string
+}
+
+// 创建基于内存的历史密码存储
+func NewMemoryPasswordHistoryStore() *MemoryPasswordHistoryStore {
+	return &MemoryPasswordHistoryStore{data: make(map[string][]string)}
+}
+
+func (s *MemoryPasswordHistoryStore) List(ctx context.Context, paramUserID string, paramLimit int) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := s.data[paramUserID]
+	if len(list) > paramLimit {
+		list = list[:paramLimit]
+	}
+	return append([]string(nil), list...), nil
+}
+
+func (s *MemoryPasswordHistoryStore) Push(ctx context.Context, paramUserID string, paramHash string, paramLimit int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := append([]string{paramHash}, s.data[paramUserID]...)
+	if len(list) > paramLimit {
+		list = list[:paramLimit]
+	}
+	s.data[paramUserID] = list
+	return nil
+}
+
+// 基于 RedisListUtils 的历史密码存储，每个用户一个列表，新的哈希放在列表头部
+type RedisPasswordHistoryStore struct {
+	cli       *redis.Client
+	keyPrefix string
+	expire    int32
+}
+
+/*
+创建基于Redis的历史密码存储
+
+  - paramCli
+  - paramKeyPrefix 列表key的前缀，完整key为 前缀+用户ID
+  - paramExpire 超时时间，<=0 时表示没有超时， 单位秒
+*/
+func CreateRedisPasswordHistoryStore(paramCli *redis.Client, paramKeyPrefix string, paramExpire int32) *RedisPasswordHistoryStore {
+	return &RedisPasswordHistoryStore{
+		cli:       paramCli,
+		keyPrefix: paramKeyPrefix,
+		expire:    paramExpire,
+	}
+}
+
+func (s *RedisPasswordHistoryStore) list(paramUserID string) *redisv8.RedisListUtils {
+	return redisv8.CreateListUtils(s.cli, s.keyPrefix+paramUserID, s.expire, true)
+}
+
+func (s *RedisPasswordHistoryStore) List(ctx context.Context, paramUserID string, paramLimit int) ([]string, error) {
+	list, err := s.list(paramUserID).Range(ctx, 0, int64(paramLimit)-1).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	return list, err
+}
+
+// 压入和裁剪在同一个 MULTI/EXEC 中执行
+func (s *RedisPasswordHistoryStore) Push(ctx context.Context, paramUserID string, paramHash string, paramLimit int) error {
+	batch := redisv8.CreateTxBatch(s.cli)
+	list := s.list(paramUserID).Batch(batch)
+	list.LPush(ctx, paramHash)
+	list.Trim(ctx, 0, int64(paramLimit)-1)
+	_, err := batch.Exec(ctx)
+	return err
+}
@@ -0,0 +1,124 @@
+package pwdutils
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	redis "github.com/go-redis/redis/v8"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestPasswordHistory(t *testing.T) {
+	ctx := context.Background()
+	history := NewPasswordHistory(NewMemoryPasswordHistoryStore(), 2)
+
+	for _, p := range []string{"first-Pass1", "second-Pass2", "third-Pass3"} {
+		hash, err := PasswordHash(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := history.Record(ctx, "u1", hash); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := history.Check(ctx, "u1", "third-Pass3"); err != ErrPasswordReused {
+		t.Errorf("Check latest = %v, want ErrPasswordReused", err)
+	}
+	if err := history.Check(ctx, "u1", "second-Pass2"); err != ErrPasswordReused {
+		t.Errorf("Check previous = %v, want ErrPasswordReused", err)
+	}
+	// 只保留最近2个，最早的密码可以再次使用
+	if err := history.Check(ctx, "u1", "first-Pass1"); err != nil {
+		t.Errorf("Check dropped = %v, want nil", err)
+	}
+	if err := history.Check(ctx, "u2", "third-Pass3"); err != nil {
+		t.Errorf("Check other user = %v, want nil", err)
+	}
+
+	history.SetMaxWork(1)
+	if err := history.Check(ctx, "u1", "new-Pass4"); err != ErrPasswordHistoryBudget {
+		t.Errorf("Check over budget = %v, want ErrPasswordHistoryBudget", err)
+	}
+}
+
+func TestPasswordHistoryRaisedCost(t *testing.T) {
+	ctx := context.Background()
+	history := NewPasswordHistory(NewMemoryPasswordHistoryStore(), 2)
+	// 提高 cost 后保存的哈希，计算量超过默认的 size * 2^DefaultCost
+	for _, p := range []string{"first-Pass1", "second-Pass2"} {
+		hash, err := bcrypt.GenerateFromPassword([]byte(p), bcrypt.DefaultCost+1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := history.Record(ctx, "u1", string(hash)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := history.Check(ctx, "u1", "new-Pass3"); err != ErrPasswordHistoryBudget {
+		t.Errorf("Check default budget = %v, want ErrPasswordHistoryBudget", err)
+	}
+
+	history.SetMaxWork(2 << (bcrypt.DefaultCost + 1))
+	if err := history.Check(ctx, "u1", "first-Pass1"); err != ErrPasswordReused {
+		t.Errorf("Check = %v, want ErrPasswordReused", err)
+	}
+	if err := history.Check(ctx, "u1", "new-Pass3"); err != nil {
+		t.Errorf("Check new = %v, want nil", err)
+	}
+}
+
+func TestPasswordHistoryBudgetFixed(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryPasswordHistoryStore()
+	history := NewPasswordHistory(store, 5)
+	hash, err := PasswordHash("first-Pass1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 保存的哈希 cost 很大时，最大计算量不会随之变大，在比较之前就停止
+	expensive := strings.Replace(hash, fmt.Sprintf("$%02d$", bcrypt.DefaultCost), "$31$", 1)
+	if c, err := bcrypt.Cost([]byte(expensive)); err != nil || c != 31 {
+		t.Fatalf("cost = %d, %v", c, err)
+	}
+	store.Push(ctx, "u1", hash, 5)
+	store.Push(ctx, "u1", expensive, 5)
+	begin := time.Now()
+	if err := history.Check(ctx, "u1", "new-Pass2"); err != ErrPasswordHistoryBudget {
+		t.Fatalf("Check = %v, want ErrPasswordHistoryBudget", err)
+	}
+	if d := time.Since(begin); d > time.Second {
+		t.Fatalf("Check took %v", d)
+	}
+}
+
+func TestRedisPasswordHistoryStore(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	cli := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer cli.Close()
+	store := CreateRedisPasswordHistoryStore(cli, "pwd_history:", 3600)
+
+	for _, h := range []string{"h1", "h2", "h3"} {
+		if err := store.Push(ctx, "u1", h, 2); err != nil {
+			t.Fatal(err)
+		}
+	}
+	list, err := store.List(ctx, "u1", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0] != "h3" || list[1] != "h2" {
+		t.Errorf("List = %v, want [h3 h2]", list)
+	}
+	if ttl := mr.TTL("pwd_history:u1"); ttl <= 0 {
+		t.Errorf("ttl = %v, want expire set", ttl)
+	}
+	if list, err := store.List(ctx, "u2", 5); err != nil || len(list) != 0 {
+		t.Errorf("List empty = %v, %v", list, err)
+	}
+}
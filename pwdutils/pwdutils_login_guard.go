@@ -0,0 +1,435 @@
+package pwdutils
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	redis "github.com/go-redis/redis/v8"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// 登录防暴力破解的配置
+type LoginGuardConfig struct {
+	KeyPrefix          string        // redis key 前缀
+	MaxAccountFailures int64         // 账号连续失败多少次后锁定，<=0 表示不锁定账号
+	MaxIPFailures      int64         // IP连续失败多少次后锁定，<=0 表示不锁定IP
+	CaptchaThreshold   int64         // 账号或IP失败多少次后要求验证码，<=0 表示不要求
+	FailureWindow      time.Duration // 失败计数的统计窗口，<=0 时使用默认值
+	BaseLockout        time.Duration // 第一次锁定的时长，之后每次翻倍，<=0 时使用默认值
+	MaxLockout         time.Duration // 锁定时长上限，<=0 时使用默认值
+	LevelResetAfter    time.Duration // 多久没有再被锁定后，锁定时长恢复为 BaseLockout，<=0 时使用默认值
+	HashCost           int           // 保存的密码哈希的bcrypt cost，账号不存在时按该cost比较，超出范围时使用 bcrypt.DefaultCost
+}
+
+// 默认配置：账号5次、IP 50次失败锁定，3次失败要求验证码，锁定时长从1分钟开始翻倍，最长1天
+func DefaultLoginGuardConfig() LoginGuardConfig {
+	return LoginGuardConfig{
+		KeyPrefix:          "login_guard:",
+		MaxAccountFailures: 5,
+		MaxIPFailures:      50,
+		CaptchaThreshold:   3,
+		FailureWindow:      15 * time.Minute,
+		BaseLockout:        time.Minute,
+		MaxLockout:         24 * time.Hour,
+		LevelResetAfter:    24 * time.Hour,
+		HashCost:           bcrypt.DefaultCost,
+	}
+}
+
+// 登录检查的结果
+type LoginResult struct {
+	OK              bool          // 密码是否验证通过
+	Locked          bool          // 账号或IP是否处于锁定状态
+	RetryAfter      time.Duration // 锁定剩余时间
+	CaptchaRequired bool          // 是否需要验证码
+	AccountFailures int64         // 当前账号的失败次数
+	IPFailures      int64         // 当前IP的失败次数
+}
+
+/*
+基于Redis的登录防暴力破解
+
+  - 按账号和IP分别统计失败次数，超过阈值后锁定，锁定时长按次数指数增长
+  - 失败次数达到阈值后要求验证码
+  - 登录成功后清除账号的失败记录(IP的失败记录保留，避免用一个正常账号给IP解锁)
+  - 账号不存在时也会按 HashCost 做一次bcrypt比较，避免通过响应时间判断账号是否存在
+*/
+type LoginGuard struct {
+	cli    *redis.Client
+	config LoginGuardConfig
+}
+
+/*
+创建一个登录防暴力破解工具
+
+  - paramCli
+  - paramConfig 配置，可以在 DefaultLoginGuardConfig() 的基础上修改
+*/
+func CreateLoginGuard(paramCli *redis.Client, paramConfig LoginGuardConfig) *LoginGuard {
+	// 时长为0时 PEXPIRE 会直接删除计数，锁定也不会生效
+	def := DefaultLoginGuardConfig()
+	if paramConfig.FailureWindow <= 0 {
+		paramConfig.FailureWindow = def.FailureWindow
+	}
+	if paramConfig.BaseLockout <= 0 {
+		paramConfig.BaseLockout = def.BaseLockout
+	}
+	if paramConfig.MaxLockout <= 0 {
+		paramConfig.MaxLockout = def.MaxLockout
+	}
+	if paramConfig.LevelResetAfter <= 0 {
+		paramConfig.LevelResetAfter = def.LevelResetAfter
+	}
+	if paramConfig.HashCost < bcrypt.MinCost || paramConfig.HashCost > bcrypt.MaxCost {
+		paramConfig.HashCost = def.HashCost
+	}
+	return &LoginGuard{
+		cli:    paramCli,
+		config: paramConfig,
+	}
+}
+
+// 锁定一个账号或IP，锁定时长按锁定级别翻倍，返回锁定的毫秒数
+// 参数为 失败计数, 锁定标记, 锁定级别 的key，ARGV 为 基础锁定毫秒, 最大锁定毫秒, 锁定级别保留毫秒
+const loginGuardLockLua = `
+local function lock(fail, mark, lv, base, max, keep)
+	local level = redis.call('INCR', lv)
+	redis.call('PEXPIRE', lv, keep)
+	if level > 40 then
+		level = 40
+	end
+	local ttl = tonumber(base) * math.pow(2, level - 1)
+	if ttl > tonumber(max) then
+		ttl = tonumber(max)
+	end
+	ttl = math.floor(ttl)
+	if ttl < 1 then
+		ttl = 1
+	end
+	redis.call('SET', mark, level, 'PX', ttl)
+	redis.call('DEL', fail)
+	return ttl
+end
+`
+
+// KEYS: 失败计数, 锁定标记, 锁定级别
+// ARGV: 统计窗口毫秒, 最大失败次数, 基础锁定毫秒, 最大锁定毫秒, 锁定级别保留毫秒
+var loginGuardFailScript = redis.NewScript(loginGuardLockLua + `
+local n = redis.call('INCR', KEYS[1])
+if n == 1 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[1])
+end
+local max = tonumber(ARGV[2])
+if max <= 0 or n < max then
+	return {n, 0}
+end
+return {n, lock(KEYS[1], KEYS[2], KEYS[3], ARGV[3], ARGV[4], ARGV[5])}
+`)
+
+// 校验密码前预占一次尝试：没有锁定、不需要验证码时先把失败次数加1，
+// 超过最大失败次数的尝试直接锁定，并发的猜测不会都通过检查。
+//
+// KEYS: 账号的 失败计数, 锁定标记, 锁定级别, [IP的 失败计数, 锁定标记, 锁定级别]
+// ARGV: 统计窗口毫秒, 账号最大失败次数, IP最大失败次数, 基础锁定毫秒, 最大锁定毫秒, 锁定级别保留毫秒,
+// 验证码阈值, 是否已通过验证码
+// 返回 {状态 0可以校验 1锁定 2需要验证码, 账号失败次数, IP失败次数, 锁定毫秒}
+var loginGuardReserveScript = redis.NewScript(loginGuardLockLua + `
+local hasIP = #KEYS > 3
+local ttl = redis.call('PTTL', KEYS[2])
+if hasIP then
+	ttl = math.max(ttl, redis.call('PTTL', KEYS[5]))
+end
+local a = tonumber(redis.call('GET', KEYS[1]) or '0')
+local i = 0
+if hasIP then
+	i = tonumber(redis.call('GET', KEYS[4]) or '0')
+end
+if ttl > 0 then
+	return {1, a, i, ttl}
+end
+local captcha = tonumber(ARGV[7])
+if captcha > 0 and ARGV[8] ~= '1' and (a >= captcha or i >= captcha) then
+	return {2, a, i, 0}
+end
+a = redis.call('INCR', KEYS[1])
+if a == 1 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[1])
+end
+if hasIP then
+	i = redis.call('INCR', KEYS[4])
+	if i == 1 then
+		redis.call('PEXPIRE', KEYS[4], ARGV[1])
+	end
+end
+ttl = 0
+if tonumber(ARGV[2]) > 0 and a > tonumber(ARGV[2]) then
+	ttl = lock(KEYS[1], KEYS[2], KEYS[3], ARGV[4], ARGV[5], ARGV[6])
+end
+if hasIP and tonumber(ARGV[3]) > 0 and i > tonumber(ARGV[3]) then
+	ttl = math.max(ttl, lock(KEYS[4], KEYS[5], KEYS[6], ARGV[4], ARGV[5], ARGV[6]))
+end
+if ttl > 0 then
+	return {1, a, i, ttl}
+end
+return {0, a, i, 0}
+`)
+
+// 校验密码后结算预占的尝试：
+// 成功时清除账号的记录，并退回IP预占的次数；
+// 失败时预占的次数就是失败次数，刚好达到最大失败次数且还没有被锁定时锁定。
+//
+// KEYS: 同 loginGuardReserveScript
+// ARGV: 是否成功, 账号最大失败次数, IP最大失败次数, 基础锁定毫秒, 最大锁定毫秒, 锁定级别保留毫秒,
+// 预占后的账号失败次数, 预占后的IP失败次数
+// 返回锁定毫秒
+var loginGuardSettleScript = redis.NewScript(loginGuardLockLua + `
+local hasIP = #KEYS > 3
+if ARGV[1] == '1' then
+	redis.call('DEL', KEYS[1], KEYS[2], KEYS[3])
+	if hasIP and tonumber(redis.call('GET', KEYS[4]) or '0') > 0 then
+		redis.call('DECR', KEYS[4])
+	end
+	return 0
+end
+local ttl = 0
+local max = tonumber(ARGV[2])
+if max > 0 and tonumber(ARGV[7]) == max and redis.call('EXISTS', KEYS[2]) == 0 then
+	ttl = lock(KEYS[1], KEYS[2], KEYS[3], ARGV[4], ARGV[5], ARGV[6])
+end
+max = tonumber(ARGV[3])
+if hasIP and max > 0 and tonumber(ARGV[8]) == max and redis.call('EXISTS', KEYS[5]) == 0 then
+	ttl = math.max(ttl, lock(KEYS[4], KEYS[5], KEYS[6], ARGV[4], ARGV[5], ARGV[6]))
+end
+return ttl
+`)
+
+var (
+	dummyHashMu sync.Mutex
+	dummyHashes = make(map[int][]byte)
+)
+
+// 账号不存在时用来比较的哈希，按真实哈希的cost生成，保证耗时与真实比较一致
+func loginDummyHash(paramCost int) []byte {
+	dummyHashMu.Lock()
+	defer dummyHashMu.Unlock()
+	if h, ok := dummyHashes[paramCost]; ok {
+		return h
+	}
+	h, _ := bcrypt.GenerateFromPassword([]byte("login-guard-dummy-password"), paramCost)
+	dummyHashes[paramCost] = h
+	return h
+}
+
+func (g *LoginGuard) failKey(paramKind, paramID string) string {
+	return g.config.KeyPrefix + "fail:" + paramKind + ":" + paramID
+}
+
+func (g *LoginGuard) lockKey(paramKind, paramID string) string {
+	return g.config.KeyPrefix + "lock:" + paramKind + ":" + paramID
+}
+
+func (g *LoginGuard) levelKey(paramKind, paramID string) string {
+	return g.config.KeyPrefix + "level:" + paramKind + ":" + paramID
+}
+
+/*
+查询账号和IP当前的状态(是否锁定、是否需要验证码)，不会修改任何数据
+
+  - paramAccount 账号
+  - paramIP 客户端IP，为空时不按IP统计
+*/
+func (g *LoginGuard) Check(ctx context.Context, paramAccount string, paramIP string) (*LoginResult, error) {
+	var acctFail, ipFail *redis.StringCmd
+	var acctLock, ipLock *redis.DurationCmd
+	cmds, err := g.cli.Pipelined(ctx, func(p redis.Pipeliner) error {
+		acctFail = p.Get(ctx, g.failKey("acct", paramAccount))
+		acctLock = p.PTTL(ctx, g.lockKey("acct", paramAccount))
+		if paramIP != "" {
+			ipFail = p.Get(ctx, g.failKey("ip", paramIP))
+			ipLock = p.PTTL(ctx, g.lockKey("ip", paramIP))
+		}
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+	// GET 不存在的key返回 redis.Nil，只会作为第一个错误返回，这里检查其余命令
+	for _, cmd := range cmds {
+		if cmdErr := cmd.Err(); cmdErr != nil && cmdErr != redis.Nil {
+			return nil, cmdErr
+		}
+	}
+
+	r := &LoginResult{}
+	r.AccountFailures, _ = acctFail.Int64()
+	g.applyLock(r, acctLock.Val())
+	if ipFail != nil {
+		r.IPFailures, _ = ipFail.Int64()
+		g.applyLock(r, ipLock.Val())
+	}
+	g.applyCaptcha(r)
+	return r, nil
+}
+
+func (g *LoginGuard) applyLock(r *LoginResult, paramTTL time.Duration) {
+	if paramTTL > 0 {
+		r.Locked = true
+		if paramTTL > r.RetryAfter {
+			r.RetryAfter = paramTTL
+		}
+	}
+}
+
+func (g *LoginGuard) applyCaptcha(r *LoginResult) {
+	threshold := g.config.CaptchaThreshold
+	if threshold > 0 && (r.AccountFailures >= threshold || r.IPFailures >= threshold) {
+		r.CaptchaRequired = true
+	}
+}
+
+/*
+校验登录密码，并根据结果更新失败记录
+
+  - paramAccount 账号
+  - paramIP 客户端IP，为空时不按IP统计
+  - paramHashedPassword 数据库中保存的密码哈希，账号不存在时传空字符串
+  - paramPassword 用户输入的密码
+  - paramCaptchaPassed 本次请求是否已经通过了验证码
+
+处于锁定状态，或需要验证码但没有通过时，不会校验密码，OK 为 false。
+校验前会原子地预占一次尝试，并发的猜测最多只有最大失败次数个会校验密码。
+*/
+func (g *LoginGuard) Verify(ctx context.Context, paramAccount string, paramIP string, paramHashedPassword string, paramPassword string, paramCaptchaPassed bool) (*LoginResult, error) {
+	keys := g.guardKeys(paramAccount, paramIP)
+	captcha := "0"
+	if paramCaptchaPassed {
+		captcha = "1"
+	}
+	ret, err := loginGuardReserveScript.Run(ctx, g.cli, keys,
+		g.config.FailureWindow.Milliseconds(),
+		g.config.MaxAccountFailures,
+		g.config.MaxIPFailures,
+		g.config.BaseLockout.Milliseconds(),
+		g.config.MaxLockout.Milliseconds(),
+		g.config.LevelResetAfter.Milliseconds(),
+		g.config.CaptchaThreshold,
+		captcha,
+	).Int64Slice()
+	if err != nil {
+		return nil, err
+	}
+	r := &LoginResult{AccountFailures: ret[1], IPFailures: ret[2]}
+	switch ret[0] {
+	case 1:
+		g.applyLock(r, time.Duration(ret[3])*time.Millisecond)
+		g.applyCaptcha(r)
+		return r, nil
+	case 2:
+		r.CaptchaRequired = true
+		return r, nil
+	}
+
+	if paramHashedPassword == "" {
+		bcrypt.CompareHashAndPassword(loginDummyHash(g.config.HashCost), []byte(paramPassword))
+	} else {
+		r.OK = PasswordVerify(paramHashedPassword, paramPassword)
+	}
+
+	ok := "0"
+	if r.OK {
+		ok = "1"
+	}
+	ttl, err := loginGuardSettleScript.Run(ctx, g.cli, keys,
+		ok,
+		g.config.MaxAccountFailures,
+		g.config.MaxIPFailures,
+		g.config.BaseLockout.Milliseconds(),
+		g.config.MaxLockout.Milliseconds(),
+		g.config.LevelResetAfter.Milliseconds(),
+		r.AccountFailures,
+		r.IPFailures,
+	).Int64()
+	if err != nil {
+		return nil, err
+	}
+	if r.OK {
+		r.AccountFailures = 0
+		if r.IPFailures > 0 {
+			r.IPFailures--
+		}
+		return r, nil
+	}
+	g.applyLock(r, time.Duration(ttl)*time.Millisecond)
+	g.applyCaptcha(r)
+	return r, nil
+}
+
+// Verify 使用的账号和IP的key，IP为空时只有账号的key
+func (g *LoginGuard) guardKeys(paramAccount string, paramIP string) []string {
+	keys := []string{g.failKey("acct", paramAccount), g.lockKey("acct", paramAccount), g.levelKey("acct", paramAccount)}
+	if paramIP != "" {
+		keys = append(keys, g.failKey("ip", paramIP), g.lockKey("ip", paramIP), g.levelKey("ip", paramIP))
+	}
+	return keys
+}
+
+/*
+记录一次登录失败，达到阈值时锁定
+
+  - paramAccount 账号
+  - paramIP 客户端IP，为空时不按IP统计
+*/
+func (g *LoginGuard) RecordFailure(ctx context.Context, paramAccount string, paramIP string) (*LoginResult, error) {
+	r := &LoginResult{}
+	n, ttl, err := g.incrFailure(ctx, "acct", paramAccount, g.config.MaxAccountFailures)
+	if err != nil {
+		return nil, err
+	}
+	r.AccountFailures = n
+	g.applyLock(r, ttl)
+
+	if paramIP != "" {
+		n, ttl, err = g.incrFailure(ctx, "ip", paramIP, g.config.MaxIPFailures)
+		if err != nil {
+			return nil, err
+		}
+		r.IPFailures = n
+		g.applyLock(r, ttl)
+	}
+	g.applyCaptcha(r)
+	return r, nil
+}
+
+func (g *LoginGuard) incrFailure(ctx context.Context, paramKind string, paramID string, paramMax int64) (int64, time.Duration, error) {
+	keys := []string{g.failKey(paramKind, paramID), g.lockKey(paramKind, paramID), g.levelKey(paramKind, paramID)}
+	ret, err := loginGuardFailScript.Run(ctx, g.cli, keys,
+		g.config.FailureWindow.Milliseconds(),
+		paramMax,
+		g.config.BaseLockout.Milliseconds(),
+		g.config.MaxLockout.Milliseconds(),
+		g.config.LevelResetAfter.Milliseconds(),
+	).Int64Slice()
+	if err != nil {
+		return 0, 0, err
+	}
+	return ret[0], time.Duration(ret[1]) * time.Millisecond, nil
+}
+
+// 登录成功后清除账号的失败次数、锁定和锁定级别
+func (g *LoginGuard) Reset(ctx context.Context, paramAccount string) error {
+	return g.cli.Del(ctx,
+		g.failKey("acct", paramAccount),
+		g.lockKey("acct", paramAccount),
+		g.levelKey("acct", paramAccount),
+	).Err()
+}
+
+// 手动解除IP的锁定和失败记录
+func (g *LoginGuard) ResetIP(ctx context.Context, paramIP string) error {
+	return g.cli.Del(ctx,
+		g.failKey("ip", paramIP),
+		g.lockKey("ip", paramIP),
+		g.levelKey("ip", paramIP),
+	).Err()
+}
@@ -0,0 +1,201 @@
+package pwdutils
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	redis "github.com/go-redis/redis/v8"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func newTestLoginGuard(t *testing.T, paramConfig LoginGuardConfig) (*LoginGuard, *miniredis.Miniredis) {
+	mr := miniredis.RunT(t)
+	cli := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { cli.Close() })
+	return CreateLoginGuard(cli, paramConfig), mr
+}
+
+func testLoginGuardConfig() LoginGuardConfig {
+	c := DefaultLoginGuardConfig()
+	c.MaxAccountFailures = 3
+	c.MaxIPFailures = 50
+	c.CaptchaThreshold = 0
+	c.BaseLockout = time.Minute
+	c.MaxLockout = 3 * time.Minute
+	return c
+}
+
+func TestLoginGuardLockoutEscalation(t *testing.T) {
+	ctx := context.Background()
+	g, mr := newTestLoginGuard(t, testLoginGuardConfig())
+	hash, err := PasswordHash("right-Pass1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fail := func() *LoginResult {
+		r, err := g.Verify(ctx, "alice", "1.2.3.4", hash, "wrong", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+
+	// 锁定时长 1分钟、2分钟，之后达到上限 3分钟
+	for _, want := range []time.Duration{time.Minute, 2 * time.Minute, 3 * time.Minute} {
+		for i := 1; i < 3; i++ {
+			if r := fail(); r.Locked || r.AccountFailures != int64(i) {
+				t.Fatalf("failure %d = %+v, want unlocked", i, r)
+			}
+		}
+		r := fail()
+		if !r.Locked || r.RetryAfter != want {
+			t.Fatalf("third failure = %+v, want locked for %v", r, want)
+		}
+		// 锁定期间正确的密码也不校验
+		r, err := g.Verify(ctx, "alice", "1.2.3.4", hash, "right-Pass1", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.OK || !r.Locked {
+			t.Fatalf("verify while locked = %+v", r)
+		}
+		mr.FastForward(want)
+	}
+
+	r, err := g.Verify(ctx, "alice", "1.2.3.4", hash, "right-Pass1", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.OK || r.AccountFailures != 0 {
+		t.Fatalf("verify after lockout = %+v", r)
+	}
+	// 登录成功后锁定级别恢复
+	for i := 0; i < 3; i++ {
+		r = fail()
+	}
+	if r.RetryAfter != time.Minute {
+		t.Errorf("lockout after success = %v, want %v", r.RetryAfter, time.Minute)
+	}
+	// IP的失败记录保留
+	check, err := g.Check(ctx, "bob", "1.2.3.4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if check.IPFailures == 0 {
+		t.Errorf("ip failures = 0, want kept after success")
+	}
+}
+
+func TestLoginGuardCaptcha(t *testing.T) {
+	ctx := context.Background()
+	c := testLoginGuardConfig()
+	c.CaptchaThreshold = 2
+	g, _ := newTestLoginGuard(t, c)
+	hash, err := PasswordHash("right-Pass1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := g.Verify(ctx, "alice", "", hash, "wrong", false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r, err := g.Verify(ctx, "alice", "", hash, "right-Pass1", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.OK || !r.CaptchaRequired || r.AccountFailures != 2 {
+		t.Fatalf("verify without captcha = %+v", r)
+	}
+	r, err = g.Verify(ctx, "alice", "", hash, "right-Pass1", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.OK {
+		t.Fatalf("verify with captcha = %+v", r)
+	}
+}
+
+func TestLoginGuardConcurrentGuesses(t *testing.T) {
+	ctx := context.Background()
+	g, _ := newTestLoginGuard(t, testLoginGuardConfig())
+	hash, err := PasswordHash("right-Pass1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	checked := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r, err := g.Verify(ctx, "alice", "", hash, "wrong", false)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if !r.Locked || r.AccountFailures == 3 {
+				checked++
+			}
+		}()
+	}
+	wg.Wait()
+	if checked != 3 {
+		t.Errorf("checked %d guesses, want 3", checked)
+	}
+	check, err := g.Check(ctx, "alice", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !check.Locked {
+		t.Errorf("account not locked after concurrent guesses")
+	}
+}
+
+func TestLoginGuardZeroDurationsUseDefaults(t *testing.T) {
+	ctx := context.Background()
+	c := testLoginGuardConfig()
+	c.FailureWindow = 0
+	c.LevelResetAfter = 0
+	g, _ := newTestLoginGuard(t, c)
+
+	if _, err := g.RecordFailure(ctx, "alice", "1.2.3.4"); err != nil {
+		t.Fatal(err)
+	}
+	r, err := g.Check(ctx, "alice", "1.2.3.4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.AccountFailures != 1 || r.IPFailures != 1 {
+		t.Errorf("failures = %d/%d, want 1/1", r.AccountFailures, r.IPFailures)
+	}
+}
+
+func TestLoginGuardDummyHashCost(t *testing.T) {
+	ctx := context.Background()
+	c := testLoginGuardConfig()
+	c.HashCost = bcrypt.MinCost
+	g, _ := newTestLoginGuard(t, c)
+
+	// 账号不存在时按配置的cost比较
+	if r, err := g.Verify(ctx, "nobody", "", "", "guess", false); err != nil || r.OK {
+		t.Fatalf("Verify unknown account = %+v, %v", r, err)
+	}
+	if cost, err := bcrypt.Cost(loginDummyHash(c.HashCost)); err != nil || cost != bcrypt.MinCost {
+		t.Errorf("dummy hash cost = %d, %v", cost, err)
+	}
+
+	c.HashCost = 0
+	if g, _ := newTestLoginGuard(t, c); g.config.HashCost != bcrypt.DefaultCost {
+		t.Errorf("default cost = %d", g.config.HashCost)
+	}
+}
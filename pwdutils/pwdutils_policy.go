@@ -0,0 +1,99 @@
+package pwdutils
+
+import (
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/qiuliaogit/commonutils/commonutils"
+)
+
+var (
+	ErrPasswordTooShort   = commonutils.NewError(commonutils.ERR_FAIL, "密码长度不足")
+	ErrPasswordTooLong    = commonutils.NewError(commonutils.ERR_FAIL, "密码长度超出限制")
+	ErrPasswordNeedUpper  = commonutils.NewError(commonutils.ERR_FAIL, "密码必须包含大写字母")
+	ErrPasswordNeedLower  = commonutils.NewError(commonutils.ERR_FAIL, "密码必须包含小写字母")
+	ErrPasswordNeedDigit  = commonutils.NewError(commonutils.ERR_FAIL, "密码必须包含数字")
+	ErrPasswordNeedSymbol = commonutils.NewError(commonutils.ERR_FAIL, "密码必须包含特殊字符")
+	ErrPasswordBreached   = commonutils.NewError(commonutils.ERR_FAIL, "密码已出现在泄露密码库中")
+)
+
+// bcrypt只处理前72个字节，超出部分会被忽略
+const BCRYPT_MAX_BYTES = 72
+
+// 密码强度策略
+type PasswordPolicy struct {
+	MinLength     int             // 最小长度(按字符计算)，<=0 表示不限制
+	MaxLength     int             // 最大长度(按字节计算)，<=0 表示不限制
+	RequireUpper  bool            // 是否要求包含大写字母
+	RequireLower  bool            // 是否要求包含小写字母
+	RequireDigit  bool            // 是否要求包含数字
+	RequireSymbol bool            // 是否要求包含特殊字符
+	Breached      BreachedChecker // 可选的泄露密码检查，nil 表示不检查
+}
+
+// 默认的密码策略：8位以上，包含大小写字母和数字
+func DefaultPasswordPolicy() *PasswordPolicy {
+	return &PasswordPolicy{
+		MinLength:    8,
+		MaxLength:    BCRYPT_MAX_BYTES,
+		RequireUpper: true,
+		RequireLower: true,
+		RequireDigit: true,
+	}
+}
+
+// 设置泄露密码检查器，返回策略本身便于链式调用
+func (p *PasswordPolicy) WithBreachedChecker(paramChecker BreachedChecker) *PasswordPolicy {
+	p.Breached = paramChecker
+	return p
+}
+
+/*
+按策略校验密码，通过返回nil，否则返回第一个不满足的规则对应的错误
+  - paramPassword 待校验的密码
+*/
+func (p *PasswordPolicy) Validate(paramPassword string) error {
+	if p.MinLength > 0 && utf8.RuneCountInString(paramPassword) < p.MinLength {
+		return ErrPasswordTooShort
+	}
+	if p.MaxLength > 0 && len(paramPassword) > p.MaxLength {
+		return ErrPasswordTooLong
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, c := range paramPassword {
+		switch {
+		case unicode.IsUpper(c):
+			hasUpper = true
+		case unicode.IsLower(c):
+			hasLower = true
+		case unicode.IsDigit(c):
+			hasDigit = true
+		case unicode.IsPunct(c) || unicode.IsSymbol(c):
+			hasSymbol = true
+		}
+	}
+	if p.RequireUpper && !hasUpper {
+		return ErrPasswordNeedUpper
+	}
+	if p.RequireLower && !hasLower {
+		return ErrPasswordNeedLower
+	}
+	if p.RequireDigit && !hasDigit {
+		return ErrPasswordNeedDigit
+	}
+	if p.RequireSymbol && !hasSymbol {
+		return ErrPasswordNeedSymbol
+	}
+
+	if p.Breached != nil {
+		breached, err := p.Breached.IsBreached(paramPassword)
+		if err != nil {
+			return commonutils.NewError(commonutils.ERR_FAIL, "泄露密码库检查失败 err:"+err.Error())
+		}
+		if breached {
+			return ErrPasswordBreached
+		}
+	}
+	return nil
+}
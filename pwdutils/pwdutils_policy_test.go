@@ -0,0 +1,45 @@
+package pwdutils
+
+import (
+	"testing"
+)
+
+func TestPasswordPolicyValidate(t *testing.T) {
+	policy := DefaultPasswordPolicy()
+	cases := []struct {
+		password string
+		want     error
+	}{
+		{"Ab1", ErrPasswordTooShort},
+		{"abcdefg1", ErrPasswordNeedUpper},
+		{"ABCDEFG1", ErrPasswordNeedLower},
+		{"Abcdefgh", ErrPasswordNeedDigit},
+		{"Abcdefg1", nil},
+	}
+	for _, c := range cases {
+		if got := policy.Validate(c.password); got != c.want {
+			t.Errorf("Validate(%q) = %v, want %v", c.password, got, c.want)
+		}
+	}
+
+	policy.RequireSymbol = true
+	if got := policy.Validate("Abcdefg1"); got != ErrPasswordNeedSymbol {
+		t.Errorf("Validate without symbol = %v", got)
+	}
+	if got := policy.Validate("Abcdefg1!"); got != nil {
+		t.Errorf("Validate with symbol = %v", got)
+	}
+}
+
+func TestPasswordPolicyBreached(t *testing.T) {
+	b := NewBloomFilter(10, 0.001)
+	b.AddPassword("Passw0rd")
+	policy := DefaultPasswordPolicy().WithBreachedChecker(b)
+
+	if got := policy.Validate("Passw0rd"); got != ErrPasswordBreached {
+		t.Errorf("Validate breached = %v, want %v", got, ErrPasswordBreached)
+	}
+	if got := policy.Validate("Xk9mQ2vLp"); got != nil {
+		t.Errorf("Validate clean = %v", got)
+	}
+}
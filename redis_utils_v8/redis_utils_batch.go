@@ -0,0 +1,155 @@
+package redisv8
+
+import (
+	"context"
+
+	redis "github.com/go-redis/redis/v8"
+	"github.com/qiuliaogit/commonutils/commonutils"
+)
+
+// 需要读取结果才能继续的方法在批量模式下调用时返回该错误
+var ErrBatchUnsupported = commonutils.NewError(commonutils.ERR_FAIL, "批量模式下不支持该操作")
+
+// 批量操作，把多个工具类的命令放到一个管道(或 MULTI/EXEC 事务)中一次发送
+//
+//   - 工具类通过 Batch(batch) 得到绑定到批量操作的副本，副本的方法把命令写入管道
+//   - 返回 *redis.XxxCmd 的方法，返回值相当于 future，Exec 之后可以读取结果和错误
+//   - RedisSetUtils.Batch 返回 RedisSetBatchUtils，方法同样返回 *redis.XxxCmd
+//   - 自动更新超时时间的 EXPIRE 命令同样写入管道
+//   - 需要先读取结果才能继续的方法(如 RedisZSetUtils.ZeroScore、RedisStreamUtils.ReadGroup)在批量模式下返回 ErrBatchUnsupported
+//
+// 示例：
+//
+//	batch := redisv8.CreateBatch(cli)
+//	nameCmd := userHSet.Batch(batch).Set(ctx, "name", "tom") // Exec 之后 nameCmd 才有结果
+//	vipCmd := tagSet.Batch(batch).Add(ctx, "vip")
+//	rankZSet.Batch(batch).AddOne(ctx, "tom", 100)
+//	result, err := batch.Exec(ctx)
+type RedisBatch struct {
+	pipe     redis.Pipeliner
+	tx       bool
+	executed bool
+}
+
+// 创建一个批量操作(普通管道，不保证原子性)
+func CreateBatch(paramCli *redis.Client) *RedisBatch {
+	return &RedisBatch{pipe: paramCli.Pipeline()}
+}
+
+// 创建一个事务批量操作，命令包装在 MULTI/EXEC 中原子执行
+func CreateTxBatch(paramCli *redis.Client) *RedisBatch {
+	return &RedisBatch{pipe: paramCli.TxPipeline(), tx: true}
+}
+
+// 是否为事务批量操作
+func (b *RedisBatch) IsTx() bool {
+	return b.tx
+}
+
+// 已写入的命令数量
+func (b *RedisBatch) Len() int {
+	return b.pipe.Len()
+}
+
+// 直接向批量操作写入命令，用于工具类没有提供的命令
+func (b *RedisBatch) Cmd() redis.Pipeliner {
+	return b.pipe
+}
+
+// 放弃所有已写入的命令
+func (b *RedisBatch) Discard() error {
+	return b.pipe.Discard()
+}
+
+/*
+执行所有已写入的命令
+
+返回的错误为第一个失败的命令的错误(不包括 redis.Nil)，每个命令的结果和错误可以通过 RedisBatchResult 获取
+*/
+func (b *RedisBatch) Exec(ctx context.Context) (*RedisBatchResult, error) {
+	if b.executed {
+		return nil, commonutils.NewError(commonutils.ERR_FAIL, "批量操作已经执行过")
+	}
+	b.executed = true
+	cmds, err := b.pipe.Exec(ctx)
+	if err == redis.Nil {
+		err = nil
+	}
+	if err == nil {
+		// Exec 只返回第一个错误，其中可能是 redis.Nil，这里再找一次真正的错误
+		for _, cmd := range cmds {
+			if cmdErr := cmd.Err(); cmdErr != nil && cmdErr != redis.Nil {
+				err = cmdErr
+				break
+			}
+		}
+	}
+	return &RedisBatchResult{Cmds: cmds}, err
+}
+
+// 批量操作的执行结果
+type RedisBatchResult struct {
+	Cmds []redis.Cmder // 按写入顺序排列的所有命令(包括自动更新超时时间的命令)
+}
+
+// 执行失败的命令(不包括返回 redis.Nil 的命令)
+func (r *RedisBatchResult) Failed() []redis.Cmder {
+	list := make([]redis.Cmder, 0)
+	for _, cmd := range r.Cmds {
+		if err := cmd.Err(); err != nil && err != redis.Nil {
+			list = append(list, cmd)
+		}
+	}
+	return list
+}
+
+// 每个命令的错误，与 Cmds 一一对应，成功或返回 redis.Nil 时为nil
+func (r *RedisBatchResult) Errors() []error {
+	list := make([]error, len(r.Cmds))
+	for i, cmd := range r.Cmds {
+		if err := cmd.Err(); err != redis.Nil {
+			list[i] = err
+		}
+	}
+	return list
+}
+
+// 执行Lua脚本，批量模式下无法在出错后回退到 EVAL，所以直接使用 EVAL
+func evalScript(ctx context.Context, paramCmd redis.Cmdable, paramScript *redis.Script, paramKeys []string, paramArgs ...interface{}) *redis.Cmd {
+	if _, ok := paramCmd.(redis.Pipeliner); ok {
+		return paramScript.Eval(ctx, paramCmd, paramKeys, paramArgs...)
+	}
+	return paramScript.Run(ctx, paramCmd, paramKeys, paramArgs...)
+}
+
+// 返回整数的Lua脚本，保存源码以便批量模式下以 EVAL 写入管道
+type intScript struct {
+	src    string
+	script *redis.Script
+}
+
+func newIntScript(paramSrc string) *intScript {
+	return &intScript{src: paramSrc, script: redis.NewScript(paramSrc)}
+}
+
+/*
+执行返回整数的Lua脚本，返回 *redis.IntCmd，便于保持工具类原有的返回类型
+
+非批量模式下先用 EVALSHA，脚本不存在时回退到 EVAL；
+批量模式下命令以 EVAL 写入管道，结果在 RedisBatch.Exec 后可用
+*/
+func evalIntScript(ctx context.Context, paramCmd redis.Cmdable, paramScript *intScript, paramKeys []string, paramArgs ...interface{}) *redis.IntCmd {
+	pipe, ok := paramCmd.(redis.Pipeliner)
+	if !ok {
+		return redis.NewIntResult(paramScript.script.Run(ctx, paramCmd, paramKeys, paramArgs...).Int64())
+	}
+	args := make([]interface{}, 0, 3+len(paramKeys)+len(paramArgs))
+	args = append(args, "eval", paramScript.src, len(paramKeys))
+	for _, key := range paramKeys {
+		args = append(args, key)
+	}
+	args = append(args, paramArgs...)
+	cmd := redis.NewIntCmd(ctx, args...)
+	pipe.Process(ctx, cmd)
+	return cmd
+}
@@ -0,0 +1,154 @@
+package redisv8
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	redis "github.com/go-redis/redis/v8"
+)
+
+func TestBatchFutures(t *testing.T) {
+	cli, mr := newTestClient(t)
+	ctx := context.Background()
+	hset := CreateHSetUtils(cli, "user:1", 60, true)
+	tags := CreateSetUtils(cli, "tags:1", 60, true)
+	rank := CreateZSetUtils(cli, "rank", 0, false)
+
+	batch := CreateBatch(cli)
+	nameCmd := hset.Batch(batch).Set(ctx, "name", "tom")
+	addCmd := tags.Batch(batch).Add(ctx, "vip", "new")
+	hasCmd := tags.Batch(batch).Has(ctx, "vip")
+	countCmd := tags.Batch(batch).Count(ctx)
+	listCmd := tags.Batch(batch).List(ctx)
+	rank.Batch(batch).AddOne(ctx, "tom", 100)
+	missCmd := hset.Batch(batch).Get(ctx, "age")
+
+	if mr.Exists("user:1") || mr.Exists("tags:1") {
+		t.Fatal("commands executed before Exec")
+	}
+	result, err := batch.Exec(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := nameCmd.Result(); err != nil || n != 1 {
+		t.Fatalf("hset = %d, %v", n, err)
+	}
+	if n, err := addCmd.Result(); err != nil || n != 2 {
+		t.Fatalf("sadd = %d, %v", n, err)
+	}
+	if ok, err := hasCmd.Result(); err != nil || !ok {
+		t.Fatalf("sismember = %v, %v", ok, err)
+	}
+	if n, err := countCmd.Result(); err != nil || n != 2 {
+		t.Fatalf("scard = %d, %v", n, err)
+	}
+	if list, err := listCmd.Result(); err != nil || len(list) != 2 {
+		t.Fatalf("smembers = %v, %v", list, err)
+	}
+	if missCmd.Err() != redis.Nil {
+		t.Fatalf("missing field err = %v", missCmd.Err())
+	}
+	// 自动更新超时时间的 EXPIRE 同样在管道中执行
+	if ttl := mr.TTL("tags:1"); ttl != 60*time.Second {
+		t.Fatalf("set ttl = %v", ttl)
+	}
+	if len(result.Failed()) != 0 {
+		t.Fatalf("failed = %v", result.Failed())
+	}
+
+	if _, err := batch.Exec(ctx); err == nil {
+		t.Fatal("second Exec should fail")
+	}
+}
+
+func TestBatchExecReportsFirstError(t *testing.T) {
+	cli, _ := newTestClient(t)
+	ctx := context.Background()
+	cli.Set(ctx, "str", "v", 0)
+	hset := CreateHSetUtils(cli, "str", 0, false)
+	user := CreateHSetUtils(cli, "user", 0, false)
+	tags := CreateSetUtils(cli, "tags", 0, false)
+
+	batch := CreateBatch(cli)
+	// redis.Nil 不算错误
+	user.Batch(batch).Get(ctx, "missing")
+	tags.Batch(batch).Add(ctx, "a")
+	wrong := hset.Batch(batch).Set(ctx, "f", "v")
+	after := tags.Batch(batch).Count(ctx)
+	result, err := batch.Exec(ctx)
+	if err == nil || err != wrong.Err() {
+		t.Fatalf("Exec err = %v, want %v", err, wrong.Err())
+	}
+	// 管道不是原子的，出错的命令不影响其他命令
+	if n, _ := after.Result(); n != 1 {
+		t.Fatalf("scard = %d", n)
+	}
+	failed := result.Failed()
+	if len(failed) != 1 || failed[0] != wrong {
+		t.Fatalf("failed = %v", failed)
+	}
+	errs := result.Errors()
+	if len(errs) != 4 || errs[0] != nil || errs[2] == nil {
+		t.Fatalf("errors = %v", errs)
+	}
+}
+
+func TestTxBatch(t *testing.T) {
+	cli, mr := newTestClient(t)
+	ctx := context.Background()
+	list := CreateListUtils(cli, "history", 0, false)
+
+	batch := CreateTxBatch(cli)
+	if !batch.IsTx() {
+		t.Fatal("IsTx = false")
+	}
+	b := list.Batch(batch)
+	b.LPush(ctx, "a", "b", "c")
+	b.Trim(ctx, 0, 1)
+	if batch.Len() != 2 {
+		t.Fatalf("Len = %d", batch.Len())
+	}
+	if _, err := batch.Exec(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := mr.List("history"); len(got) != 2 || got[0] != "c" {
+		t.Fatalf("list = %v", got)
+	}
+
+	// Discard 之后不会执行任何命令
+	batch = CreateTxBatch(cli)
+	list.Batch(batch).LPush(ctx, "d")
+	batch.Discard()
+	if _, err := batch.Exec(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := mr.List("history"); len(got) != 2 {
+		t.Fatalf("list after discard = %v", got)
+	}
+}
+
+func TestBatchUnsupported(t *testing.T) {
+	cli, mr := newTestClient(t)
+	ctx := context.Background()
+	rank := CreateZSetUtils(cli, "rank", 0, false)
+	rank.AddOne(ctx, "tom", 100)
+	stream := CreateStreamUtils(cli, "events", 0, false)
+
+	batch := CreateBatch(cli)
+	if err := rank.Batch(batch).ZeroScore(ctx); err != ErrBatchUnsupported {
+		t.Fatalf("ZeroScore err = %v", err)
+	}
+	if err := stream.Batch(batch).CreateGroup(ctx, "g", "0"); err != ErrBatchUnsupported {
+		t.Fatalf("CreateGroup err = %v", err)
+	}
+	if _, err := stream.Batch(batch).ReadGroup(ctx, "g", "c", 1, -1); err != ErrBatchUnsupported {
+		t.Fatalf("ReadGroup err = %v", err)
+	}
+	if batch.Len() != 0 {
+		t.Fatalf("Len = %d", batch.Len())
+	}
+	if score, _ := mr.ZScore("rank", "tom"); score != 100 {
+		t.Fatalf("score = %v", score)
+	}
+}
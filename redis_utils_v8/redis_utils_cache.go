@@ -0,0 +1,354 @@
+package redisv8
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math"
+	mrand "math/rand"
+	"sync"
+	"time"
+
+	redis "github.com/go-redis/redis/v8"
+	"github.com/qiuliaogit/commonutils/commonutils"
+)
+
+var (
+	// 加载函数返回该错误时表示数据不存在，会按 NegativeTTL 缓存空结果
+	ErrCacheNotFound = commonutils.NewError(commonutils.ERR_FAIL, "缓存的数据不存在")
+	// 加载函数 panic 时返回的错误，实际返回 *CacheLoaderPanicError，用 errors.Is 判断
+	ErrCacheLoaderPanic = commonutils.NewError(commonutils.ERR_FAIL, "缓存的加载函数panic")
+)
+
+// 加载函数 panic 时返回的错误，带有 panic 的值
+type CacheLoaderPanicError struct {
+	Key   string      // 缓存的key
+	Value interface{} // recover 得到的值
+}
+
+func (e *CacheLoaderPanicError) Error() string {
+	return commonutils.NewErrorf(commonutils.ERR_FAIL, "缓存 %s 的加载函数panic: %v", e.Key, e.Value).Error()
+}
+
+// 使 errors.Is(err, ErrCacheLoaderPanic) 成立
+func (e *CacheLoaderPanicError) Is(paramTarget error) bool {
+	return paramTarget == ErrCacheLoaderPanic
+}
+
+// 缓存时间随机浮动比例的上限
+const cacheMaxJitter = 0.99
+
+// 缓存的配置
+type RedisCacheOptions struct {
+	TTL              time.Duration // 缓存时间
+	Jitter           float64       // 缓存时间的随机浮动比例，如 0.1 表示 ±10%，避免大量key同时过期，范围 [0, 1)
+	NegativeTTL      time.Duration // 空结果的缓存时间，0 表示不缓存空结果
+	LockTTL          time.Duration // 跨实例加载锁的超时时间，0 表示只在进程内去重
+	LockWait         time.Duration // 没有抢到锁时最多等待其他实例加载的时间
+	EarlyRefreshBeta float64       // 提前刷新系数，0 表示不提前刷新，一般为1，越大越早刷新
+}
+
+// 默认配置：缓存5分钟±10%，空结果缓存30秒，使用3秒的跨实例加载锁，提前刷新系数为1
+func DefaultRedisCacheOptions() RedisCacheOptions {
+	return RedisCacheOptions{
+		TTL:              5 * time.Minute,
+		Jitter:           0.1,
+		NegativeTTL:      30 * time.Second,
+		LockTTL:          3 * time.Second,
+		LockWait:         3 * time.Second,
+		EarlyRefreshBeta: 1,
+	}
+}
+
+/*
+基于redis的旁路缓存(cache-aside)
+
+  - 缓存不存在时调用加载函数，并把结果写入缓存
+  - 同一进程内同一个key的并发加载只执行一次(singleflight)
+  - 可选使用redis锁，多个实例之间同一个key同时只有一个实例加载
+  - 加载函数返回 ErrCacheNotFound 时缓存空结果，防止缓存穿透
+  - 缓存时间加随机浮动，并按 XFetch 算法在过期前以一定概率提前刷新，防止缓存雪崩和击穿
+*/
+type RedisCache struct {
+	cli       *redis.Client
+	keyPrefix string
+	options   RedisCacheOptions
+	group     cacheCallGroup
+}
+
+/*
+创建一个旁路缓存
+
+  - paramCli
+  - paramKeyPrefix 缓存key的前缀
+  - paramOptions 配置，可以在 DefaultRedisCacheOptions() 的基础上修改
+*/
+func CreateCache(paramCli *redis.Client, paramKeyPrefix string, paramOptions RedisCacheOptions) *RedisCache {
+	// 浮动比例 >=1 时缓存时间可能为0或负数，限制在 [0, 1) 内
+	if paramOptions.Jitter < 0 {
+		paramOptions.Jitter = 0
+	} else if paramOptions.Jitter >= 1 {
+		paramOptions.Jitter = cacheMaxJitter
+	}
+	return &RedisCache{
+		cli:       paramCli,
+		keyPrefix: paramKeyPrefix,
+		options:   paramOptions,
+		group:     cacheCallGroup{calls: make(map[string]*cacheCall)},
+	}
+}
+
+// 缓存的数据，带上逻辑过期时间和加载耗时，用于计算提前刷新
+type cacheEntry struct {
+	negative bool
+	expireAt int64 // 逻辑过期时间 毫秒
+	delta    int64 // 加载耗时 毫秒
+	value    []byte
+}
+
+// 格式： 1字节标记 + 8字节过期时间 + 8字节加载耗时 + 数据
+func (e *cacheEntry) encode() []byte {
+	buf := make([]byte, 17+len(e.value))
+	if e.negative {
+		buf[0] = 1
+	}
+	binary.BigEndian.PutUint64(buf[1:9], uint64(e.expireAt))
+	binary.BigEndian.PutUint64(buf[9:17], uint64(e.delta))
+	copy(buf[17:], e.value)
+	return buf
+}
+
+func decodeCacheEntry(paramData []byte) (*cacheEntry, bool) {
+	if len(paramData) < 17 {
+		return nil, false
+	}
+	return &cacheEntry{
+		negative: paramData[0] == 1,
+		expireAt: int64(binary.BigEndian.Uint64(paramData[1:9])),
+		delta:    int64(binary.BigEndian.Uint64(paramData[9:17])),
+		value:    paramData[17:],
+	}, true
+}
+
+func (e *cacheEntry) result() ([]byte, error) {
+	if e.negative {
+		return nil, ErrCacheNotFound
+	}
+	return e.value, nil
+}
+
+// XFetch：越接近过期、加载越慢，越有可能提前刷新
+func (c *RedisCache) shouldRefresh(paramEntry *cacheEntry) bool {
+	now := time.Now().UnixMilli()
+	if now >= paramEntry.expireAt {
+		return true
+	}
+	if c.options.EarlyRefreshBeta <= 0 || paramEntry.delta <= 0 {
+		return false
+	}
+	gap := float64(paramEntry.delta) * c.options.EarlyRefreshBeta * -math.Log(1-mrand.Float64())
+	return float64(now)+gap >= float64(paramEntry.expireAt)
+}
+
+func (c *RedisCache) jitter(paramTTL time.Duration) time.Duration {
+	if c.options.Jitter <= 0 || paramTTL <= 0 {
+		return paramTTL
+	}
+	factor := 1 + c.options.Jitter*(2*mrand.Float64()-1)
+	return time.Duration(float64(paramTTL) * factor)
+}
+
+func (c *RedisCache) read(ctx context.Context, paramKey string) (*cacheEntry, error) {
+	data, err := c.cli.Get(ctx, c.keyPrefix+paramKey).Bytes()
+	if err != nil {
+		return nil, err
+	}
+	entry, ok := decodeCacheEntry(data)
+	if !ok {
+		return nil, redis.Nil
+	}
+	return entry, nil
+}
+
+/*
+获取缓存，缓存不存在或需要刷新时调用加载函数
+
+  - paramKey 缓存的key(不含前缀)
+  - paramLoader 加载函数，数据不存在时返回 ErrCacheNotFound(可以用 %w 包装)
+
+redis 出错时不使用缓存和加载锁，直接调用加载函数保证可用性，进程内的并发加载仍然只执行一次。
+加载函数 panic 时，当前调用和等待中的调用都返回带有 panic 值的 *CacheLoaderPanicError，
+可以用 errors.Is(err, ErrCacheLoaderPanic) 判断
+*/
+func (c *RedisCache) Get(ctx context.Context, paramKey string, paramLoader func(context.Context) ([]byte, error)) ([]byte, error) {
+	entry, err := c.read(ctx, paramKey)
+	if err != nil && err != redis.Nil {
+		return c.group.do(paramKey, func() ([]byte, error) {
+			return paramLoader(ctx)
+		})
+	}
+	if entry != nil && !c.shouldRefresh(entry) {
+		return entry.result()
+	}
+	return c.group.do(paramKey, func() ([]byte, error) {
+		return c.load(ctx, paramKey, entry, paramLoader)
+	})
+}
+
+// 加载并写入缓存，paramStale 为已有的(即将)过期的缓存
+func (c *RedisCache) load(ctx context.Context, paramKey string, paramStale *cacheEntry, paramLoader func(context.Context) ([]byte, error)) ([]byte, error) {
+	if c.options.LockTTL > 0 {
+		token, locked := c.lock(ctx, paramKey)
+		if locked {
+			defer c.unlock(ctx, paramKey, token)
+		} else if entry := c.waitOther(ctx, paramKey, paramStale); entry != nil {
+			return entry.result()
+		}
+	}
+
+	begin := time.Now()
+	value, err := paramLoader(ctx)
+	delta := time.Since(begin).Milliseconds()
+	if err != nil && !errors.Is(err, ErrCacheNotFound) {
+		// 加载失败时，如果还有旧的缓存则继续使用
+		if paramStale != nil {
+			return paramStale.result()
+		}
+		return nil, err
+	}
+
+	entry := &cacheEntry{negative: errors.Is(err, ErrCacheNotFound), delta: delta, value: value}
+	ttl := c.options.TTL
+	if entry.negative {
+		ttl = c.options.NegativeTTL
+	}
+	ttl = c.jitter(ttl)
+	if ttl > 0 {
+		entry.expireAt = time.Now().Add(ttl).UnixMilli()
+		// 实际过期时间多保留一些，提前刷新失败时仍然可以使用旧的缓存
+		c.cli.Set(ctx, c.keyPrefix+paramKey, entry.encode(), ttl+ttl/10+time.Second)
+	}
+	return entry.result()
+}
+
+// 等待其他实例加载完成，超时后返回旧的缓存；没有旧缓存时返回nil，由当前实例自己加载
+func (c *RedisCache) waitOther(ctx context.Context, paramKey string, paramStale *cacheEntry) *cacheEntry {
+	if paramStale != nil {
+		// 有旧的缓存时不等待，直接使用旧的缓存
+		return paramStale
+	}
+	deadline := time.Now().Add(c.options.LockWait)
+	for time.Now().Before(deadline) {
+		if !sleepContext(ctx, 50*time.Millisecond) {
+			return nil
+		}
+		entry, err := c.read(ctx, paramKey)
+		if err == nil && time.Now().UnixMilli() < entry.expireAt {
+			return entry
+		}
+	}
+	return nil
+}
+
+var cacheUnlockScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// 加载锁的key，使用单独的前缀，不会与缓存的key冲突
+func (c *RedisCache) lockKey(paramKey string) string {
+	return "cache_lock:" + c.keyPrefix + paramKey
+}
+
+func (c *RedisCache) lock(ctx context.Context, paramKey string) (string, bool) {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	token := hex.EncodeToString(buf)
+	ok, err := c.cli.SetNX(ctx, c.lockKey(paramKey), token, c.options.LockTTL).Result()
+	if err != nil {
+		// redis 出错时不阻止加载
+		return "", false
+	}
+	return token, ok
+}
+
+func (c *RedisCache) unlock(ctx context.Context, paramKey string, paramToken string) {
+	cacheUnlockScript.Run(ctx, c.cli, []string{c.lockKey(paramKey)}, paramToken)
+}
+
+// 删除缓存，数据更新后调用
+func (c *RedisCache) Del(ctx context.Context, paramKeys ...string) *redis.IntCmd {
+	keys := make([]string, 0, len(paramKeys))
+	for _, key := range paramKeys {
+		keys = append(keys, c.keyPrefix+key)
+	}
+	return c.cli.Del(ctx, keys...)
+}
+
+/*
+以JSON格式缓存 T 类型的数据
+
+  - paramCache 缓存
+  - paramKey 缓存的key(不含前缀)
+  - paramLoader 加载函数，数据不存在时返回 ErrCacheNotFound(可以用 %w 包装)
+*/
+func CacheGetJSON[T any](ctx context.Context, paramCache *RedisCache, paramKey string, paramLoader func(context.Context) (T, error)) (T, error) {
+	var ret T
+	data, err := paramCache.Get(ctx, paramKey, func(ctx context.Context) ([]byte, error) {
+		v, err := paramLoader(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(v)
+	})
+	if err != nil {
+		return ret, err
+	}
+	err = json.Unmarshal(data, &ret)
+	return ret, err
+}
+
+// 进程内的并发加载去重
+type cacheCall struct {
+	wg    sync.WaitGroup
+	value []byte
+	err   error
+}
+
+type cacheCallGroup struct {
+	mu    sync.Mutex
+	calls map[string]*cacheCall
+}
+
+// 同一个key同时只执行一次 paramFn，其余调用等待并共享结果
+func (g *cacheCallGroup) do(paramKey string, paramFn func() ([]byte, error)) (retValue []byte, retErr error) {
+	g.mu.Lock()
+	if call, ok := g.calls[paramKey]; ok {
+		g.mu.Unlock()
+		call.wg.Wait()
+		return call.value, call.err
+	}
+	call := &cacheCall{}
+	call.wg.Add(1)
+	g.calls[paramKey] = call
+	g.mu.Unlock()
+
+	defer func() {
+		// 加载函数 panic 时转为错误，等待中的调用不会拿到 nil, nil
+		if r := recover(); r != nil {
+			call.value, call.err = nil, &CacheLoaderPanicError{Key: paramKey, Value: r}
+			retValue, retErr = nil, call.err
+		}
+		g.mu.Lock()
+		delete(g.calls, paramKey)
+		g.mu.Unlock()
+		call.wg.Done()
+	}()
+	call.value, call.err = paramFn()
+	return call.value, call.err
+}
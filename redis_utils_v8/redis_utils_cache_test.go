@@ -0,0 +1,158 @@
+package redisv8
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCacheGetLoadsOnce(t *testing.T) {
+	ctx := context.Background()
+	cli, _ := newTestClient(t)
+	cache := CreateCache(cli, "c:", DefaultRedisCacheOptions())
+
+	var calls int32
+	loader := func(ctx context.Context) ([]byte, error) {
+		atomic.AddInt32(&calls, 1)
+		return []byte("v1"), nil
+	}
+	for i := 0; i < 3; i++ {
+		v, err := cache.Get(ctx, "k", loader)
+		if err != nil || string(v) != "v1" {
+			t.Fatalf("Get = %q, %v", v, err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("loader calls = %d, want 1", calls)
+	}
+
+	_, err := cache.Get(ctx, "missing", func(ctx context.Context) ([]byte, error) {
+		atomic.AddInt32(&calls, 1)
+		return nil, ErrCacheNotFound
+	})
+	if err != ErrCacheNotFound {
+		t.Fatalf("Get missing = %v, want ErrCacheNotFound", err)
+	}
+	_, err = cache.Get(ctx, "missing", loader)
+	if err != ErrCacheNotFound || calls != 2 {
+		t.Errorf("Get cached missing = %v, calls = %d", err, calls)
+	}
+}
+
+// 并发调用 Get，加载函数阻塞到所有调用都开始后才返回
+func concurrentCacheGet(t *testing.T, paramCache *RedisCache, paramLoader func(context.Context) ([]byte, error)) []error {
+	const n = 10
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = paramCache.Get(context.Background(), "k", paramLoader)
+		}(i)
+	}
+	wg.Wait()
+	return errs
+}
+
+func TestCacheRedisDownUsesSingleflight(t *testing.T) {
+	cli, mr := newTestClient(t)
+	cache := CreateCache(cli, "c:", DefaultRedisCacheOptions())
+	mr.Close()
+
+	var calls int32
+	errs := concurrentCacheGet(t, cache, func(ctx context.Context) ([]byte, error) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(200 * time.Millisecond)
+		return []byte("v"), nil
+	})
+	for _, err := range errs {
+		if err != nil {
+			t.Fatalf("Get = %v", err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("loader calls = %d, want 1", calls)
+	}
+}
+
+func TestCacheLoaderPanic(t *testing.T) {
+	cli, _ := newTestClient(t)
+	cache := CreateCache(cli, "c:", DefaultRedisCacheOptions())
+
+	errs := concurrentCacheGet(t, cache, func(ctx context.Context) ([]byte, error) {
+		time.Sleep(200 * time.Millisecond)
+		panic("boom")
+	})
+	for i, err := range errs {
+		var panicErr *CacheLoaderPanicError
+		if !errors.Is(err, ErrCacheLoaderPanic) || !errors.As(err, &panicErr) || panicErr.Value != "boom" {
+			t.Errorf("Get %d = %v, want ErrCacheLoaderPanic with the panic value", i, err)
+		}
+	}
+}
+
+func TestCacheLockKeyNamespace(t *testing.T) {
+	ctx := context.Background()
+	cli, _ := newTestClient(t)
+	cache := CreateCache(cli, "c:", DefaultRedisCacheOptions())
+
+	// 缓存key以 :loading 结尾时不能占用其他key的加载锁
+	if _, err := cache.Get(ctx, "a:loading", func(ctx context.Context) ([]byte, error) {
+		return []byte("v"), nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	token, ok := cache.lock(ctx, "a")
+	if !ok {
+		t.Fatal("lock for key a is taken by a cache entry")
+	}
+	cache.unlock(ctx, "a", token)
+	if n, _ := cli.Exists(ctx, cache.lockKey("a")).Result(); n != 0 {
+		t.Errorf("lock key not released")
+	}
+}
+
+func TestCacheJitterClamped(t *testing.T) {
+	cli, _ := newTestClient(t)
+	options := DefaultRedisCacheOptions()
+	options.Jitter = 3
+	cache := CreateCache(cli, "c:", options)
+	if cache.options.Jitter >= 1 {
+		t.Fatalf("jitter = %v", cache.options.Jitter)
+	}
+	for i := 0; i < 1000; i++ {
+		if ttl := cache.jitter(time.Minute); ttl <= 0 || ttl >= 2*time.Minute {
+			t.Fatalf("ttl = %v", ttl)
+		}
+	}
+	options.Jitter = -1
+	if cache := CreateCache(cli, "c:", options); cache.jitter(time.Minute) != time.Minute {
+		t.Fatal("negative jitter not clamped to 0")
+	}
+}
+
+func TestCacheWrappedNotFound(t *testing.T) {
+	ctx := context.Background()
+	cli, mr := newTestClient(t)
+	cache := CreateCache(cli, "c:", DefaultRedisCacheOptions())
+
+	var calls int32
+	loader := func(ctx context.Context) ([]byte, error) {
+		atomic.AddInt32(&calls, 1)
+		return nil, fmt.Errorf("user 1: %w", ErrCacheNotFound)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := cache.Get(ctx, "user:1", loader); err != ErrCacheNotFound {
+			t.Fatalf("Get = %v", err)
+		}
+	}
+	// 包装后的 ErrCacheNotFound 同样按空结果缓存
+	if calls != 1 || !mr.Exists("c:user:1") {
+		t.Fatalf("calls = %d, cached = %v", calls, mr.Exists("c:user:1"))
+	}
+}
@@ -0,0 +1,199 @@
+package redisv8
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/qiuliaogit/commonutils/commonutils"
+)
+
+// 解析后的 cron 表达式
+//
+// 格式为 分 时 日 月 周，支持 *、列表 1,2、范围 1-5、步长 */5 或 1-30/5，
+// 月和周可以使用英文缩写 JAN-DEC、SUN-SAT，周日可以写 0 或 7。
+// 也支持 @yearly @annually @monthly @weekly @daily @midnight @hourly。
+// 日和周都不是 * 时，满足任意一个即可，与 vixie cron 一致。
+type CronSchedule struct {
+	spec   string
+	minute uint64
+	hour   uint64
+	dom    uint64
+	month  uint64
+	dow    uint64
+	// 日或周为 * 时只按另一个匹配
+	domStar bool
+	dowStar bool
+}
+
+// cron 字段的取值范围
+type cronField struct {
+	name  string
+	min   int
+	max   int
+	names map[string]int
+}
+
+var (
+	cronMinute = cronField{name: "分", min: 0, max: 59}
+	cronHour   = cronField{name: "时", min: 0, max: 23}
+	cronDom    = cronField{name: "日", min: 1, max: 31}
+	cronMonth  = cronField{name: "月", min: 1, max: 12, names: map[string]int{
+		"JAN": 1, "FEB": 2, "MAR": 3, "APR": 4, "MAY": 5, "JUN": 6,
+		"JUL": 7, "AUG": 8, "SEP": 9, "OCT": 10, "NOV": 11, "DEC": 12,
+	}}
+	// 7 也表示周日，解析后合并到 0
+	cronDow = cronField{name: "周", min: 0, max: 7, names: map[string]int{
+		"SUN": 0, "MON": 1, "TUE": 2, "WED": 3, "THU": 4, "FRI": 5, "SAT": 6,
+	}}
+)
+
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// 解析 cron 表达式
+func ParseCron(paramSpec string) (*CronSchedule, error) {
+	spec := strings.TrimSpace(paramSpec)
+	if expanded, ok := cronDescriptors[strings.ToLower(spec)]; ok {
+		spec = expanded
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, commonutils.NewError(commonutils.ERR_FAIL, "cron 表达式需要5个字段："+paramSpec)
+	}
+	s := &CronSchedule{spec: paramSpec}
+	var err error
+	if s.minute, err = parseCronField(fields[0], cronMinute); err != nil {
+		return nil, err
+	}
+	if s.hour, err = parseCronField(fields[1], cronHour); err != nil {
+		return nil, err
+	}
+	if s.dom, err = parseCronField(fields[2], cronDom); err != nil {
+		return nil, err
+	}
+	if s.month, err = parseCronField(fields[3], cronMonth); err != nil {
+		return nil, err
+	}
+	if s.dow, err = parseCronField(fields[4], cronDow); err != nil {
+		return nil, err
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow = s.dow&^(1<<7) | 1
+	}
+	s.domStar = strings.HasPrefix(fields[2], "*") || fields[2] == "?"
+	s.dowStar = strings.HasPrefix(fields[4], "*") || fields[4] == "?"
+	return s, nil
+}
+
+// 解析一个字段，返回取值的位集合
+func parseCronField(paramField string, paramRange cronField) (uint64, error) {
+	fail := func() (uint64, error) {
+		return 0, commonutils.NewError(commonutils.ERR_FAIL, "cron 表达式的"+paramRange.name+"字段格式错误："+paramField)
+	}
+	var bits uint64
+	for _, part := range strings.Split(paramField, ",") {
+		rangePart, step := part, 1
+		if i := strings.IndexByte(part, '/'); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return fail()
+			}
+			rangePart, step = part[:i], n
+		}
+		var lo, hi int
+		switch {
+		case rangePart == "*" || rangePart == "?":
+			lo, hi = paramRange.min, paramRange.max
+		case strings.IndexByte(rangePart, '-') > 0:
+			i := strings.IndexByte(rangePart, '-')
+			var ok1, ok2 bool
+			lo, ok1 = paramRange.value(rangePart[:i])
+			hi, ok2 = paramRange.value(rangePart[i+1:])
+			if !ok1 || !ok2 || lo > hi {
+				return fail()
+			}
+		default:
+			var ok bool
+			lo, ok = paramRange.value(rangePart)
+			if !ok {
+				return fail()
+			}
+			hi = lo
+			// 5/10 表示从5开始每10个
+			if step > 1 {
+				hi = paramRange.max
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// 解析字段中的一个值，支持英文缩写
+func (f cronField) value(paramValue string) (int, bool) {
+	if v, ok := f.names[strings.ToUpper(paramValue)]; ok {
+		return v, true
+	}
+	v, err := strconv.Atoi(paramValue)
+	if err != nil || v < f.min || v > f.max {
+		return 0, false
+	}
+	return v, true
+}
+
+// 原始的表达式
+func (s *CronSchedule) String() string {
+	return s.spec
+}
+
+// 日期是否满足日和周的条件
+func (s *CronSchedule) matchDay(paramTime time.Time) bool {
+	domMatch := s.dom&(1<<uint(paramTime.Day())) != 0
+	dowMatch := s.dow&(1<<uint(paramTime.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+/*
+计算指定时间之后的下一次触发时间，使用 paramTime 的时区
+
+5年内没有可触发的时间时(如 2月30日)返回零值
+*/
+func (s *CronSchedule) Next(paramTime time.Time) time.Time {
+	loc := paramTime.Location()
+	t := paramTime.Truncate(time.Minute).Add(time.Minute)
+	yearLimit := t.Year() + 5
+
+	for t.Year() <= yearLimit {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !s.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
@@ -0,0 +1,368 @@
+package redisv8
+
+import (
+	"context"
+	"strconv"
+
+	redis "github.com/go-redis/redis/v8"
+	"github.com/qiuliaogit/commonutils/commonutils"
+	"github.com/qiuliaogit/utilsext/dec"
+	"github.com/shopspring/decimal"
+)
+
+var (
+	ErrDecimalScale       = commonutils.NewError(commonutils.ERR_FAIL, "小数位数超过了定点数的精度")
+	ErrDecimalOverflow    = commonutils.NewError(commonutils.ERR_FAIL, "数值超出了定点数的范围")
+	ErrDecimalOutOfBounds = commonutils.NewError(commonutils.ERR_FAIL, "结果超出了允许的范围")
+)
+
+// redis 内部用 double 比较分数和范围，超过 2^53 后无法精确表示整数
+const maxExactScaled = int64(1) << 53
+
+// 定点数的精度，值按 value * 10^scale 的整数保存
+type decimalScale int32
+
+// decimal 转为定点整数，小数位数超过精度时返回 ErrDecimalScale
+func (s decimalScale) toScaled(paramValue decimal.Decimal) (int64, error) {
+	return DecimalToScaled(paramValue, int32(s))
+}
+
+// 定点整数的字符串转为 decimal
+func (s decimalScale) fromScaled(paramValue string) (decimal.Decimal, error) {
+	return DecimalFromScaled(paramValue, int32(s))
+}
+
+/*
+decimal 转为 value * 10^scale 的定点整数
+
+  - paramValue 数值
+  - paramScale 小数位数
+
+小数位数超过精度时返回 ErrDecimalScale，超过 2^53 时返回 ErrDecimalOverflow
+*/
+func DecimalToScaled(paramValue decimal.Decimal, paramScale int32) (int64, error) {
+	shifted := paramValue.Shift(paramScale)
+	if !shifted.Equal(shifted.Truncate(0)) {
+		return 0, ErrDecimalScale
+	}
+	n := shifted.BigInt()
+	if !n.IsInt64() || n.Int64() >= maxExactScaled || n.Int64() <= -maxExactScaled {
+		return 0, ErrDecimalOverflow
+	}
+	return n.Int64(), nil
+}
+
+// 定点整数的字符串转为 decimal，空字符串返回0
+func DecimalFromScaled(paramValue string, paramScale int32) (decimal.Decimal, error) {
+	if paramValue == "" {
+		return dec.Zero(), nil
+	}
+	d, err := decimal.NewFromString(paramValue)
+	if err != nil {
+		return dec.Zero(), commonutils.NewError(commonutils.ERR_FAIL, "定点数格式错误："+paramValue)
+	}
+	return d.Shift(-paramScale), nil
+}
+
+// 可选的上下限，用于 IncrBounded，nil 表示不限制
+type DecimalBounds struct {
+	Min *decimal.Decimal
+	Max *decimal.Decimal
+}
+
+// 不允许为负数的范围，常用于余额
+func NonNegativeBounds() DecimalBounds {
+	zero := dec.Zero()
+	return DecimalBounds{Min: &zero}
+}
+
+// 转为脚本参数：是否有下限、下限、是否有上限、上限
+func (b DecimalBounds) scriptArgs(paramScale decimalScale) ([]interface{}, error) {
+	args := []interface{}{"0", 0, "0", 0}
+	if b.Min != nil {
+		v, err := paramScale.toScaled(*b.Min)
+		if err != nil {
+			return nil, err
+		}
+		args[0], args[1] = "1", v
+	}
+	if b.Max != nil {
+		v, err := paramScale.toScaled(*b.Max)
+		if err != nil {
+			return nil, err
+		}
+		args[2], args[3] = "1", v
+	}
+	return args, nil
+}
+
+/*
+定点数的Hash工具类，字段按 value * 10^scale 的整数保存，使用 HINCRBY 做精确的加减
+
+例如 scale 为 4 时，余额 12.3456 保存为 123456。
+写入时超过 2^53 的定点整数返回 ErrDecimalOverflow，IncrBounded 的范围检查和结果的 2^53 检查在 Lua 脚本中完成。
+*/
+type RedisDecimalHSetUtils struct {
+	hset  *RedisHSetUtils
+	scale decimalScale
+}
+
+/*
+创建一个定点数的Hash工具类
+
+  - paramHSet 底层的Hash工具类，超时时间等设置沿用它的配置
+  - paramScale 小数位数
+*/
+func CreateDecimalHSetUtils(paramHSet *RedisHSetUtils, paramScale int32) *RedisDecimalHSetUtils {
+	return &RedisDecimalHSetUtils{
+		hset:  paramHSet,
+		scale: decimalScale(paramScale),
+	}
+}
+
+// 设置某个字段
+func (m *RedisDecimalHSetUtils) Set(ctx context.Context, paramFieldName string, paramValue decimal.Decimal) error {
+	v, err := m.scale.toScaled(paramValue)
+	if err != nil {
+		return err
+	}
+	return m.hset.Set(ctx, paramFieldName, v).Err()
+}
+
+// 获取某个字段，字段不存在时返回0
+func (m *RedisDecimalHSetUtils) Get(ctx context.Context, paramFieldName string) (decimal.Decimal, error) {
+	v, err := m.hset.Get(ctx, paramFieldName).Result()
+	if err == redis.Nil {
+		return dec.Zero(), nil
+	}
+	if err != nil {
+		return dec.Zero(), err
+	}
+	return m.scale.fromScaled(v)
+}
+
+// 获取所有字段
+func (m *RedisDecimalHSetUtils) GetAll(ctx context.Context) (map[string]decimal.Decimal, error) {
+	all, err := m.hset.GetAll(ctx).Result()
+	if err != nil {
+		return nil, err
+	}
+	ret := make(map[string]decimal.Decimal, len(all))
+	for field, v := range all {
+		d, err := m.scale.fromScaled(v)
+		if err != nil {
+			return nil, err
+		}
+		ret[field] = d
+	}
+	return ret, nil
+}
+
+// 增减指定数值，返回增减后的值，不检查结果是否超过 2^53，需要检查时使用 IncrBounded
+func (m *RedisDecimalHSetUtils) Incr(ctx context.Context, paramFieldName string, paramIncrement decimal.Decimal) (decimal.Decimal, error) {
+	delta, err := m.scale.toScaled(paramIncrement)
+	if err != nil {
+		return dec.Zero(), err
+	}
+	v, err := m.hset.Incr(ctx, paramFieldName, delta).Result()
+	if err != nil {
+		return dec.Zero(), err
+	}
+	return m.scale.fromScaled(strconv.FormatInt(v, 10))
+}
+
+// KEYS: hash
+// ARGV: 字段, 增量, 是否有下限, 下限, 是否有上限, 上限, 超时秒数
+// 返回 {状态, 当前值}，状态 1 成功，0 超出范围，2 超过 2^53
+var hsetDecimalIncrScript = redis.NewScript(`
+local existed = redis.call('HEXISTS', KEYS[1], ARGV[1])
+local v = redis.call('HINCRBY', KEYS[1], ARGV[1], ARGV[2])
+local status = 1
+if v >= 9007199254740992 or v <= -9007199254740992 then
+	status = 2
+elseif (ARGV[3] == '1' and v < tonumber(ARGV[4])) or (ARGV[5] == '1' and v > tonumber(ARGV[6])) then
+	status = 0
+end
+if status ~= 1 then
+	if existed == 1 then
+		redis.call('HINCRBY', KEYS[1], ARGV[1], -tonumber(ARGV[2]))
+	else
+		redis.call('HDEL', KEYS[1], ARGV[1])
+	end
+	return {status, redis.call('HGET', KEYS[1], ARGV[1]) or '0'}
+end
+if tonumber(ARGV[7]) > 0 then
+	redis.call('EXPIRE', KEYS[1], ARGV[7])
+end
+return {1, redis.call('HGET', KEYS[1], ARGV[1])}
+`)
+
+/*
+原子地增减指定数值，结果超出范围时不修改并返回 ErrDecimalOutOfBounds，结果超过 2^53 时不修改并返回 ErrDecimalOverflow
+
+  - paramFieldName 字段名
+  - paramIncrement 增量，可以为负数
+  - paramBounds 范围，如 NonNegativeBounds() 表示结果不能为负数
+
+返回修改后的值，失败时返回当前的值。需要立即得到结果，始终使用客户端执行，不受批量模式影响
+*/
+func (m *RedisDecimalHSetUtils) IncrBounded(ctx context.Context, paramFieldName string, paramIncrement decimal.Decimal, paramBounds DecimalBounds) (decimal.Decimal, error) {
+	delta, err := m.scale.toScaled(paramIncrement)
+	if err != nil {
+		return dec.Zero(), err
+	}
+	bounds, err := paramBounds.scriptArgs(m.scale)
+	if err != nil {
+		return dec.Zero(), err
+	}
+	args := append([]interface{}{paramFieldName, delta}, bounds...)
+	args = append(args, m.hset.scriptExpire())
+	ret, err := hsetDecimalIncrScript.Run(ctx, m.hset.cli, []string{m.hset.HSetKey}, args...).Slice()
+	if err != nil {
+		return dec.Zero(), err
+	}
+	return decimalScriptResult(m.scale, ret)
+}
+
+// 解析脚本返回的 {状态, 当前值}
+func decimalScriptResult(paramScale decimalScale, paramRet []interface{}) (decimal.Decimal, error) {
+	if len(paramRet) != 2 {
+		return dec.Zero(), commonutils.NewError(commonutils.ERR_FAIL, "脚本返回值格式错误")
+	}
+	value, _ := paramRet[1].(string)
+	d, err := paramScale.fromScaled(value)
+	if err != nil {
+		return dec.Zero(), err
+	}
+	switch status, _ := paramRet[0].(int64); status {
+	case 1:
+		return d, nil
+	case 2:
+		return d, ErrDecimalOverflow
+	default:
+		return d, ErrDecimalOutOfBounds
+	}
+}
+
+// 带 decimal 分数的成员
+type DecimalZ struct {
+	Member string
+	Score  decimal.Decimal
+}
+
+/*
+定点数的有序集合工具类，分数按 value * 10^scale 的整数保存
+
+redis 的分数为 double，整数部分在 2^53 以内时可以精确表示，加减不会产生误差
+*/
+type RedisDecimalZSetUtils struct {
+	zset  *RedisZSetUtils
+	scale decimalScale
+}
+
+/*
+创建一个定点数的有序集合工具类
+
+  - paramZSet 底层的有序集合工具类
+  - paramScale 小数位数
+*/
+func CreateDecimalZSetUtils(paramZSet *RedisZSetUtils, paramScale int32) *RedisDecimalZSetUtils {
+	return &RedisDecimalZSetUtils{
+		zset:  paramZSet,
+		scale: decimalScale(paramScale),
+	}
+}
+
+// 增加一个成员或设置成员的分数
+func (m *RedisDecimalZSetUtils) AddOne(ctx context.Context, paramMember string, paramScore decimal.Decimal) error {
+	v, err := m.scale.toScaled(paramScore)
+	if err != nil {
+		return err
+	}
+	return m.zset.AddOneIntScore(ctx, paramMember, v).Err()
+}
+
+// 获取成员的分数，成员不存在时返回 redis.Nil
+func (m *RedisDecimalZSetUtils) GetScore(ctx context.Context, paramMember string) (decimal.Decimal, error) {
+	v, err := m.zset.GetScore(ctx, paramMember).Result()
+	if err != nil {
+		return dec.Zero(), err
+	}
+	return m.scale.fromScaled(strconv.FormatFloat(v, 'f', 0, 64))
+}
+
+// 给成员增加分数，返回增加后的分数，不检查结果是否超过 2^53，需要检查时使用 IncrementScoreBounded
+func (m *RedisDecimalZSetUtils) IncrementScore(ctx context.Context, paramMember string, paramIncrement decimal.Decimal) (decimal.Decimal, error) {
+	delta, err := m.scale.toScaled(paramIncrement)
+	if err != nil {
+		return dec.Zero(), err
+	}
+	v, err := m.zset.IncrementScoreIntScore(ctx, paramMember, delta).Result()
+	if err != nil {
+		return dec.Zero(), err
+	}
+	return m.scale.fromScaled(strconv.FormatFloat(v, 'f', 0, 64))
+}
+
+// KEYS: zset
+// ARGV: 成员, 增量, 是否有下限, 下限, 是否有上限, 上限
+// 返回 {状态, 当前分数}，状态 1 成功，0 超出范围，2 超过 2^53
+var zsetDecimalIncrScript = redis.NewScript(`
+local old = redis.call('ZSCORE', KEYS[1], ARGV[1])
+local v = tonumber(redis.call('ZINCRBY', KEYS[1], ARGV[2], ARGV[1]))
+local status = 1
+if v >= 9007199254740992 or v <= -9007199254740992 then
+	status = 2
+elseif (ARGV[3] == '1' and v < tonumber(ARGV[4])) or (ARGV[5] == '1' and v > tonumber(ARGV[6])) then
+	status = 0
+end
+if status ~= 1 then
+	if old then
+		redis.call('ZADD', KEYS[1], old, ARGV[1])
+	else
+		redis.call('ZREM', KEYS[1], ARGV[1])
+	end
+	return {status, string.format('%.0f', tonumber(old or '0'))}
+end
+return {1, string.format('%.0f', v)}
+`)
+
+/*
+原子地给成员增加分数，结果超出范围时不修改并返回 ErrDecimalOutOfBounds，结果超过 2^53 时不修改并返回 ErrDecimalOverflow
+
+返回修改后的分数，失败时返回当前的分数。需要立即得到结果，始终使用客户端执行，不受批量模式影响
+*/
+func (m *RedisDecimalZSetUtils) IncrementScoreBounded(ctx context.Context, paramMember string, paramIncrement decimal.Decimal, paramBounds DecimalBounds) (decimal.Decimal, error) {
+	delta, err := m.scale.toScaled(paramIncrement)
+	if err != nil {
+		return dec.Zero(), err
+	}
+	bounds, err := paramBounds.scriptArgs(m.scale)
+	if err != nil {
+		return dec.Zero(), err
+	}
+	args := append([]interface{}{paramMember, delta}, bounds...)
+	ret, err := zsetDecimalIncrScript.Run(ctx, m.zset.cli, []string{m.zset.key}, args...).Slice()
+	if err != nil {
+		return dec.Zero(), err
+	}
+	return decimalScriptResult(m.scale, ret)
+}
+
+// 取指定排名范围内的成员和分数，按分数从大到小
+func (m *RedisDecimalZSetUtils) MemberListRevWithScore(ctx context.Context, paramStartRank, paramStopRank int64) ([]DecimalZ, error) {
+	list, err := m.zset.MemberListRevWithScore2(ctx, paramStartRank, paramStopRank).Result()
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]DecimalZ, 0, len(list))
+	for _, z := range list {
+		d, err := m.scale.fromScaled(strconv.FormatFloat(z.Score, 'f', 0, 64))
+		if err != nil {
+			return nil, err
+		}
+		member, _ := z.Member.(string)
+		ret = append(ret, DecimalZ{Member: member, Score: d})
+	}
+	return ret, nil
+}
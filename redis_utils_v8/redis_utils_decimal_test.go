@@ -0,0 +1,94 @@
+package redisv8
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestDecimalHSetIncrBounded(t *testing.T) {
+	cli, mr := newTestClient(t)
+	ctx := context.Background()
+	hset := CreateHSetUtils(cli, "wallet:1", 60, true)
+	wallet := CreateDecimalHSetUtils(hset, 2)
+
+	v, err := wallet.IncrBounded(ctx, "balance", decimal.RequireFromString("10.25"), NonNegativeBounds())
+	if err != nil || !v.Equal(decimal.RequireFromString("10.25")) {
+		t.Fatalf("incr = %v, %v", v, err)
+	}
+	if got := mr.HGet("wallet:1", "balance"); got != "1025" {
+		t.Fatalf("stored = %q", got)
+	}
+	// 超出下限时不修改，返回当前的值
+	v, err = wallet.IncrBounded(ctx, "balance", decimal.RequireFromString("-11"), NonNegativeBounds())
+	if err != ErrDecimalOutOfBounds || !v.Equal(decimal.RequireFromString("10.25")) {
+		t.Fatalf("out of bounds = %v, %v", v, err)
+	}
+	// 字段原本不存在时，超出范围后删除字段
+	if _, err := wallet.IncrBounded(ctx, "frozen", decimal.RequireFromString("-1"), NonNegativeBounds()); err != ErrDecimalOutOfBounds {
+		t.Fatalf("missing field err = %v", err)
+	}
+	if mr.HGet("wallet:1", "frozen") != "" {
+		t.Fatal("missing field should be removed")
+	}
+	if _, err := wallet.IncrBounded(ctx, "balance", decimal.RequireFromString("0.001"), NonNegativeBounds()); err != ErrDecimalScale {
+		t.Fatalf("scale err = %v", err)
+	}
+
+	// 结果超过 2^53 时不修改
+	hset.Set(ctx, "big", strconv.FormatInt(maxExactScaled-1, 10))
+	if _, err := wallet.IncrBounded(ctx, "big", decimal.RequireFromString("0.01"), DecimalBounds{}); err != ErrDecimalOverflow {
+		t.Fatalf("overflow err = %v", err)
+	}
+	if got := mr.HGet("wallet:1", "big"); got != strconv.FormatInt(maxExactScaled-1, 10) {
+		t.Fatalf("big = %q", got)
+	}
+
+	// 批量模式的副本同样立即返回结果
+	batch := CreateBatch(cli)
+	v, err = CreateDecimalHSetUtils(hset.Batch(batch), 2).IncrBounded(ctx, "balance", decimal.RequireFromString("1"), NonNegativeBounds())
+	if err != nil || !v.Equal(decimal.RequireFromString("11.25")) || batch.Len() != 0 {
+		t.Fatalf("batch copy = %v, %v, len %d", v, err, batch.Len())
+	}
+}
+
+func TestDecimalZSetIncrementScoreBounded(t *testing.T) {
+	cli, _ := newTestClient(t)
+	ctx := context.Background()
+	zset := CreateZSetUtils(cli, "rank", 0, false)
+	rank := CreateDecimalZSetUtils(zset, 2)
+
+	max := decimal.RequireFromString("100")
+	bounds := DecimalBounds{Max: &max}
+	v, err := rank.IncrementScoreBounded(ctx, "tom", decimal.RequireFromString("99.5"), bounds)
+	if err != nil || !v.Equal(decimal.RequireFromString("99.5")) {
+		t.Fatalf("incr = %v, %v", v, err)
+	}
+	v, err = rank.IncrementScoreBounded(ctx, "tom", decimal.RequireFromString("1"), bounds)
+	if err != ErrDecimalOutOfBounds || !v.Equal(decimal.RequireFromString("99.5")) {
+		t.Fatalf("out of bounds = %v, %v", v, err)
+	}
+
+	zset.AddOneIntScore(ctx, "big", maxExactScaled-2)
+	if _, err := rank.IncrementScoreBounded(ctx, "big", decimal.RequireFromString("0.02"), DecimalBounds{}); err != ErrDecimalOverflow {
+		t.Fatalf("overflow err = %v", err)
+	}
+	if score, _ := zset.GetScore(ctx, "big").Result(); int64(score) != maxExactScaled-2 {
+		t.Fatalf("big = %v", score)
+	}
+}
+
+func TestDecimalToScaled(t *testing.T) {
+	v, err := DecimalToScaled(decimal.RequireFromString("12.3456"), 4)
+	if err != nil || v != 123456 {
+		t.Errorf("DecimalToScaled = %d, %v", v, err)
+	}
+	if _, err := DecimalToScaled(decimal.RequireFromString("0.00001"), 4); err != ErrDecimalScale {
+		t.Errorf("scale error = %v", err)
+	}
+	if _, err := DecimalToScaled(decimal.RequireFromString("1e20"), 4); err != ErrDecimalOverflow {
+		t.Errorf("overflow error = %v", err)
+	}
+}
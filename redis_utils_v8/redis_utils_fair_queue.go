@@ -0,0 +1,348 @@
+package redisv8
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+
+	redis "github.com/go-redis/redis/v8"
+	"github.com/qiuliaogit/commonutils/commonutils"
+)
+
+var ErrFairQueueTenantFull = commonutils.NewError(commonutils.ERR_FAIL, "租户的队列已满")
+
+// 判断 Push 的错误是否为租户的队列已满，批量模式下错误为redis返回的脚本错误
+func IsFairQueueTenantFull(paramErr error) bool {
+	if paramErr == nil {
+		return false
+	}
+	return paramErr == ErrFairQueueTenantFull || strings.Contains(paramErr.Error(), "TENANT_FULL")
+}
+
+/*
+基于redis的多租户公平队列，避免一个租户积压大量消息导致其他租户饿死
+
+  - key:t:租户 每个租户一个列表
+  - key:ring 有积压的租户的轮转列表，弹出时按顺序轮流取各租户的元素
+  - key:members 在轮转列表中的租户集合，避免重复加入
+  - key:weight 租户的权重，每轮最多连续取权重个元素，默认为1
+  - key:credit 租户本轮已连续取出的数量
+  - key:cap 租户的最大积压数量，没有设置时使用创建时的默认值
+  - key:paused 暂停的租户，弹出时跳过，可以继续压入
+
+弹出时要取哪个租户的列表只能在 Lua 脚本中决定，租户列表的 key 只能在脚本中拼接，
+所以队列key没有 hash tag 时会自动加上，如 notify 变为 {notify}，保证集群模式下所有 key 在同一个 slot。
+自动更新超时时间只作用于租户列表、ring、members、credit，权重、最大积压数量和暂停的设置不会过期
+*/
+type RedisFairQueueUtils struct {
+	key         string
+	cli         *redis.Client
+	pipe        redis.Pipeliner // 批量执行时命令写入的管道，nil 表示直接执行
+	expire      int32           // 超时时间单位秒
+	auto_expire bool            // 是否自动更新超期时间  否则手动更新，默认自动更新
+	defaultCap  int64
+}
+
+/*
+创建一个多租户公平队列
+
+  - paramCli
+  - paramQueueKey 队列的key，没有 hash tag 时自动加上
+  - paramExpire 超时时间，<=0 时表示没有超时， 单位秒
+  - paramAutoExpire 是否在压入和弹出后自动更新超时时间
+  - paramDefaultCap 每个租户默认的最大积压数量 0表示不限制
+*/
+func CreateFairQueueUtils(paramCli *redis.Client, paramQueueKey string, paramExpire int32, paramAutoExpire bool, paramDefaultCap int64) *RedisFairQueueUtils {
+	if !hasHashTag(paramQueueKey) {
+		paramQueueKey = "{" + paramQueueKey + "}"
+	}
+	return &RedisFairQueueUtils{
+		key:         paramQueueKey,
+		cli:         paramCli,
+		expire:      paramExpire,
+		auto_expire: paramAutoExpire,
+		defaultCap:  paramDefaultCap,
+	}
+}
+
+// key 是否带有非空的 hash tag，规则与redis集群计算 slot 时相同
+func hasHashTag(paramKey string) bool {
+	begin := strings.IndexByte(paramKey, '{')
+	if begin < 0 {
+		return false
+	}
+	end := strings.IndexByte(paramKey[begin+1:], '}')
+	return end > 0
+}
+
+// 队列的key，带有 hash tag
+func (m *RedisFairQueueUtils) GetKey() string {
+	return m.key
+}
+
+// 设置字段更新超时标志
+func (m *RedisFairQueueUtils) SetAutoExpire(paramValue bool) {
+	m.auto_expire = paramValue
+}
+
+// 自动更新超时时传给脚本的超时秒数，0 表示不更新
+func (m *RedisFairQueueUtils) scriptExpire() int32 {
+	if m.auto_expire && m.expire > 0 {
+		return m.expire
+	}
+	return 0
+}
+
+// 租户列表key的前缀
+func (m *RedisFairQueueUtils) tenantPrefix() string {
+	return m.key + ":t:"
+}
+
+// 租户的列表key
+func (m *RedisFairQueueUtils) tenantKey(paramTenant string) string {
+	return m.tenantPrefix() + paramTenant
+}
+
+func (m *RedisFairQueueUtils) scriptKeys() []string {
+	return []string{
+		m.key + ":ring",
+		m.key + ":members",
+		m.key + ":weight",
+		m.key + ":credit",
+		m.key + ":cap",
+		m.key + ":paused",
+	}
+}
+
+// KEYS: ring, members, weight, credit, cap, paused, 租户的列表
+// ARGV: 租户, 默认最大积压数量, 超时秒数, 元素...
+// 元素分批 RPUSH，避免 unpack 超过 Lua 栈的限制
+var fairQueuePushScript = newIntScript(`
+local cap = tonumber(redis.call('HGET', KEYS[5], ARGV[1]) or ARGV[2])
+local n = #ARGV - 3
+if cap > 0 and redis.call('LLEN', KEYS[7]) + n > cap then
+	return redis.error_reply('TENANT_FULL')
+end
+local len = 0
+for i = 4, #ARGV, 1000 do
+	len = redis.call('RPUSH', KEYS[7], unpack(ARGV, i, math.min(i + 999, #ARGV)))
+end
+if redis.call('SADD', KEYS[2], ARGV[1]) == 1 then
+	redis.call('RPUSH', KEYS[1], ARGV[1])
+end
+if tonumber(ARGV[3]) > 0 then
+	redis.call('EXPIRE', KEYS[7], ARGV[3])
+	redis.call('EXPIRE', KEYS[1], ARGV[3])
+	redis.call('EXPIRE', KEYS[2], ARGV[3])
+end
+return len
+`)
+
+/*
+压入租户的队列
+
+  - paramTenant 租户
+  - paramValue 元素
+
+返回租户的积压数量，超过租户的最大积压数量时不压入并返回 ErrFairQueueTenantFull(批量模式下用 IsFairQueueTenantFull 判断)
+*/
+func (m *RedisFairQueueUtils) Push(ctx context.Context, paramTenant string, paramValue ...interface{}) *redis.IntCmd {
+	if len(paramValue) == 0 {
+		return m.Backlog(ctx, paramTenant)
+	}
+	keys := append(m.scriptKeys(), m.tenantKey(paramTenant))
+	args := append([]interface{}{paramTenant, m.defaultCap, m.scriptExpire()}, paramValue...)
+	retCmd := evalIntScript(ctx, m.cmd(), fairQueuePushScript, keys, args...)
+	if m.pipe == nil && IsFairQueueTenantFull(retCmd.Err()) {
+		retCmd.SetErr(ErrFairQueueTenantFull)
+	}
+	return retCmd
+}
+
+// KEYS: ring, members, weight, credit, cap, paused
+// ARGV: 租户列表key的前缀, 超时秒数
+var fairQueuePopScript = redis.NewScript(`
+local function touch(list)
+	if tonumber(ARGV[2]) > 0 then
+		redis.call('EXPIRE', list, ARGV[2])
+		redis.call('EXPIRE', KEYS[1], ARGV[2])
+		redis.call('EXPIRE', KEYS[2], ARGV[2])
+		redis.call('EXPIRE', KEYS[4], ARGV[2])
+	end
+end
+local rounds = redis.call('LLEN', KEYS[1])
+for i = 1, rounds do
+	local tenant = redis.call('LINDEX', KEYS[1], 0)
+	if not tenant then
+		return false
+	end
+	local list = ARGV[1] .. tenant
+	if redis.call('SISMEMBER', KEYS[6], tenant) == 1 then
+		redis.call('RPUSH', KEYS[1], redis.call('LPOP', KEYS[1]))
+	else
+		local v = redis.call('LPOP', list)
+		if not v then
+			redis.call('LPOP', KEYS[1])
+			redis.call('SREM', KEYS[2], tenant)
+			redis.call('HDEL', KEYS[4], tenant)
+		else
+			local weight = tonumber(redis.call('HGET', KEYS[3], tenant) or '1')
+			local credit = redis.call('HINCRBY', KEYS[4], tenant, 1)
+			local left = redis.call('LLEN', list)
+			if left == 0 then
+				redis.call('LPOP', KEYS[1])
+				redis.call('SREM', KEYS[2], tenant)
+				redis.call('HDEL', KEYS[4], tenant)
+			elseif credit >= weight then
+				redis.call('HDEL', KEYS[4], tenant)
+				redis.call('RPUSH', KEYS[1], redis.call('LPOP', KEYS[1]))
+			end
+			touch(list)
+			return {tenant, v}
+		end
+	end
+end
+return false
+`)
+
+/*
+按租户轮流弹出一个元素，返回租户和元素
+
+权重为 n 的租户每轮最多连续弹出 n 个元素，暂停的租户被跳过，
+没有可弹出的元素时返回 redis.Nil。弹出需要立即取得结果，批量模式下返回 ErrBatchUnsupported
+*/
+func (m *RedisFairQueueUtils) Pop(ctx context.Context) (string, string, error) {
+	if m.pipe != nil {
+		return "", "", ErrBatchUnsupported
+	}
+	ret, err := fairQueuePopScript.Run(ctx, m.cli, m.scriptKeys(), m.tenantPrefix(), m.scriptExpire()).Slice()
+	if err != nil {
+		return "", "", err
+	}
+	tenant, _ := ret[0].(string)
+	value, _ := ret[1].(string)
+	return tenant, value, nil
+}
+
+// 租户的积压数量
+func (m *RedisFairQueueUtils) Backlog(ctx context.Context, paramTenant string) *redis.IntCmd {
+	return m.cmd().LLen(ctx, m.tenantKey(paramTenant))
+}
+
+// 查看租户最早的若干个元素，不弹出
+func (m *RedisFairQueueUtils) Peek(ctx context.Context, paramTenant string, paramCount int64) *redis.StringSliceCmd {
+	return m.cmd().LRange(ctx, m.tenantKey(paramTenant), 0, paramCount-1)
+}
+
+// 设置租户的权重，<=1 时恢复为默认的1
+func (m *RedisFairQueueUtils) SetWeight(ctx context.Context, paramTenant string, paramWeight int64) *redis.IntCmd {
+	if paramWeight <= 1 {
+		return m.cmd().HDel(ctx, m.key+":weight", paramTenant)
+	}
+	return m.cmd().HSet(ctx, m.key+":weight", paramTenant, paramWeight)
+}
+
+// 设置租户的最大积压数量，<0 时恢复为默认值，0 表示不限制
+func (m *RedisFairQueueUtils) SetCap(ctx context.Context, paramTenant string, paramCap int64) *redis.IntCmd {
+	if paramCap < 0 {
+		return m.cmd().HDel(ctx, m.key+":cap", paramTenant)
+	}
+	return m.cmd().HSet(ctx, m.key+":cap", paramTenant, paramCap)
+}
+
+// 暂停租户，暂停期间不弹出该租户的元素，可以继续压入
+func (m *RedisFairQueueUtils) Pause(ctx context.Context, paramTenant string) *redis.IntCmd {
+	return m.cmd().SAdd(ctx, m.key+":paused", paramTenant)
+}
+
+// 恢复租户
+func (m *RedisFairQueueUtils) Resume(ctx context.Context, paramTenant string) *redis.IntCmd {
+	return m.cmd().SRem(ctx, m.key+":paused", paramTenant)
+}
+
+// 删除租户所有积压的元素
+func (m *RedisFairQueueUtils) Purge(ctx context.Context, paramTenant string) *redis.IntCmd {
+	return m.cmd().Del(ctx, m.tenantKey(paramTenant))
+}
+
+// 一个租户的统计
+type FairQueueTenantStats struct {
+	Tenant  string
+	Backlog int64
+	Weight  int64
+	Cap     int64
+	Paused  bool
+}
+
+// 所有有积压或暂停的租户的统计，按积压数量从多到少，积压相同时按租户排序。批量模式下返回 ErrBatchUnsupported
+func (m *RedisFairQueueUtils) Stats(ctx context.Context) ([]FairQueueTenantStats, error) {
+	if m.pipe != nil {
+		return nil, ErrBatchUnsupported
+	}
+	var membersCmd, pausedCmd *redis.StringSliceCmd
+	var weightCmd, capCmd *redis.StringStringMapCmd
+	_, err := m.cli.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		membersCmd = pipe.SMembers(ctx, m.key+":members")
+		pausedCmd = pipe.SMembers(ctx, m.key+":paused")
+		weightCmd = pipe.HGetAll(ctx, m.key+":weight")
+		capCmd = pipe.HGetAll(ctx, m.key+":cap")
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	paused := make(map[string]bool)
+	for _, t := range pausedCmd.Val() {
+		paused[t] = true
+	}
+	tenants := membersCmd.Val()
+	for t := range paused {
+		if !commonutils.IsInArray(t, tenants) {
+			tenants = append(tenants, t)
+		}
+	}
+	backlogCmds := make([]*redis.IntCmd, len(tenants))
+	_, err = m.cli.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, t := range tenants {
+			backlogCmds[i] = pipe.LLen(ctx, m.tenantKey(t))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]FairQueueTenantStats, len(tenants))
+	for i, t := range tenants {
+		s := FairQueueTenantStats{Tenant: t, Backlog: backlogCmds[i].Val(), Weight: 1, Cap: m.defaultCap, Paused: paused[t]}
+		if w, ok := weightCmd.Val()[t]; ok {
+			s.Weight, _ = strconv.ParseInt(w, 10, 64)
+		}
+		if c, ok := capCmd.Val()[t]; ok {
+			s.Cap, _ = strconv.ParseInt(c, 10, 64)
+		}
+		ret[i] = s
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Backlog != ret[j].Backlog {
+			return ret[i].Backlog > ret[j].Backlog
+		}
+		return ret[i].Tenant < ret[j].Tenant
+	})
+	return ret, nil
+}
+
+// 取执行命令的客户端，批量模式下为管道
+func (m *RedisFairQueueUtils) cmd() redis.Cmdable {
+	if m.pipe != nil {
+		return m.pipe
+	}
+	return m.cli
+}
+
+// 返回绑定到批量操作的副本，副本的命令会写入批量操作，在 RedisBatch.Exec 时一起执行
+func (m *RedisFairQueueUtils) Batch(paramBatch *RedisBatch) *RedisFairQueueUtils {
+	c := *m
+	c.pipe = paramBatch.pipe
+	return &c
+}
@@ -0,0 +1,125 @@
+package redisv8
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	redis "github.com/go-redis/redis/v8"
+)
+
+// 连续弹出 paramCount 次，返回各次的租户
+func popTenants(t *testing.T, paramQueue *RedisFairQueueUtils, paramCount int) string {
+	t.Helper()
+	tenants := make([]string, 0, paramCount)
+	for i := 0; i < paramCount; i++ {
+		tenant, _, err := paramQueue.Pop(context.Background())
+		if err != nil {
+			t.Fatalf("Pop %d: %v", i, err)
+		}
+		tenants = append(tenants, tenant)
+	}
+	return strings.Join(tenants, "")
+}
+
+func TestFairQueueWeightedRoundRobin(t *testing.T) {
+	cli, _ := newTestClient(t)
+	ctx := context.Background()
+	q := CreateFairQueueUtils(cli, "notify", 0, false, 0)
+	if q.GetKey() != "{notify}" {
+		t.Fatalf("key = %q", q.GetKey())
+	}
+
+	q.Push(ctx, "a", 1, 2, 3, 4, 5, 6)
+	q.Push(ctx, "b", 1, 2, 3)
+	q.Push(ctx, "c", 1)
+	q.SetWeight(ctx, "a", 2)
+	// a 每轮连续取2个，c 取完后离开轮转
+	if got := popTenants(t, q, 10); got != "aabcaabaab" {
+		t.Fatalf("order = %s", got)
+	}
+	if _, _, err := q.Pop(ctx); err != redis.Nil {
+		t.Fatalf("empty Pop err = %v", err)
+	}
+	// 同一租户内先进先出
+	q.Push(ctx, "a", "x", "y")
+	if _, v, _ := q.Pop(ctx); v != "x" {
+		t.Fatalf("value = %q", v)
+	}
+}
+
+func TestFairQueuePause(t *testing.T) {
+	cli, _ := newTestClient(t)
+	ctx := context.Background()
+	q := CreateFairQueueUtils(cli, "{notify}", 0, false, 0)
+
+	q.Push(ctx, "a", 1, 2)
+	q.Push(ctx, "b", 1, 2)
+	q.Pause(ctx, "a")
+	if got := popTenants(t, q, 2); got != "bb" {
+		t.Fatalf("order while paused = %s", got)
+	}
+	if _, _, err := q.Pop(ctx); err != redis.Nil {
+		t.Fatalf("only paused tenant left, err = %v", err)
+	}
+	// 暂停期间可以继续压入
+	if n, err := q.Push(ctx, "a", 3).Result(); err != nil || n != 3 {
+		t.Fatalf("push while paused = %d, %v", n, err)
+	}
+	stats, err := q.Stats(ctx)
+	if err != nil || len(stats) != 1 || stats[0].Tenant != "a" || !stats[0].Paused || stats[0].Backlog != 3 {
+		t.Fatalf("stats = %+v, %v", stats, err)
+	}
+	q.Resume(ctx, "a")
+	if got := popTenants(t, q, 3); got != "aaa" {
+		t.Fatalf("order after resume = %s", got)
+	}
+}
+
+func TestFairQueueCap(t *testing.T) {
+	cli, mr := newTestClient(t)
+	ctx := context.Background()
+	q := CreateFairQueueUtils(cli, "{notify}", 60, true, 2)
+
+	if n, err := q.Push(ctx, "a", 1, 2).Result(); err != nil || n != 2 {
+		t.Fatalf("push = %d, %v", n, err)
+	}
+	// 超过上限时整批不压入
+	if _, err := q.Push(ctx, "a", 3).Result(); err != ErrFairQueueTenantFull {
+		t.Fatalf("full err = %v", err)
+	}
+	if n, _ := q.Backlog(ctx, "a").Result(); n != 2 {
+		t.Fatalf("backlog = %d", n)
+	}
+	if mr.TTL("{notify}:t:a") != 60*time.Second || mr.TTL("{notify}:ring") != 60*time.Second {
+		t.Fatal("expire not set")
+	}
+
+	// 单独设置的上限优先于默认值，0 表示不限制
+	q.SetCap(ctx, "a", 0)
+	if err := q.Push(ctx, "a", 3, 4, 5).Err(); err != nil {
+		t.Fatal(err)
+	}
+	q.SetCap(ctx, "b", 1)
+	batch := CreateBatch(cli)
+	okCmd := q.Batch(batch).Push(ctx, "b", 1)
+	fullCmd := q.Batch(batch).Push(ctx, "b", 2)
+	batch.Exec(ctx)
+	if okCmd.Err() != nil || !IsFairQueueTenantFull(fullCmd.Err()) {
+		t.Fatalf("batch push = %v, %v", okCmd.Err(), fullCmd.Err())
+	}
+	// 需要立即取得结果的方法不能在批量模式下使用
+	pending := CreateBatch(cli)
+	if _, _, err := q.Batch(pending).Pop(ctx); err != ErrBatchUnsupported {
+		t.Fatalf("batch Pop err = %v", err)
+	}
+	if _, err := q.Batch(pending).Stats(ctx); err != ErrBatchUnsupported {
+		t.Fatalf("batch Stats err = %v", err)
+	}
+
+	stats, err := q.Stats(ctx)
+	if err != nil || len(stats) != 2 || stats[0].Tenant != "a" || stats[0].Cap != 0 || stats[1].Cap != 1 {
+		t.Fatalf("stats = %+v, %v", stats, err)
+	}
+}
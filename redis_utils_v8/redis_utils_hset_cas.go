@@ -0,0 +1,231 @@
+package redisv8
+
+import (
+	"context"
+	mrand "math/rand"
+	"time"
+
+	redis "github.com/go-redis/redis/v8"
+	"github.com/qiuliaogit/commonutils/commonutils"
+)
+
+// 乐观锁更新时超过最大重试次数仍然冲突
+var ErrCASConflict = commonutils.NewError(commonutils.ERR_FAIL, "并发修改冲突，超过最大重试次数")
+
+// 乐观锁更新的重试配置
+type CASOptions struct {
+	MaxRetries  int           // 最大重试次数
+	BaseBackoff time.Duration // 第一次重试前的等待时间，之后每次翻倍
+	MaxBackoff  time.Duration // 最长等待时间
+}
+
+// 默认重试配置：最多重试10次，等待时间从5毫秒开始翻倍，最长200毫秒
+func DefaultCASOptions() CASOptions {
+	return CASOptions{
+		MaxRetries:  10,
+		BaseBackoff: 5 * time.Millisecond,
+		MaxBackoff:  200 * time.Millisecond,
+	}
+}
+
+// 第 paramAttempt 次重试前的等待时间，加上随机值避免多个客户端同时重试
+func (o CASOptions) backoff(paramAttempt int) time.Duration {
+	d := o.BaseBackoff << paramAttempt
+	if d <= 0 || d > o.MaxBackoff {
+		d = o.MaxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(mrand.Int63n(int64(d/2)+1))
+}
+
+/*
+使用 WATCH/MULTI/EXEC 对多个字段做读取-计算-写回，期间字段被其他客户端修改时自动重试
+
+  - paramFields 需要读取的字段
+  - paramOptions 重试配置
+  - paramFn 计算函数，参数为字段当前的值(不存在的字段不在map中)，返回需要写入的字段和值，返回nil表示不写入
+
+计算函数可能被调用多次，不能有副作用。超过最大重试次数返回 ErrCASConflict。
+*/
+func (m *RedisHSetUtils) UpdateFields(ctx context.Context, paramFields []string, paramOptions CASOptions, paramFn func(paramValues map[string]string) (map[string]interface{}, error)) (map[string]interface{}, error) {
+	var result map[string]interface{}
+	txf := func(tx *redis.Tx) error {
+		values := make(map[string]string, len(paramFields))
+		if len(paramFields) > 0 {
+			list, err := tx.HMGet(ctx, m.HSetKey, paramFields...).Result()
+			if err != nil {
+				return err
+			}
+			for i, v := range list {
+				if s, ok := v.(string); ok {
+					values[paramFields[i]] = s
+				}
+			}
+		}
+		updates, err := paramFn(values)
+		if err != nil {
+			return err
+		}
+		result = updates
+		if len(updates) == 0 {
+			return nil
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, m.HSetKey, updates)
+			if m.auto_expire && m.expire > 0 {
+				pipe.Expire(ctx, m.HSetKey, time.Duration(m.expire)*time.Second)
+			}
+			return nil
+		})
+		return err
+	}
+
+	for attempt := 0; attempt <= paramOptions.MaxRetries; attempt++ {
+		if attempt > 0 && !sleepContext(ctx, paramOptions.backoff(attempt-1)) {
+			return nil, ctx.Err()
+		}
+		// WATCH 需要独占连接，不能在批量模式下使用，这里始终使用客户端
+		err := m.cli.Watch(ctx, txf, m.HSetKey)
+		if err == redis.TxFailedErr {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return result, nil
+	}
+	return nil, ErrCASConflict
+}
+
+/*
+使用 WATCH/MULTI/EXEC 对一个字段做读取-计算-写回，期间被其他客户端修改时自动重试
+
+  - paramFieldName 字段名
+  - paramOptions 重试配置
+  - paramFn 计算函数，参数为字段当前的值和是否存在，返回新的值
+
+例如按 decimal 增加余额：
+
+	hset.Update(ctx, "balance", redisv8.DefaultCASOptions(), func(old string, exists bool) (string, error) {
+		return dec.Add(old, amount).String(), nil
+	})
+*/
+func (m *RedisHSetUtils) Update(ctx context.Context, paramFieldName string, paramOptions CASOptions, paramFn func(paramOld string, paramExists bool) (string, error)) (string, error) {
+	var newValue string
+	_, err := m.UpdateFields(ctx, []string{paramFieldName}, paramOptions, func(paramValues map[string]string) (map[string]interface{}, error) {
+		old, exists := paramValues[paramFieldName]
+		v, err := paramFn(old, exists)
+		if err != nil {
+			return nil, err
+		}
+		newValue = v
+		return map[string]interface{}{paramFieldName: v}, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return newValue, nil
+}
+
+// KEYS: hash
+// ARGV: 字段, 是否要求字段不存在(1/0), 期望的值, 新的值, 超时秒数
+var hsetCompareAndSetScript = redis.NewScript(`
+local cur = redis.call('HGET', KEYS[1], ARGV[1])
+if ARGV[2] == '1' then
+	if cur then
+		return 0
+	end
+elseif cur ~= ARGV[3] then
+	return 0
+end
+redis.call('HSET', KEYS[1], ARGV[1], ARGV[4])
+if tonumber(ARGV[5]) > 0 then
+	redis.call('EXPIRE', KEYS[1], ARGV[5])
+end
+return 1
+`)
+
+// 自动更新超时时间时的秒数，否则为0
+func (m *RedisHSetUtils) scriptExpire() int32 {
+	if m.auto_expire && m.expire > 0 {
+		return m.expire
+	}
+	return 0
+}
+
+/*
+原子地比较并设置字段(Lua脚本)，字段当前的值等于 paramExpected 时才写入新值，返回是否写入
+
+  - paramFieldName 字段名
+  - paramExpected 期望的当前值，为nil表示要求字段不存在
+  - paramValue 新的值
+
+需要立即得到比较结果，始终使用客户端执行，不受批量模式影响。
+同时比较版本号并写入多个字段时使用 CompareVersionAndSet
+*/
+func (m *RedisHSetUtils) CompareAndSet(ctx context.Context, paramFieldName string, paramExpected *string, paramValue interface{}) (bool, error) {
+	mustNotExist, expected := "0", ""
+	if paramExpected == nil {
+		mustNotExist = "1"
+	} else {
+		expected = *paramExpected
+	}
+	ret, err := hsetCompareAndSetScript.Run(ctx, m.cli, []string{m.HSetKey},
+		paramFieldName, mustNotExist, expected, paramValue, m.scriptExpire()).Int()
+	if err != nil {
+		return false, err
+	}
+	return ret == 1, nil
+}
+
+// KEYS: hash
+// ARGV: 版本号字段, 期望的版本号, 超时秒数, 字段1, 值1, 字段2, 值2...
+var hsetCompareVersionScript = redis.NewScript(`
+local cur = tonumber(redis.call('HGET', KEYS[1], ARGV[1]) or '0')
+if cur ~= tonumber(ARGV[2]) then
+	return {0, cur}
+end
+local ver = cur + 1
+for i = 4, #ARGV, 2 do
+	redis.call('HSET', KEYS[1], ARGV[i], ARGV[i + 1])
+end
+redis.call('HSET', KEYS[1], ARGV[1], ver)
+if tonumber(ARGV[3]) > 0 then
+	redis.call('EXPIRE', KEYS[1], ARGV[3])
+end
+return {1, ver}
+`)
+
+/*
+原子地比较版本号并写入多个字段(Lua脚本)，版本号字段等于 paramVersion 时写入 paramValues，并把版本号加1
+
+  - paramVersionField 版本号字段名，字段不存在时版本号为0
+  - paramVersion 读取数据时得到的版本号
+  - paramValues 需要写入的字段和值
+
+返回是否写入，以及当前的版本号(写入成功时为新的版本号)。始终使用客户端执行，不受批量模式影响
+
+	values, _ := hset.GetAll(ctx).Result()
+	ver, _ := strconv.ParseInt(values["ver"], 10, 64)
+	ok, _, err := hset.CompareVersionAndSet(ctx, "ver", ver, map[string]interface{}{"balance": newBalance})
+*/
+func (m *RedisHSetUtils) CompareVersionAndSet(ctx context.Context, paramVersionField string, paramVersion int64, paramValues map[string]interface{}) (bool, int64, error) {
+	args := make([]interface{}, 0, 3+2*len(paramValues))
+	args = append(args, paramVersionField, paramVersion, m.scriptExpire())
+	for field, value := range paramValues {
+		if field == paramVersionField {
+			return false, 0, commonutils.NewError(commonutils.ERR_FAIL, "不能直接写入版本号字段："+field)
+		}
+		args = append(args, field, value)
+	}
+	ret, err := hsetCompareVersionScript.Run(ctx, m.cli, []string{m.HSetKey}, args...).Int64Slice()
+	if err != nil {
+		return false, 0, err
+	}
+	if len(ret) != 2 {
+		return false, 0, commonutils.NewError(commonutils.ERR_FAIL, "比较版本号脚本返回值格式错误："+m.HSetKey)
+	}
+	return ret[0] == 1, ret[1], nil
+}
@@ -0,0 +1,96 @@
+package redisv8
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestHSetCompareAndSet(t *testing.T) {
+	cli, mr := newTestClient(t)
+	ctx := context.Background()
+	hset := CreateHSetUtils(cli, "order:1", 60, true)
+
+	if ok, err := hset.CompareAndSet(ctx, "state", nil, "new"); err != nil || !ok {
+		t.Fatalf("set missing = %v, %v", ok, err)
+	}
+	if ok, _ := hset.CompareAndSet(ctx, "state", nil, "new"); ok {
+		t.Fatal("field exists, should not set")
+	}
+	wrong := "paid"
+	if ok, _ := hset.CompareAndSet(ctx, "state", &wrong, "shipped"); ok {
+		t.Fatal("value differs, should not set")
+	}
+
+	// 批量模式的副本同样立即返回比较结果
+	batch := CreateBatch(cli)
+	expected := "new"
+	if ok, err := hset.Batch(batch).CompareAndSet(ctx, "state", &expected, "paid"); err != nil || !ok {
+		t.Fatalf("batch copy = %v, %v", ok, err)
+	}
+	if batch.Len() != 0 {
+		t.Fatalf("Len = %d", batch.Len())
+	}
+	if v := mr.HGet("order:1", "state"); v != "paid" {
+		t.Fatalf("state = %q", v)
+	}
+	if mr.TTL("order:1") <= 0 {
+		t.Fatal("expire not refreshed")
+	}
+}
+
+func TestHSetCompareVersionAndSet(t *testing.T) {
+	cli, mr := newTestClient(t)
+	ctx := context.Background()
+	hset := CreateHSetUtils(cli, "account:1", 0, false)
+
+	ok, ver, err := hset.CompareVersionAndSet(ctx, "ver", 0, map[string]interface{}{"balance": "10.5", "owner": "tom"})
+	if err != nil || !ok || ver != 1 {
+		t.Fatalf("first write = %v, %d, %v", ok, ver, err)
+	}
+	// 使用旧的版本号写入失败，返回当前的版本号
+	ok, ver, err = hset.CompareVersionAndSet(ctx, "ver", 0, map[string]interface{}{"balance": "0"})
+	if err != nil || ok || ver != 1 {
+		t.Fatalf("stale write = %v, %d, %v", ok, ver, err)
+	}
+	if v := mr.HGet("account:1", "balance"); v != "10.5" {
+		t.Fatalf("balance = %q", v)
+	}
+	if _, _, err := hset.CompareVersionAndSet(ctx, "ver", 1, map[string]interface{}{"ver": 5}); err == nil {
+		t.Fatal("writing the version field should fail")
+	}
+
+	// 并发读取-修改-写回，冲突时重新读取，最终不丢失更新
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				values, err := hset.GetAll(ctx).Result()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				ver, _ := strconv.ParseInt(values["ver"], 10, 64)
+				n, _ := strconv.Atoi(values["count"])
+				ok, _, err := hset.CompareVersionAndSet(ctx, "ver", ver, map[string]interface{}{"count": n + 1})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if ok {
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if v := mr.HGet("account:1", "count"); v != "10" {
+		t.Fatalf("count = %q", v)
+	}
+	if v := mr.HGet("account:1", "ver"); v != "11" {
+		t.Fatalf("ver = %q", v)
+	}
+}
@@ -0,0 +1,279 @@
+package redisv8
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	redis "github.com/go-redis/redis/v8"
+	"github.com/qiuliaogit/commonutils/commonutils"
+)
+
+var (
+	ErrIdempotencyInProgress = commonutils.NewError(commonutils.ERR_FAIL, "相同幂等key的请求正在处理中")
+	ErrIdempotencyMismatch   = commonutils.NewError(commonutils.ERR_FAIL, "幂等key已被参数不同的请求使用")
+	ErrIdempotencyLost       = commonutils.NewError(commonutils.ERR_FAIL, "幂等key的占用已超时失效")
+)
+
+// 幂等记录的状态
+const (
+	IDEMPOTENCY_IN_PROGRESS = "in_progress"
+	IDEMPOTENCY_COMPLETED   = "completed"
+)
+
+// 幂等存储的配置，TTL、LockTTL、PollInterval <=0 时使用默认值
+type RedisIdempotencyOptions struct {
+	TTL          time.Duration // 处理完成后保存响应的时间，在此期间重复的请求直接返回保存的响应
+	LockTTL      time.Duration // 处理中的占用时间，进程崩溃后超过该时间其他请求可以重新处理，处理较慢时用 Extend 续期
+	Wait         time.Duration // 遇到处理中的请求时最多等待的时间，0 表示直接返回 ErrIdempotencyInProgress
+	PollInterval time.Duration // 等待时查询的间隔
+}
+
+// 默认配置：响应保存24小时，处理中占用30秒，不等待
+func DefaultRedisIdempotencyOptions() RedisIdempotencyOptions {
+	return RedisIdempotencyOptions{
+		TTL:          24 * time.Hour,
+		LockTTL:      30 * time.Second,
+		PollInterval: 100 * time.Millisecond,
+	}
+}
+
+// 保存在redis中的幂等记录
+type IdempotencyRecord struct {
+	State       string `json:"state"`
+	Token       string `json:"token,omitempty"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+	Response    []byte `json:"response,omitempty"`
+	StartedAt   int64  `json:"started_at"`
+	CompletedAt int64  `json:"completed_at,omitempty"`
+}
+
+// 是否已处理完成
+func (r *IdempotencyRecord) Completed() bool {
+	return r.State == IDEMPOTENCY_COMPLETED
+}
+
+/*
+基于redis的幂等存储，保证同一个幂等key的请求只处理一次，如支付回调
+
+  - 用 SET NX 占用幂等key，状态为处理中，占用带超时，进程崩溃后会自动释放
+  - 处理完成后保存响应，在 TTL 内重复的请求直接返回保存的响应
+  - 处理失败时释放占用，客户端可以重试
+  - 可选记录请求的指纹，同一个key用于不同参数的请求时返回 ErrIdempotencyMismatch
+*/
+type RedisIdempotencyStore struct {
+	cli       *redis.Client
+	keyPrefix string
+	options   RedisIdempotencyOptions
+}
+
+/*
+创建一个幂等存储
+
+  - paramCli
+  - paramKeyPrefix 幂等key的前缀
+  - paramOptions 配置，可以在 DefaultRedisIdempotencyOptions() 的基础上修改
+*/
+func CreateIdempotencyStore(paramCli *redis.Client, paramKeyPrefix string, paramOptions RedisIdempotencyOptions) *RedisIdempotencyStore {
+	// SET PX 0 会报错，没有超时的占用在进程崩溃后永远不会释放，所以不允许为0
+	def := DefaultRedisIdempotencyOptions()
+	if paramOptions.TTL <= 0 {
+		paramOptions.TTL = def.TTL
+	}
+	if paramOptions.LockTTL <= 0 {
+		paramOptions.LockTTL = def.LockTTL
+	}
+	if paramOptions.PollInterval <= 0 {
+		paramOptions.PollInterval = def.PollInterval
+	}
+	return &RedisIdempotencyStore{
+		cli:       paramCli,
+		keyPrefix: paramKeyPrefix,
+		options:   paramOptions,
+	}
+}
+
+// 一次成功的占用，处理完成后调用 Complete，失败时调用 Release
+type IdempotencyReservation struct {
+	store *RedisIdempotencyStore
+	key   string
+	token string
+}
+
+// 获取幂等记录，不存在时返回 redis.Nil
+func (s *RedisIdempotencyStore) Get(ctx context.Context, paramKey string) (*IdempotencyRecord, error) {
+	data, err := s.cli.Get(ctx, s.keyPrefix+paramKey).Bytes()
+	if err != nil {
+		return nil, err
+	}
+	r := &IdempotencyRecord{}
+	if err := json.Unmarshal(data, r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// 删除幂等记录，之后相同key的请求会重新处理
+func (s *RedisIdempotencyStore) Del(ctx context.Context, paramKey string) error {
+	return s.cli.Del(ctx, s.keyPrefix+paramKey).Err()
+}
+
+// 尝试占用一次，已存在时返回当前的记录
+func (s *RedisIdempotencyStore) tryBegin(ctx context.Context, paramKey string, paramFingerprint string) (*IdempotencyReservation, *IdempotencyRecord, error) {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	token := hex.EncodeToString(buf)
+	data, err := json.Marshal(&IdempotencyRecord{
+		State:       IDEMPOTENCY_IN_PROGRESS,
+		Token:       token,
+		Fingerprint: paramFingerprint,
+		StartedAt:   time.Now().Unix(),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	ok, err := s.cli.SetNX(ctx, s.keyPrefix+paramKey, data, s.options.LockTTL).Result()
+	if err != nil {
+		return nil, nil, err
+	}
+	if ok {
+		return &IdempotencyReservation{store: s, key: paramKey, token: token}, nil, nil
+	}
+	r, err := s.Get(ctx, paramKey)
+	if err == redis.Nil {
+		// 刚好过期或被删除，由调用方重试
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if paramFingerprint != "" && r.Fingerprint != "" && r.Fingerprint != paramFingerprint {
+		return nil, r, ErrIdempotencyMismatch
+	}
+	return nil, r, nil
+}
+
+/*
+开始处理一个请求
+
+  - paramKey 幂等key，如回调的订单号
+  - paramFingerprint 请求参数的摘要，为空时不检查
+
+占用成功时返回占用，已处理完成时返回保存的记录，
+正在处理中时按 Wait 等待处理完成，超时后返回 ErrIdempotencyInProgress
+*/
+func (s *RedisIdempotencyStore) Begin(ctx context.Context, paramKey string, paramFingerprint string) (*IdempotencyReservation, *IdempotencyRecord, error) {
+	deadline := time.Now().Add(s.options.Wait)
+	for {
+		reservation, record, err := s.tryBegin(ctx, paramKey, paramFingerprint)
+		if err != nil || reservation != nil {
+			return reservation, record, err
+		}
+		if record != nil && record.Completed() {
+			return nil, record, nil
+		}
+		if record != nil && !time.Now().Before(deadline) {
+			return nil, record, ErrIdempotencyInProgress
+		}
+		if record != nil && !sleepContext(ctx, s.options.PollInterval) {
+			return nil, record, ctx.Err()
+		}
+	}
+}
+
+/*
+按幂等key执行一次处理函数
+
+  - paramKey 幂等key
+  - paramFingerprint 请求参数的摘要，为空时不检查
+  - paramFn 处理函数，返回需要保存的响应，出错时释放占用，不保存响应
+
+返回响应和是否为保存的响应
+*/
+func (s *RedisIdempotencyStore) Do(ctx context.Context, paramKey string, paramFingerprint string, paramFn func(ctx context.Context) ([]byte, error)) ([]byte, bool, error) {
+	reservation, record, err := s.Begin(ctx, paramKey, paramFingerprint)
+	if err != nil {
+		return nil, false, err
+	}
+	if reservation == nil {
+		return record.Response, true, nil
+	}
+	response, err := paramFn(ctx)
+	if err != nil {
+		reservation.Release(ctx)
+		return nil, false, err
+	}
+	if err := reservation.Complete(ctx, response); err != nil {
+		return response, false, err
+	}
+	return response, false, nil
+}
+
+// 处理中的记录仍属于自己时执行操作
+// KEYS: 幂等key
+// ARGV: 令牌, 操作(complete/extend/release), 新的记录, 超时毫秒
+var idempotencyOwnerScript = redis.NewScript(`
+local data = redis.call('GET', KEYS[1])
+if not data then
+	return 0
+end
+local record = cjson.decode(data)
+if record.state ~= 'in_progress' or record.token ~= ARGV[1] then
+	return 0
+end
+if ARGV[2] == 'release' then
+	redis.call('DEL', KEYS[1])
+elseif ARGV[2] == 'extend' then
+	redis.call('PEXPIRE', KEYS[1], ARGV[4])
+else
+	redis.call('SET', KEYS[1], ARGV[3], 'PX', ARGV[4])
+end
+return 1
+`)
+
+func (r *IdempotencyReservation) run(ctx context.Context, paramAction string, paramRecord []byte, paramTTL time.Duration) error {
+	ok, err := idempotencyOwnerScript.Run(ctx, r.store.cli, []string{r.store.keyPrefix + r.key}, r.token, paramAction, paramRecord, paramTTL.Milliseconds()).Int()
+	if err != nil {
+		return err
+	}
+	if ok == 0 {
+		return ErrIdempotencyLost
+	}
+	return nil
+}
+
+/*
+处理完成，保存响应，之后 TTL 内相同key的请求直接返回该响应
+
+占用已超时被其他请求取得时返回 ErrIdempotencyLost
+*/
+func (r *IdempotencyReservation) Complete(ctx context.Context, paramResponse []byte) error {
+	record, err := r.store.Get(ctx, r.key)
+	if err == redis.Nil {
+		return ErrIdempotencyLost
+	}
+	if err != nil {
+		return err
+	}
+	record.State = IDEMPOTENCY_COMPLETED
+	record.Token = ""
+	record.Response = paramResponse
+	record.CompletedAt = time.Now().Unix()
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return r.run(ctx, "complete", data, r.store.options.TTL)
+}
+
+// 延长处理中的占用时间，处理时间可能超过 LockTTL 时定期调用
+func (r *IdempotencyReservation) Extend(ctx context.Context) error {
+	return r.run(ctx, "extend", nil, r.store.options.LockTTL)
+}
+
+// 释放占用，不保存响应，相同key的请求可以重新处理
+func (r *IdempotencyReservation) Release(ctx context.Context) error {
+	return r.run(ctx, "release", nil, 0)
+}
@@ -0,0 +1,123 @@
+package redisv8
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestIdempotencyComplete(t *testing.T) {
+	cli, mr := newTestClient(t)
+	ctx := context.Background()
+	store := CreateIdempotencyStore(cli, "idem:", DefaultRedisIdempotencyOptions())
+
+	calls := 0
+	fn := func(ctx context.Context) ([]byte, error) {
+		calls++
+		return []byte("ok"), nil
+	}
+	resp, saved, err := store.Do(ctx, "order1", "fp", fn)
+	if err != nil || saved || string(resp) != "ok" {
+		t.Fatalf("first Do = %q, %v, %v", resp, saved, err)
+	}
+	resp, saved, err = store.Do(ctx, "order1", "fp", fn)
+	if err != nil || !saved || string(resp) != "ok" || calls != 1 {
+		t.Fatalf("second Do = %q, %v, %v, calls %d", resp, saved, err, calls)
+	}
+	if ttl := mr.TTL("idem:order1"); ttl != 24*time.Hour {
+		t.Fatalf("ttl = %v", ttl)
+	}
+	if _, _, err := store.Do(ctx, "order1", "other", fn); err != ErrIdempotencyMismatch {
+		t.Fatalf("mismatch err = %v", err)
+	}
+}
+
+func TestIdempotencyRelease(t *testing.T) {
+	cli, mr := newTestClient(t)
+	ctx := context.Background()
+	store := CreateIdempotencyStore(cli, "idem:", DefaultRedisIdempotencyOptions())
+
+	failed := errors.New("failed")
+	if _, _, err := store.Do(ctx, "order1", "", func(ctx context.Context) ([]byte, error) {
+		return nil, failed
+	}); err != failed {
+		t.Fatalf("err = %v", err)
+	}
+	if mr.Exists("idem:order1") {
+		t.Fatal("reservation should be released")
+	}
+	// 释放后可以重新处理
+	r, _, err := store.Begin(ctx, "order1", "")
+	if err != nil || r == nil {
+		t.Fatalf("Begin = %v, %v", r, err)
+	}
+	// 处理中时不等待直接返回
+	if _, record, err := store.Begin(ctx, "order1", ""); err != ErrIdempotencyInProgress || record == nil {
+		t.Fatalf("in progress = %v, %v", record, err)
+	}
+}
+
+func TestIdempotencyExtendAndLost(t *testing.T) {
+	cli, mr := newTestClient(t)
+	ctx := context.Background()
+	options := DefaultRedisIdempotencyOptions()
+	options.LockTTL = 10 * time.Second
+	store := CreateIdempotencyStore(cli, "idem:", options)
+
+	first, _, err := store.Begin(ctx, "order1", "")
+	if err != nil || first == nil {
+		t.Fatalf("Begin = %v, %v", first, err)
+	}
+	mr.FastForward(8 * time.Second)
+	if err := first.Extend(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := mr.TTL("idem:order1"); ttl != 10*time.Second {
+		t.Fatalf("extended ttl = %v", ttl)
+	}
+
+	// 占用超时后被其他请求取得，原来的占用不能再完成、续期或释放
+	mr.FastForward(11 * time.Second)
+	second, _, err := store.Begin(ctx, "order1", "")
+	if err != nil || second == nil {
+		t.Fatalf("second Begin = %v, %v", second, err)
+	}
+	if err := first.Complete(ctx, []byte("late")); err != ErrIdempotencyLost {
+		t.Fatalf("Complete err = %v", err)
+	}
+	if err := first.Extend(ctx); err != ErrIdempotencyLost {
+		t.Fatalf("Extend err = %v", err)
+	}
+	if err := first.Release(ctx); err != ErrIdempotencyLost {
+		t.Fatalf("Release err = %v", err)
+	}
+	if err := second.Complete(ctx, []byte("ok")); err != nil {
+		t.Fatal(err)
+	}
+	record, err := store.Get(ctx, "order1")
+	if err != nil || !record.Completed() || string(record.Response) != "ok" {
+		t.Fatalf("record = %+v, %v", record, err)
+	}
+}
+
+func TestIdempotencyZeroOptionsUseDefaults(t *testing.T) {
+	cli, mr := newTestClient(t)
+	ctx := context.Background()
+	store := CreateIdempotencyStore(cli, "idem:", RedisIdempotencyOptions{})
+
+	r, _, err := store.Begin(ctx, "order1", "")
+	if err != nil || r == nil {
+		t.Fatalf("Begin = %v, %v", r, err)
+	}
+	if ttl := mr.TTL("idem:order1"); ttl != 30*time.Second {
+		t.Fatalf("lock ttl = %v", ttl)
+	}
+	// TTL 为0时 SET PX 0 会报错
+	if err := r.Complete(ctx, []byte("ok")); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := mr.TTL("idem:order1"); ttl != 24*time.Hour {
+		t.Fatalf("ttl = %v", ttl)
+	}
+}
@@ -0,0 +1,650 @@
+package redisv8
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	mrand "math/rand"
+	"strconv"
+	"sync"
+	"time"
+
+	redis "github.com/go-redis/redis/v8"
+	"github.com/qiuliaogit/commonutils/commonutils"
+)
+
+var (
+	ErrJobTypeNotRegistered = commonutils.NewError(commonutils.ERR_FAIL, "没有注册该类型的任务处理函数")
+	ErrJobLost              = commonutils.NewError(commonutils.ERR_FAIL, "任务已执行超时并被重新排队")
+)
+
+// 任务的状态
+const (
+	JOB_STATUS_PENDING  = "pending"  // 等待执行
+	JOB_STATUS_RUNNING  = "running"  // 执行中
+	JOB_STATUS_RETRYING = "retrying" // 失败后等待重试
+	JOB_STATUS_DONE     = "done"     // 执行成功
+	JOB_STATUS_DEAD     = "dead"     // 超过最大次数，已进入死信列表
+)
+
+// 任务
+type Job struct {
+	ID          string
+	Type        string
+	Payload     string
+	Status      string
+	Attempts    int64  // 已执行的次数
+	MaxAttempts int64  // 最多执行的次数
+	Error       string // 最后一次失败的原因
+	CreatedAt   int64
+	UpdatedAt   int64
+	RunAt       int64 // 重试或延迟执行的时间 秒
+}
+
+// 任务的处理函数，返回错误时按退避时间重试
+type JobHandler func(ctx context.Context, paramJob *Job) error
+
+// 任务队列的配置，各项 <=0 时使用 DefaultRedisJobQueueOptions() 中的值
+type RedisJobQueueOptions struct {
+	MaxAttempts  int64         // 最多执行的次数，包括第一次
+	BackoffBase  time.Duration // 第一次重试的等待时间，之后每次翻倍
+	BackoffMax   time.Duration // 重试等待时间的上限
+	Visibility   time.Duration // 执行超时时间，执行期间每 1/3 该时间自动延长一次，超过后没有延长认为执行的实例已崩溃，任务重新排队
+	PollInterval time.Duration // 队列为空时查询的间隔
+	Concurrency  int           // 每个实例同时执行的任务数
+	DoneTTL      time.Duration // 执行成功后任务状态保存的时间
+}
+
+// 默认配置：最多执行5次，重试等待1秒起翻倍最多10分钟，执行超时5分钟，单协程执行，成功的任务保存7天
+func DefaultRedisJobQueueOptions() RedisJobQueueOptions {
+	return RedisJobQueueOptions{
+		MaxAttempts:  5,
+		BackoffBase:  time.Second,
+		BackoffMax:   10 * time.Minute,
+		Visibility:   5 * time.Minute,
+		PollInterval: 500 * time.Millisecond,
+		Concurrency:  1,
+		DoneTTL:      7 * 24 * time.Hour,
+	}
+}
+
+/*
+基于redis的任务队列，支持失败重试、指数退避和死信列表
+
+  - key:ready 待执行的任务ID队列(RedisQueueUtils)
+  - key:delayed 延迟执行和等待重试的任务，分数为执行时间(毫秒)
+  - key:running 执行中的任务，分数为执行超时时间(毫秒)
+  - key:dead 超过最大次数的任务ID列表
+  - key:job:任务ID 任务的状态哈希
+
+任务的 key 在 Lua 脚本中拼接，集群模式下队列key需要带 hash tag，如 {jobs}
+*/
+type RedisJobQueue struct {
+	cli      *redis.Client
+	key      string
+	options  RedisJobQueueOptions
+	ready    *RedisQueueUtils
+	delayed  *RedisZSetUtils
+	running  *RedisZSetUtils
+	dead     *RedisListUtils
+	mu       sync.Mutex
+	handlers map[string]JobHandler
+}
+
+/*
+创建一个任务队列
+
+  - paramCli
+  - paramQueueKey 队列的key
+  - paramOptions 配置，可以在 DefaultRedisJobQueueOptions() 的基础上修改
+*/
+func CreateJobQueue(paramCli *redis.Client, paramQueueKey string, paramOptions RedisJobQueueOptions) *RedisJobQueue {
+	// Visibility 为0时任务一取出就会被其他实例当作超时重新排队，PollInterval 为0时队列为空会空转，所以不允许为0
+	def := DefaultRedisJobQueueOptions()
+	if paramOptions.MaxAttempts <= 0 {
+		paramOptions.MaxAttempts = def.MaxAttempts
+	}
+	if paramOptions.BackoffBase <= 0 {
+		paramOptions.BackoffBase = def.BackoffBase
+	}
+	if paramOptions.BackoffMax <= 0 {
+		paramOptions.BackoffMax = def.BackoffMax
+	}
+	if paramOptions.Visibility <= 0 {
+		paramOptions.Visibility = def.Visibility
+	}
+	if paramOptions.PollInterval <= 0 {
+		paramOptions.PollInterval = def.PollInterval
+	}
+	if paramOptions.Concurrency < 1 {
+		paramOptions.Concurrency = def.Concurrency
+	}
+	if paramOptions.DoneTTL <= 0 {
+		paramOptions.DoneTTL = def.DoneTTL
+	}
+	return &RedisJobQueue{
+		cli:      paramCli,
+		key:      paramQueueKey,
+		options:  paramOptions,
+		ready:    CreateQueueUtils(paramCli, paramQueueKey+":ready", 0, false),
+		delayed:  CreateZSetUtils(paramCli, paramQueueKey+":delayed", 0, false),
+		running:  CreateZSetUtils(paramCli, paramQueueKey+":running", 0, false),
+		dead:     CreateListUtils(paramCli, paramQueueKey+":dead", 0, false),
+		handlers: make(map[string]JobHandler),
+	}
+}
+
+// 任务状态哈希的 key 前缀
+func (q *RedisJobQueue) jobPrefix() string {
+	return q.key + ":job:"
+}
+
+func (q *RedisJobQueue) jobHSet(paramID string) *RedisHSetUtils {
+	return CreateHSetUtils(q.cli, q.jobPrefix()+paramID, 0, false)
+}
+
+// 注册任务类型的处理函数，需要在 Run 之前注册
+func (q *RedisJobQueue) Register(paramType string, paramHandler JobHandler) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.handlers[paramType] = paramHandler
+}
+
+func (q *RedisJobQueue) handler(paramType string) JobHandler {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.handlers[paramType]
+}
+
+// 添加一个任务，立即执行
+func (q *RedisJobQueue) Enqueue(ctx context.Context, paramType string, paramPayload string) (*Job, error) {
+	return q.EnqueueDelay(ctx, paramType, paramPayload, 0)
+}
+
+/*
+添加一个任务
+
+  - paramType 任务类型
+  - paramPayload 任务数据，一般为JSON
+  - paramDelay 延迟执行的时间，<=0 表示立即执行
+*/
+func (q *RedisJobQueue) EnqueueDelay(ctx context.Context, paramType string, paramPayload string, paramDelay time.Duration) (*Job, error) {
+	buf := make([]byte, 12)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	job := &Job{
+		ID:          hex.EncodeToString(buf),
+		Type:        paramType,
+		Payload:     paramPayload,
+		Status:      JOB_STATUS_PENDING,
+		MaxAttempts: q.options.MaxAttempts,
+		CreatedAt:   now.Unix(),
+		UpdatedAt:   now.Unix(),
+	}
+	b := CreateTxBatch(q.cli)
+	if paramDelay > 0 {
+		runAt := now.Add(paramDelay)
+		job.RunAt = runAt.Unix()
+		q.jobHSet(job.ID).Batch(b).MultSet(ctx, job.fields()...)
+		q.delayed.Batch(b).AddOneIntScore(ctx, job.ID, runAt.UnixMilli())
+	} else {
+		q.jobHSet(job.ID).Batch(b).MultSet(ctx, job.fields()...)
+		q.ready.Batch(b).Push(ctx, job.ID)
+	}
+	if _, err := b.Exec(ctx); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+func (j *Job) fields() []interface{} {
+	return []interface{}{
+		"type", j.Type,
+		"payload", j.Payload,
+		"status", j.Status,
+		"attempts", j.Attempts,
+		"max_attempts", j.MaxAttempts,
+		"error", j.Error,
+		"created_at", j.CreatedAt,
+		"updated_at", j.UpdatedAt,
+		"run_at", j.RunAt,
+	}
+}
+
+func parseJob(paramID string, paramFields map[string]string) *Job {
+	num := func(paramName string) int64 {
+		v, _ := strconv.ParseInt(paramFields[paramName], 10, 64)
+		return v
+	}
+	return &Job{
+		ID:          paramID,
+		Type:        paramFields["type"],
+		Payload:     paramFields["payload"],
+		Status:      paramFields["status"],
+		Attempts:    num("attempts"),
+		MaxAttempts: num("max_attempts"),
+		Error:       paramFields["error"],
+		CreatedAt:   num("created_at"),
+		UpdatedAt:   num("updated_at"),
+		RunAt:       num("run_at"),
+	}
+}
+
+// 按ID获取任务，不存在时返回 redis.Nil
+func (q *RedisJobQueue) Get(ctx context.Context, paramID string) (*Job, error) {
+	fields, err := q.jobHSet(paramID).GetAll(ctx).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		return nil, redis.Nil
+	}
+	return parseJob(paramID, fields), nil
+}
+
+// KEYS: ready, running
+// ARGV: 任务key前缀, 执行超时时间(毫秒), 当前时间(秒)
+var jobPopScript = redis.NewScript(`
+local id = redis.call('LPOP', KEYS[1])
+if not id then
+	return false
+end
+local job = ARGV[1] .. id
+if redis.call('EXISTS', job) == 0 then
+	return ''
+end
+redis.call('ZADD', KEYS[2], ARGV[2], id)
+redis.call('HINCRBY', job, 'attempts', 1)
+redis.call('HSET', job, 'status', 'running', 'updated_at', ARGV[3])
+return id
+`)
+
+// 取出一个任务并标记为执行中，队列为空时返回 redis.Nil
+func (q *RedisJobQueue) pop(ctx context.Context) (*Job, error) {
+	for {
+		now := time.Now()
+		id, err := jobPopScript.Run(ctx, q.cli, []string{q.ready.key, q.running.key},
+			q.jobPrefix(), now.Add(q.options.Visibility).UnixMilli(), now.Unix()).Text()
+		if err != nil {
+			return nil, err
+		}
+		if id == "" {
+			// 任务已被删除
+			continue
+		}
+		return q.Get(ctx, id)
+	}
+}
+
+// KEYS: ready, delayed, running, dead
+// ARGV: 任务key前缀, 当前时间(毫秒), 当前时间(秒), 每次最多处理的数量
+var jobPromoteScript = redis.NewScript(`
+local ids = redis.call('ZRANGEBYSCORE', KEYS[2], '-inf', ARGV[2], 'LIMIT', 0, ARGV[4])
+for _, id in ipairs(ids) do
+	redis.call('ZREM', KEYS[2], id)
+	redis.call('RPUSH', KEYS[1], id)
+	redis.call('HSET', ARGV[1] .. id, 'status', 'pending', 'updated_at', ARGV[3])
+end
+local expired = redis.call('ZRANGEBYSCORE', KEYS[3], '-inf', ARGV[2], 'LIMIT', 0, ARGV[4])
+for _, id in ipairs(expired) do
+	local job = ARGV[1] .. id
+	redis.call('ZREM', KEYS[3], id)
+	local v = redis.call('HMGET', job, 'attempts', 'max_attempts')
+	if tonumber(v[1] or '0') >= tonumber(v[2] or '0') then
+		redis.call('RPUSH', KEYS[4], id)
+		redis.call('HSET', job, 'status', 'dead', 'error', 'timeout', 'updated_at', ARGV[3])
+	else
+		redis.call('RPUSH', KEYS[1], id)
+		redis.call('HSET', job, 'status', 'pending', 'error', 'timeout', 'updated_at', ARGV[3])
+	end
+end
+return #ids + #expired
+`)
+
+// 把到期的延迟任务和执行超时的任务放回待执行队列
+func (q *RedisJobQueue) promote(ctx context.Context) (int64, error) {
+	now := time.Now()
+	return jobPromoteScript.Run(ctx, q.cli, []string{q.ready.key, q.delayed.key, q.running.key, q.dead.key},
+		q.jobPrefix(), now.UnixMilli(), now.Unix(), 100).Int64()
+}
+
+// KEYS: delayed, running, dead
+// ARGV: 任务key前缀, 任务ID, 错误, 当前时间(秒), 重试时间(毫秒)
+var jobFailScript = redis.NewScript(`
+if redis.call('ZREM', KEYS[2], ARGV[2]) == 0 then
+	return -1
+end
+local job = ARGV[1] .. ARGV[2]
+local v = redis.call('HMGET', job, 'attempts', 'max_attempts')
+if tonumber(v[1] or '0') >= tonumber(v[2] or '0') then
+	redis.call('RPUSH', KEYS[3], ARGV[2])
+	redis.call('HSET', job, 'status', 'dead', 'error', ARGV[3], 'updated_at', ARGV[4])
+	return 0
+end
+redis.call('ZADD', KEYS[1], ARGV[5], ARGV[2])
+redis.call('HSET', job, 'status', 'retrying', 'error', ARGV[3], 'updated_at', ARGV[4], 'run_at', math.floor(ARGV[5] / 1000))
+return 1
+`)
+
+// 第 n 次失败后的等待时间，带 ±20% 的随机浮动
+func (q *RedisJobQueue) backoff(paramAttempts int64) time.Duration {
+	d := q.options.BackoffBase
+	for i := int64(1); i < paramAttempts && d < q.options.BackoffMax; i++ {
+		d *= 2
+	}
+	if q.options.BackoffMax > 0 && d > q.options.BackoffMax {
+		d = q.options.BackoffMax
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d*4/5 + time.Duration(mrand.Int63n(int64(d*2/5)+1))
+}
+
+// 执行失败，重试或进入死信列表，任务已执行超时被重新排队时返回 ErrJobLost
+func (q *RedisJobQueue) fail(ctx context.Context, paramJob *Job, paramErr error) error {
+	now := time.Now()
+	retryAt := now.Add(q.backoff(paramJob.Attempts))
+	ret, err := jobFailScript.Run(ctx, q.cli, []string{q.delayed.key, q.running.key, q.dead.key},
+		q.jobPrefix(), paramJob.ID, paramErr.Error(), now.Unix(), retryAt.UnixMilli()).Int()
+	if err != nil {
+		return err
+	}
+	if ret < 0 {
+		return ErrJobLost
+	}
+	return nil
+}
+
+// KEYS: running
+// ARGV: 任务key前缀, 任务ID, 当前时间(秒), 保存时间(秒)
+var jobDoneScript = redis.NewScript(`
+if redis.call('ZREM', KEYS[1], ARGV[2]) == 0 then
+	return 0
+end
+local job = ARGV[1] .. ARGV[2]
+redis.call('HSET', job, 'status', 'done', 'error', '', 'updated_at', ARGV[3])
+if tonumber(ARGV[4]) > 0 then
+	redis.call('EXPIRE', job, ARGV[4])
+end
+return 1
+`)
+
+// 执行成功，任务已执行超时被重新排队时不修改状态并返回 ErrJobLost
+func (q *RedisJobQueue) done(ctx context.Context, paramJob *Job) error {
+	ok, err := jobDoneScript.Run(ctx, q.cli, []string{q.running.key},
+		q.jobPrefix(), paramJob.ID, time.Now().Unix(), int64(q.options.DoneTTL/time.Second)).Int()
+	if err != nil {
+		return err
+	}
+	if ok == 0 {
+		return ErrJobLost
+	}
+	return nil
+}
+
+// KEYS: ready, running
+// ARGV: 任务key前缀, 任务ID, 当前时间(秒), 是否放到尾部 1放到尾部 0放到头部
+var jobReleaseScript = redis.NewScript(`
+if redis.call('ZREM', KEYS[2], ARGV[2]) == 0 then
+	return 0
+end
+local job = ARGV[1] .. ARGV[2]
+redis.call('HINCRBY', job, 'attempts', -1)
+redis.call('HSET', job, 'status', 'pending', 'updated_at', ARGV[3])
+if ARGV[4] == '1' then
+	redis.call('RPUSH', KEYS[1], ARGV[2])
+else
+	redis.call('LPUSH', KEYS[1], ARGV[2])
+end
+return 1
+`)
+
+/*
+把任务放回待执行队列，不计入执行次数
+
+  - paramTail 是否放到尾部，停止执行时放到头部尽快再执行，没有处理函数时放到尾部不阻塞其他任务
+*/
+func (q *RedisJobQueue) release(ctx context.Context, paramJob *Job, paramTail bool) error {
+	tail := "0"
+	if paramTail {
+		tail = "1"
+	}
+	return jobReleaseScript.Run(ctx, q.cli, []string{q.ready.key, q.running.key},
+		q.jobPrefix(), paramJob.ID, time.Now().Unix(), tail).Err()
+}
+
+// KEYS: running
+// ARGV: 任务ID, 新的执行超时时间(毫秒)
+var jobExtendScript = redis.NewScript(`
+if not redis.call('ZSCORE', KEYS[1], ARGV[1]) then
+	return 0
+end
+redis.call('ZADD', KEYS[1], ARGV[2], ARGV[1])
+return 1
+`)
+
+// 延长执行超时时间，任务已执行超时被重新排队时返回 ErrJobLost
+func (q *RedisJobQueue) extend(ctx context.Context, paramJob *Job) error {
+	ok, err := jobExtendScript.Run(ctx, q.cli, []string{q.running.key},
+		paramJob.ID, time.Now().Add(q.options.Visibility).UnixMilli()).Int()
+	if err != nil {
+		return err
+	}
+	if ok == 0 {
+		return ErrJobLost
+	}
+	return nil
+}
+
+// 执行任务，panic 时作为失败处理
+func (q *RedisJobQueue) call(ctx context.Context, paramJob *Job) (err error) {
+	h := q.handler(paramJob.Type)
+	if h == nil {
+		return ErrJobTypeNotRegistered
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = commonutils.NewErrorf(commonutils.ERR_FAIL, "任务 %s panic: %v", paramJob.ID, r)
+		}
+	}()
+	return h(ctx, paramJob)
+}
+
+/*
+执行任务，执行期间每 1/3 Visibility 延长一次执行超时时间，
+任务已执行超时被其他实例重新排队时取消处理函数的 ctx
+*/
+func (q *RedisJobQueue) callWithHeartbeat(ctx context.Context, paramJob *Job) error {
+	interval := q.options.Visibility / 3
+	if interval <= 0 {
+		return q.call(ctx, paramJob)
+	}
+	jobCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for sleepContext(jobCtx, interval) {
+			if err := q.extend(jobCtx, paramJob); err == ErrJobLost {
+				cancel()
+				return
+			}
+		}
+	}()
+	err := q.call(jobCtx, paramJob)
+	cancel()
+	wg.Wait()
+	return err
+}
+
+/*
+执行一个任务，队列为空时返回 false
+
+一般使用 Run，需要自行控制执行时机时可以直接调用。
+执行期间 ctx 被取消并且处理函数返回错误时，任务放回待执行队列，不计入执行次数。
+当前实例没有注册该类型的处理函数时，任务放回待执行队列的尾部，不计入执行次数，留给注册了该类型的实例执行，
+此时返回 false 和 ErrJobTypeNotRegistered，Run 会等待 PollInterval 后再取任务，避免反复取出同一个任务
+*/
+func (q *RedisJobQueue) RunOnce(ctx context.Context) (bool, error) {
+	if _, err := q.promote(ctx); err != nil {
+		return false, err
+	}
+	job, err := q.pop(ctx)
+	if err == redis.Nil {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if q.handler(job.Type) == nil {
+		if err := q.release(context.Background(), job, true); err != nil {
+			return false, err
+		}
+		return false, ErrJobTypeNotRegistered
+	}
+	err = q.callWithHeartbeat(ctx, job)
+	if err != nil && ctx.Err() != nil {
+		// 停止执行导致的失败不算一次失败的执行
+		return true, q.release(context.Background(), job, false)
+	}
+	if err != nil {
+		return true, q.fail(context.Background(), job, err)
+	}
+	return true, q.done(context.Background(), job)
+}
+
+/*
+按配置的并发数执行任务，阻塞直到 ctx 被取消，返回 ctx.Err()
+
+  - paramOnError 出错时的回调，可以为 nil
+*/
+func (q *RedisJobQueue) Run(ctx context.Context, paramOnError func(error)) error {
+	var wg sync.WaitGroup
+	for i := 0; i < q.options.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				ok, err := q.RunOnce(ctx)
+				if err != nil && ctx.Err() == nil && paramOnError != nil {
+					paramOnError(err)
+				}
+				if !ok && !sleepContext(ctx, q.options.PollInterval) {
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return ctx.Err()
+}
+
+// 各状态的任务数量
+type JobQueueStats struct {
+	Ready   int64
+	Delayed int64
+	Running int64
+	Dead    int64
+}
+
+// 取各状态的任务数量
+func (q *RedisJobQueue) Stats(ctx context.Context) (JobQueueStats, error) {
+	b := CreateBatch(q.cli)
+	ready := q.ready.Batch(b).Count(ctx)
+	delayed := q.delayed.Batch(b).Count(ctx)
+	running := q.running.Batch(b).Count(ctx)
+	dead := q.dead.Batch(b).Count(ctx)
+	if _, err := b.Exec(ctx); err != nil {
+		return JobQueueStats{}, err
+	}
+	return JobQueueStats{
+		Ready:   ready.Val(),
+		Delayed: delayed.Val(),
+		Running: running.Val(),
+		Dead:    dead.Val(),
+	}, nil
+}
+
+// 查看死信列表中的任务，最早的在前
+func (q *RedisJobQueue) DeadJobs(ctx context.Context, paramStart int64, paramStop int64) ([]*Job, error) {
+	ids, err := q.dead.Range(ctx, paramStart, paramStop).Result()
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]*Job, 0, len(ids))
+	for _, id := range ids {
+		job, err := q.Get(ctx, id)
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, job)
+	}
+	return ret, nil
+}
+
+// KEYS: ready, dead
+// ARGV: 任务key前缀, 任务ID, 当前时间(秒)
+var jobRequeueScript = redis.NewScript(`
+if redis.call('LREM', KEYS[2], 0, ARGV[2]) == 0 then
+	return 0
+end
+redis.call('HSET', ARGV[1] .. ARGV[2], 'status', 'pending', 'attempts', 0, 'updated_at', ARGV[3])
+redis.call('RPUSH', KEYS[1], ARGV[2])
+return 1
+`)
+
+// 把死信列表中的任务重新放回待执行队列，执行次数清零，任务不在死信列表中时返回 false
+func (q *RedisJobQueue) Requeue(ctx context.Context, paramID string) (bool, error) {
+	ok, err := jobRequeueScript.Run(ctx, q.cli, []string{q.ready.key, q.dead.key},
+		q.jobPrefix(), paramID, time.Now().Unix()).Int()
+	return ok == 1, err
+}
+
+// 把死信列表中的所有任务重新放回待执行队列，返回数量
+func (q *RedisJobQueue) RequeueAll(ctx context.Context) (int64, error) {
+	ids, err := q.dead.Range(ctx, 0, -1).Result()
+	if err != nil {
+		return 0, err
+	}
+	var n int64
+	for _, id := range ids {
+		ok, err := q.Requeue(ctx, id)
+		if err != nil {
+			return n, err
+		}
+		if ok {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// 从死信列表中删除任务及其状态
+func (q *RedisJobQueue) Purge(ctx context.Context, paramID string) error {
+	b := CreateTxBatch(q.cli)
+	q.dead.Batch(b).Del(ctx, paramID)
+	b.Cmd().Del(ctx, q.jobPrefix()+paramID)
+	_, err := b.Exec(ctx)
+	return err
+}
+
+// 清空死信列表，返回删除的数量
+func (q *RedisJobQueue) PurgeDead(ctx context.Context) (int64, error) {
+	ids, err := q.dead.Range(ctx, 0, -1).Result()
+	if err != nil {
+		return 0, err
+	}
+	for _, id := range ids {
+		if err := q.Purge(ctx, id); err != nil {
+			return 0, err
+		}
+	}
+	return int64(len(ids)), nil
+}
@@ -0,0 +1,252 @@
+package redisv8
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testJobQueueOptions() RedisJobQueueOptions {
+	options := DefaultRedisJobQueueOptions()
+	options.MaxAttempts = 2
+	options.BackoffBase = time.Millisecond
+	options.BackoffMax = time.Millisecond
+	options.PollInterval = 10 * time.Millisecond
+	options.DoneTTL = time.Hour
+	return options
+}
+
+func TestJobQueueDone(t *testing.T) {
+	cli, mr := newTestClient(t)
+	ctx := context.Background()
+	q := CreateJobQueue(cli, "{jobs}", testJobQueueOptions())
+	q.Register("mail", func(ctx context.Context, paramJob *Job) error { return nil })
+
+	job, err := q.Enqueue(ctx, "mail", "a@b.c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := q.RunOnce(ctx); !ok || err != nil {
+		t.Fatalf("RunOnce = %v, %v", ok, err)
+	}
+	got, err := q.Get(ctx, job.ID)
+	if err != nil || got.Status != JOB_STATUS_DONE || got.Attempts != 1 {
+		t.Fatalf("job = %+v, %v", got, err)
+	}
+	if mr.TTL("{jobs}:job:"+job.ID) != time.Hour {
+		t.Fatal("done ttl not set")
+	}
+	if ok, err := q.RunOnce(ctx); ok || err != nil {
+		t.Fatalf("empty RunOnce = %v, %v", ok, err)
+	}
+}
+
+func TestJobQueueDoneAfterLost(t *testing.T) {
+	cli, _ := newTestClient(t)
+	ctx := context.Background()
+	q := CreateJobQueue(cli, "{jobs}", testJobQueueOptions())
+
+	job, _ := q.Enqueue(ctx, "mail", "")
+	popped, err := q.pop(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 执行超时后被其他实例重新排队
+	q.running.AddOneIntScore(ctx, job.ID, 0)
+	if n, _ := q.promote(ctx); n != 1 {
+		t.Fatalf("promote = %d", n)
+	}
+	if err := q.extend(ctx, popped); err != ErrJobLost {
+		t.Fatalf("extend err = %v", err)
+	}
+	if err := q.done(ctx, popped); err != ErrJobLost {
+		t.Fatalf("done err = %v", err)
+	}
+	if err := q.fail(ctx, popped, errors.New("x")); err != ErrJobLost {
+		t.Fatalf("fail err = %v", err)
+	}
+	got, _ := q.Get(ctx, job.ID)
+	if got.Status != JOB_STATUS_PENDING {
+		t.Fatalf("status = %s", got.Status)
+	}
+	if stats, _ := q.Stats(ctx); stats.Ready != 1 || stats.Running != 0 {
+		t.Fatalf("stats = %+v", stats)
+	}
+}
+
+func TestJobQueueRetryAndDead(t *testing.T) {
+	cli, _ := newTestClient(t)
+	ctx := context.Background()
+	q := CreateJobQueue(cli, "{jobs}", testJobQueueOptions())
+	q.Register("mail", func(ctx context.Context, paramJob *Job) error { return errors.New("smtp down") })
+
+	job, _ := q.Enqueue(ctx, "mail", "")
+	q.RunOnce(ctx)
+	got, _ := q.Get(ctx, job.ID)
+	if got.Status != JOB_STATUS_RETRYING || got.Error != "smtp down" {
+		t.Fatalf("job = %+v", got)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if ok, err := q.RunOnce(ctx); !ok || err != nil {
+		t.Fatalf("retry RunOnce = %v, %v", ok, err)
+	}
+	dead, err := q.DeadJobs(ctx, 0, -1)
+	if err != nil || len(dead) != 1 || dead[0].Status != JOB_STATUS_DEAD || dead[0].Attempts != 2 {
+		t.Fatalf("dead = %+v, %v", dead, err)
+	}
+
+	if n, err := q.RequeueAll(ctx); n != 1 || err != nil {
+		t.Fatalf("RequeueAll = %d, %v", n, err)
+	}
+	got, _ = q.Get(ctx, job.ID)
+	if got.Status != JOB_STATUS_PENDING || got.Attempts != 0 {
+		t.Fatalf("requeued job = %+v", got)
+	}
+	q.RunOnce(ctx)
+	time.Sleep(5 * time.Millisecond)
+	q.RunOnce(ctx)
+	if n, err := q.PurgeDead(ctx); n != 1 || err != nil {
+		t.Fatalf("PurgeDead = %d, %v", n, err)
+	}
+	if stats, _ := q.Stats(ctx); stats != (JobQueueStats{}) {
+		t.Fatalf("stats = %+v", stats)
+	}
+}
+
+func TestJobQueueHeartbeat(t *testing.T) {
+	cli, _ := newTestClient(t)
+	ctx := context.Background()
+	options := testJobQueueOptions()
+	options.Visibility = 150 * time.Millisecond
+	q := CreateJobQueue(cli, "{jobs}", options)
+	other := CreateJobQueue(cli, "{jobs}", options)
+	q.Register("report", func(ctx context.Context, paramJob *Job) error {
+		// 执行时间超过 Visibility，期间其他实例检查超时的任务
+		for end := time.Now().Add(500 * time.Millisecond); time.Now().Before(end); {
+			if _, err := other.promote(ctx); err != nil {
+				return err
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		return ctx.Err()
+	})
+
+	job, _ := q.Enqueue(ctx, "report", "")
+	if ok, err := q.RunOnce(ctx); !ok || err != nil {
+		t.Fatalf("RunOnce = %v, %v", ok, err)
+	}
+	got, _ := q.Get(ctx, job.ID)
+	if got.Status != JOB_STATUS_DONE || got.Attempts != 1 {
+		t.Fatalf("job = %+v", got)
+	}
+	if stats, _ := q.Stats(ctx); stats.Ready != 0 {
+		t.Fatalf("stats = %+v", stats)
+	}
+}
+
+func TestJobQueueCancelNotCounted(t *testing.T) {
+	cli, _ := newTestClient(t)
+	q := CreateJobQueue(cli, "{jobs}", testJobQueueOptions())
+	var started int32
+	q.Register("report", func(ctx context.Context, paramJob *Job) error {
+		atomic.StoreInt32(&started, 1)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	job, _ := q.Enqueue(context.Background(), "report", "")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var errs int32
+	done := make(chan struct{})
+	go func() {
+		q.Run(ctx, func(error) { atomic.AddInt32(&errs, 1) })
+		close(done)
+	}()
+	waitFor(t, "started", func() bool { return atomic.LoadInt32(&started) == 1 })
+	cancel()
+	<-done
+
+	// 停止执行时任务放回待执行队列，不计入执行次数
+	got, _ := q.Get(context.Background(), job.ID)
+	if got.Status != JOB_STATUS_PENDING || got.Attempts != 0 || got.Error != "" {
+		t.Fatalf("job = %+v", got)
+	}
+	if stats, _ := q.Stats(context.Background()); stats.Ready != 1 || stats.Running != 0 || stats.Delayed != 0 {
+		t.Fatalf("stats = %+v", stats)
+	}
+	if atomic.LoadInt32(&errs) != 0 {
+		t.Fatal("cancel reported as error")
+	}
+}
+
+func TestJobQueueZeroOptionsUseDefaults(t *testing.T) {
+	cli, _ := newTestClient(t)
+	ctx := context.Background()
+	q := CreateJobQueue(cli, "{jobs}", RedisJobQueueOptions{})
+	if q.options != DefaultRedisJobQueueOptions() {
+		t.Fatalf("options = %+v", q.options)
+	}
+
+	job, _ := q.Enqueue(ctx, "mail", "")
+	if job.MaxAttempts != 5 {
+		t.Fatalf("max attempts = %d", job.MaxAttempts)
+	}
+	popped, err := q.pop(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Visibility 为0时执行中的任务会被其他实例立即重新排队
+	if n, _ := q.promote(ctx); n != 0 {
+		t.Fatalf("promote = %d", n)
+	}
+	if err := q.extend(ctx, popped); err != nil {
+		t.Fatal(err)
+	}
+	// MaxAttempts 为0时第一次失败就会进入死信列表
+	if err := q.fail(ctx, popped, errors.New("x")); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := q.Get(ctx, job.ID)
+	if got.Status != JOB_STATUS_RETRYING {
+		t.Fatalf("status = %s", got.Status)
+	}
+}
+
+func TestJobQueueUnregisteredTypeNotCounted(t *testing.T) {
+	cli, _ := newTestClient(t)
+	ctx := context.Background()
+	q := CreateJobQueue(cli, "{jobs}", testJobQueueOptions())
+	var ran int32
+	q.Register("mail", func(ctx context.Context, paramJob *Job) error {
+		atomic.AddInt32(&ran, 1)
+		return nil
+	})
+	unknown, _ := q.Enqueue(ctx, "sms", "")
+	mail, _ := q.Enqueue(ctx, "mail", "")
+
+	// 没有处理函数的任务放回队尾，不计入执行次数，不会进入死信列表
+	if ok, err := q.RunOnce(ctx); ok || err != ErrJobTypeNotRegistered {
+		t.Fatalf("RunOnce unknown = %v, %v", ok, err)
+	}
+	if ok, err := q.RunOnce(ctx); !ok || err != nil {
+		t.Fatalf("RunOnce mail = %v, %v", ok, err)
+	}
+	if ok, err := q.RunOnce(ctx); ok || err != ErrJobTypeNotRegistered {
+		t.Fatalf("RunOnce unknown again = %v, %v", ok, err)
+	}
+	if atomic.LoadInt32(&ran) != 1 {
+		t.Fatal("registered job blocked by the unregistered one")
+	}
+	if got, _ := q.Get(ctx, mail.ID); got.Status != JOB_STATUS_DONE {
+		t.Fatalf("mail = %+v", got)
+	}
+	got, _ := q.Get(ctx, unknown.ID)
+	if got.Status != JOB_STATUS_PENDING || got.Attempts != 0 {
+		t.Fatalf("unknown = %+v", got)
+	}
+	if stats, _ := q.Stats(ctx); stats.Ready != 1 || stats.Running != 0 || stats.Dead != 0 {
+		t.Fatalf("stats = %+v", stats)
+	}
+}
@@ -0,0 +1,521 @@
+package redisv8
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	redis "github.com/go-redis/redis/v8"
+	"github.com/qiuliaogit/commonutils/commonutils"
+)
+
+// redis key 的数据类型
+type RedisKeyType string
+
+const (
+	KEY_TYPE_STRING RedisKeyType = "string"
+	KEY_TYPE_HASH   RedisKeyType = "hash"
+	KEY_TYPE_LIST   RedisKeyType = "list"
+	KEY_TYPE_SET    RedisKeyType = "set"
+	KEY_TYPE_ZSET   RedisKeyType = "zset"
+	KEY_TYPE_STREAM RedisKeyType = "stream"
+)
+
+// 判断是否为支持的数据类型
+func (t RedisKeyType) valid() bool {
+	return commonutils.IsIn(t, KEY_TYPE_STRING, KEY_TYPE_HASH, KEY_TYPE_LIST, KEY_TYPE_SET, KEY_TYPE_ZSET, KEY_TYPE_STREAM)
+}
+
+// key 模板超时时间的上限，工具类的超时时间为 int32 秒
+const keyPatternMaxTTL = time.Duration(math.MaxInt32) * time.Second
+
+// key 各段之间的分隔符
+const KEY_SEPARATOR = ":"
+
+// 参数段的类型
+const (
+	keySegmentLiteral = ""
+	keySegmentInt     = "int"
+	keySegmentUint    = "uint"
+	keySegmentStr     = "str"
+)
+
+// key 模板中的一段
+type keySegment struct {
+	literal string // 固定文本，参数段为空
+	name    string // 参数名
+	kind    string // 参数类型 int/uint/str，固定文本为空
+	hashTag bool   // 是否作为集群的 hash tag，生成时用 {} 包裹
+}
+
+/*
+key 的前缀生成器，生成的 key 为 "应用:环境:各段..."
+
+	b := redisv8.CreateKeyBuilder("shop", "prod")
+	b.Key("user", 1001, "profile")               // shop:prod:user:1001:profile
+	b.Key("order", redisv8.HashTag(1001), "list") // shop:prod:order:{1001}:list
+*/
+type RedisKeyBuilder struct {
+	prefix string
+}
+
+/*
+创建一个 key 生成器
+
+  - paramApp 应用名
+  - paramEnv 环境，如 prod、test，为空时省略
+*/
+func CreateKeyBuilder(paramApp string, paramEnv string) *RedisKeyBuilder {
+	prefix := paramApp
+	if paramEnv != "" {
+		prefix += KEY_SEPARATOR + paramEnv
+	}
+	return &RedisKeyBuilder{prefix: prefix}
+}
+
+// 取 key 的前缀
+func (b *RedisKeyBuilder) Prefix() string {
+	return b.prefix
+}
+
+// 集群的 hash tag，相同 hash tag 的 key 分配在同一个槽，可以在事务和脚本中一起使用
+type HashTag string
+
+/*
+按顺序拼接各段生成 key
+
+  - paramSegments 各段的值，支持 string、整数、HashTag，其他类型使用 fmt.Sprint
+
+字符串(包括 HashTag 的内容)中的 ":" "{" "}" 和 "%" 会转义为 %3A %7B %7D %25，
+所以 Key("a:b") 与 Key("a", "b") 不同，外部输入也不能插入 hash tag
+*/
+func (b *RedisKeyBuilder) Key(paramSegments ...interface{}) string {
+	parts := make([]string, 0, len(paramSegments)+1)
+	parts = append(parts, b.prefix)
+	for _, seg := range paramSegments {
+		parts = append(parts, formatKeySegment(seg))
+	}
+	return strings.Join(parts, KEY_SEPARATOR)
+}
+
+// key 段中需要转义的字符
+var keySegmentEscaper = strings.NewReplacer("%", "%25", ":", "%3A", "{", "%7B", "}", "%7D")
+
+func formatKeySegment(paramSegment interface{}) string {
+	switch v := paramSegment.(type) {
+	case string:
+		return keySegmentEscaper.Replace(v)
+	case HashTag:
+		return "{" + keySegmentEscaper.Replace(string(v)) + "}"
+	case int:
+		return strconv.Itoa(v)
+	case int32:
+		return commonutils.I(v)
+	case int64:
+		return commonutils.I(v)
+	case uint:
+		return commonutils.U(v)
+	case uint32:
+		return commonutils.U(v)
+	case uint64:
+		return commonutils.U(v)
+	default:
+		return keySegmentEscaper.Replace(fmt.Sprint(v))
+	}
+}
+
+// 已登记的 key 模板
+type RedisKeyPattern struct {
+	Name        string        // 名称，唯一
+	Pattern     string        // 模板，如 user:{uid:int}:profile
+	Type        RedisKeyType  // 数据类型
+	TTL         time.Duration // 超时时间，0 表示不过期
+	Description string        // 说明
+	segments    []keySegment
+	builder     *RedisKeyBuilder
+}
+
+/*
+解析 key 模板
+
+模板由 ":" 分隔，每段为固定文本或参数，参数的格式为 {名称:类型}，类型为 int、uint、str，
+参数名前加 "#" 表示该段作为集群 hash tag，如 order:{#uid:int}:{oid:str}
+*/
+func parseKeyPattern(paramPattern string) ([]keySegment, error) {
+	if paramPattern == "" {
+		return nil, commonutils.NewError(commonutils.ERR_FAIL, "key 模板不能为空")
+	}
+	parts := splitKeyPattern(paramPattern)
+	segments := make([]keySegment, 0, len(parts))
+	names := make(map[string]bool)
+	hashTags := 0
+	for _, part := range parts {
+		if !strings.HasPrefix(part, "{") {
+			if part == "" || strings.ContainsAny(part, "{}") {
+				return nil, commonutils.NewError(commonutils.ERR_FAIL, "key 模板格式错误："+paramPattern)
+			}
+			segments = append(segments, keySegment{literal: part})
+			continue
+		}
+		if !strings.HasSuffix(part, "}") {
+			return nil, commonutils.NewError(commonutils.ERR_FAIL, "key 模板参数格式错误："+paramPattern)
+		}
+		seg := keySegment{}
+		name, kind, found := strings.Cut(part[1:len(part)-1], ":")
+		if !found {
+			kind = keySegmentStr
+		}
+		if strings.HasPrefix(name, "#") {
+			seg.hashTag = true
+			name = name[1:]
+			hashTags++
+		}
+		if name == "" || names[name] || !commonutils.IsIn(kind, keySegmentInt, keySegmentUint, keySegmentStr) {
+			return nil, commonutils.NewError(commonutils.ERR_FAIL, "key 模板参数错误："+part+" 模板："+paramPattern)
+		}
+		names[name] = true
+		seg.name, seg.kind = name, kind
+		segments = append(segments, seg)
+	}
+	if hashTags > 1 {
+		return nil, commonutils.NewError(commonutils.ERR_FAIL, "key 模板只能有一个 hash tag："+paramPattern)
+	}
+	return segments, nil
+}
+
+// 按 ":" 拆分模板，参数 {名称:类型} 内的 ":" 不拆分
+func splitKeyPattern(paramPattern string) []string {
+	parts := make([]string, 0, 4)
+	depth, start := 0, 0
+	for i, c := range paramPattern {
+		switch c {
+		case '{':
+			depth++
+		case '}':
+			depth--
+		case ':':
+			if depth == 0 {
+				parts = append(parts, paramPattern[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, paramPattern[start:])
+}
+
+/*
+按模板生成 key，参数按模板中的顺序传入，类型不匹配时返回错误
+
+  - paramValues 参数值，int/uint 参数只能传整数，str 参数只能传字符串
+*/
+func (p *RedisKeyPattern) Key(paramValues ...interface{}) (string, error) {
+	parts := make([]string, 0, len(p.segments)+1)
+	parts = append(parts, p.builder.prefix)
+	i := 0
+	for _, seg := range p.segments {
+		if seg.kind == keySegmentLiteral {
+			parts = append(parts, seg.literal)
+			continue
+		}
+		if i >= len(paramValues) {
+			return "", commonutils.NewError(commonutils.ERR_FAIL, "key 参数数量不足："+p.Name)
+		}
+		value, err := formatKeyParam(seg, paramValues[i])
+		if err != nil {
+			return "", commonutils.NewError(commonutils.ERR_FAIL, p.Name+" 参数 "+seg.name+" 错误："+err.Error())
+		}
+		if seg.hashTag {
+			value = "{" + value + "}"
+		}
+		parts = append(parts, value)
+		i++
+	}
+	if i != len(paramValues) {
+		return "", commonutils.NewError(commonutils.ERR_FAIL, "key 参数数量过多："+p.Name)
+	}
+	return strings.Join(parts, KEY_SEPARATOR), nil
+}
+
+// 按模板生成 key，参数错误时 panic，适用于参数类型在编译期已确定的场景
+func (p *RedisKeyPattern) MustKey(paramValues ...interface{}) string {
+	key, err := p.Key(paramValues...)
+	if err != nil {
+		panic(err)
+	}
+	return key
+}
+
+func formatKeyParam(paramSegment keySegment, paramValue interface{}) (string, error) {
+	switch paramSegment.kind {
+	case keySegmentInt:
+		switch v := paramValue.(type) {
+		case int, int8, int16, int32, int64:
+			return fmt.Sprint(v), nil
+		}
+	case keySegmentUint:
+		switch v := paramValue.(type) {
+		case uint, uint8, uint16, uint32, uint64:
+			return fmt.Sprint(v), nil
+		}
+	case keySegmentStr:
+		if v, ok := paramValue.(string); ok {
+			if v == "" || strings.ContainsAny(v, KEY_SEPARATOR+"{}") {
+				return "", commonutils.NewError(commonutils.ERR_FAIL, "字符串参数不能为空，也不能包含 : { }")
+			}
+			return v, nil
+		}
+	}
+	return "", commonutils.NewErrorf(commonutils.ERR_FAIL, "需要 %s 类型，实际为 %T", paramSegment.kind, paramValue)
+}
+
+// 判断 key(不含前缀的各段)是否符合模板
+func (p *RedisKeyPattern) match(paramParts []string) bool {
+	if len(paramParts) != len(p.segments) {
+		return false
+	}
+	for i, seg := range p.segments {
+		part := paramParts[i]
+		if seg.kind == keySegmentLiteral {
+			if part != seg.literal {
+				return false
+			}
+			continue
+		}
+		if seg.hashTag {
+			if len(part) < 2 || part[0] != '{' || part[len(part)-1] != '}' {
+				return false
+			}
+			part = part[1 : len(part)-1]
+		}
+		switch seg.kind {
+		case keySegmentInt:
+			if _, err := strconv.ParseInt(part, 10, 64); err != nil {
+				return false
+			}
+		case keySegmentUint:
+			if _, err := strconv.ParseUint(part, 10, 64); err != nil {
+				return false
+			}
+		default:
+			if part == "" {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// 判断两个模板是否可能生成相同的 key
+func (p *RedisKeyPattern) conflicts(paramOther *RedisKeyPattern) bool {
+	if len(p.segments) != len(paramOther.segments) {
+		return false
+	}
+	for i, a := range p.segments {
+		b := paramOther.segments[i]
+		switch {
+		case a.kind == keySegmentLiteral && b.kind == keySegmentLiteral:
+			if a.literal != b.literal {
+				return false
+			}
+		case a.kind == keySegmentLiteral:
+			if !(&RedisKeyPattern{segments: []keySegment{b}}).match([]string{a.literal}) {
+				return false
+			}
+		case b.kind == keySegmentLiteral:
+			if !(&RedisKeyPattern{segments: []keySegment{a}}).match([]string{b.literal}) {
+				return false
+			}
+		case a.hashTag != b.hashTag:
+			return false
+		}
+	}
+	return true
+}
+
+// 超时时间 单位秒，用于创建各个工具类，不足1秒的部分向上取整，避免小于1秒的 TTL 变成0(不过期)
+func (p *RedisKeyPattern) ExpireSeconds() int32 {
+	return int32((p.TTL + time.Second - 1) / time.Second)
+}
+
+func (p *RedisKeyPattern) checkType(paramType RedisKeyType) error {
+	if p.Type != paramType {
+		return commonutils.NewError(commonutils.ERR_FAIL, "key "+p.Name+" 的类型为 "+string(p.Type)+"，不是 "+string(paramType))
+	}
+	return nil
+}
+
+// 按模板创建 HSet 工具类，超时时间使用模板的 TTL
+func (p *RedisKeyPattern) CreateHSetUtils(paramCli *redis.Client, paramValues ...interface{}) (*RedisHSetUtils, error) {
+	if err := p.checkType(KEY_TYPE_HASH); err != nil {
+		return nil, err
+	}
+	key, err := p.Key(paramValues...)
+	if err != nil {
+		return nil, err
+	}
+	return CreateHSetUtils(paramCli, key, p.ExpireSeconds(), p.TTL > 0), nil
+}
+
+// 按模板创建 List 工具类，超时时间使用模板的 TTL
+func (p *RedisKeyPattern) CreateListUtils(paramCli *redis.Client, paramValues ...interface{}) (*RedisListUtils, error) {
+	if err := p.checkType(KEY_TYPE_LIST); err != nil {
+		return nil, err
+	}
+	key, err := p.Key(paramValues...)
+	if err != nil {
+		return nil, err
+	}
+	return CreateListUtils(paramCli, key, p.ExpireSeconds(), p.TTL > 0), nil
+}
+
+// 按模板创建 Set 工具类，超时时间使用模板的 TTL
+func (p *RedisKeyPattern) CreateSetUtils(paramCli *redis.Client, paramValues ...interface{}) (*RedisSetUtils, error) {
+	if err := p.checkType(KEY_TYPE_SET); err != nil {
+		return nil, err
+	}
+	key, err := p.Key(paramValues...)
+	if err != nil {
+		return nil, err
+	}
+	return CreateSetUtils(paramCli, key, p.ExpireSeconds(), p.TTL > 0), nil
+}
+
+// 按模板创建 ZSet 工具类，超时时间使用模板的 TTL
+func (p *RedisKeyPattern) CreateZSetUtils(paramCli *redis.Client, paramValues ...interface{}) (*RedisZSetUtils, error) {
+	if err := p.checkType(KEY_TYPE_ZSET); err != nil {
+		return nil, err
+	}
+	key, err := p.Key(paramValues...)
+	if err != nil {
+		return nil, err
+	}
+	return CreateZSetUtils(paramCli, key, p.ExpireSeconds(), p.TTL > 0), nil
+}
+
+/*
+key 模板的登记表，记录服务用到的所有 key 的模板、类型和超时时间
+
+登记时检查模板是否会与已登记的模板生成相同的 key，可以列出、校验所有 key 并生成文档
+*/
+type RedisKeyRegistry struct {
+	builder  *RedisKeyBuilder
+	mu       sync.RWMutex
+	patterns map[string]*RedisKeyPattern
+}
+
+// 创建一个 key 登记表
+func CreateKeyRegistry(paramBuilder *RedisKeyBuilder) *RedisKeyRegistry {
+	return &RedisKeyRegistry{
+		builder:  paramBuilder,
+		patterns: make(map[string]*RedisKeyPattern),
+	}
+}
+
+/*
+登记一个 key 模板
+
+  - paramName 名称，唯一
+  - paramPattern 模板，如 user:{uid:int}:profile
+  - paramType 数据类型
+  - paramTTL 超时时间，0 表示不过期，不足1秒的部分按1秒计算
+  - paramDescription 说明
+
+数据类型不是 KEY_TYPE_* 之一或超时时间为负数时返回错误
+*/
+func (r *RedisKeyRegistry) Register(paramName string, paramPattern string, paramType RedisKeyType, paramTTL time.Duration, paramDescription string) (*RedisKeyPattern, error) {
+	if !paramType.valid() {
+		return nil, commonutils.NewError(commonutils.ERR_FAIL, "key "+paramName+" 的数据类型错误："+string(paramType))
+	}
+	if paramTTL < 0 || paramTTL > keyPatternMaxTTL {
+		return nil, commonutils.NewError(commonutils.ERR_FAIL, "key "+paramName+" 的超时时间超出范围："+paramTTL.String())
+	}
+	segments, err := parseKeyPattern(paramPattern)
+	if err != nil {
+		return nil, err
+	}
+	p := &RedisKeyPattern{
+		Name:        paramName,
+		Pattern:     paramPattern,
+		Type:        paramType,
+		TTL:         paramTTL,
+		Description: paramDescription,
+		segments:    segments,
+		builder:     r.builder,
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.patterns[paramName]; ok {
+		return nil, commonutils.NewError(commonutils.ERR_FAIL, "key 名称重复："+paramName)
+	}
+	for _, other := range r.patterns {
+		if p.conflicts(other) {
+			return nil, commonutils.NewError(commonutils.ERR_FAIL, "key 模板 "+paramPattern+" 与 "+other.Name+"("+other.Pattern+") 冲突")
+		}
+	}
+	r.patterns[paramName] = p
+	return p, nil
+}
+
+// 登记一个 key 模板，出错时 panic，适用于包级变量的初始化
+func (r *RedisKeyRegistry) MustRegister(paramName string, paramPattern string, paramType RedisKeyType, paramTTL time.Duration, paramDescription string) *RedisKeyPattern {
+	p, err := r.Register(paramName, paramPattern, paramType, paramTTL, paramDescription)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// 按名称获取模板
+func (r *RedisKeyRegistry) Get(paramName string) (*RedisKeyPattern, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	p, ok := r.patterns[paramName]
+	return p, ok
+}
+
+// 按名称排序列出所有模板
+func (r *RedisKeyRegistry) List() []*RedisKeyPattern {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	list := make([]*RedisKeyPattern, 0, len(r.patterns))
+	for _, p := range r.patterns {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// 查找完整的 key 对应的模板，可用于校验 key 是否已登记
+func (r *RedisKeyRegistry) Match(paramKey string) (*RedisKeyPattern, bool) {
+	prefix := r.builder.prefix + KEY_SEPARATOR
+	if !strings.HasPrefix(paramKey, prefix) {
+		return nil, false
+	}
+	parts := strings.Split(paramKey[len(prefix):], KEY_SEPARATOR)
+	for _, p := range r.List() {
+		if p.match(parts) {
+			return p, true
+		}
+	}
+	return nil, false
+}
+
+// 生成 markdown 格式的 key 文档
+func (r *RedisKeyRegistry) Document() string {
+	var sb strings.Builder
+	sb.WriteString("| 名称 | key | 类型 | 超时 | 说明 |\n")
+	sb.WriteString("| --- | --- | --- | --- | --- |\n")
+	for _, p := range r.List() {
+		ttl := "不过期"
+		if p.TTL > 0 {
+			ttl = p.TTL.String()
+		}
+		sb.WriteString("| " + p.Name + " | `" + r.builder.prefix + KEY_SEPARATOR + p.Pattern + "` | " + string(p.Type) + " | " + ttl + " | " + p.Description + " |\n")
+	}
+	return sb.String()
+}
@@ -0,0 +1,97 @@
+package redisv8
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKeyBuilder(t *testing.T) {
+	b := CreateKeyBuilder("shop", "prod")
+	if key := b.Key("user", 1001, "profile"); key != "shop:prod:user:1001:profile" {
+		t.Errorf("Key = %s", key)
+	}
+	if key := b.Key("order", HashTag("1001"), "list"); key != "shop:prod:order:{1001}:list" {
+		t.Errorf("Key with hash tag = %s", key)
+	}
+	if key := CreateKeyBuilder("shop", "").Key("cfg"); key != "shop:cfg" {
+		t.Errorf("Key without env = %s", key)
+	}
+	// 字符串中的分隔符和 hash tag 会被转义
+	if a, b := b.Key("a:b"), b.Key("a", "b"); a == b {
+		t.Errorf("Key(\"a:b\") = Key(\"a\", \"b\") = %s", a)
+	}
+	if key := b.Key("user", "x}{y", HashTag("1:2")); key != "shop:prod:user:x%7D%7By:{1%3A2}" {
+		t.Errorf("Key escaped = %s", key)
+	}
+	if a, b := b.Key("a%3Ab"), b.Key("a:b"); a == b {
+		t.Errorf("Key escape is ambiguous: %s", a)
+	}
+}
+
+func TestKeyRegistry(t *testing.T) {
+	r := CreateKeyRegistry(CreateKeyBuilder("shop", "test"))
+	profile := r.MustRegister("user_profile", "user:{uid:int}:profile", KEY_TYPE_HASH, time.Hour, "用户资料")
+	orders := r.MustRegister("user_orders", "order:{#uid:int}:{status:str}", KEY_TYPE_ZSET, 0, "用户订单")
+
+	key, err := profile.Key(int64(42))
+	if err != nil || key != "shop:test:user:42:profile" {
+		t.Errorf("profile.Key = %s, %v", key, err)
+	}
+	if _, err := profile.Key("42"); err == nil {
+		t.Error("profile.Key should reject string for int param")
+	}
+	if _, err := profile.Key(1, 2); err == nil {
+		t.Error("profile.Key should reject extra params")
+	}
+	if key := orders.MustKey(7, "paid"); key != "shop:test:order:{7}:paid" {
+		t.Errorf("orders.Key = %s", key)
+	}
+	if _, err := orders.Key(7, "a:b"); err == nil {
+		t.Error("orders.Key should reject separator in string param")
+	}
+
+	// 冲突检测
+	if _, err := r.Register("user_name", "user:{name:str}:profile", KEY_TYPE_STRING, 0, ""); err == nil {
+		t.Error("Register should detect conflict between str and int params")
+	}
+	if _, err := r.Register("user_admin", "user:admin:profile", KEY_TYPE_HASH, 0, ""); err != nil {
+		t.Errorf("Register literal that cannot match int param = %v", err)
+	}
+	if _, err := r.Register("user_1", "user:1:profile", KEY_TYPE_HASH, 0, ""); err == nil {
+		t.Error("Register should detect literal matching an int param")
+	}
+	if _, err := r.Register("bad", "user:{uid:float}", KEY_TYPE_HASH, 0, ""); err == nil {
+		t.Error("Register should reject unknown param type")
+	}
+	if _, err := r.Register("bad", "bad:type", RedisKeyType("hashes"), 0, ""); err == nil {
+		t.Error("Register should reject unknown key type")
+	}
+	if _, err := r.Register("bad", "bad:ttl", KEY_TYPE_HASH, -time.Second, ""); err == nil {
+		t.Error("Register should reject negative ttl")
+	}
+	// 不足1秒的超时时间向上取整，不能变成不过期
+	short := r.MustRegister("captcha", "captcha:{id:str}", KEY_TYPE_STRING, 500*time.Millisecond, "验证码")
+	if n := short.ExpireSeconds(); n != 1 {
+		t.Errorf("ExpireSeconds = %d, want 1", n)
+	}
+	if n := profile.ExpireSeconds(); n != 3600 {
+		t.Errorf("ExpireSeconds = %d, want 3600", n)
+	}
+
+	if p, ok := r.Match("shop:test:order:{7}:paid"); !ok || p != orders {
+		t.Errorf("Match orders = %v, %v", p, ok)
+	}
+	if _, ok := r.Match("shop:test:user:abc:profile"); ok {
+		t.Error("Match should reject non-int uid")
+	}
+	if len(r.List()) != 4 {
+		t.Errorf("List = %d patterns", len(r.List()))
+	}
+	if doc := r.Document(); !strings.Contains(doc, "`shop:test:user:{uid:int}:profile`") {
+		t.Errorf("Document missing pattern:\n%s", doc)
+	}
+	if _, err := profile.CreateZSetUtils(nil, 1); err == nil {
+		t.Error("CreateZSetUtils should reject hash pattern")
+	}
+}
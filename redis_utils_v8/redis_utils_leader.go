@@ -0,0 +1,247 @@
+package redisv8
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	redis "github.com/go-redis/redis/v8"
+)
+
+/*
+基于redis的选主，保证周期任务等只在一个实例上执行
+
+  - 各实例用 SET NX 竞选同一个key，值为实例ID，带超时
+  - 当选后每 1/3 超时时间续期一次，key 已被删除或被其他实例取得时立即失去领导权，连接出错时在有效期内继续重试
+  - 领导权的有效期从发出竞选或续期命令前开始计算，到期前留出 1/10 超时时间的余量，
+    有效期内没有续期成功时立即取消 OnElected 的 ctx，保证 key 过期、其他实例当选之前已经停止执行
+  - Run 退出时等领导者的任务结束后主动删除key，其他实例可以立即当选
+
+使用示例：
+
+	elector := redisv8.CreateLeaderElector(cli, "job:leader", "", 15*time.Second)
+	elector.OnElected(func(ctx context.Context) {
+		// 当选后执行，失去领导权时 ctx 被取消
+	})
+	go elector.Run(ctx)
+*/
+type RedisLeaderElector struct {
+	cli        *redis.Client
+	key        string
+	id         string
+	ttl        time.Duration
+	mu         sync.Mutex
+	leading    bool
+	onElected  func(ctx context.Context)
+	onRevoked  func()
+	errHandler func(error)
+}
+
+/*
+创建一个选主器
+
+  - paramCli
+  - paramKey 竞选的key，各实例使用相同的key
+  - paramID 实例ID，为空时使用 主机名-进程ID
+  - paramTTL 领导权的超时时间，当选实例崩溃后最多经过该时间其他实例可以当选
+*/
+func CreateLeaderElector(paramCli *redis.Client, paramKey string, paramID string, paramTTL time.Duration) *RedisLeaderElector {
+	if paramID == "" {
+		host, _ := os.Hostname()
+		paramID = fmt.Sprintf("%s-%d", host, os.Getpid())
+	}
+	return &RedisLeaderElector{
+		cli: paramCli,
+		key: paramKey,
+		id:  paramID,
+		ttl: paramTTL,
+	}
+}
+
+// 实例ID
+func (e *RedisLeaderElector) ID() string {
+	return e.id
+}
+
+// 设置当选后的回调，在单独的协程中执行，失去领导权时 ctx 被取消，需要在 Run 之前设置
+func (e *RedisLeaderElector) OnElected(paramFn func(ctx context.Context)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.onElected = paramFn
+}
+
+// 设置失去领导权后的回调，需要在 Run 之前设置
+func (e *RedisLeaderElector) OnRevoked(paramFn func()) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.onRevoked = paramFn
+}
+
+// 设置出错时的回调，如竞选或续期时连接失败
+func (e *RedisLeaderElector) SetErrorHandler(paramFn func(error)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.errHandler = paramFn
+}
+
+func (e *RedisLeaderElector) reportError(paramErr error) {
+	e.mu.Lock()
+	fn := e.errHandler
+	e.mu.Unlock()
+	if fn != nil && paramErr != nil {
+		fn(paramErr)
+	}
+}
+
+// 当前实例是否为领导者
+func (e *RedisLeaderElector) IsLeader() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.leading
+}
+
+// 当前领导者的实例ID，没有领导者时返回空字符串
+func (e *RedisLeaderElector) Leader(ctx context.Context) (string, error) {
+	id, err := e.cli.Get(ctx, e.key).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return id, err
+}
+
+// KEYS: 竞选的key
+// ARGV: 实例ID, 超时毫秒
+var leaderRenewScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// KEYS: 竞选的key
+// ARGV: 实例ID
+var leaderResignScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// 竞选或续期，返回是否为领导者
+func (e *RedisLeaderElector) campaign(ctx context.Context, paramLeading bool) (bool, error) {
+	if paramLeading {
+		ok, err := leaderRenewScript.Run(ctx, e.cli, []string{e.key}, e.id, e.ttl.Milliseconds()).Int()
+		return ok == 1, err
+	}
+	ok, err := e.cli.SetNX(ctx, e.key, e.id, e.ttl).Result()
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		// 重启后可能仍是之前的自己
+		id, err := e.cli.Get(ctx, e.key).Result()
+		if err == nil && id == e.id {
+			return e.campaign(ctx, true)
+		}
+	}
+	return ok, nil
+}
+
+func (e *RedisLeaderElector) setLeading(paramLeading bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.leading = paramLeading
+}
+
+/*
+参与竞选，阻塞直到 ctx 被取消，返回 ctx.Err()
+
+退出时如果是领导者，先取消 OnElected 的 ctx 并等待它返回、调用 OnRevoked，再删除竞选的key
+*/
+func (e *RedisLeaderElector) Run(ctx context.Context) error {
+	e.mu.Lock()
+	onElected, onRevoked := e.onElected, e.onRevoked
+	e.mu.Unlock()
+
+	var leaderCtx context.Context
+	var cancelLeader context.CancelFunc
+	var expireTimer *time.Timer // 领导权到期时取消 leaderCtx
+	var wg sync.WaitGroup
+	var validUntil time.Time
+	revoke := func() {
+		expireTimer.Stop()
+		cancelLeader()
+		wg.Wait()
+		e.setLeading(false)
+		if onRevoked != nil {
+			onRevoked()
+		}
+		cancelLeader = nil
+	}
+
+	interval := e.ttl / 3
+	// 本地时钟与redis的时钟速率可能有偏差，提前一点认为领导权到期
+	lease := e.ttl - e.ttl/10
+	for {
+		leading := cancelLeader != nil
+		// 在发出命令之前记录时间，key 的超时从redis收到命令时开始，不会早于该时间
+		start := time.Now()
+		campaignCtx, cancelCampaign := ctx, context.CancelFunc(func() {})
+		if leading {
+			// 续期的往返时间不能超过领导权的有效期
+			campaignCtx, cancelCampaign = context.WithDeadline(ctx, validUntil)
+		}
+		ok, err := e.campaign(campaignCtx, leading)
+		cancelCampaign()
+		if err != nil {
+			e.reportError(err)
+		}
+		switch {
+		case ok && !leading:
+			validUntil = start.Add(lease)
+			e.setLeading(true)
+			leaderCtx, cancelLeader = context.WithCancel(ctx)
+			expireTimer = time.AfterFunc(time.Until(validUntil), cancelLeader)
+			if onElected != nil {
+				wg.Add(1)
+				go func(paramCtx context.Context) {
+					defer wg.Done()
+					onElected(paramCtx)
+				}(leaderCtx)
+			}
+		case ok && expireTimer.Stop():
+			validUntil = start.Add(lease)
+			expireTimer.Reset(time.Until(validUntil))
+		case leading && (err == nil || !time.Now().Before(validUntil)):
+			// key 已被删除或被其他实例取得，或续期成功前领导权已经到期
+			revoke()
+		}
+
+		var leaderDone <-chan struct{}
+		if cancelLeader != nil {
+			leaderDone = leaderCtx.Done()
+		}
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			if cancelLeader != nil {
+				// 先等领导者的任务结束再删除key，否则其他实例可能在任务结束前当选
+				revoke()
+				resignCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+				if err := leaderResignScript.Run(resignCtx, e.cli, []string{e.key}, e.id).Err(); err != nil {
+					e.reportError(err)
+				}
+				cancel()
+			}
+			return ctx.Err()
+		case <-leaderDone:
+			// 有效期内没有续期成功
+			timer.Stop()
+			revoke()
+		case <-timer.C:
+		}
+	}
+}
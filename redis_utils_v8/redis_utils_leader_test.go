@@ -0,0 +1,128 @@
+package redisv8
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLeaderElectionFailover(t *testing.T) {
+	cli, _ := newTestClient(t)
+	a := CreateLeaderElector(cli, "leader", "a", 300*time.Millisecond)
+	b := CreateLeaderElector(cli, "leader", "b", 300*time.Millisecond)
+
+	ctxA, cancelA := context.WithCancel(context.Background())
+	doneA := make(chan struct{})
+	go func() {
+		a.Run(ctxA)
+		close(doneA)
+	}()
+	waitFor(t, "a elected", a.IsLeader)
+
+	ctxB, cancelB := context.WithCancel(context.Background())
+	defer cancelB()
+	go b.Run(ctxB)
+	time.Sleep(150 * time.Millisecond)
+	if b.IsLeader() {
+		t.Fatal("b should not be elected while a is leading")
+	}
+
+	// a 退出时删除key，b 不需要等到超时就可以当选
+	cancelA()
+	<-doneA
+	if a.IsLeader() {
+		t.Fatal("a still leading after Run returned")
+	}
+	waitFor(t, "b elected", b.IsLeader)
+	if id, _ := b.Leader(context.Background()); id != "b" {
+		t.Fatalf("leader = %q", id)
+	}
+}
+
+func TestLeaderRenewFailureCancelsBeforeTTL(t *testing.T) {
+	cli, mr := newTestClient(t)
+	ttl := 300 * time.Millisecond
+	e := CreateLeaderElector(cli, "leader", "a", ttl)
+
+	elected := make(chan struct{})
+	cancelledAt := make(chan time.Time, 1)
+	e.OnElected(func(ctx context.Context) {
+		close(elected)
+		<-ctx.Done()
+		cancelledAt <- time.Now()
+	})
+	var revoked int32
+	e.OnRevoked(func() { atomic.AddInt32(&revoked, 1) })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go e.Run(ctx)
+	<-elected
+
+	// redis 不可用后无法续期，最后一次续期成功后 ttl 内 key 会过期，leaderCtx 必须在此之前取消
+	mr.Close()
+	closedAt := time.Now()
+	select {
+	case at := <-cancelledAt:
+		if d := at.Sub(closedAt); d >= ttl {
+			t.Fatalf("leader ctx cancelled %v after redis failure, ttl %v", d, ttl)
+		}
+	case <-time.After(2 * ttl):
+		t.Fatal("leader ctx not cancelled")
+	}
+	waitFor(t, "revoked", func() bool { return !e.IsLeader() && atomic.LoadInt32(&revoked) == 1 })
+}
+
+func TestLeaderKeyTakenRevokes(t *testing.T) {
+	cli, mr := newTestClient(t)
+	e := CreateLeaderElector(cli, "leader", "a", 300*time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go e.Run(ctx)
+	waitFor(t, "elected", e.IsLeader)
+
+	mr.Set("leader", "b")
+	waitFor(t, "revoked", func() bool { return !e.IsLeader() })
+	if id, _ := e.Leader(ctx); id != "b" {
+		t.Fatalf("leader = %q", id)
+	}
+}
+
+func TestLeaderResignAfterJobReturns(t *testing.T) {
+	cli, mr := newTestClient(t)
+	e := CreateLeaderElector(cli, "leader", "a", time.Second)
+
+	elected := make(chan struct{})
+	release := make(chan struct{})
+	var stopped int32
+	e.OnElected(func(ctx context.Context) {
+		close(elected)
+		<-ctx.Done()
+		// 任务收到取消后还需要一段时间才能结束
+		<-release
+		atomic.StoreInt32(&stopped, 1)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		e.Run(ctx)
+		close(done)
+	}()
+	<-elected
+	cancel()
+	time.Sleep(100 * time.Millisecond)
+	// 任务结束之前key一直保留，其他实例不能当选
+	if !mr.Exists("leader") {
+		t.Fatal("key deleted while the leader job is still running")
+	}
+	close(release)
+	<-done
+	if atomic.LoadInt32(&stopped) != 1 {
+		t.Fatal("Run returned before the leader job")
+	}
+	if mr.Exists("leader") {
+		t.Fatal("key not deleted after the leader job returned")
+	}
+}
@@ -5,16 +5,28 @@ import (
 	"time"
 
 	redis "github.com/go-redis/redis/v8"
+	"github.com/qiuliaogit/commonutils/commonutils"
 )
 
+// 阻塞命令的等待时间为0并且 ctx 没有截止时间时返回该错误
+var ErrBlockingNoDeadline = commonutils.NewError(commonutils.ERR_FAIL, "阻塞命令的等待时间为0时 ctx 必须有截止时间")
+
 // 基于redis的列表工具类
 type RedisListUtils struct {
 	key         string
 	cli         *redis.Client
-	expire      int32 // 超时时间单位秒
-	auto_expire bool  // 是否自动更新超期时间  否则手动更新，默认自动更新
+	pipe        redis.Pipeliner // 批量执行时命令写入的管道，nil 表示直接执行
+	expire      int32           // 超时时间单位秒
+	auto_expire bool            // 是否自动更新超期时间  否则手动更新，默认自动更新
+	max_size    int64           // 最近记录模式的最大长度 0表示不限制
 }
 
+// 列表的方向，用于 Move
+const (
+	LIST_LEFT  = "LEFT"  // 头部
+	LIST_RIGHT = "RIGHT" // 尾部
+)
+
 // 设置字段更新超时标志
 func (m *RedisListUtils) SetAutoExpire(paramValue bool) {
 	m.auto_expire = paramValue
@@ -24,16 +36,16 @@ func (m *RedisListUtils) SetAutoExpire(paramValue bool) {
 func (m *RedisListUtils) ExpireSecond(ctx context.Context, paramSeconds int) {
 	if paramSeconds < 0 {
 		paramSeconds = -1
-		m.cli.Persist(ctx, m.key)
+		m.cmd().Persist(ctx, m.key)
 	} else {
-		m.cli.Expire(ctx, m.key, time.Duration(paramSeconds)*time.Second)
+		m.cmd().Expire(ctx, m.key, time.Duration(paramSeconds)*time.Second)
 	}
 	m.expire = int32(paramSeconds)
 }
 
 // 取队列的数量
 func (m *RedisListUtils) Count(ctx context.Context) *redis.IntCmd {
-	retCmd := m.cli.LLen(ctx, m.key)
+	retCmd := m.cmd().LLen(ctx, m.key)
 	m.afterExpire(ctx, retCmd.Err())
 	return retCmd
 }
@@ -41,87 +53,273 @@ func (m *RedisListUtils) Count(ctx context.Context) *redis.IntCmd {
 // 更新超时时间
 func (m *RedisListUtils) afterExpire(ctx context.Context, paramErr error) {
 	if paramErr == nil && m.auto_expire && m.expire > 0 {
-		m.cli.Expire(ctx, m.key, time.Duration(m.expire)*time.Second)
+		m.cmd().Expire(ctx, m.key, time.Duration(m.expire)*time.Second)
 	}
 }
 
 // 在列表中添加一个或多个值到列表尾部
 func (m *RedisListUtils) RPush(ctx context.Context, paramValue ...interface{}) *redis.IntCmd {
-	retCmd := m.cli.RPush(ctx, m.key, paramValue...)
+	retCmd := m.cmd().RPush(ctx, m.key, paramValue...)
 	m.afterExpire(ctx, retCmd.Err())
 	return retCmd
 }
 
 // 移除列表的最后一个元素，返回值为移除的元素。
 func (m *RedisListUtils) RPop(ctx context.Context) *redis.StringCmd {
-	retCmd := m.cli.RPop(ctx, m.key)
+	retCmd := m.cmd().RPop(ctx, m.key)
 	m.afterExpire(ctx, retCmd.Err())
 	return retCmd
 }
 
 // 移出并获取列表的第一个元素
 func (m *RedisListUtils) LPop(ctx context.Context) *redis.StringCmd {
-	retCmd := m.cli.LPop(ctx, m.key)
+	retCmd := m.cmd().LPop(ctx, m.key)
 	m.afterExpire(ctx, retCmd.Err())
 	return retCmd
 }
 
 // 弹出多个元素
 func (m *RedisListUtils) LPopCount(ctx context.Context, paramCount int) *redis.StringSliceCmd {
-	retCmd := m.cli.LPopCount(ctx, m.key, paramCount)
+	retCmd := m.cmd().LPopCount(ctx, m.key, paramCount)
 	m.afterExpire(ctx, retCmd.Err())
 	return retCmd
 }
 
 // 将一个或多个值插入到列表头部
 func (m *RedisListUtils) LPush(ctx context.Context, paramValue ...interface{}) *redis.IntCmd {
-	retCmd := m.cli.LPush(ctx, m.key, paramValue...)
+	retCmd := m.cmd().LPush(ctx, m.key, paramValue...)
 	m.afterExpire(ctx, retCmd.Err())
 	return retCmd
 }
 
 // 通过索引获取列表中的元素
 func (m *RedisListUtils) Get(ctx context.Context, paramIndex int64) *redis.StringCmd {
-	retCmd := m.cli.LIndex(ctx, m.key, paramIndex)
+	retCmd := m.cmd().LIndex(ctx, m.key, paramIndex)
 	m.afterExpire(ctx, retCmd.Err())
 	return retCmd
 }
 
 // 通过索引设置列表元素的值
 func (m *RedisListUtils) Set(ctx context.Context, paramIndex int64, paramValue interface{}) *redis.StatusCmd {
-	retCmd := m.cli.LSet(ctx, m.key, paramIndex, paramValue)
+	retCmd := m.cmd().LSet(ctx, m.key, paramIndex, paramValue)
 	m.afterExpire(ctx, retCmd.Err())
 	return retCmd
 }
 
 // 移除表中所有与 value 相等的值
 func (m *RedisListUtils) Del(ctx context.Context, paramValue interface{}) *redis.IntCmd {
-	retCmd := m.cli.LRem(ctx, m.key, 0, paramValue)
+	retCmd := m.cmd().LRem(ctx, m.key, 0, paramValue)
 	m.afterExpire(ctx, retCmd.Err())
 	return retCmd
 }
 
 // 从列表尾部开始删除count个与value相同的值
 func (m *RedisListUtils) DelFromTail(ctx context.Context, paramValue interface{}, paramCount uint) *redis.IntCmd {
-	retCmd := m.cli.LRem(ctx, m.key, int64(paramCount), paramValue)
+	retCmd := m.cmd().LRem(ctx, m.key, int64(paramCount), paramValue)
 	m.afterExpire(ctx, retCmd.Err())
 	return retCmd
 }
 
 // 从列表头部开始删除count个与value相同的值
 func (m *RedisListUtils) DelFromHead(ctx context.Context, paramValue interface{}, paramCount uint) *redis.IntCmd {
-	retCmd := m.cli.LRem(ctx, m.key, -int64(paramCount), paramValue)
+	retCmd := m.cmd().LRem(ctx, m.key, -int64(paramCount), paramValue)
 	m.afterExpire(ctx, retCmd.Err())
 	return retCmd
 }
 
 // 获取列表指定范围内的元素
 func (m *RedisListUtils) Range(ctx context.Context, paramStart int64, paramEnd int64) *redis.StringSliceCmd {
-	retCmd := m.cli.LRange(ctx, m.key, paramStart, paramEnd)
+	retCmd := m.cmd().LRange(ctx, m.key, paramStart, paramEnd)
+	m.afterExpire(ctx, retCmd.Err())
+	return retCmd
+}
+
+// 只保留列表指定范围内的元素，范围外的元素会被删除
+func (m *RedisListUtils) Trim(ctx context.Context, paramStart int64, paramStop int64) *redis.StatusCmd {
+	retCmd := m.cmd().LTrim(ctx, m.key, paramStart, paramStop)
+	m.afterExpire(ctx, retCmd.Err())
+	return retCmd
+}
+
+// 在 pivot 之前插入元素，返回插入后列表的长度，pivot 不存在时返回 -1
+func (m *RedisListUtils) InsertBefore(ctx context.Context, paramPivot interface{}, paramValue interface{}) *redis.IntCmd {
+	retCmd := m.cmd().LInsertBefore(ctx, m.key, paramPivot, paramValue)
+	m.afterExpire(ctx, retCmd.Err())
+	return retCmd
+}
+
+// 在 pivot 之后插入元素，返回插入后列表的长度，pivot 不存在时返回 -1
+func (m *RedisListUtils) InsertAfter(ctx context.Context, paramPivot interface{}, paramValue interface{}) *redis.IntCmd {
+	retCmd := m.cmd().LInsertAfter(ctx, m.key, paramPivot, paramValue)
+	m.afterExpire(ctx, retCmd.Err())
+	return retCmd
+}
+
+/*
+查找元素的位置，不存在时返回 redis.Nil (需要 redis 6.0.6 以上)
+
+  - paramValue 元素
+  - paramRank 第几个匹配的元素，1 为从头部开始的第一个，-1 为从尾部开始的第一个，0 按1处理
+*/
+func (m *RedisListUtils) Pos(ctx context.Context, paramValue string, paramRank int64) *redis.IntCmd {
+	retCmd := m.cmd().LPos(ctx, m.key, paramValue, redis.LPosArgs{Rank: paramRank})
+	m.afterExpire(ctx, retCmd.Err())
+	return retCmd
+}
+
+// 查找元素的所有位置，paramCount 为最多返回的数量，0 表示全部
+func (m *RedisListUtils) PosAll(ctx context.Context, paramValue string, paramCount int64) *redis.IntSliceCmd {
+	retCmd := m.cmd().LPosCount(ctx, m.key, paramValue, paramCount, redis.LPosArgs{})
 	m.afterExpire(ctx, retCmd.Err())
 	return retCmd
 }
 
+// 目标列表在当前的管道中更新超时时间
+func (m *RedisListUtils) afterMove(ctx context.Context, paramDst *RedisListUtils, paramErr error) {
+	m.afterExpire(ctx, paramErr)
+	d := *paramDst
+	d.pipe = m.pipe
+	d.afterExpire(ctx, paramErr)
+}
+
+/*
+把一个元素从当前列表移动到另一个列表，返回移动的元素，当前列表为空时返回 redis.Nil (需要 redis 6.2 以上)
+
+  - paramDst 目标列表，可以是自己，用于轮转
+  - paramSrcPos 从当前列表的哪一端取出 LIST_LEFT / LIST_RIGHT
+  - paramDstPos 放到目标列表的哪一端 LIST_LEFT / LIST_RIGHT
+*/
+func (m *RedisListUtils) Move(ctx context.Context, paramDst *RedisListUtils, paramSrcPos string, paramDstPos string) *redis.StringCmd {
+	retCmd := m.cmd().LMove(ctx, m.key, paramDst.key, paramSrcPos, paramDstPos)
+	m.afterMove(ctx, paramDst, retCmd.Err())
+	return retCmd
+}
+
+/*
+阻塞命令的等待时间
+
+go-redis v8 在阻塞读取期间只使用 ctx 的截止时间，不会响应 ctx 的取消，
+所以等待时间 <=0 时改为等待到 ctx 的截止时间，ctx 没有截止时间时返回 ErrBlockingNoDeadline，
+剩余不足1秒时按超时返回 redis.Nil
+*/
+func blockingTimeout(ctx context.Context, paramTimeout time.Duration) (time.Duration, error) {
+	if paramTimeout > 0 {
+		return paramTimeout, nil
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return 0, ErrBlockingNoDeadline
+	}
+	// go-redis v8 按整秒发送等待时间，不足1秒按1秒，向下取整避免连接先于命令超时
+	d := time.Until(deadline).Truncate(time.Second)
+	if d <= 0 {
+		return 0, redis.Nil
+	}
+	return d, nil
+}
+
+/*
+阻塞地把一个元素从当前列表移动到另一个列表，批量模式下返回 ErrBatchUnsupported
+
+  - paramTimeout 最长等待时间，<=0 表示等待到 ctx 的截止时间，此时 ctx 必须有截止时间，否则返回 ErrBlockingNoDeadline
+
+等待期间取消 ctx 不会使命令提前返回，超时返回 redis.Nil
+*/
+func (m *RedisListUtils) BMove(ctx context.Context, paramDst *RedisListUtils, paramSrcPos string, paramDstPos string, paramTimeout time.Duration) *redis.StringCmd {
+	if m.pipe != nil {
+		return redis.NewStringResult("", ErrBatchUnsupported)
+	}
+	timeout, err := blockingTimeout(ctx, paramTimeout)
+	if err != nil {
+		return redis.NewStringResult("", err)
+	}
+	retCmd := m.cli.BLMove(ctx, m.key, paramDst.key, paramSrcPos, paramDstPos, timeout)
+	m.afterMove(ctx, paramDst, retCmd.Err())
+	return retCmd
+}
+
+/*
+阻塞地移除并返回列表的第一个元素，批量模式下返回 ErrBatchUnsupported
+
+  - paramTimeout 最长等待时间，<=0 表示等待到 ctx 的截止时间，此时 ctx 必须有截止时间，否则返回 ErrBlockingNoDeadline
+
+等待期间取消 ctx 不会使命令提前返回，超时返回 redis.Nil
+*/
+func (m *RedisListUtils) BLPop(ctx context.Context, paramTimeout time.Duration) *redis.StringCmd {
+	if m.pipe != nil {
+		return redis.NewStringResult("", ErrBatchUnsupported)
+	}
+	timeout, err := blockingTimeout(ctx, paramTimeout)
+	if err != nil {
+		return redis.NewStringResult("", err)
+	}
+	return m.popResult(ctx, m.cli.BLPop(ctx, timeout, m.key))
+}
+
+// 阻塞地移除并返回列表的最后一个元素，等待时间同 BLPop，批量模式下返回 ErrBatchUnsupported，超时返回 redis.Nil
+func (m *RedisListUtils) BRPop(ctx context.Context, paramTimeout time.Duration) *redis.StringCmd {
+	if m.pipe != nil {
+		return redis.NewStringResult("", ErrBatchUnsupported)
+	}
+	timeout, err := blockingTimeout(ctx, paramTimeout)
+	if err != nil {
+		return redis.NewStringResult("", err)
+	}
+	return m.popResult(ctx, m.cli.BRPop(ctx, timeout, m.key))
+}
+
+// 阻塞弹出的结果为 [key, 元素]，只返回元素
+func (m *RedisListUtils) popResult(ctx context.Context, paramCmd *redis.StringSliceCmd) *redis.StringCmd {
+	ret, err := paramCmd.Result()
+	m.afterExpire(ctx, err)
+	if err != nil {
+		return redis.NewStringResult("", err)
+	}
+	return redis.NewStringResult(ret[1], nil)
+}
+
+// KEYS: 列表
+// ARGV: 最大长度, 超时秒数, 元素...
+// 元素分批 LPUSH，避免 unpack 超过 Lua 栈的限制
+var listPushRecentScript = newIntScript(`
+local len = 0
+for i = 3, #ARGV, 1000 do
+	len = redis.call('LPUSH', KEYS[1], unpack(ARGV, i, math.min(i + 999, #ARGV)))
+end
+local max = tonumber(ARGV[1])
+if max > 0 and len > max then
+	redis.call('LTRIM', KEYS[1], 0, max - 1)
+	len = max
+end
+if tonumber(ARGV[2]) > 0 then
+	redis.call('EXPIRE', KEYS[1], ARGV[2])
+end
+return len
+`)
+
+/*
+最近记录模式：把元素压入列表头部，并在同一个Lua脚本中只保留最新的 max_size 个
+
+返回压入后列表的长度，多个元素时最后一个在最前面。
+用 CreateListUtilsCapped 创建时生效，max_size 为0时等同于 LPush
+*/
+func (m *RedisListUtils) PushRecent(ctx context.Context, paramValue ...interface{}) *redis.IntCmd {
+	if m.max_size <= 0 {
+		return m.LPush(ctx, paramValue...)
+	}
+	expire := int32(0)
+	if m.auto_expire && m.expire > 0 {
+		expire = m.expire
+	}
+	args := append([]interface{}{m.max_size, expire}, paramValue...)
+	return evalIntScript(ctx, m.cmd(), listPushRecentScript, []string{m.key}, args...)
+}
+
+// 取最近的若干个元素，最新的在前，配合 PushRecent 使用
+func (m *RedisListUtils) Recent(ctx context.Context, paramCount int64) *redis.StringSliceCmd {
+	return m.Range(ctx, 0, paramCount-1)
+}
+
 /*
 创建一个List操作工具类
 
@@ -138,3 +336,37 @@ func CreateListUtils(paramCli *redis.Client, paramListKey string, paramExpire in
 		auto_expire: paramAutoExpire,
 	}
 }
+
+/*
+创建一个最近记录模式的List操作工具类，PushRecent 时只保留最新的若干个元素
+
+  - paramCli
+  - paramListKey 列表的key
+  - paramExpire 超时时间，<=0 时表示没有超时， 单位秒
+  - paramAutoExpire 是否在更新后自动更新超时时间
+  - paramMaxSize 保留的最大数量
+*/
+func CreateListUtilsCapped(paramCli *redis.Client, paramListKey string, paramExpire int32, paramAutoExpire bool, paramMaxSize int64) *RedisListUtils {
+	return &RedisListUtils{
+		key:         paramListKey,
+		cli:         paramCli,
+		expire:      paramExpire,
+		auto_expire: paramAutoExpire,
+		max_size:    paramMaxSize,
+	}
+}
+
+// 取执行命令的客户端，批量模式下为管道
+func (m *RedisListUtils) cmd() redis.Cmdable {
+	if m.pipe != nil {
+		return m.pipe
+	}
+	return m.cli
+}
+
+// 返回绑定到批量操作的副本，副本的命令会写入批量操作，在 RedisBatch.Exec 时一起执行
+func (m *RedisListUtils) Batch(paramBatch *RedisBatch) *RedisListUtils {
+	c := *m
+	c.pipe = paramBatch.pipe
+	return &c
+}
@@ -0,0 +1,183 @@
+package redisv8
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	redis "github.com/go-redis/redis/v8"
+)
+
+func TestListPushRecent(t *testing.T) {
+	cli, mr := newTestClient(t)
+	ctx := context.Background()
+	l := CreateListUtilsCapped(cli, "recent", 60, true, 3)
+
+	if n, err := l.PushRecent(ctx, "a", "b").Result(); err != nil || n != 2 {
+		t.Fatalf("push = %d, %v", n, err)
+	}
+	// 只保留最新的3个，最后压入的在最前面
+	if n, err := l.PushRecent(ctx, "c", "d").Result(); err != nil || n != 3 {
+		t.Fatalf("push = %d, %v", n, err)
+	}
+	if list, _ := l.Recent(ctx, 10).Result(); len(list) != 3 || list[0] != "d" || list[2] != "b" {
+		t.Fatalf("recent = %v", list)
+	}
+	if mr.TTL("recent") != 60*time.Second {
+		t.Fatal("expire not set")
+	}
+
+	batch := CreateBatch(cli)
+	pushCmd := l.Batch(batch).PushRecent(ctx, "e")
+	if _, err := batch.Exec(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := pushCmd.Result(); err != nil || n != 3 {
+		t.Fatalf("batch push = %d, %v", n, err)
+	}
+
+	// 一次压入大量元素时分批 LPUSH
+	values := make([]interface{}, 10000)
+	for i := range values {
+		values[i] = strconv.Itoa(i)
+	}
+	if n, err := l.PushRecent(ctx, values...).Result(); err != nil || n != 3 {
+		t.Fatalf("large push = %d, %v", n, err)
+	}
+	if list, _ := l.Recent(ctx, 3).Result(); list[0] != "9999" || list[2] != "9997" {
+		t.Fatalf("recent = %v", list)
+	}
+
+	// 没有限制长度时等同于 LPush
+	u := CreateListUtils(cli, "plain", 0, false)
+	u.PushRecent(ctx, 1, 2, 3, 4)
+	if n, _ := u.Count(ctx).Result(); n != 4 {
+		t.Fatalf("count = %d", n)
+	}
+}
+
+func TestListPosInsertTrim(t *testing.T) {
+	cli, mr := newTestClient(t)
+	ctx := context.Background()
+	l := CreateListUtils(cli, "list", 0, false)
+
+	l.RPush(ctx, "a", "b", "a", "c")
+	if i, err := l.Pos(ctx, "a", -1).Result(); err != nil || i != 2 {
+		t.Fatalf("Pos = %d, %v", i, err)
+	}
+	if _, err := l.Pos(ctx, "x", 0).Result(); err != redis.Nil {
+		t.Fatalf("missing Pos err = %v", err)
+	}
+	if list, _ := l.PosAll(ctx, "a", 0).Result(); len(list) != 2 || list[0] != 0 || list[1] != 2 {
+		t.Fatalf("PosAll = %v", list)
+	}
+	// 查找同样更新超时时间
+	expiring := CreateListUtils(cli, "list", 60, true)
+	expiring.Pos(ctx, "a", 0)
+	if mr.TTL("list") != 60*time.Second {
+		t.Fatal("Pos expire not set")
+	}
+	mr.SetTTL("list", time.Second)
+	expiring.PosAll(ctx, "a", 0)
+	if mr.TTL("list") != 60*time.Second {
+		t.Fatal("PosAll expire not set")
+	}
+
+	l.InsertBefore(ctx, "c", "x")
+	l.InsertAfter(ctx, "c", "y")
+	if list, _ := mr.List("list"); len(list) != 6 || list[3] != "x" || list[5] != "y" {
+		t.Fatalf("list = %v", list)
+	}
+	if n, _ := l.Del(ctx, "a").Result(); n != 2 {
+		t.Fatalf("Del = %d", n)
+	}
+	if err := l.Trim(ctx, 0, 1).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if list, _ := mr.List("list"); len(list) != 2 || list[0] != "b" || list[1] != "x" {
+		t.Fatalf("list = %v", list)
+	}
+}
+
+func TestListMove(t *testing.T) {
+	cli, mr := newTestClient(t)
+	ctx := context.Background()
+	src := CreateListUtils(cli, "src", 60, true)
+	dst := CreateListUtils(cli, "dst", 60, true)
+
+	src.RPush(ctx, "a", "b", "c")
+	if v, err := src.Move(ctx, dst, LIST_LEFT, LIST_RIGHT).Result(); err != nil || v != "a" {
+		t.Fatalf("Move = %q, %v", v, err)
+	}
+	if mr.TTL("dst") != 60*time.Second {
+		t.Fatal("dst expire not set")
+	}
+	// 目标是自己时轮转
+	if v, _ := src.Move(ctx, src, LIST_RIGHT, LIST_LEFT).Result(); v != "c" {
+		t.Fatalf("rotate = %q", v)
+	}
+	if list, _ := mr.List("src"); len(list) != 2 || list[0] != "c" || list[1] != "b" {
+		t.Fatalf("src = %v", list)
+	}
+
+	empty := CreateListUtils(cli, "empty", 0, false)
+	if _, err := empty.Move(ctx, dst, LIST_LEFT, LIST_RIGHT).Result(); err != redis.Nil {
+		t.Fatalf("empty Move err = %v", err)
+	}
+	if v, err := src.BMove(ctx, dst, LIST_LEFT, LIST_LEFT, time.Second).Result(); err != nil || v != "c" {
+		t.Fatalf("BMove = %q, %v", v, err)
+	}
+	if list, _ := dst.Range(ctx, 0, -1).Result(); len(list) != 2 || list[0] != "c" || list[1] != "a" {
+		t.Fatalf("dst = %v", list)
+	}
+}
+
+func TestListBlockingPop(t *testing.T) {
+	cli, _ := newTestClient(t)
+	ctx := context.Background()
+	l := CreateListUtils(cli, "list", 0, false)
+
+	if _, err := l.BLPop(ctx, 50*time.Millisecond).Result(); err != redis.Nil {
+		t.Fatalf("timeout err = %v", err)
+	}
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		l.RPush(context.Background(), "a", "b")
+	}()
+	if v, err := l.BLPop(ctx, 2*time.Second).Result(); err != nil || v != "a" {
+		t.Fatalf("BLPop = %q, %v", v, err)
+	}
+	if v, err := l.BRPop(ctx, time.Second).Result(); err != nil || v != "b" {
+		t.Fatalf("BRPop = %q, %v", v, err)
+	}
+
+	// 等待时间为0时使用 ctx 的截止时间，没有截止时间时 go-redis 会一直阻塞，所以不允许
+	if err := l.BLPop(ctx, 0).Err(); err != ErrBlockingNoDeadline {
+		t.Fatalf("no deadline err = %v", err)
+	}
+	deadlineCtx, cancel := context.WithTimeout(ctx, 1100*time.Millisecond)
+	defer cancel()
+	begin := time.Now()
+	if err := l.BRPop(deadlineCtx, 0).Err(); err != redis.Nil {
+		t.Fatalf("deadline err = %v", err)
+	}
+	if d := time.Since(begin); d > 3*time.Second {
+		t.Fatalf("BRPop waited %v", d)
+	}
+
+	// 批量模式下阻塞命令会在 Exec 之前直接执行，所以不支持
+	b := CreateBatch(cli)
+	if err := l.Batch(b).BLPop(ctx, time.Second).Err(); err != ErrBatchUnsupported {
+		t.Fatalf("batch BLPop err = %v", err)
+	}
+	if err := l.Batch(b).BRPop(ctx, time.Second).Err(); err != ErrBatchUnsupported {
+		t.Fatalf("batch BRPop err = %v", err)
+	}
+	if err := l.Batch(b).BMove(ctx, l, LIST_LEFT, LIST_RIGHT, time.Second).Err(); err != ErrBatchUnsupported {
+		t.Fatalf("batch BMove err = %v", err)
+	}
+	if b.Len() != 0 {
+		t.Fatalf("batch len = %d", b.Len())
+	}
+}
@@ -0,0 +1,437 @@
+package redisv8
+
+import (
+	"container/list"
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	redis "github.com/go-redis/redis/v8"
+)
+
+// 近端缓存的统计数据
+type NearCacheStats struct {
+	Hits          int64 // 命中次数
+	Misses        int64 // 未命中次数
+	Evictions     int64 // 因容量或超时被淘汰的次数
+	Invalidations int64 // 收到的失效通知次数
+	Size          int   // 当前缓存的hash数量
+}
+
+// 一个hash在本地的缓存
+type nearCacheEntry struct {
+	key      string
+	all      map[string]string  // HGETALL 的结果，nil 表示没有加载
+	fields   map[string]*string // HGET 的结果，值为nil表示字段不存在
+	expireAt time.Time          // 零值表示不过期
+	elem     *list.Element
+}
+
+/*
+基于进程内LRU的近端缓存，放在redis hash前面，减少热点hash的读取
+
+  - 按hash的key缓存，容量满时淘汰最久未使用的hash，每个hash在超过TTL后失效
+  - 通过 RedisHSetUtils 的包装 RedisNearHSetUtils 写入时，通过Pub/Sub广播失效通知，所有实例删除本地缓存
+  - go-redis v8 不支持 RESP3 的客户端缓存(CLIENT TRACKING)，所以使用Pub/Sub实现失效通知
+  - 只有在 Run 收到订阅确认后才使用本地缓存，自动更新超时时间时本地缓存不会超过key的超时时间；订阅连接出错时清空本地缓存，
+    在重新订阅成功之前读取都直接访问redis，不会因为丢失通知读到旧的数据
+*/
+type RedisNearCache struct {
+	cli        *redis.Client
+	channel    string
+	capacity   int
+	ttl        time.Duration
+	mu         sync.Mutex
+	entries    map[string]*nearCacheEntry
+	lru        *list.List
+	epoch      uint64 // 每次失效时加一，加载期间发生过失效时不写入本地缓存
+	subscribed bool   // 是否正在接收失效通知，否则不使用本地缓存
+	subscriber *RedisSubscriber
+	publisher  *RedisPublisher
+
+	hits          atomic.Int64
+	misses        atomic.Int64
+	evictions     atomic.Int64
+	invalidations atomic.Int64
+}
+
+/*
+创建一个近端缓存
+
+  - paramCli
+  - paramChannel 失效通知的频道，使用同一组hash的所有实例需要相同
+  - paramCapacity 最多缓存的hash数量
+  - paramTTL 本地缓存的最长时间，作为丢失失效通知时的兜底
+*/
+func CreateNearCache(paramCli *redis.Client, paramChannel string, paramCapacity int, paramTTL time.Duration) *RedisNearCache {
+	if paramCapacity <= 0 {
+		paramCapacity = 1000
+	}
+	c := &RedisNearCache{
+		cli:        paramCli,
+		channel:    paramChannel,
+		capacity:   paramCapacity,
+		ttl:        paramTTL,
+		entries:    make(map[string]*nearCacheEntry),
+		lru:        list.New(),
+		subscriber: CreateSubscriber(paramCli),
+		publisher:  CreatePublisher(paramCli),
+	}
+	return c
+}
+
+/*
+订阅失效通知，阻塞直到 ctx 被取消
+
+一般在启动时用单独的协程运行：go cache.Run(ctx)
+*/
+func (c *RedisNearCache) Run(ctx context.Context) error {
+	c.subscriber.SetErrorHandler(func(error) {
+		// 连接出错期间可能丢失通知，清空本地缓存，重新订阅之前不使用本地缓存
+		c.setSubscribed(false)
+	})
+	c.subscriber.SetConnectHandler(func() {
+		c.setSubscribed(true)
+	})
+	defer c.setSubscribed(false)
+	c.subscriber.Subscribe(ctx, c.channel, func(ctx context.Context, paramMsg *redis.Message) error {
+		c.invalidations.Add(1)
+		c.invalidateLocal(paramMsg.Payload)
+		return nil
+	})
+	return c.subscriber.Run(ctx)
+}
+
+// 取统计数据
+func (c *RedisNearCache) Stats() NearCacheStats {
+	c.mu.Lock()
+	size := len(c.entries)
+	c.mu.Unlock()
+	return NearCacheStats{
+		Hits:          c.hits.Load(),
+		Misses:        c.misses.Load(),
+		Evictions:     c.evictions.Load(),
+		Invalidations: c.invalidations.Load(),
+		Size:          size,
+	}
+}
+
+// 清空本地缓存
+func (c *RedisNearCache) Flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.flushLocked()
+}
+
+func (c *RedisNearCache) flushLocked() {
+	c.epoch++
+	c.entries = make(map[string]*nearCacheEntry)
+	c.lru.Init()
+}
+
+// 设置是否正在接收失效通知，状态变化时清空本地缓存
+func (c *RedisNearCache) setSubscribed(paramValue bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.subscribed = paramValue
+	c.flushLocked()
+}
+
+// 删除本地缓存并通知其他实例删除
+func (c *RedisNearCache) Invalidate(ctx context.Context, paramKey string) error {
+	c.invalidateLocal(paramKey)
+	return c.publisher.Publish(ctx, c.channel, paramKey).Err()
+}
+
+func (c *RedisNearCache) invalidateLocal(paramKey string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.epoch++
+	if e, ok := c.entries[paramKey]; ok {
+		c.removeLocked(e)
+	}
+}
+
+func (c *RedisNearCache) removeLocked(paramEntry *nearCacheEntry) {
+	c.lru.Remove(paramEntry.elem)
+	delete(c.entries, paramEntry.key)
+}
+
+// 取有效的缓存，调用方需要持有锁
+func (c *RedisNearCache) getLocked(paramKey string) *nearCacheEntry {
+	if !c.subscribed {
+		return nil
+	}
+	e, ok := c.entries[paramKey]
+	if !ok {
+		return nil
+	}
+	if !e.expireAt.IsZero() && time.Now().After(e.expireAt) {
+		c.removeLocked(e)
+		c.evictions.Add(1)
+		return nil
+	}
+	c.lru.MoveToFront(e.elem)
+	return e
+}
+
+// 取或创建缓存，paramTTL 为新建缓存的有效时间，调用方需要持有锁
+func (c *RedisNearCache) ensureLocked(paramKey string, paramTTL time.Duration) *nearCacheEntry {
+	if e := c.getLocked(paramKey); e != nil {
+		return e
+	}
+	e := &nearCacheEntry{key: paramKey}
+	if paramTTL > 0 {
+		e.expireAt = time.Now().Add(paramTTL)
+	}
+	e.elem = c.lru.PushFront(e)
+	c.entries[paramKey] = e
+	for c.lru.Len() > c.capacity {
+		oldest := c.lru.Back().Value.(*nearCacheEntry)
+		c.removeLocked(oldest)
+		c.evictions.Add(1)
+	}
+	return e
+}
+
+func (c *RedisNearCache) currentEpoch() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.epoch
+}
+
+// 读取本地缓存的整个hash
+func (c *RedisNearCache) lookupAll(paramKey string) (map[string]string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e := c.getLocked(paramKey); e != nil && e.all != nil {
+		c.hits.Add(1)
+		return e.all, true
+	}
+	c.misses.Add(1)
+	return nil, false
+}
+
+func (c *RedisNearCache) storeAll(paramKey string, paramEpoch uint64, paramTTL time.Duration, paramAll map[string]string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.epoch != paramEpoch || !c.subscribed {
+		return
+	}
+	e := c.ensureLocked(paramKey, paramTTL)
+	e.all = paramAll
+	e.fields = nil
+}
+
+// 读取本地缓存的字段，第二个返回值表示是否命中
+func (c *RedisNearCache) lookupField(paramKey string, paramField string) (*string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e := c.getLocked(paramKey); e != nil {
+		if e.all != nil {
+			c.hits.Add(1)
+			if v, ok := e.all[paramField]; ok {
+				return &v, true
+			}
+			return nil, true
+		}
+		if v, ok := e.fields[paramField]; ok {
+			c.hits.Add(1)
+			return v, true
+		}
+	}
+	c.misses.Add(1)
+	return nil, false
+}
+
+func (c *RedisNearCache) storeField(paramKey string, paramEpoch uint64, paramTTL time.Duration, paramField string, paramValue *string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.epoch != paramEpoch || !c.subscribed {
+		return
+	}
+	e := c.ensureLocked(paramKey, paramTTL)
+	if e.all != nil {
+		return
+	}
+	if e.fields == nil {
+		e.fields = make(map[string]*string)
+	}
+	e.fields[paramField] = paramValue
+}
+
+/*
+创建一个带近端缓存的HSet操作工具类，方法与 RedisHSetUtils 相同
+
+  - paramHSetKey 集合的key
+  - paramExpire 超时时间，<=0 时表示没有超时， 单位秒
+  - paramAutoExpire 是否在更新后自动更新超时时间(命中本地缓存的读取不会更新超时时间)
+*/
+func (c *RedisNearCache) CreateHSetUtils(paramHSetKey string, paramExpire int32, paramAutoExpire bool) *RedisNearHSetUtils {
+	return &RedisNearHSetUtils{
+		HSetKey: paramHSetKey,
+		hset:    CreateHSetUtils(c.cli, paramHSetKey, paramExpire, paramAutoExpire),
+		cache:   c,
+	}
+}
+
+// 带近端缓存的Hash集合工具类，读取优先使用本地缓存，写入后广播失效通知
+type RedisNearHSetUtils struct {
+	HSetKey string
+	hset    *RedisHSetUtils
+	cache   *RedisNearCache
+}
+
+// 写入成功后使缓存失效
+func (m *RedisNearHSetUtils) afterWrite(ctx context.Context, paramErr error) {
+	if paramErr == nil {
+		m.cache.Invalidate(ctx, m.HSetKey)
+	}
+}
+
+// 设置某个字段
+func (m *RedisNearHSetUtils) Set(ctx context.Context, paramFieldName string, paramValue interface{}) *redis.IntCmd {
+	retCmd := m.hset.Set(ctx, paramFieldName, paramValue)
+	m.afterWrite(ctx, retCmd.Err())
+	return retCmd
+}
+
+// 设置多个字段
+func (m *RedisNearHSetUtils) MultSet(ctx context.Context, paramFieldValues ...interface{}) *redis.BoolCmd {
+	retCmd := m.hset.MultSet(ctx, paramFieldValues...)
+	m.afterWrite(ctx, retCmd.Err())
+	return retCmd
+}
+
+// 获取某个字段
+func (m *RedisNearHSetUtils) Get(ctx context.Context, paramFieldName string) *redis.StringCmd {
+	if v, ok := m.cache.lookupField(m.HSetKey, paramFieldName); ok {
+		if v == nil {
+			return redis.NewStringResult("", redis.Nil)
+		}
+		return redis.NewStringResult(*v, nil)
+	}
+	epoch := m.cache.currentEpoch()
+	retCmd := m.hset.Get(ctx, paramFieldName)
+	switch err := retCmd.Err(); err {
+	case nil:
+		v := retCmd.Val()
+		m.cache.storeField(m.HSetKey, epoch, m.localTTL(), paramFieldName, &v)
+	case redis.Nil:
+		m.cache.storeField(m.HSetKey, epoch, m.localTTL(), paramFieldName, nil)
+	}
+	return retCmd
+}
+
+/*
+本地缓存的有效时间
+
+自动更新超时时间时，从redis读取后key的超时时间为 expire 秒，本地缓存不能比key保留得更久，
+否则key过期后仍然读到本地的旧数据
+*/
+func (m *RedisNearHSetUtils) localTTL() time.Duration {
+	ttl := m.cache.ttl
+	if m.hset.auto_expire && m.hset.expire > 0 {
+		if d := time.Duration(m.hset.expire) * time.Second; ttl <= 0 || d < ttl {
+			ttl = d
+		}
+	}
+	return ttl
+}
+
+// 设置超时 -1表示设为不过期，修改后使所有实例的本地缓存失效，本地缓存不会保留到key过期之后
+func (m *RedisNearHSetUtils) ExpireSecond(ctx context.Context, paramSeconds int) {
+	m.hset.ExpireSecond(ctx, paramSeconds)
+	m.cache.Invalidate(ctx, m.HSetKey)
+}
+
+// 删除某个字段
+func (m *RedisNearHSetUtils) Del(ctx context.Context, paramFieldName string) *redis.IntCmd {
+	retCmd := m.hset.Del(ctx, paramFieldName)
+	m.afterWrite(ctx, retCmd.Err())
+	return retCmd
+}
+
+// 判断某个字段是否存在
+func (m *RedisNearHSetUtils) Exists(ctx context.Context, paramFieldName string) *redis.BoolCmd {
+	if v, ok := m.cache.lookupField(m.HSetKey, paramFieldName); ok {
+		return redis.NewBoolResult(v != nil, nil)
+	}
+	return m.hset.Exists(ctx, paramFieldName)
+}
+
+// 获取所有字段与值的映射，返回的map不能修改
+func (m *RedisNearHSetUtils) GetAll(ctx context.Context) *redis.StringStringMapCmd {
+	if all, ok := m.cache.lookupAll(m.HSetKey); ok {
+		return redis.NewStringStringMapResult(all, nil)
+	}
+	epoch := m.cache.currentEpoch()
+	retCmd := m.hset.GetAll(ctx)
+	if retCmd.Err() == nil {
+		m.cache.storeAll(m.HSetKey, epoch, m.localTTL(), retCmd.Val())
+	}
+	return retCmd
+}
+
+// 获取所有字段
+func (m *RedisNearHSetUtils) GetFields(ctx context.Context) *redis.StringSliceCmd {
+	all, err := m.GetAll(ctx).Result()
+	if err != nil {
+		return redis.NewStringSliceResult(nil, err)
+	}
+	fields := make([]string, 0, len(all))
+	for field := range all {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return redis.NewStringSliceResult(fields, nil)
+}
+
+// 获取所有值，顺序与 GetFields 一致
+func (m *RedisNearHSetUtils) GetValues(ctx context.Context) *redis.StringSliceCmd {
+	all, err := m.GetAll(ctx).Result()
+	if err != nil {
+		return redis.NewStringSliceResult(nil, err)
+	}
+	fields := make([]string, 0, len(all))
+	for field := range all {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	values := make([]string, 0, len(all))
+	for _, field := range fields {
+		values = append(values, all[field])
+	}
+	return redis.NewStringSliceResult(values, nil)
+}
+
+// 获取字段数量
+func (m *RedisNearHSetUtils) Count(ctx context.Context) *redis.IntCmd {
+	if all, ok := m.cache.lookupAll(m.HSetKey); ok {
+		return redis.NewIntResult(int64(len(all)), nil)
+	}
+	return m.hset.Count(ctx)
+}
+
+// 增减指定数值
+func (m *RedisNearHSetUtils) Incr(ctx context.Context, paramFieldName string, paramIncrement int64) *redis.IntCmd {
+	retCmd := m.hset.Incr(ctx, paramFieldName, paramIncrement)
+	m.afterWrite(ctx, retCmd.Err())
+	return retCmd
+}
+
+// 自减一
+func (m *RedisNearHSetUtils) Dec(ctx context.Context, paramFieldName string) *redis.IntCmd {
+	retCmd := m.hset.Dec(ctx, paramFieldName)
+	m.afterWrite(ctx, retCmd.Err())
+	return retCmd
+}
+
+// 自增一
+func (m *RedisNearHSetUtils) Inc(ctx context.Context, paramFieldName string) *redis.IntCmd {
+	retCmd := m.hset.Inc(ctx, paramFieldName)
+	m.afterWrite(ctx, retCmd.Err())
+	return retCmd
+}
@@ -0,0 +1,130 @@
+package redisv8
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	redis "github.com/go-redis/redis/v8"
+)
+
+// 等待条件成立，超时后测试失败
+func waitFor(t *testing.T, paramWhat string, paramCond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for !paramCond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", paramWhat)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func (c *RedisNearCache) isSubscribed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.subscribed
+}
+
+func TestNearCacheInvalidation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cli, _ := newTestClient(t)
+	local := CreateNearCache(cli, "near:invalidate", 10, time.Minute)
+	remote := CreateNearCache(cli, "near:invalidate", 10, time.Minute)
+	user := local.CreateHSetUtils("user:1", 0, false)
+	remoteUser := remote.CreateHSetUtils("user:1", 0, false)
+	if err := user.Set(ctx, "name", "tom").Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 订阅之前不使用本地缓存
+	user.GetAll(ctx)
+	user.GetAll(ctx)
+	if s := local.Stats(); s.Hits != 0 || s.Size != 0 {
+		t.Fatalf("stats before Run = %+v", s)
+	}
+
+	go local.Run(ctx)
+	waitFor(t, "subscribed", local.isSubscribed)
+	user.GetAll(ctx)
+	if v, _ := user.Get(ctx, "name").Result(); v != "tom" {
+		t.Fatalf("Get = %q", v)
+	}
+	if s := local.Stats(); s.Hits != 1 || s.Size != 1 {
+		t.Fatalf("stats after Run = %+v", s)
+	}
+
+	// 其他实例写入后本地缓存失效
+	if err := remoteUser.Set(ctx, "name", "jerry").Err(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "invalidation", func() bool { return local.Stats().Invalidations > 0 })
+	if v, _ := user.Get(ctx, "name").Result(); v != "jerry" {
+		t.Errorf("Get after invalidation = %q, want jerry", v)
+	}
+}
+
+func TestNearCacheBypassWhileResubscribing(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cli, mr := newTestClient(t)
+	local := CreateNearCache(cli, "near:invalidate", 10, time.Minute)
+	user := local.CreateHSetUtils("user:1", 0, false)
+	if err := user.Set(ctx, "name", "tom").Err(); err != nil {
+		t.Fatal(err)
+	}
+	go local.Run(ctx)
+	waitFor(t, "subscribed", local.isSubscribed)
+	user.Get(ctx, "name")
+
+	// 断开期间的写入没有失效通知，恢复订阅之前不能读到本地的旧数据
+	mr.Close()
+	waitFor(t, "unsubscribed", func() bool { return !local.isSubscribed() })
+	if s := local.Stats(); s.Size != 0 {
+		t.Fatalf("local cache not flushed: %+v", s)
+	}
+	if err := mr.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	mr.HSet("user:1", "name", "jerry")
+	if v, _ := user.Get(ctx, "name").Result(); v != "jerry" {
+		t.Errorf("Get while resubscribing = %q, want jerry", v)
+	}
+	waitFor(t, "resubscribed", local.isSubscribed)
+	if v, _ := user.Get(ctx, "name").Result(); v != "jerry" {
+		t.Errorf("Get after resubscribe = %q, want jerry", v)
+	}
+}
+
+func TestNearCacheExpire(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cli, _ := newTestClient(t)
+	local := CreateNearCache(cli, "near:invalidate", 10, time.Minute)
+	user := local.CreateHSetUtils("user:1", 0, false)
+	if err := user.Set(ctx, "name", "tom").Err(); err != nil {
+		t.Fatal(err)
+	}
+	go local.Run(ctx)
+	waitFor(t, "subscribed", local.isSubscribed)
+	// 订阅确认之后才标记为已订阅，此时发布的失效通知一定能收到
+	if n, _ := cli.Publish(ctx, "near:invalidate", "other").Result(); n != 1 {
+		t.Fatalf("subscribers = %d", n)
+	}
+	user.Get(ctx, "name")
+
+	// 修改超时时间后本地缓存失效，key 过期后不能读到本地的旧数据
+	user.ExpireSecond(ctx, 0)
+	if _, err := user.Get(ctx, "name").Result(); err != redis.Nil {
+		t.Fatalf("Get after expire err = %v", err)
+	}
+
+	// 本地缓存的时间不超过自动更新的key超时时间
+	if ttl := local.CreateHSetUtils("user:2", 1, true).localTTL(); ttl != time.Second {
+		t.Fatalf("local ttl = %v", ttl)
+	}
+	if ttl := local.CreateHSetUtils("user:3", 120, true).localTTL(); ttl != time.Minute {
+		t.Fatalf("local ttl = %v", ttl)
+	}
+}
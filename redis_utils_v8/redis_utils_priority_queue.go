@@ -0,0 +1,295 @@
+package redisv8
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	redis "github.com/go-redis/redis/v8"
+	"github.com/qiuliaogit/commonutils/commonutils"
+)
+
+var ErrQueuePriority = commonutils.NewError(commonutils.ERR_FAIL, "队列的优先级超出范围")
+
+/*
+基于redis的优先级队列工具类
+
+每个优先级一个列表，key 为 队列key:优先级，优先级越大越先弹出，同一优先级内先进先出。
+压入、弹出和超过最大长度时的淘汰都在 Lua 脚本中完成，集群模式下队列key需要带 hash tag，如 {notify}
+*/
+type RedisPriorityQueueUtils struct {
+	key         string
+	cli         *redis.Client
+	pipe        redis.Pipeliner // 批量执行时命令写入的管道，nil 表示直接执行
+	expire      int32           // 超时时间单位秒
+	auto_expire bool            // 是否自动更新超期时间  否则手动更新，默认自动更新
+	max_size    int64           // 所有优先级合计的最大长度 0表示不限制
+	levels      int             // 优先级的数量，优先级为 0 ~ levels-1
+}
+
+/*
+创建一个优先级队列操作工具类
+
+  - paramCli
+  - paramQueueKey 队列的key
+  - paramLevels 优先级的数量，优先级为 0 ~ paramLevels-1，越大越优先
+  - paramExpire 超时时间，<=0 时表示没有超时， 单位秒
+  - paramAutoExpire 是否在更新后自动更新超时时间
+  - paramMaxSize 队列最大长度 0表示不限制，超过时先淘汰优先级最低的最早的元素
+*/
+func CreatePriorityQueueUtils(paramCli *redis.Client, paramQueueKey string, paramLevels int, paramExpire int32, paramAutoExpire bool, paramMaxSize int64) *RedisPriorityQueueUtils {
+	if paramLevels < 1 {
+		paramLevels = 1
+	}
+	return &RedisPriorityQueueUtils{
+		key:         paramQueueKey,
+		cli:         paramCli,
+		expire:      paramExpire,
+		auto_expire: paramAutoExpire,
+		max_size:    paramMaxSize,
+		levels:      paramLevels,
+	}
+}
+
+// 设置字段更新超时标志
+func (m *RedisPriorityQueueUtils) SetAutoExpire(paramValue bool) {
+	m.auto_expire = paramValue
+}
+
+// 优先级的数量
+func (m *RedisPriorityQueueUtils) Levels() int {
+	return m.levels
+}
+
+// 某个优先级的列表key
+func (m *RedisPriorityQueueUtils) levelKey(paramPriority int) string {
+	return m.key + ":" + strconv.Itoa(paramPriority)
+}
+
+// 所有优先级的列表key，从低到高
+func (m *RedisPriorityQueueUtils) levelKeys() []string {
+	keys := make([]string, m.levels)
+	for i := range keys {
+		keys[i] = m.levelKey(i)
+	}
+	return keys
+}
+
+// 从高到低的列表key，用于 BLPOP
+func (m *RedisPriorityQueueUtils) levelKeysDesc() []string {
+	keys := make([]string, m.levels)
+	for i := range keys {
+		keys[i] = m.levelKey(m.levels - 1 - i)
+	}
+	return keys
+}
+
+// 根据列表key取优先级
+func (m *RedisPriorityQueueUtils) keyPriority(paramKey string) int {
+	p, _ := strconv.Atoi(paramKey[len(m.key)+1:])
+	return p
+}
+
+// 自动更新超时时传给脚本的超时秒数，0 表示不更新
+func (m *RedisPriorityQueueUtils) scriptExpire() int32 {
+	if m.auto_expire && m.expire > 0 {
+		return m.expire
+	}
+	return 0
+}
+
+// 设置超时 -1表示设为不过期
+func (m *RedisPriorityQueueUtils) ExpireSecond(ctx context.Context, paramSeconds int) {
+	for _, key := range m.levelKeys() {
+		if paramSeconds < 0 {
+			m.cmd().Persist(ctx, key)
+		} else {
+			m.cmd().Expire(ctx, key, time.Duration(paramSeconds)*time.Second)
+		}
+	}
+}
+
+// KEYS: 各优先级的列表，从低到高
+// ARGV: 压入的优先级(从1开始), 最大长度, 超时秒数, 元素...
+// 元素分批 RPUSH，避免 unpack 超过 Lua 栈的限制
+var priorityQueuePushScript = newIntScript(`
+local key = KEYS[tonumber(ARGV[1])]
+for i = 4, #ARGV, 1000 do
+	redis.call('RPUSH', key, unpack(ARGV, i, math.min(i + 999, #ARGV)))
+end
+local evicted = 0
+local max = tonumber(ARGV[2])
+if max > 0 then
+	local total = 0
+	for i = 1, #KEYS do
+		total = total + redis.call('LLEN', KEYS[i])
+	end
+	for i = 1, #KEYS do
+		if total <= max then
+			break
+		end
+		local drop = math.min(redis.call('LLEN', KEYS[i]), total - max)
+		if drop > 0 then
+			redis.call('LTRIM', KEYS[i], drop, -1)
+			total = total - drop
+			evicted = evicted + drop
+		end
+	end
+end
+if tonumber(ARGV[3]) > 0 then
+	for i = 1, #KEYS do
+		redis.call('EXPIRE', KEYS[i], ARGV[3])
+	end
+end
+return evicted
+`)
+
+/*
+按优先级压入队列
+
+  - paramPriority 优先级 0 ~ Levels()-1
+  - paramValue 元素
+
+返回超过最大长度被淘汰的元素数量，批量模式下 Exec 之后可用
+*/
+func (m *RedisPriorityQueueUtils) Push(ctx context.Context, paramPriority int, paramValue ...interface{}) *redis.IntCmd {
+	if paramPriority < 0 || paramPriority >= m.levels {
+		return redis.NewIntResult(0, ErrQueuePriority)
+	}
+	if len(paramValue) == 0 {
+		return redis.NewIntResult(0, nil)
+	}
+	args := append([]interface{}{paramPriority + 1, m.max_size, m.scriptExpire()}, paramValue...)
+	return evalIntScript(ctx, m.cmd(), priorityQueuePushScript, m.levelKeys(), args...)
+}
+
+// KEYS: 各优先级的列表，从高到低
+// ARGV: 是否弹出 1弹出 0只查看, 超时秒数
+var priorityQueuePopScript = redis.NewScript(`
+for i = 1, #KEYS do
+	local v
+	if ARGV[1] == '1' then
+		v = redis.call('LPOP', KEYS[i])
+	else
+		v = redis.call('LINDEX', KEYS[i], 0)
+	end
+	if v then
+		if ARGV[1] == '1' and tonumber(ARGV[2]) > 0 then
+			redis.call('EXPIRE', KEYS[i], ARGV[2])
+		end
+		return {v, #KEYS - i}
+	end
+end
+return false
+`)
+
+// 弹出或查看需要立即取得结果，批量模式下返回 ErrBatchUnsupported
+func (m *RedisPriorityQueueUtils) pop(ctx context.Context, paramRemove string) (string, int, error) {
+	if m.pipe != nil {
+		return "", 0, ErrBatchUnsupported
+	}
+	ret, err := priorityQueuePopScript.Run(ctx, m.cli, m.levelKeysDesc(), paramRemove, m.scriptExpire()).Slice()
+	if err != nil {
+		return "", 0, err
+	}
+	value, _ := ret[0].(string)
+	priority, _ := ret[1].(int64)
+	return value, int(priority), nil
+}
+
+// 弹出优先级最高的最早的元素，返回元素和它的优先级，队列为空时返回 redis.Nil
+func (m *RedisPriorityQueueUtils) Pop(ctx context.Context) (string, int, error) {
+	return m.pop(ctx, "1")
+}
+
+// 查看下一个会弹出的元素，不弹出，队列为空时返回 redis.Nil
+func (m *RedisPriorityQueueUtils) Peek(ctx context.Context) (string, int, error) {
+	return m.pop(ctx, "0")
+}
+
+/*
+阻塞弹出优先级最高的元素，队列为空时等待，批量模式下返回 ErrBatchUnsupported
+
+  - paramTimeout 最长等待时间，<=0 表示等待到 ctx 的截止时间，此时 ctx 必须有截止时间，否则返回 ErrBlockingNoDeadline
+
+等待期间取消 ctx 不会使命令提前返回，超时返回 redis.Nil。
+和 Pop 不同，自动更新超时时间是弹出之后单独的一次 EXPIRE，不是原子的，只更新弹出的优先级的列表；
+EXPIRE 失败时元素已经弹出，仍然返回元素和优先级，同时返回该错误
+*/
+func (m *RedisPriorityQueueUtils) BPop(ctx context.Context, paramTimeout time.Duration) (string, int, error) {
+	if m.pipe != nil {
+		return "", 0, ErrBatchUnsupported
+	}
+	timeout, err := blockingTimeout(ctx, paramTimeout)
+	if err != nil {
+		return "", 0, err
+	}
+	ret, err := m.cli.BLPop(ctx, timeout, m.levelKeysDesc()...).Result()
+	if err != nil {
+		return "", 0, err
+	}
+	if m.auto_expire && m.expire > 0 {
+		err = m.cli.Expire(ctx, ret[0], time.Duration(m.expire)*time.Second).Err()
+	}
+	return ret[1], m.keyPriority(ret[0]), err
+}
+
+// 查看某个优先级最早的若干个元素，不弹出
+func (m *RedisPriorityQueueUtils) PeekByPriority(ctx context.Context, paramPriority int, paramCount int64) *redis.StringSliceCmd {
+	return m.cmd().LRange(ctx, m.levelKey(paramPriority), 0, paramCount-1)
+}
+
+// 某个优先级的元素数量
+func (m *RedisPriorityQueueUtils) CountByPriority(ctx context.Context, paramPriority int) *redis.IntCmd {
+	return m.cmd().LLen(ctx, m.levelKey(paramPriority))
+}
+
+// 各优先级的元素数量，下标为优先级，批量模式下返回 ErrBatchUnsupported，需要时用 CountByPriority
+func (m *RedisPriorityQueueUtils) Counts(ctx context.Context) ([]int64, error) {
+	if m.pipe != nil {
+		return nil, ErrBatchUnsupported
+	}
+	cmds := make([]*redis.IntCmd, m.levels)
+	_, err := m.cli.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i := range cmds {
+			cmds[i] = pipe.LLen(ctx, m.levelKey(i))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]int64, m.levels)
+	for i, c := range cmds {
+		ret[i] = c.Val()
+	}
+	return ret, nil
+}
+
+// 队列的总数量，批量模式下返回 ErrBatchUnsupported
+func (m *RedisPriorityQueueUtils) Count(ctx context.Context) (int64, error) {
+	counts, err := m.Counts(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, c := range counts {
+		total += c
+	}
+	return total, nil
+}
+
+// 取执行命令的客户端，批量模式下为管道
+func (m *RedisPriorityQueueUtils) cmd() redis.Cmdable {
+	if m.pipe != nil {
+		return m.pipe
+	}
+	return m.cli
+}
+
+// 返回绑定到批量操作的副本，副本的命令会写入批量操作，在 RedisBatch.Exec 时一起执行
+func (m *RedisPriorityQueueUtils) Batch(paramBatch *RedisBatch) *RedisPriorityQueueUtils {
+	c := *m
+	c.pipe = paramBatch.pipe
+	return &c
+}
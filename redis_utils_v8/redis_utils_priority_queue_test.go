@@ -0,0 +1,145 @@
+package redisv8
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	redis "github.com/go-redis/redis/v8"
+)
+
+func TestPriorityQueueOrder(t *testing.T) {
+	cli, mr := newTestClient(t)
+	ctx := context.Background()
+	q := CreatePriorityQueueUtils(cli, "{notify}", 3, 60, true, 0)
+
+	q.Push(ctx, 0, "low1", "low2")
+	q.Push(ctx, 2, "urgent")
+	q.Push(ctx, 1, "normal")
+	if mr.TTL("{notify}:2") != 60*time.Second {
+		t.Fatal("expire not set")
+	}
+	if _, err := q.Push(ctx, 3, "x").Result(); err != ErrQueuePriority {
+		t.Fatalf("priority err = %v", err)
+	}
+
+	if v, p, err := q.Peek(ctx); err != nil || v != "urgent" || p != 2 {
+		t.Fatalf("Peek = %q, %d, %v", v, p, err)
+	}
+	want := []struct {
+		value    string
+		priority int
+	}{{"urgent", 2}, {"normal", 1}, {"low1", 0}, {"low2", 0}}
+	for _, w := range want {
+		v, p, err := q.Pop(ctx)
+		if err != nil || v != w.value || p != w.priority {
+			t.Fatalf("Pop = %q, %d, %v, want %q, %d", v, p, err, w.value, w.priority)
+		}
+	}
+	if _, _, err := q.Pop(ctx); err != redis.Nil {
+		t.Fatalf("empty Pop err = %v", err)
+	}
+	if _, _, err := q.Peek(ctx); err != redis.Nil {
+		t.Fatalf("empty Peek err = %v", err)
+	}
+}
+
+func TestPriorityQueueEviction(t *testing.T) {
+	cli, _ := newTestClient(t)
+	ctx := context.Background()
+	q := CreatePriorityQueueUtils(cli, "{notify}", 3, 0, false, 4)
+
+	q.Push(ctx, 1, "n1", "n2")
+	q.Push(ctx, 0, "l1")
+	q.Push(ctx, 2, "u1")
+	// 超过最大长度时先淘汰优先级最低的最早的元素，不够时再淘汰更高的优先级
+	if n, err := q.Push(ctx, 2, "u2", "u3").Result(); err != nil || n != 2 {
+		t.Fatalf("evicted = %d, %v", n, err)
+	}
+	counts, err := q.Counts(ctx)
+	if err != nil || counts[0] != 0 || counts[1] != 1 || counts[2] != 3 {
+		t.Fatalf("counts = %v, %v", counts, err)
+	}
+	if list, _ := q.PeekByPriority(ctx, 1, 10).Result(); len(list) != 1 || list[0] != "n2" {
+		t.Fatalf("priority 1 = %v", list)
+	}
+	if n, _ := q.Count(ctx); n != 4 {
+		t.Fatalf("count = %d", n)
+	}
+}
+
+func TestPriorityQueueBatchAndLargePush(t *testing.T) {
+	cli, _ := newTestClient(t)
+	ctx := context.Background()
+	q := CreatePriorityQueueUtils(cli, "{notify}", 2, 0, false, 0)
+
+	batch := CreateBatch(cli)
+	pushCmd := q.Batch(batch).Push(ctx, 1, "a")
+	countCmd := q.Batch(batch).CountByPriority(ctx, 1)
+	if _, err := batch.Exec(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := pushCmd.Result(); err != nil || n != 0 {
+		t.Fatalf("batch push = %d, %v", n, err)
+	}
+	if n := countCmd.Val(); n != 1 {
+		t.Fatalf("batch count = %d", n)
+	}
+	// 需要立即取得结果的方法不能在批量模式下使用
+	pending := CreateBatch(cli)
+	if _, _, err := q.Batch(pending).Pop(ctx); err != ErrBatchUnsupported {
+		t.Fatalf("batch Pop err = %v", err)
+	}
+	if _, _, err := q.Batch(pending).Peek(ctx); err != ErrBatchUnsupported {
+		t.Fatalf("batch Peek err = %v", err)
+	}
+	if _, _, err := q.Batch(pending).BPop(ctx, time.Second); err != ErrBatchUnsupported {
+		t.Fatalf("batch BPop err = %v", err)
+	}
+	if _, err := q.Batch(pending).Counts(ctx); err != ErrBatchUnsupported {
+		t.Fatalf("batch Counts err = %v", err)
+	}
+	if n, _ := q.CountByPriority(ctx, 1).Result(); n != 1 {
+		t.Fatalf("popped outside the batch, count = %d", n)
+	}
+
+	// 一次压入大量元素时分批 RPUSH
+	values := make([]interface{}, 10000)
+	for i := range values {
+		values[i] = strconv.Itoa(i)
+	}
+	if err := q.Push(ctx, 0, values...).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := q.CountByPriority(ctx, 0).Result(); n != 10000 {
+		t.Fatalf("count = %d", n)
+	}
+	if list, _ := q.PeekByPriority(ctx, 0, 1).Result(); list[0] != "0" {
+		t.Fatalf("first = %v", list)
+	}
+}
+
+func TestPriorityQueueBPop(t *testing.T) {
+	cli, _ := newTestClient(t)
+	ctx := context.Background()
+	q := CreatePriorityQueueUtils(cli, "{notify}", 3, 0, false, 0)
+
+	if _, _, err := q.BPop(ctx, 50*time.Millisecond); err != redis.Nil {
+		t.Fatalf("timeout err = %v", err)
+	}
+	q.Push(ctx, 0, "low")
+	q.Push(ctx, 1, "normal")
+	if v, p, err := q.BPop(ctx, time.Second); err != nil || v != "normal" || p != 1 {
+		t.Fatalf("BPop = %q, %d, %v", v, p, err)
+	}
+	// 等待时间为0时 ctx 必须有截止时间
+	if _, _, err := q.BPop(ctx, 0); err != ErrBlockingNoDeadline {
+		t.Fatalf("no deadline err = %v", err)
+	}
+	deadlineCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	if v, p, err := q.BPop(deadlineCtx, 0); err != nil || v != "low" || p != 0 {
+		t.Fatalf("BPop with deadline = %q, %d, %v", v, p, err)
+	}
+}
@@ -0,0 +1,289 @@
+package redisv8
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"sync"
+	"time"
+
+	redis "github.com/go-redis/redis/v8"
+	"github.com/qiuliaogit/commonutils/commonutils"
+)
+
+// 订阅消息的处理函数
+type PubSubHandler func(ctx context.Context, paramMsg *redis.Message) error
+
+/*
+把JSON格式的消息解码为 T 后再交给处理函数
+
+	sub.Subscribe(ctx, "cache:invalidate", redisv8.JSONHandler(func(ctx context.Context, channel string, v InvalidateEvent) error {
+		...
+	}))
+*/
+func JSONHandler[T any](paramFn func(ctx context.Context, paramChannel string, paramValue T) error) PubSubHandler {
+	return func(ctx context.Context, paramMsg *redis.Message) error {
+		var v T
+		if err := json.Unmarshal([]byte(paramMsg.Payload), &v); err != nil {
+			return commonutils.NewError(commonutils.ERR_FAIL, "解析订阅消息失败："+paramMsg.Channel+" err:"+err.Error())
+		}
+		return paramFn(ctx, paramMsg.Channel, v)
+	}
+}
+
+// 基于redis的消息发布工具类
+type RedisPublisher struct {
+	cli *redis.Client
+}
+
+// 创建一个消息发布工具类
+func CreatePublisher(paramCli *redis.Client) *RedisPublisher {
+	return &RedisPublisher{cli: paramCli}
+}
+
+// 发布原始消息，返回收到消息的订阅者数量
+func (p *RedisPublisher) Publish(ctx context.Context, paramChannel string, paramMessage interface{}) *redis.IntCmd {
+	return p.cli.Publish(ctx, paramChannel, paramMessage)
+}
+
+// 把 paramValue 编码为JSON后发布，配合 JSONHandler 使用
+func (p *RedisPublisher) PublishJSON(ctx context.Context, paramChannel string, paramValue interface{}) (int64, error) {
+	data, err := json.Marshal(paramValue)
+	if err != nil {
+		return 0, err
+	}
+	return p.cli.Publish(ctx, paramChannel, string(data)).Result()
+}
+
+/*
+基于redis的消息订阅工具类
+
+  - 支持频道和模式订阅，每个订阅对应一个处理函数
+  - 连接断开后自动重连并重新订阅所有频道
+  - 在调用 Run 的协程中接收和处理消息，另有一个监视协程在 ctx 取消时关闭连接，Run 返回时监视协程同时退出，不会遗留协程
+*/
+type RedisSubscriber struct {
+	cli          *redis.Client
+	mu           sync.Mutex
+	channels     map[string]PubSubHandler
+	patterns     map[string]PubSubHandler
+	pubsub       *redis.PubSub // Run 运行期间有效
+	errorHandler func(error)
+	connHandler  func()
+	pingInterval time.Duration
+}
+
+// 创建一个消息订阅工具类
+func CreateSubscriber(paramCli *redis.Client) *RedisSubscriber {
+	return &RedisSubscriber{
+		cli:          paramCli,
+		channels:     make(map[string]PubSubHandler),
+		patterns:     make(map[string]PubSubHandler),
+		pingInterval: 30 * time.Second,
+	}
+}
+
+// 设置错误处理函数，处理函数返回的错误和连接错误都会交给它，默认忽略
+func (s *RedisSubscriber) SetErrorHandler(paramFn func(error)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.errorHandler = paramFn
+}
+
+// 设置连接处理函数，收到redis的订阅确认以及连接出错后重新订阅成功时调用，没有任何订阅时在 Run 开始时调用
+func (s *RedisSubscriber) SetConnectHandler(paramFn func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.connHandler = paramFn
+}
+
+// 设置检查连接的间隔，没有收到消息超过该时间时发送PING
+func (s *RedisSubscriber) SetPingInterval(paramInterval time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if paramInterval > 0 {
+		s.pingInterval = paramInterval
+	}
+}
+
+// 订阅频道，可以在 Run 之前或运行期间调用
+func (s *RedisSubscriber) Subscribe(ctx context.Context, paramChannel string, paramHandler PubSubHandler) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.channels[paramChannel] = paramHandler
+	if s.pubsub != nil {
+		return s.pubsub.Subscribe(ctx, paramChannel)
+	}
+	return nil
+}
+
+// 按模式订阅频道，如 "cache:*"
+func (s *RedisSubscriber) PSubscribe(ctx context.Context, paramPattern string, paramHandler PubSubHandler) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.patterns[paramPattern] = paramHandler
+	if s.pubsub != nil {
+		return s.pubsub.PSubscribe(ctx, paramPattern)
+	}
+	return nil
+}
+
+// 取消订阅频道
+func (s *RedisSubscriber) Unsubscribe(ctx context.Context, paramChannel string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.channels, paramChannel)
+	if s.pubsub != nil {
+		return s.pubsub.Unsubscribe(ctx, paramChannel)
+	}
+	return nil
+}
+
+// 取消模式订阅
+func (s *RedisSubscriber) PUnsubscribe(ctx context.Context, paramPattern string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.patterns, paramPattern)
+	if s.pubsub != nil {
+		return s.pubsub.PUnsubscribe(ctx, paramPattern)
+	}
+	return nil
+}
+
+func (s *RedisSubscriber) reportError(paramErr error) {
+	s.mu.Lock()
+	fn := s.errorHandler
+	s.mu.Unlock()
+	if fn != nil && paramErr != nil {
+		fn(paramErr)
+	}
+}
+
+func (s *RedisSubscriber) reportConnect() {
+	s.mu.Lock()
+	fn := s.connHandler
+	s.mu.Unlock()
+	if fn != nil {
+		fn()
+	}
+}
+
+func (s *RedisSubscriber) handler(paramMsg *redis.Message) PubSubHandler {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if paramMsg.Pattern != "" {
+		return s.patterns[paramMsg.Pattern]
+	}
+	return s.channels[paramMsg.Channel]
+}
+
+// 建立订阅连接，第三个返回值表示是否发送了订阅命令，需要等待redis的确认
+func (s *RedisSubscriber) open(ctx context.Context) (*redis.PubSub, time.Duration, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pubsub != nil {
+		return nil, 0, false, commonutils.NewError(commonutils.ERR_FAIL, "订阅已经在运行中")
+	}
+
+	pubsub := s.cli.Subscribe(ctx)
+	channels := make([]string, 0, len(s.channels))
+	for channel := range s.channels {
+		channels = append(channels, channel)
+	}
+	patterns := make([]string, 0, len(s.patterns))
+	for pattern := range s.patterns {
+		patterns = append(patterns, pattern)
+	}
+	if len(channels) > 0 {
+		if err := pubsub.Subscribe(ctx, channels...); err != nil {
+			pubsub.Close()
+			return nil, 0, false, err
+		}
+	}
+	if len(patterns) > 0 {
+		if err := pubsub.PSubscribe(ctx, patterns...); err != nil {
+			pubsub.Close()
+			return nil, 0, false, err
+		}
+	}
+	s.pubsub = pubsub
+	return pubsub, s.pingInterval, len(channels)+len(patterns) > 0, nil
+}
+
+func (s *RedisSubscriber) close(paramPubSub *redis.PubSub) {
+	s.mu.Lock()
+	if s.pubsub == paramPubSub {
+		s.pubsub = nil
+	}
+	s.mu.Unlock()
+	paramPubSub.Close()
+}
+
+/*
+接收并分发消息，阻塞直到 ctx 被取消，返回 ctx.Err()
+
+消息在当前协程中按顺序处理，处理函数不应长时间阻塞。
+运行期间会启动一个监视协程，ctx 取消时关闭连接使阻塞中的接收立即返回，Run 返回前该协程退出
+*/
+func (s *RedisSubscriber) Run(ctx context.Context) error {
+	pubsub, pingInterval, pending, err := s.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer s.close(pubsub)
+
+	// ctx 取消时关闭连接，使阻塞中的接收立即返回
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			pubsub.Close()
+		case <-stop:
+		}
+	}()
+	// 订阅命令只是发送出去，收到确认之前可能丢失消息，等第一次收到回复时再通知连接成功
+	if !pending {
+		s.reportConnect()
+	}
+
+	backoff := 100 * time.Millisecond
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		msg, err := pubsub.ReceiveTimeout(ctx, pingInterval)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				// 长时间没有消息，发送PING检查连接，失败时下一次接收会自动重连
+				pubsub.Ping(ctx)
+				continue
+			}
+			// 连接断开，等待后重试，go-redis 会在重连后重新订阅所有频道
+			pending = true
+			s.reportError(err)
+			if !sleepContext(ctx, backoff) {
+				return ctx.Err()
+			}
+			if backoff < 5*time.Second {
+				backoff *= 2
+			}
+			continue
+		}
+		backoff = 100 * time.Millisecond
+		if pending {
+			// 第一次收到的是订阅确认，重连时已经重新订阅，出错后第一次收到消息说明订阅已恢复
+			pending = false
+			s.reportConnect()
+		}
+
+		if m, ok := msg.(*redis.Message); ok {
+			if h := s.handler(m); h != nil {
+				s.reportError(h(ctx, m))
+			}
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package redisv8
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	redis "github.com/go-redis/redis/v8"
+)
+
+type testEvent struct {
+	ID int `json:"id"`
+}
+
+func TestPubSubDispatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cli, _ := newTestClient(t)
+	pub := CreatePublisher(cli)
+	sub := CreateSubscriber(cli)
+
+	var mu sync.Mutex
+	var got []string
+	record := func(paramWhat string) {
+		mu.Lock()
+		defer mu.Unlock()
+		got = append(got, paramWhat)
+	}
+	var connects, errs int32
+	sub.SetConnectHandler(func() { atomic.AddInt32(&connects, 1) })
+	sub.SetErrorHandler(func(error) { atomic.AddInt32(&errs, 1) })
+	sub.Subscribe(ctx, "plain", func(ctx context.Context, paramMsg *redis.Message) error {
+		record("plain:" + paramMsg.Payload)
+		return nil
+	})
+	sub.Subscribe(ctx, "json", JSONHandler(func(ctx context.Context, paramChannel string, paramValue testEvent) error {
+		if paramValue.ID == 0 {
+			return errors.New("empty id")
+		}
+		record("json")
+		return nil
+	}))
+	sub.PSubscribe(ctx, "cache:*", func(ctx context.Context, paramMsg *redis.Message) error {
+		record("pattern:" + paramMsg.Channel)
+		return nil
+	})
+	go sub.Run(ctx)
+	waitFor(t, "connected", func() bool { return atomic.LoadInt32(&connects) == 1 })
+
+	if n, err := pub.Publish(ctx, "plain", "a").Result(); err != nil || n != 1 {
+		t.Fatalf("Publish = %d, %v", n, err)
+	}
+	pub.PublishJSON(ctx, "json", testEvent{ID: 1})
+	pub.PublishJSON(ctx, "json", testEvent{})
+	pub.Publish(ctx, "json", "not json")
+	pub.Publish(ctx, "cache:user", "x")
+	waitFor(t, "messages", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(got) == 3
+	})
+	waitFor(t, "handler errors", func() bool { return atomic.LoadInt32(&errs) == 2 })
+	mu.Lock()
+	if got[0] != "plain:a" || got[1] != "json" || got[2] != "pattern:cache:user" {
+		t.Errorf("got = %v", got)
+	}
+	mu.Unlock()
+
+	// 运行期间订阅和取消订阅
+	sub.Subscribe(ctx, "late", func(ctx context.Context, paramMsg *redis.Message) error {
+		record("late")
+		return nil
+	})
+	sub.Unsubscribe(ctx, "plain")
+	waitFor(t, "late subscription", func() bool {
+		n, _ := pub.Publish(ctx, "late", "").Result()
+		return n == 1
+	})
+	if n, _ := pub.Publish(ctx, "plain", "b").Result(); n != 0 {
+		t.Errorf("plain receivers = %d", n)
+	}
+}
+
+func TestPubSubResubscribe(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cli, mr := newTestClient(t)
+	pub := CreatePublisher(cli)
+	sub := CreateSubscriber(cli)
+
+	var connects, errs, received int32
+	sub.SetConnectHandler(func() { atomic.AddInt32(&connects, 1) })
+	sub.SetErrorHandler(func(error) { atomic.AddInt32(&errs, 1) })
+	sub.Subscribe(ctx, "events", func(ctx context.Context, paramMsg *redis.Message) error {
+		atomic.AddInt32(&received, 1)
+		return nil
+	})
+	go sub.Run(ctx)
+	waitFor(t, "connected", func() bool { return atomic.LoadInt32(&connects) == 1 })
+
+	// 连接断开后报告错误，恢复后重新订阅并再次调用连接处理函数
+	mr.Close()
+	waitFor(t, "connection error", func() bool { return atomic.LoadInt32(&errs) > 0 })
+	if err := mr.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "resubscribed", func() bool {
+		pub.Publish(ctx, "events", "x")
+		return atomic.LoadInt32(&received) > 0
+	})
+	waitFor(t, "reconnected", func() bool { return atomic.LoadInt32(&connects) == 2 })
+}
+
+func TestPubSubRunStopsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cli, _ := newTestClient(t)
+	sub := CreateSubscriber(cli)
+	sub.SetPingInterval(time.Hour)
+	sub.Subscribe(ctx, "events", func(ctx context.Context, paramMsg *redis.Message) error { return nil })
+
+	var connected int32
+	sub.SetConnectHandler(func() { atomic.StoreInt32(&connected, 1) })
+	done := make(chan error, 1)
+	go func() { done <- sub.Run(ctx) }()
+	waitFor(t, "connected", func() bool { return atomic.LoadInt32(&connected) == 1 })
+	if err := sub.Run(ctx); err == nil {
+		t.Fatal("second Run should fail while running")
+	}
+
+	// 阻塞在接收中的 Run 在 ctx 取消后立即返回
+	cancel()
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Fatalf("Run err = %v", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("Run did not return after cancel")
+	}
+	// 停止后可以重新运行
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	defer cancel2()
+	atomic.StoreInt32(&connected, 0)
+	go sub.Run(ctx2)
+	waitFor(t, "connected again", func() bool { return atomic.LoadInt32(&connected) == 1 })
+}
@@ -2,18 +2,40 @@ package redisv8
 
 import (
 	"context"
+	"strings"
 	"time"
 
 	redis "github.com/go-redis/redis/v8"
+	"github.com/qiuliaogit/commonutils/commonutils"
 )
 
+var ErrQueueFull = commonutils.NewError(commonutils.ERR_FAIL, "队列已满")
+
+// 队列超过最大长度时的处理策略
+type QueueOverflowPolicy int
+
+const (
+	QUEUE_OVERFLOW_DROP_OLDEST QueueOverflowPolicy = 0 // 淘汰最早的元素，默认
+	QUEUE_OVERFLOW_REJECT_NEW  QueueOverflowPolicy = 1 // 拒绝新的元素
+)
+
+// 判断 Push 的错误是否为队列已满，批量模式下错误为redis返回的脚本错误
+func IsQueueFull(paramErr error) bool {
+	if paramErr == nil {
+		return false
+	}
+	return paramErr == ErrQueueFull || strings.Contains(paramErr.Error(), "QUEUE_FULL")
+}
+
 // 基于redis的队列工具类
 type RedisQueueUtils struct {
 	key         string
 	cli         *redis.Client
-	expire      int32 // 超时时间单位秒
-	auto_expire bool  // 是否自动更新超期时间  否则手动更新，默认自动更新
-	max_size    int64 // 队列最大长度 0表示不限制
+	pipe        redis.Pipeliner // 批量执行时命令写入的管道，nil 表示直接执行
+	expire      int32           // 超时时间单位秒
+	auto_expire bool            // 是否自动更新超期时间  否则手动更新，默认自动更新
+	max_size    int64           // 队列最大长度 0表示不限制
+	overflow    QueueOverflowPolicy
 }
 
 /*
@@ -53,6 +75,11 @@ func CreateQueueUtilsMax(paramCli *redis.Client, paramQueueKey string, paramExpi
 	}
 }
 
+// 设置超过最大长度时的处理策略，默认淘汰最早的元素
+func (m *RedisQueueUtils) SetOverflowPolicy(paramPolicy QueueOverflowPolicy) {
+	m.overflow = paramPolicy
+}
+
 // 设置字段更新超时标志
 func (m *RedisQueueUtils) SetAutoExpire(paramValue bool) {
 	m.auto_expire = paramValue
@@ -61,15 +88,15 @@ func (m *RedisQueueUtils) SetAutoExpire(paramValue bool) {
 // 设置超时 -1表示设为不过期
 func (m *RedisQueueUtils) ExpireSecond(ctx context.Context, paramSeconds int) {
 	if paramSeconds < 0 {
-		m.cli.Persist(ctx, m.key)
+		m.cmd().Persist(ctx, m.key)
 	} else {
-		m.cli.Expire(ctx, m.key, time.Duration(paramSeconds)*time.Second)
+		m.cmd().Expire(ctx, m.key, time.Duration(paramSeconds)*time.Second)
 	}
 }
 
 // 取队列的数量
 func (m *RedisQueueUtils) Count(ctx context.Context) *redis.IntCmd {
-	retCmd := m.cli.LLen(ctx, m.key)
+	retCmd := m.cmd().LLen(ctx, m.key)
 	m.afterExpire(ctx, retCmd.Err())
 	return retCmd
 }
@@ -77,35 +104,136 @@ func (m *RedisQueueUtils) Count(ctx context.Context) *redis.IntCmd {
 // 更新超时时间
 func (m *RedisQueueUtils) afterExpire(ctx context.Context, paramErr error) {
 	if paramErr == nil && m.auto_expire && m.expire > 0 {
-		m.cli.Expire(ctx, m.key, time.Duration(m.expire)*time.Second)
+		m.cmd().Expire(ctx, m.key, time.Duration(m.expire)*time.Second)
 	}
 }
 
-// 压入队列
+// KEYS: 队列
+// ARGV: 是否返回详细结果, 最大长度, 超出时的策略(0淘汰最早的 1拒绝新的), 超时秒数, 元素...
+// 返回压入后的长度，详细结果为 {长度, 淘汰的数量, 是否被拒绝}
+// 元素分批 RPUSH，避免 unpack 超过 Lua 栈的限制
+var queuePushScript = newIntScript(`
+local n = #ARGV - 4
+local max = tonumber(ARGV[2])
+if max > 0 and ARGV[3] == '1' then
+	local len = redis.call('LLEN', KEYS[1])
+	if len + n > max then
+		if ARGV[1] == '1' then
+			return {len, 0, 1}
+		end
+		return redis.error_reply('QUEUE_FULL')
+	end
+end
+local len = 0
+for i = 5, #ARGV, 1000 do
+	len = redis.call('RPUSH', KEYS[1], unpack(ARGV, i, math.min(i + 999, #ARGV)))
+end
+local evicted = 0
+if max > 0 and len > max then
+	evicted = len - max
+	redis.call('LTRIM', KEYS[1], evicted, -1)
+	len = max
+end
+if tonumber(ARGV[4]) > 0 then
+	redis.call('EXPIRE', KEYS[1], ARGV[4])
+end
+if ARGV[1] == '1' then
+	return {len, evicted, 0}
+end
+return len
+`)
+
+// 自动更新超时时传给脚本的超时秒数，0 表示不更新
+func (m *RedisQueueUtils) scriptExpire() int32 {
+	if m.auto_expire && m.expire > 0 {
+		return m.expire
+	}
+	return 0
+}
+
+/*
+压入队列，返回压入后队列的长度
+
+限制了最大长度时，压入和裁剪在一个Lua脚本中原子地完成：
+QUEUE_OVERFLOW_DROP_OLDEST 时淘汰最早的元素，
+QUEUE_OVERFLOW_REJECT_NEW 时不压入并返回 ErrQueueFull(批量模式下用 IsQueueFull 判断)。
+需要知道淘汰数量时使用 PushEx。
+没有元素时不压入，返回当前队列的长度，不更新超时时间
+*/
 func (m *RedisQueueUtils) Push(ctx context.Context, paramValue ...interface{}) *redis.IntCmd {
-	retCmd := m.cli.RPush(ctx, m.key, paramValue...)
-	if retCmd.Err() == nil {
+	if len(paramValue) == 0 {
+		return m.cmd().LLen(ctx, m.key)
+	}
+	if m.max_size <= 0 {
+		retCmd := m.cmd().RPush(ctx, m.key, paramValue...)
 		m.afterExpire(ctx, retCmd.Err())
-		if m.max_size > 0 {
-			lastPos := retCmd.Val()
-			if lastPos >= m.max_size {
-				m.cli.LTrim(ctx, m.key, lastPos-m.max_size, lastPos)
-			}
-		}
+		return retCmd
+	}
+	args := append([]interface{}{"0", m.max_size, int(m.overflow), m.scriptExpire()}, paramValue...)
+	retCmd := evalIntScript(ctx, m.cmd(), queuePushScript, []string{m.key}, args...)
+	if m.pipe == nil && IsQueueFull(retCmd.Err()) {
+		retCmd.SetErr(ErrQueueFull)
 	}
 	return retCmd
 }
 
+// 压入队列的结果
+type QueuePushResult struct {
+	Len      int64 // 压入后队列的长度
+	Evicted  int64 // 超过最大长度被淘汰的最早的元素数量
+	Rejected bool  // 队列已满被拒绝，没有压入
+}
+
+/*
+压入队列并返回详细结果，批量模式下返回 ErrBatchUnsupported
+
+QUEUE_OVERFLOW_REJECT_NEW 时队列已满返回 Rejected 为 true，不返回错误。
+没有元素时和 Push 一样不压入，Len 为当前队列的长度
+*/
+func (m *RedisQueueUtils) PushEx(ctx context.Context, paramValue ...interface{}) (*QueuePushResult, error) {
+	if m.pipe != nil {
+		return nil, ErrBatchUnsupported
+	}
+	if len(paramValue) == 0 {
+		n, err := m.cli.LLen(ctx, m.key).Result()
+		if err != nil {
+			return nil, err
+		}
+		return &QueuePushResult{Len: n}, nil
+	}
+	args := append([]interface{}{"1", m.max_size, int(m.overflow), m.scriptExpire()}, paramValue...)
+	ret, err := queuePushScript.script.Run(ctx, m.cli, []string{m.key}, args...).Int64Slice()
+	if err != nil {
+		return nil, err
+	}
+	return &QueuePushResult{Len: ret[0], Evicted: ret[1], Rejected: ret[2] == 1}, nil
+}
+
 // 弹出队列
 func (m *RedisQueueUtils) Pop(ctx context.Context) *redis.StringCmd {
-	retCmd := m.cli.LPop(ctx, m.key)
+	retCmd := m.cmd().LPop(ctx, m.key)
 	m.afterExpire(ctx, retCmd.Err())
 	return retCmd
 }
 
 // 弹出多个元素
 func (m *RedisQueueUtils) PopCount(ctx context.Context, paramCount int) *redis.StringSliceCmd {
-	retCmd := m.cli.LPopCount(ctx, m.key, paramCount)
+	retCmd := m.cmd().LPopCount(ctx, m.key, paramCount)
 	m.afterExpire(ctx, retCmd.Err())
 	return retCmd
 }
+
+// 取执行命令的客户端，批量模式下为管道
+func (m *RedisQueueUtils) cmd() redis.Cmdable {
+	if m.pipe != nil {
+		return m.pipe
+	}
+	return m.cli
+}
+
+// 返回绑定到批量操作的副本，副本的命令会写入批量操作，在 RedisBatch.Exec 时一起执行
+func (m *RedisQueueUtils) Batch(paramBatch *RedisBatch) *RedisQueueUtils {
+	c := *m
+	c.pipe = paramBatch.pipe
+	return &c
+}
@@ -0,0 +1,130 @@
+package redisv8
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestQueueDropOldest(t *testing.T) {
+	cli, mr := newTestClient(t)
+	ctx := context.Background()
+	q := CreateQueueUtilsMax(cli, "queue", 60, true, 3)
+
+	if n, err := q.Push(ctx, 1, 2).Result(); err != nil || n != 2 {
+		t.Fatalf("push = %d, %v", n, err)
+	}
+	// 超过最大长度时淘汰最早的元素
+	if n, err := q.Push(ctx, 3, 4).Result(); err != nil || n != 3 {
+		t.Fatalf("push = %d, %v", n, err)
+	}
+	ret, err := q.PushEx(ctx, 5, 6)
+	if err != nil || ret.Len != 3 || ret.Evicted != 2 || ret.Rejected {
+		t.Fatalf("PushEx = %+v, %v", ret, err)
+	}
+	if list, _ := mr.List("queue"); len(list) != 3 || list[0] != "4" || list[2] != "6" {
+		t.Fatalf("list = %v", list)
+	}
+	if mr.TTL("queue") != 60*time.Second {
+		t.Fatal("expire not set")
+	}
+}
+
+func TestQueueRejectNew(t *testing.T) {
+	cli, mr := newTestClient(t)
+	ctx := context.Background()
+	q := CreateQueueUtilsMax(cli, "queue", 0, false, 2)
+	q.SetOverflowPolicy(QUEUE_OVERFLOW_REJECT_NEW)
+
+	q.Push(ctx, 1)
+	// 整批超过最大长度时都不压入
+	if _, err := q.Push(ctx, 2, 3).Result(); err != ErrQueueFull {
+		t.Fatalf("full err = %v", err)
+	}
+	ret, err := q.PushEx(ctx, 2, 3)
+	if err != nil || !ret.Rejected || ret.Len != 1 || ret.Evicted != 0 {
+		t.Fatalf("PushEx = %+v, %v", ret, err)
+	}
+
+	batch := CreateBatch(cli)
+	okCmd := q.Batch(batch).Push(ctx, 2)
+	fullCmd := q.Batch(batch).Push(ctx, 3)
+	batch.Exec(ctx)
+	if n, err := okCmd.Result(); err != nil || n != 2 {
+		t.Fatalf("batch push = %d, %v", n, err)
+	}
+	if !IsQueueFull(fullCmd.Err()) {
+		t.Fatalf("batch full err = %v", fullCmd.Err())
+	}
+	if list, _ := mr.List("queue"); len(list) != 2 || list[1] != "2" {
+		t.Fatalf("list = %v", list)
+	}
+}
+
+func TestQueuePushExBatch(t *testing.T) {
+	cli, mr := newTestClient(t)
+	ctx := context.Background()
+	q := CreateQueueUtilsMax(cli, "queue", 0, false, 2)
+
+	// 批量模式下 PushEx 需要直接读取结果，不能放到事务中
+	batch := CreateTxBatch(cli)
+	if _, err := q.Batch(batch).PushEx(ctx, 1); err != ErrBatchUnsupported {
+		t.Fatalf("batch PushEx err = %v", err)
+	}
+	batch.Discard()
+	if mr.Exists("queue") {
+		t.Fatal("pushed outside the batch")
+	}
+}
+
+func TestQueueLargePush(t *testing.T) {
+	cli, _ := newTestClient(t)
+	ctx := context.Background()
+	q := CreateQueueUtilsMax(cli, "queue", 0, false, 9000)
+
+	// 一次压入大量元素时分批 RPUSH
+	values := make([]interface{}, 10000)
+	for i := range values {
+		values[i] = strconv.Itoa(i)
+	}
+	ret, err := q.PushEx(ctx, values...)
+	if err != nil || ret.Len != 9000 || ret.Evicted != 1000 {
+		t.Fatalf("PushEx = %+v, %v", ret, err)
+	}
+	if v, _ := q.Pop(ctx).Result(); v != "1000" {
+		t.Fatalf("first = %q", v)
+	}
+
+	// 脚本缓存被清空后回退到 EVAL
+	cli.ScriptFlush(ctx)
+	if n, err := q.Push(ctx, values[:5000]...).Result(); err != nil || n != 9000 {
+		t.Fatalf("push after flush = %d, %v", n, err)
+	}
+}
+
+func TestQueuePushNoValues(t *testing.T) {
+	cli, _ := newTestClient(t)
+	ctx := context.Background()
+	limited := CreateQueueUtilsMax(cli, "queue", 0, false, 5)
+	unlimited := CreateQueueUtils(cli, "queue", 0, false)
+	limited.Push(ctx, "a", "b")
+
+	// 没有元素时不论是否限制长度都不压入，返回当前长度
+	for _, q := range []*RedisQueueUtils{limited, unlimited} {
+		if n, err := q.Push(ctx).Result(); err != nil || n != 2 {
+			t.Fatalf("Push() = %d, %v", n, err)
+		}
+		if ret, err := q.PushEx(ctx); err != nil || ret.Len != 2 || ret.Evicted != 0 || ret.Rejected {
+			t.Fatalf("PushEx() = %+v, %v", ret, err)
+		}
+	}
+	batch := CreateBatch(cli)
+	lenCmd := unlimited.Batch(batch).Push(ctx)
+	if _, err := batch.Exec(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if n := lenCmd.Val(); n != 2 {
+		t.Fatalf("batch Push() = %d", n)
+	}
+}
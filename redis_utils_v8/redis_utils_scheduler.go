@@ -0,0 +1,422 @@
+package redisv8
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	redis "github.com/go-redis/redis/v8"
+	"github.com/qiuliaogit/commonutils/commonutils"
+)
+
+var ErrSchedulerJobNotFound = commonutils.NewError(commonutils.ERR_FAIL, "定时任务不存在")
+
+// 定时任务的执行函数
+type CronJobHandler func(ctx context.Context) error
+
+// 一次执行的记录
+type CronRunRecord struct {
+	Job        string `json:"job"`
+	Scheduled  int64  `json:"scheduled"` // 计划执行时间 秒
+	StartedAt  int64  `json:"started_at"`
+	FinishedAt int64  `json:"finished_at"`
+	Instance   string `json:"instance"`
+	Error      string `json:"error,omitempty"`
+}
+
+// 是否执行成功
+func (r *CronRunRecord) OK() bool {
+	return r.Error == ""
+}
+
+// 定时任务的状态
+type CronJobInfo struct {
+	Name    string
+	Spec    string
+	Next    time.Time // 暂停时为零值
+	Paused  bool
+	Running bool // 是否在当前实例上执行中
+}
+
+type cronJob struct {
+	name     string
+	schedule *CronSchedule
+	handler  CronJobHandler
+	running  bool
+}
+
+/*
+基于redis的分布式定时任务调度器
+
+  - key:next 有序集合，成员为任务名，分数为下一次执行时间(秒)
+  - key:spec 哈希，任务名对应的 cron 表达式，表达式修改后重新计算下一次执行时间
+  - key:paused 集合，暂停的任务
+  - key:history:任务名 列表，最近的执行记录，包含失败原因
+
+各实例注册相同的任务并运行 Run，到期的任务通过比较并修改下一次执行时间来抢占，
+每次触发只会被一个实例执行。抢占后实例崩溃时该次执行会丢失，不会重试。
+错过的触发(如所有实例都停止期间)不会补执行，只执行一次后按当前时间计算下一次。
+*/
+type RedisScheduler struct {
+	cli          *redis.Client
+	prefix       string
+	instance     string
+	location     *time.Location
+	historySize  int64
+	pollInterval time.Duration
+	mu           sync.Mutex
+	jobs         map[string]*cronJob
+	errHandler   func(error)
+	wg           sync.WaitGroup
+}
+
+/*
+创建一个调度器
+
+  - paramCli
+  - paramPrefix key 的前缀，各实例使用相同的前缀
+  - paramHistorySize 每个任务保留的执行记录条数，<=0 时保留100条
+*/
+func CreateScheduler(paramCli *redis.Client, paramPrefix string, paramHistorySize int64) *RedisScheduler {
+	if paramHistorySize <= 0 {
+		// LTRIM 0 -1 不会裁剪，执行记录会无限增长
+		paramHistorySize = 100
+	}
+	host, _ := os.Hostname()
+	return &RedisScheduler{
+		cli:          paramCli,
+		prefix:       paramPrefix,
+		instance:     fmt.Sprintf("%s-%d", host, os.Getpid()),
+		location:     time.Local,
+		historySize:  paramHistorySize,
+		pollInterval: time.Second,
+		jobs:         make(map[string]*cronJob),
+	}
+}
+
+// 设置计算执行时间使用的时区，默认为本地时区，需要在 Run 之前设置
+func (s *RedisScheduler) SetLocation(paramLocation *time.Location) {
+	s.location = paramLocation
+}
+
+// 设置检查到期任务的间隔，默认1秒
+func (s *RedisScheduler) SetPollInterval(paramInterval time.Duration) {
+	s.pollInterval = paramInterval
+}
+
+// 设置出错时的回调，如连接失败、任务执行失败
+func (s *RedisScheduler) SetErrorHandler(paramFn func(error)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.errHandler = paramFn
+}
+
+func (s *RedisScheduler) reportError(paramErr error) {
+	s.mu.Lock()
+	fn := s.errHandler
+	s.mu.Unlock()
+	if fn != nil && paramErr != nil {
+		fn(paramErr)
+	}
+}
+
+func (s *RedisScheduler) nextKey() string {
+	return s.prefix + ":next"
+}
+
+func (s *RedisScheduler) specKey() string {
+	return s.prefix + ":spec"
+}
+
+func (s *RedisScheduler) pausedKey() string {
+	return s.prefix + ":paused"
+}
+
+func (s *RedisScheduler) history(paramJob string) *RedisListUtils {
+	return CreateListUtils(s.cli, s.prefix+":history:"+paramJob, 0, false)
+}
+
+/*
+注册一个定时任务，需要在 Run 之前注册
+
+  - paramName 任务名，各实例相同
+  - paramSpec cron 表达式，如 "0 3 * * *" 每天3点
+  - paramHandler 执行函数
+*/
+func (s *RedisScheduler) Register(paramName string, paramSpec string, paramHandler CronJobHandler) error {
+	schedule, err := ParseCron(paramSpec)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs[paramName] = &cronJob{name: paramName, schedule: schedule, handler: paramHandler}
+	return nil
+}
+
+// KEYS: next, spec, paused
+// ARGV: 任务名, cron 表达式, 下一次执行时间
+var schedulerInitScript = redis.NewScript(`
+if redis.call('HGET', KEYS[2], ARGV[1]) ~= ARGV[2] then
+	redis.call('HSET', KEYS[2], ARGV[1], ARGV[2])
+	redis.call('ZREM', KEYS[1], ARGV[1])
+end
+if redis.call('SISMEMBER', KEYS[3], ARGV[1]) == 0 then
+	redis.call('ZADD', KEYS[1], 'NX', ARGV[3], ARGV[1])
+end
+return 1
+`)
+
+// 写入各任务的表达式和下一次执行时间，已有的不修改
+func (s *RedisScheduler) init(ctx context.Context) error {
+	now := time.Now().In(s.location)
+	for _, job := range s.jobList() {
+		next := job.schedule.Next(now)
+		if next.IsZero() {
+			continue
+		}
+		err := schedulerInitScript.Run(ctx, s.cli, []string{s.nextKey(), s.specKey(), s.pausedKey()},
+			job.name, job.schedule.String(), next.Unix()).Err()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *RedisScheduler) jobList() []*cronJob {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ret := make([]*cronJob, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		ret = append(ret, job)
+	}
+	return ret
+}
+
+/*
+检查并执行到期的任务，阻塞直到 ctx 被取消，返回 ctx.Err()
+
+任务在单独的协程中执行，同一个任务在当前实例上上一次没有执行完时跳过本次。
+退出时等待执行中的任务结束。
+*/
+func (s *RedisScheduler) Run(ctx context.Context) error {
+	defer s.wg.Wait()
+	for {
+		if err := s.init(ctx); err == nil {
+			break
+		} else if ctx.Err() == nil {
+			s.reportError(err)
+		}
+		if !sleepContext(ctx, s.pollInterval) {
+			return ctx.Err()
+		}
+	}
+	for {
+		if err := s.poll(ctx); err != nil && ctx.Err() == nil {
+			s.reportError(err)
+		}
+		if !sleepContext(ctx, s.pollInterval) {
+			return ctx.Err()
+		}
+	}
+}
+
+// KEYS: next, paused
+// ARGV: 任务名, 预期的执行时间, 下一次执行时间
+var schedulerClaimScript = redis.NewScript(`
+if redis.call('SISMEMBER', KEYS[2], ARGV[1]) == 1 then
+	return 0
+end
+local score = redis.call('ZSCORE', KEYS[1], ARGV[1])
+if not score or tonumber(score) ~= tonumber(ARGV[2]) then
+	return 0
+end
+redis.call('ZADD', KEYS[1], ARGV[3], ARGV[1])
+return 1
+`)
+
+// 抢占并执行一轮到期的任务
+func (s *RedisScheduler) poll(ctx context.Context) error {
+	now := time.Now().In(s.location)
+	due, err := s.cli.ZRangeByScoreWithScores(ctx, s.nextKey(), &redis.ZRangeBy{
+		Min: MIN_VALUE,
+		Max: commonutils.I(now.Unix()),
+	}).Result()
+	if err != nil {
+		return err
+	}
+	for _, z := range due {
+		name, _ := z.Member.(string)
+		s.mu.Lock()
+		job, ok := s.jobs[name]
+		busy := ok && job.running
+		s.mu.Unlock()
+		if !ok || busy {
+			continue
+		}
+		next := job.schedule.Next(now)
+		if next.IsZero() {
+			continue
+		}
+		scheduled := int64(z.Score)
+		claimed, err := schedulerClaimScript.Run(ctx, s.cli, []string{s.nextKey(), s.pausedKey()},
+			name, scheduled, next.Unix()).Int()
+		if err != nil {
+			return err
+		}
+		if claimed == 1 {
+			s.start(ctx, job, scheduled)
+		}
+	}
+	return nil
+}
+
+// 在单独的协程中执行任务并记录结果
+func (s *RedisScheduler) start(ctx context.Context, paramJob *cronJob, paramScheduled int64) {
+	s.mu.Lock()
+	paramJob.running = true
+	s.mu.Unlock()
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		record := &CronRunRecord{
+			Job:       paramJob.name,
+			Scheduled: paramScheduled,
+			StartedAt: time.Now().Unix(),
+			Instance:  s.instance,
+		}
+		err := s.call(ctx, paramJob)
+		record.FinishedAt = time.Now().Unix()
+		if err != nil {
+			record.Error = err.Error()
+			s.reportError(err)
+		}
+		s.mu.Lock()
+		paramJob.running = false
+		s.mu.Unlock()
+		s.record(context.Background(), record)
+	}()
+}
+
+// 执行任务，panic 时作为失败记录
+func (s *RedisScheduler) call(ctx context.Context, paramJob *cronJob) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = commonutils.NewErrorf(commonutils.ERR_FAIL, "定时任务 %s panic: %v", paramJob.name, r)
+		}
+	}()
+	return paramJob.handler(ctx)
+}
+
+// 写入执行记录，只保留最近的 historySize 条
+func (s *RedisScheduler) record(ctx context.Context, paramRecord *CronRunRecord) {
+	data, err := json.Marshal(paramRecord)
+	if err != nil {
+		return
+	}
+	b := CreateTxBatch(s.cli)
+	list := s.history(paramRecord.Job).Batch(b)
+	list.LPush(ctx, string(data))
+	list.Trim(ctx, 0, s.historySize-1)
+	if _, err := b.Exec(ctx); err != nil {
+		s.reportError(err)
+	}
+}
+
+// 最近的执行记录，最新的在前
+func (s *RedisScheduler) History(ctx context.Context, paramJob string, paramCount int64) ([]*CronRunRecord, error) {
+	list, err := s.history(paramJob).Range(ctx, 0, paramCount-1).Result()
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]*CronRunRecord, 0, len(list))
+	for _, data := range list {
+		r := &CronRunRecord{}
+		if err := json.Unmarshal([]byte(data), r); err != nil {
+			continue
+		}
+		ret = append(ret, r)
+	}
+	return ret, nil
+}
+
+// 暂停任务，所有实例都不再执行，执行中的不受影响
+func (s *RedisScheduler) Pause(ctx context.Context, paramJob string) error {
+	_, err := s.cli.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.SAdd(ctx, s.pausedKey(), paramJob)
+		pipe.ZRem(ctx, s.nextKey(), paramJob)
+		return nil
+	})
+	return err
+}
+
+// 恢复任务，按当前时间计算下一次执行时间
+func (s *RedisScheduler) Resume(ctx context.Context, paramJob string) error {
+	spec, err := s.cli.HGet(ctx, s.specKey(), paramJob).Result()
+	if err == redis.Nil {
+		return ErrSchedulerJobNotFound
+	}
+	if err != nil {
+		return err
+	}
+	schedule, err := ParseCron(spec)
+	if err != nil {
+		return err
+	}
+	next := schedule.Next(time.Now().In(s.location))
+	_, err = s.cli.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.SRem(ctx, s.pausedKey(), paramJob)
+		if !next.IsZero() {
+			pipe.ZAdd(ctx, s.nextKey(), &redis.Z{Member: paramJob, Score: float64(next.Unix())})
+		}
+		return nil
+	})
+	return err
+}
+
+// 所有实例注册过的任务的状态，按任务名排序
+func (s *RedisScheduler) Jobs(ctx context.Context) ([]CronJobInfo, error) {
+	var specCmd *redis.StringStringMapCmd
+	var nextCmd *redis.ZSliceCmd
+	var pausedCmd *redis.StringSliceCmd
+	_, err := s.cli.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		specCmd = pipe.HGetAll(ctx, s.specKey())
+		nextCmd = pipe.ZRangeWithScores(ctx, s.nextKey(), 0, -1)
+		pausedCmd = pipe.SMembers(ctx, s.pausedKey())
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	next := make(map[string]int64)
+	for _, z := range nextCmd.Val() {
+		name, _ := z.Member.(string)
+		next[name] = int64(z.Score)
+	}
+	paused := make(map[string]bool)
+	for _, name := range pausedCmd.Val() {
+		paused[name] = true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ret := make([]CronJobInfo, 0, len(specCmd.Val()))
+	for name, spec := range specCmd.Val() {
+		info := CronJobInfo{Name: name, Spec: spec, Paused: paused[name]}
+		if t, ok := next[name]; ok {
+			info.Next = time.Unix(t, 0).In(s.location)
+		}
+		if job, ok := s.jobs[name]; ok {
+			info.Running = job.running
+		}
+		ret = append(ret, info)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Name < ret[j].Name
+	})
+	return ret, nil
+}
@@ -0,0 +1,208 @@
+package redisv8
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	redis "github.com/go-redis/redis/v8"
+)
+
+// 把任务的下一次执行时间改为已到期
+func makeCronDue(t *testing.T, paramScheduler *RedisScheduler, paramJob string) int64 {
+	t.Helper()
+	due := time.Now().Add(-time.Minute).Unix()
+	if err := paramScheduler.cli.ZAdd(context.Background(), paramScheduler.nextKey(), &redis.Z{Member: paramJob, Score: float64(due)}).Err(); err != nil {
+		t.Fatal(err)
+	}
+	return due
+}
+
+func TestSchedulerHistoryDefaultSize(t *testing.T) {
+	cli, mr := newTestClient(t)
+	ctx := context.Background()
+	s := CreateScheduler(cli, "cron", 0)
+
+	for i := 0; i < 120; i++ {
+		s.record(ctx, &CronRunRecord{Job: "report"})
+	}
+	// 保留条数为0时使用默认值，不会无限增长
+	if list, _ := mr.List("cron:history:report"); len(list) != 100 {
+		t.Fatalf("history len = %d", len(list))
+	}
+	records, err := s.History(ctx, "report", 5)
+	if err != nil || len(records) != 5 {
+		t.Fatalf("History = %d, %v", len(records), err)
+	}
+}
+
+func TestSchedulerJobsSorted(t *testing.T) {
+	cli, _ := newTestClient(t)
+	ctx := context.Background()
+	s := CreateScheduler(cli, "cron", 10)
+	for _, name := range []string{"cleanup", "archive", "report", "backup"} {
+		if err := s.Register(name, "0 3 * * *", func(ctx context.Context) error { return nil }); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.init(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Pause(ctx, "report"); err != nil {
+		t.Fatal(err)
+	}
+
+	jobs, err := s.Jobs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"archive", "backup", "cleanup", "report"}
+	if len(jobs) != len(want) {
+		t.Fatalf("jobs = %v", jobs)
+	}
+	for i, job := range jobs {
+		if job.Name != want[i] {
+			t.Fatalf("jobs[%d] = %s, want %s", i, job.Name, want[i])
+		}
+		if job.Paused != (job.Name == "report") {
+			t.Fatalf("%s paused = %v", job.Name, job.Paused)
+		}
+	}
+}
+
+func TestSchedulerRunsOnceAcrossInstances(t *testing.T) {
+	cli, _ := newTestClient(t)
+	ctx := context.Background()
+	var calls int32
+	instances := make([]*RedisScheduler, 3)
+	for i := range instances {
+		instances[i] = CreateScheduler(cli, "cron", 10)
+		instances[i].Register("report", "* * * * *", func(ctx context.Context) error {
+			atomic.AddInt32(&calls, 1)
+			return nil
+		})
+		if err := instances[i].init(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	due := makeCronDue(t, instances[0], "report")
+
+	// 各实例同时检查到期的任务，只有一个能抢占
+	var wg sync.WaitGroup
+	for _, s := range instances {
+		wg.Add(1)
+		go func(s *RedisScheduler) {
+			defer wg.Done()
+			if err := s.poll(ctx); err != nil {
+				t.Error(err)
+			}
+		}(s)
+	}
+	wg.Wait()
+	for _, s := range instances {
+		s.wg.Wait()
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Fatalf("calls = %d", n)
+	}
+	// 抢占后下一次执行时间已经更新，再次检查不会执行
+	for _, s := range instances {
+		s.poll(ctx)
+		s.wg.Wait()
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Fatalf("calls after repoll = %d", n)
+	}
+	records, _ := instances[0].History(ctx, "report", 10)
+	if len(records) != 1 || records[0].Scheduled != due || !records[0].OK() {
+		t.Fatalf("history = %+v", records)
+	}
+}
+
+func TestSchedulerPauseResume(t *testing.T) {
+	cli, mr := newTestClient(t)
+	ctx := context.Background()
+	s := CreateScheduler(cli, "cron", 10)
+	var calls int32
+	s.Register("report", "* * * * *", func(ctx context.Context) error {
+		atomic.AddInt32(&calls, 1)
+		return nil
+	})
+	if err := s.init(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Pause(ctx, "report"); err != nil {
+		t.Fatal(err)
+	}
+	// 暂停期间其他实例按旧的时间写入也不会被抢占
+	makeCronDue(t, s, "report")
+	s.poll(ctx)
+	s.wg.Wait()
+	if n := atomic.LoadInt32(&calls); n != 0 {
+		t.Fatalf("paused calls = %d", n)
+	}
+	// 重启后 init 不会恢复暂停的任务
+	mr.ZRem("cron:next", "report")
+	s.init(ctx)
+	if mr.Exists("cron:next") {
+		t.Fatal("init rescheduled a paused job")
+	}
+
+	if err := s.Resume(ctx, "report"); err != nil {
+		t.Fatal(err)
+	}
+	jobs, _ := s.Jobs(ctx)
+	if len(jobs) != 1 || jobs[0].Paused || !jobs[0].Next.After(time.Now()) {
+		t.Fatalf("jobs = %+v", jobs)
+	}
+	makeCronDue(t, s, "report")
+	s.poll(ctx)
+	s.wg.Wait()
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Fatalf("resumed calls = %d", n)
+	}
+	if err := s.Resume(ctx, "missing"); err != ErrSchedulerJobNotFound {
+		t.Fatalf("Resume missing err = %v", err)
+	}
+}
+
+func TestSchedulerRecordsFailures(t *testing.T) {
+	cli, _ := newTestClient(t)
+	ctx := context.Background()
+	s := CreateScheduler(cli, "cron", 10)
+	var errs int32
+	s.SetErrorHandler(func(error) { atomic.AddInt32(&errs, 1) })
+	s.Register("fail", "* * * * *", func(ctx context.Context) error { return errors.New("disk full") })
+	s.Register("panic", "* * * * *", func(ctx context.Context) error { panic("boom") })
+	if err := s.init(ctx); err != nil {
+		t.Fatal(err)
+	}
+	makeCronDue(t, s, "fail")
+	makeCronDue(t, s, "panic")
+	if err := s.poll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	s.wg.Wait()
+
+	failed, _ := s.History(ctx, "fail", 10)
+	if len(failed) != 1 || failed[0].OK() || failed[0].Error != "disk full" {
+		t.Fatalf("fail history = %+v", failed)
+	}
+	panicked, _ := s.History(ctx, "panic", 10)
+	if len(panicked) != 1 || !strings.Contains(panicked[0].Error, "boom") {
+		t.Fatalf("panic history = %+v", panicked)
+	}
+	if n := atomic.LoadInt32(&errs); n != 2 {
+		t.Fatalf("reported errors = %d", n)
+	}
+	jobs, _ := s.Jobs(ctx)
+	for _, job := range jobs {
+		if job.Running {
+			t.Fatalf("%s still running", job.Name)
+		}
+	}
+}
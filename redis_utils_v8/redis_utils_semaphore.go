@@ -0,0 +1,261 @@
+package redisv8
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	redis "github.com/go-redis/redis/v8"
+	"github.com/qiuliaogit/commonutils/commonutils"
+)
+
+var (
+	ErrSemaphoreFull = commonutils.NewError(commonutils.ERR_FAIL, "信号量已满")
+	ErrSemaphoreLost = commonutils.NewError(commonutils.ERR_FAIL, "信号量的租约已超时失效")
+)
+
+/*
+基于redis的分布式计数信号量，用于限制多个实例对同一资源的并发数，如第三方支付网关
+
+  - key:holders 持有者的有序集合，分数为租约到期时间(毫秒)，到期未续约的持有者会被自动清除
+  - key:waiters 等待者的有序集合，分数为排队序号，先排队的先获得，保证公平
+  - key:waiting 等待者的心跳，分数为到期时间，长时间没有再次尝试的等待者会被移出队列
+  - key:seq 排队序号
+
+时间使用各实例的本地时间，各实例的时钟误差需要远小于租约时间。
+集群模式下 key 需要带 hash tag，如 {pay_gateway}
+*/
+type RedisSemaphore struct {
+	cli          *redis.Client
+	key          string
+	limit        int64
+	lease        time.Duration
+	pollInterval time.Duration
+	holders      *RedisZSetUtils
+}
+
+/*
+创建一个信号量
+
+  - paramCli
+  - paramKey 信号量的key，各实例使用相同的key
+  - paramLimit 最大并发数
+  - paramLease 租约时间，持有者需要在到期前调用 Renew 续约，<=0 时为30秒，最短10毫秒
+*/
+func CreateSemaphore(paramCli *redis.Client, paramKey string, paramLimit int64, paramLease time.Duration) *RedisSemaphore {
+	// Run 每 1/3 租约时间续约一次，租约过短时会不停地访问redis
+	if paramLease <= 0 {
+		paramLease = 30 * time.Second
+	} else if paramLease < 10*time.Millisecond {
+		paramLease = 10 * time.Millisecond
+	}
+	return &RedisSemaphore{
+		cli:          paramCli,
+		key:          paramKey,
+		limit:        paramLimit,
+		lease:        paramLease,
+		pollInterval: 50 * time.Millisecond,
+		holders:      CreateZSetUtils(paramCli, paramKey+":holders", 0, false),
+	}
+}
+
+// 设置阻塞获取时重试的间隔，默认50毫秒，<=0 时不修改
+func (s *RedisSemaphore) SetPollInterval(paramInterval time.Duration) {
+	if paramInterval > 0 {
+		s.pollInterval = paramInterval
+	}
+}
+
+// 一次成功获取的租约
+type SemaphoreLease struct {
+	sem *RedisSemaphore
+	id  string
+}
+
+// 租约ID
+func (l *SemaphoreLease) ID() string {
+	return l.id
+}
+
+func (s *RedisSemaphore) keys() []string {
+	return []string{s.key + ":holders", s.key + ":waiters", s.key + ":waiting", s.key + ":seq"}
+}
+
+// 等待者多久没有再次尝试后移出队列
+func (s *RedisSemaphore) waitTimeout() time.Duration {
+	return 3*s.pollInterval + time.Second
+}
+
+// KEYS: holders, waiters, waiting, seq
+// ARGV: ID, 最大并发数, 当前时间, 租约到期时间, 等待心跳到期时间, 失败时是否离开队列
+var semaphoreAcquireScript = redis.NewScript(`
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', ARGV[3])
+local expired = redis.call('ZRANGEBYSCORE', KEYS[3], '-inf', ARGV[3])
+for _, id in ipairs(expired) do
+	redis.call('ZREM', KEYS[2], id)
+	redis.call('ZREM', KEYS[3], id)
+end
+if redis.call('ZSCORE', KEYS[2], ARGV[1]) == false then
+	redis.call('ZADD', KEYS[2], redis.call('INCR', KEYS[4]), ARGV[1])
+end
+local rank = redis.call('ZRANK', KEYS[2], ARGV[1])
+if rank < tonumber(ARGV[2]) - redis.call('ZCARD', KEYS[1]) then
+	redis.call('ZREM', KEYS[2], ARGV[1])
+	redis.call('ZREM', KEYS[3], ARGV[1])
+	redis.call('ZADD', KEYS[1], ARGV[4], ARGV[1])
+	return 1
+end
+if ARGV[6] == '1' then
+	redis.call('ZREM', KEYS[2], ARGV[1])
+	redis.call('ZREM', KEYS[3], ARGV[1])
+else
+	redis.call('ZADD', KEYS[3], ARGV[5], ARGV[1])
+end
+return 0
+`)
+
+func (s *RedisSemaphore) tryAcquire(ctx context.Context, paramID string, paramLeave bool) (bool, error) {
+	now := time.Now()
+	leave := "0"
+	if paramLeave {
+		leave = "1"
+	}
+	ok, err := semaphoreAcquireScript.Run(ctx, s.cli, s.keys(),
+		paramID, s.limit, now.UnixMilli(), now.Add(s.lease).UnixMilli(), now.Add(s.waitTimeout()).UnixMilli(), leave).Int()
+	if err != nil {
+		return false, err
+	}
+	return ok == 1, nil
+}
+
+func newSemaphoreID() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// 尝试获取一次，没有空位或前面有人排队时返回 ErrSemaphoreFull
+func (s *RedisSemaphore) TryAcquire(ctx context.Context) (*SemaphoreLease, error) {
+	id := newSemaphoreID()
+	ok, err := s.tryAcquire(ctx, id, true)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrSemaphoreFull
+	}
+	return &SemaphoreLease{sem: s, id: id}, nil
+}
+
+/*
+阻塞获取，按排队顺序获得，ctx 取消或超时后离开队列并返回 ctx 的错误
+
+  - ctx 可以用 context.WithTimeout 限制最长等待时间
+*/
+func (s *RedisSemaphore) Acquire(ctx context.Context) (*SemaphoreLease, error) {
+	id := newSemaphoreID()
+	for {
+		ok, err := s.tryAcquire(ctx, id, false)
+		if err != nil {
+			s.leave(context.Background(), id)
+			return nil, err
+		}
+		if ok {
+			return &SemaphoreLease{sem: s, id: id}, nil
+		}
+		if !sleepContext(ctx, s.pollInterval) {
+			s.leave(context.Background(), id)
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// 离开等待队列
+func (s *RedisSemaphore) leave(ctx context.Context, paramID string) {
+	keys := s.keys()
+	s.cli.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZRem(ctx, keys[1], paramID)
+		pipe.ZRem(ctx, keys[2], paramID)
+		return nil
+	})
+}
+
+// 当前持有者的数量，不含已到期的
+func (s *RedisSemaphore) Count(ctx context.Context) (int64, error) {
+	return s.holders.CountByIntMinScore(ctx, time.Now().UnixMilli()+1).Result()
+}
+
+// 当前排队等待的数量
+func (s *RedisSemaphore) Waiting(ctx context.Context) (int64, error) {
+	return s.cli.ZCard(ctx, s.key+":waiters").Result()
+}
+
+// 当前持有者的租约ID
+func (s *RedisSemaphore) Holders(ctx context.Context) ([]string, error) {
+	return s.holders.MemberListByMinScore(ctx, float64(time.Now().UnixMilli()), false).Result()
+}
+
+// KEYS: holders
+// ARGV: ID, 当前时间, 新的租约到期时间
+var semaphoreRenewScript = redis.NewScript(`
+local score = redis.call('ZSCORE', KEYS[1], ARGV[1])
+if score == false or tonumber(score) <= tonumber(ARGV[2]) then
+	return 0
+end
+redis.call('ZADD', KEYS[1], ARGV[3], ARGV[1])
+return 1
+`)
+
+// 续约，租约已到期被清除时返回 ErrSemaphoreLost
+func (l *SemaphoreLease) Renew(ctx context.Context) error {
+	now := time.Now()
+	ok, err := semaphoreRenewScript.Run(ctx, l.sem.cli, []string{l.sem.key + ":holders"},
+		l.id, now.UnixMilli(), now.Add(l.sem.lease).UnixMilli()).Int()
+	if err != nil {
+		return err
+	}
+	if ok == 0 {
+		return ErrSemaphoreLost
+	}
+	return nil
+}
+
+// 释放
+func (l *SemaphoreLease) Release(ctx context.Context) error {
+	return l.sem.holders.Remove(ctx, l.id).Err()
+}
+
+/*
+获取信号量后执行函数，执行期间每 1/3 租约时间自动续约，执行完后释放
+
+  - paramFn 执行的函数，续约失败时它的 ctx 会被取消
+
+获取时阻塞等待，ctx 取消时返回 ctx 的错误
+*/
+func (s *RedisSemaphore) Run(ctx context.Context, paramFn func(ctx context.Context) error) error {
+	lease, err := s.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer lease.Release(context.Background())
+
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for sleepContext(runCtx, s.lease/3) {
+			if err := lease.Renew(runCtx); err == ErrSemaphoreLost {
+				cancel()
+				return
+			}
+		}
+	}()
+	err = paramFn(runCtx)
+	cancel()
+	wg.Wait()
+	return err
+}
@@ -0,0 +1,157 @@
+package redisv8
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSemaphoreLimit(t *testing.T) {
+	cli, _ := newTestClient(t)
+	ctx := context.Background()
+	sem := CreateSemaphore(cli, "{pay}", 2, time.Minute)
+
+	l1, err := sem.TryAcquire(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sem.TryAcquire(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sem.TryAcquire(ctx); err != ErrSemaphoreFull {
+		t.Fatalf("third err = %v", err)
+	}
+	// TryAcquire 失败时不留在队列中
+	if n, _ := sem.Waiting(ctx); n != 0 {
+		t.Fatalf("waiting = %d", n)
+	}
+	if n, _ := sem.Count(ctx); n != 2 {
+		t.Fatalf("count = %d", n)
+	}
+	l1.Release(ctx)
+	if _, err := sem.TryAcquire(ctx); err != nil {
+		t.Fatalf("after release err = %v", err)
+	}
+}
+
+func TestSemaphoreFairQueue(t *testing.T) {
+	cli, _ := newTestClient(t)
+	ctx := context.Background()
+	sem := CreateSemaphore(cli, "{pay}", 1, time.Minute)
+
+	holder, err := sem.TryAcquire(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := sem.tryAcquire(ctx, "a", false); err != nil || ok {
+		t.Fatalf("queued = %v, %v", ok, err)
+	}
+	if n, _ := sem.Waiting(ctx); n != 1 {
+		t.Fatalf("waiting = %d", n)
+	}
+	holder.Release(ctx)
+	// 空出的位置属于先排队的 a
+	if _, err := sem.TryAcquire(ctx); err != ErrSemaphoreFull {
+		t.Fatalf("jump the queue err = %v", err)
+	}
+	if ok, err := sem.tryAcquire(ctx, "a", false); err != nil || !ok {
+		t.Fatalf("a = %v, %v", ok, err)
+	}
+	if n, _ := sem.Waiting(ctx); n != 0 {
+		t.Fatalf("waiting after acquire = %d", n)
+	}
+}
+
+func TestSemaphoreStaleWaiterRemoved(t *testing.T) {
+	cli, _ := newTestClient(t)
+	ctx := context.Background()
+	sem := CreateSemaphore(cli, "{pay}", 1, time.Minute)
+	sem.SetPollInterval(10 * time.Millisecond)
+
+	holder, _ := sem.TryAcquire(ctx)
+	sem.tryAcquire(ctx, "gone", false)
+	holder.Release(ctx)
+	if _, err := sem.TryAcquire(ctx); err != ErrSemaphoreFull {
+		t.Fatalf("before timeout err = %v", err)
+	}
+	// 等待者长时间没有再次尝试，被移出队列后不再挡住后面的请求
+	time.Sleep(sem.waitTimeout() + 50*time.Millisecond)
+	if _, err := sem.TryAcquire(ctx); err != nil {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestSemaphoreAcquireTimeoutLeavesQueue(t *testing.T) {
+	cli, _ := newTestClient(t)
+	sem := CreateSemaphore(cli, "{pay}", 1, time.Minute)
+	sem.SetPollInterval(10 * time.Millisecond)
+	sem.TryAcquire(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := sem.Acquire(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("err = %v", err)
+	}
+	if n, _ := sem.Waiting(context.Background()); n != 0 {
+		t.Fatalf("waiting = %d", n)
+	}
+}
+
+func TestSemaphoreLeaseExpiry(t *testing.T) {
+	cli, _ := newTestClient(t)
+	ctx := context.Background()
+	sem := CreateSemaphore(cli, "{pay}", 1, 100*time.Millisecond)
+
+	lease, err := sem.TryAcquire(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lease.Renew(ctx); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(150 * time.Millisecond)
+	if n, _ := sem.Count(ctx); n != 0 {
+		t.Fatalf("count = %d", n)
+	}
+	if err := lease.Renew(ctx); err != ErrSemaphoreLost {
+		t.Fatalf("renew err = %v", err)
+	}
+	if _, err := sem.TryAcquire(ctx); err != nil {
+		t.Fatalf("acquire after expiry err = %v", err)
+	}
+}
+
+func TestSemaphoreRunCancelsWhenLost(t *testing.T) {
+	cli, mr := newTestClient(t)
+	sem := CreateSemaphore(cli, "{pay}", 1, 150*time.Millisecond)
+
+	err := sem.Run(context.Background(), func(ctx context.Context) error {
+		// 模拟租约被清除，续约失败后 ctx 被取消
+		mr.Del("{pay}:holders")
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(2 * time.Second):
+			return nil
+		}
+	})
+	if err != context.Canceled {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestSemaphoreInvalidDurations(t *testing.T) {
+	cli, _ := newTestClient(t)
+	sem := CreateSemaphore(cli, "{pay}", 1, 0)
+	if sem.lease != 30*time.Second {
+		t.Fatalf("zero lease = %v", sem.lease)
+	}
+	if sem := CreateSemaphore(cli, "{pay}", 1, time.Nanosecond); sem.lease != 10*time.Millisecond {
+		t.Fatalf("tiny lease = %v", sem.lease)
+	}
+	sem.SetPollInterval(0)
+	sem.SetPollInterval(-time.Second)
+	if sem.pollInterval != 50*time.Millisecond {
+		t.Fatalf("poll interval = %v", sem.pollInterval)
+	}
+}
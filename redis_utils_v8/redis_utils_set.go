@@ -103,3 +103,66 @@ func (u *RedisSetUtils) ExpireSecond(ctx context.Context, paramSeconds int) {
 		u.cli.Expire(ctx, u.key, time.Duration(paramSeconds)*time.Second)
 	}
 }
+
+/*
+绑定到批量操作的集合工具类，通过 RedisSetUtils.Batch 创建
+
+方法返回 *redis.XxxCmd，相当于 future，RedisBatch.Exec 之后才能读取结果和错误
+*/
+type RedisSetBatchUtils struct {
+	set  *RedisSetUtils
+	pipe redis.Pipeliner
+}
+
+// 返回绑定到批量操作的副本，副本的命令会写入批量操作，在 RedisBatch.Exec 时一起执行
+func (u *RedisSetUtils) Batch(paramBatch *RedisBatch) *RedisSetBatchUtils {
+	return &RedisSetBatchUtils{set: u, pipe: paramBatch.pipe}
+}
+
+func (u *RedisSetBatchUtils) afterExpire(ctx context.Context) {
+	if u.set.auto_expire && u.set.expire > 0 {
+		u.pipe.Expire(ctx, u.set.key, time.Duration(u.set.expire)*time.Second)
+	}
+}
+
+// 增加一个元素
+func (u *RedisSetBatchUtils) Add(paramCtx context.Context, paramValue ...interface{}) *redis.IntCmd {
+	ret := u.pipe.SAdd(paramCtx, u.set.key, paramValue...)
+	u.afterExpire(paramCtx)
+	return ret
+}
+
+// 删除一个元素
+func (u *RedisSetBatchUtils) Del(paramCtx context.Context, paramValue ...interface{}) *redis.IntCmd {
+	ret := u.pipe.SRem(paramCtx, u.set.key, paramValue...)
+	u.afterExpire(paramCtx)
+	return ret
+}
+
+// 判断元素是否存在
+func (u *RedisSetBatchUtils) Has(paramCtx context.Context, paramValue interface{}) *redis.BoolCmd {
+	return u.pipe.SIsMember(paramCtx, u.set.key, paramValue)
+}
+
+// 获取元素的数量
+func (u *RedisSetBatchUtils) Count(paramCtx context.Context) *redis.IntCmd {
+	return u.pipe.SCard(paramCtx, u.set.key)
+}
+
+// 清空集合
+func (u *RedisSetBatchUtils) Clean(paramCtx context.Context) *redis.IntCmd {
+	return u.pipe.Del(paramCtx, u.set.key)
+}
+
+// 获取集合所有元素
+func (u *RedisSetBatchUtils) List(paramCtx context.Context) *redis.StringSliceCmd {
+	return u.pipe.SMembers(paramCtx, u.set.key)
+}
+
+// 设置超时 -1表示设为不过期
+func (u *RedisSetBatchUtils) ExpireSecond(ctx context.Context, paramSeconds int) *redis.BoolCmd {
+	if paramSeconds < 0 {
+		return u.pipe.Persist(ctx, u.set.key)
+	}
+	return u.pipe.Expire(ctx, u.set.key, time.Duration(paramSeconds)*time.Second)
+}
@@ -0,0 +1,496 @@
+package redisv8
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	redis "github.com/go-redis/redis/v8"
+	"github.com/qiuliaogit/commonutils/commonutils"
+)
+
+// 转入死信流时附加的字段
+const (
+	STREAM_DEAD_LETTER_SOURCE_ID    = "_dl_source_id"    // 原消息ID
+	STREAM_DEAD_LETTER_DELIVERIES   = "_dl_deliveries"   // 已投递次数
+	STREAM_DEAD_LETTER_SOURCE_KEY   = "_dl_source_key"   // 原流的key
+	STREAM_DEAD_LETTER_SOURCE_GROUP = "_dl_source_group" // 原消费组
+)
+
+// 基于redis的Stream工具类
+type RedisStreamUtils struct {
+	key         string
+	cli         *redis.Client
+	pipe        redis.Pipeliner // 批量执行时命令写入的管道，nil 表示直接执行
+	expire      int32           // 超时时间单位秒
+	auto_expire bool            // 是否自动更新超期时间  否则手动更新，默认自动更新
+	max_len     int64           // 流的最大长度(近似裁剪) 0表示不限制
+}
+
+/*
+创建一个Stream操作工具类, 不限制流的长度
+
+  - paramCli
+  - paramStreamKey 流的key
+  - paramExpire 超时时间，<=0 时表示没有超时， 单位秒
+  - paramAutoExpire 是否在更新后自动更新超时时间
+*/
+func CreateStreamUtils(paramCli *redis.Client, paramStreamKey string, paramExpire int32, paramAutoExpire bool) *RedisStreamUtils {
+	return CreateStreamUtilsMax(paramCli, paramStreamKey, paramExpire, paramAutoExpire, 0)
+}
+
+/*
+创建一个Stream操作工具类
+
+  - paramCli
+  - paramStreamKey 流的key
+  - paramExpire 超时时间，<=0 时表示没有超时， 单位秒
+  - paramAutoExpire 是否在更新后自动更新超时时间
+  - paramMaxLen 流的最大长度 0表示不限制，使用 MAXLEN ~ 近似裁剪，实际长度可能略大于该值
+*/
+func CreateStreamUtilsMax(paramCli *redis.Client, paramStreamKey string, paramExpire int32, paramAutoExpire bool, paramMaxLen int64) *RedisStreamUtils {
+	return &RedisStreamUtils{
+		key:         paramStreamKey,
+		cli:         paramCli,
+		expire:      paramExpire,
+		auto_expire: paramAutoExpire,
+		max_len:     paramMaxLen,
+	}
+}
+
+// 取流的key
+func (m *RedisStreamUtils) GetKey() string {
+	return m.key
+}
+
+// 设置字段更新超时标志
+func (m *RedisStreamUtils) SetAutoExpire(paramValue bool) {
+	m.auto_expire = paramValue
+}
+
+// 设置超时 -1表示设为不过期
+func (m *RedisStreamUtils) ExpireSecond(ctx context.Context, paramSeconds int) {
+	if paramSeconds < 0 {
+		m.cmd().Persist(ctx, m.key)
+	} else {
+		m.cmd().Expire(ctx, m.key, time.Duration(paramSeconds)*time.Second)
+	}
+}
+
+// 更新超时时间
+func (m *RedisStreamUtils) afterExpire(ctx context.Context, paramErr error) {
+	if paramErr == nil && m.auto_expire && m.expire > 0 {
+		m.cmd().Expire(ctx, m.key, time.Duration(m.expire)*time.Second)
+	}
+}
+
+// 取流的长度
+func (m *RedisStreamUtils) Count(ctx context.Context) *redis.IntCmd {
+	retCmd := m.cmd().XLen(ctx, m.key)
+	m.afterExpire(ctx, retCmd.Err())
+	return retCmd
+}
+
+/*
+添加一条消息，返回消息ID
+
+  - paramValues 消息内容，如 map[string]interface{}{"type": "order", "id": 1}
+*/
+func (m *RedisStreamUtils) Add(ctx context.Context, paramValues map[string]interface{}) *redis.StringCmd {
+	args := &redis.XAddArgs{
+		Stream: m.key,
+		Values: paramValues,
+	}
+	if m.max_len > 0 {
+		args.MaxLen = m.max_len
+		args.Approx = true
+	}
+	retCmd := m.cmd().XAdd(ctx, args)
+	m.afterExpire(ctx, retCmd.Err())
+	return retCmd
+}
+
+// 按ID范围获取消息，"-" 和 "+" 表示最小和最大ID
+func (m *RedisStreamUtils) Range(ctx context.Context, paramStart string, paramStop string, paramCount int64) *redis.XMessageSliceCmd {
+	if paramCount > 0 {
+		return m.cmd().XRangeN(ctx, m.key, paramStart, paramStop, paramCount)
+	}
+	return m.cmd().XRange(ctx, m.key, paramStart, paramStop)
+}
+
+// 删除指定ID的消息
+func (m *RedisStreamUtils) Del(ctx context.Context, paramIDs ...string) *redis.IntCmd {
+	return m.cmd().XDel(ctx, m.key, paramIDs...)
+}
+
+// 按最大长度裁剪流(精确裁剪)
+func (m *RedisStreamUtils) Trim(ctx context.Context, paramMaxLen int64) *redis.IntCmd {
+	return m.cmd().XTrimMaxLen(ctx, m.key, paramMaxLen)
+}
+
+/*
+创建消费组，流不存在时自动创建，消费组已存在时不报错
+
+  - paramGroup 消费组名称
+  - paramStartID 开始消费的位置，"0" 表示从头开始(可以回放历史消息)，"$" 表示只消费新消息
+*/
+func (m *RedisStreamUtils) CreateGroup(ctx context.Context, paramGroup string, paramStartID string) error {
+	if m.pipe != nil {
+		return ErrBatchUnsupported
+	}
+	err := m.cmd().XGroupCreateMkStream(ctx, m.key, paramGroup, paramStartID).Err()
+	if err != nil && strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return nil
+	}
+	m.afterExpire(ctx, err)
+	return err
+}
+
+// 删除消费组
+func (m *RedisStreamUtils) DestroyGroup(ctx context.Context, paramGroup string) *redis.IntCmd {
+	return m.cmd().XGroupDestroy(ctx, m.key, paramGroup)
+}
+
+// 重置消费组的读取位置，用于回放消息
+func (m *RedisStreamUtils) SetGroupID(ctx context.Context, paramGroup string, paramStartID string) *redis.StatusCmd {
+	return m.cmd().XGroupSetID(ctx, m.key, paramGroup, paramStartID)
+}
+
+/*
+以消费组的方式读取新消息，没有消息时返回 redis.Nil
+
+  - paramGroup 消费组名称
+  - paramConsumer 消费者名称，同一个组内需唯一
+  - paramCount 最多读取的数量
+  - paramBlock 没有消息时阻塞等待的时间，<0 表示不阻塞，0 表示一直阻塞
+*/
+func (m *RedisStreamUtils) ReadGroup(ctx context.Context, paramGroup string, paramConsumer string, paramCount int64, paramBlock time.Duration) ([]redis.XMessage, error) {
+	if m.pipe != nil {
+		return nil, ErrBatchUnsupported
+	}
+	streams, err := m.cmd().XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    paramGroup,
+		Consumer: paramConsumer,
+		Streams:  []string{m.key, ">"},
+		Count:    paramCount,
+		Block:    paramBlock,
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+	m.afterExpire(ctx, err)
+	if len(streams) == 0 {
+		return nil, redis.Nil
+	}
+	return streams[0].Messages, nil
+}
+
+// 确认消息已处理
+func (m *RedisStreamUtils) Ack(ctx context.Context, paramGroup string, paramIDs ...string) *redis.IntCmd {
+	return m.cmd().XAck(ctx, m.key, paramGroup, paramIDs...)
+}
+
+/*
+查询消费组中未确认的消息
+
+  - paramGroup 消费组名称
+  - paramMinIdle 只返回空闲时间超过该值的消息
+  - paramCount 最多返回的数量
+*/
+func (m *RedisStreamUtils) Pending(ctx context.Context, paramGroup string, paramMinIdle time.Duration, paramCount int64) *redis.XPendingExtCmd {
+	return m.cmd().XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream: m.key,
+		Group:  paramGroup,
+		Idle:   paramMinIdle,
+		Start:  "-",
+		End:    "+",
+		Count:  paramCount,
+	})
+}
+
+/*
+认领其他消费者长时间未确认的消息(XAUTOCLAIM，需要 redis 6.2 以上)
+
+  - paramGroup 消费组名称
+  - paramConsumer 认领到的消费者名称
+  - paramMinIdle 空闲时间超过该值的消息才会被认领
+  - paramStartID 开始扫描的ID，第一次传 "0-0"
+  - paramCount 最多认领的数量
+
+返回认领到的消息和下一次扫描的开始ID，"0-0" 表示已经扫描完一轮
+*/
+func (m *RedisStreamUtils) AutoClaim(ctx context.Context, paramGroup string, paramConsumer string, paramMinIdle time.Duration, paramStartID string, paramCount int64) ([]redis.XMessage, string, error) {
+	if m.pipe != nil {
+		return nil, "", ErrBatchUnsupported
+	}
+	// redis 7 的返回值多了已删除的ID列表，go-redis v8 的 XAutoClaim 无法解析，这里自己解析
+	reply, err := m.cli.Do(ctx, "xautoclaim", m.key, paramGroup, paramConsumer,
+		paramMinIdle.Milliseconds(), paramStartID, "count", paramCount).Slice()
+	if err != nil {
+		return nil, "", err
+	}
+	if len(reply) < 2 {
+		return nil, "", commonutils.NewError(commonutils.ERR_FAIL, "XAUTOCLAIM 返回值格式错误："+m.key)
+	}
+	next, _ := reply[0].(string)
+	entries, _ := reply[1].([]interface{})
+	messages := make([]redis.XMessage, 0, len(entries))
+	for _, entry := range entries {
+		if msg, ok := parseXMessage(entry); ok {
+			messages = append(messages, msg)
+		}
+	}
+	return messages, next, nil
+}
+
+// 解析 [id, [field, value, ...]] 格式的消息，已删除的消息(值为nil)返回 false
+func parseXMessage(paramEntry interface{}) (redis.XMessage, bool) {
+	pair, ok := paramEntry.([]interface{})
+	if !ok || len(pair) != 2 {
+		return redis.XMessage{}, false
+	}
+	id, _ := pair[0].(string)
+	fields, ok := pair[1].([]interface{})
+	if id == "" || !ok {
+		return redis.XMessage{}, false
+	}
+	values := make(map[string]interface{}, len(fields)/2)
+	for i := 0; i+1 < len(fields); i += 2 {
+		values[fmt.Sprint(fields[i])] = fields[i+1]
+	}
+	return redis.XMessage{ID: id, Values: values}, true
+}
+
+/*
+把投递次数达到 paramMaxDeliveries 的未确认消息转入死信流，并在原消费组中确认
+
+每条消息的确认和转入在一个Lua脚本中原子地完成，多个实例同时调用时同一条消息只会转入一次。
+集群模式下死信流需要和原流使用相同的 hash tag，如 {orders} 和 {orders}:dead
+
+  - paramGroup 消费组名称
+  - paramDeadLetter 死信流
+  - paramMaxDeliveries 最大投递次数
+  - paramMinIdle 只处理空闲时间超过该值的消息，避免处理正在被消费的消息
+  - paramCount 每页检查的数量，会从最早的消息开始翻页，直到检查完所有空闲超过 paramMinIdle 的消息
+
+返回转入死信流的消息数量
+*/
+func (m *RedisStreamUtils) MoveToDeadLetter(ctx context.Context, paramGroup string, paramDeadLetter *RedisStreamUtils, paramMaxDeliveries int64, paramMinIdle time.Duration, paramCount int64) (int, error) {
+	if m.pipe != nil {
+		return 0, ErrBatchUnsupported
+	}
+	if paramCount <= 0 {
+		paramCount = 100
+	}
+	moved := 0
+	start := "-"
+	for {
+		pending, err := m.cli.XPendingExt(ctx, &redis.XPendingExtArgs{
+			Stream: m.key,
+			Group:  paramGroup,
+			Idle:   paramMinIdle,
+			Start:  start,
+			End:    "+",
+			Count:  paramCount,
+		}).Result()
+		if err != nil && err != redis.Nil {
+			return moved, err
+		}
+		n, err := m.moveToDeadLetter(ctx, paramGroup, paramDeadLetter, paramMaxDeliveries, pending)
+		moved += n
+		if err != nil {
+			return moved, err
+		}
+		if int64(len(pending)) < paramCount {
+			return moved, nil
+		}
+		start = nextStreamID(pending[len(pending)-1].ID)
+	}
+}
+
+// 流ID的下一个ID，用于 XPENDING 翻页时从上一页最后一条之后开始
+func nextStreamID(paramID string) string {
+	i := strings.IndexByte(paramID, '-')
+	if i < 0 {
+		return paramID + "-1"
+	}
+	ms, err1 := strconv.ParseUint(paramID[:i], 10, 64)
+	seq, err2 := strconv.ParseUint(paramID[i+1:], 10, 64)
+	if err1 != nil || err2 != nil {
+		return paramID
+	}
+	if seq == math.MaxUint64 {
+		return strconv.FormatUint(ms+1, 10) + "-0"
+	}
+	return strconv.FormatUint(ms, 10) + "-" + strconv.FormatUint(seq+1, 10)
+}
+
+// KEYS: 原流, 死信流
+// ARGV: 消费组, 消息ID, 已投递次数, 死信流最大长度, 死信流超时秒数
+// 先确认再转入，确认失败说明已经被其他调用转入或被消费者确认，不会重复转入
+var streamDeadLetterScript = redis.NewScript(`
+if redis.call('XACK', KEYS[1], ARGV[1], ARGV[2]) == 0 then
+	return 0
+end
+local msgs = redis.call('XRANGE', KEYS[1], ARGV[2], ARGV[2])
+if #msgs == 0 then
+	return 1
+end
+local args = {'XADD', KEYS[2]}
+if tonumber(ARGV[4]) > 0 then
+	table.insert(args, 'MAXLEN')
+	table.insert(args, '~')
+	table.insert(args, ARGV[4])
+end
+table.insert(args, '*')
+for _, v in ipairs(msgs[1][2]) do
+	table.insert(args, v)
+end
+for _, v in ipairs({'` + STREAM_DEAD_LETTER_SOURCE_ID + `', ARGV[2], '` + STREAM_DEAD_LETTER_DELIVERIES + `', ARGV[3],
+	'` + STREAM_DEAD_LETTER_SOURCE_KEY + `', KEYS[1], '` + STREAM_DEAD_LETTER_SOURCE_GROUP + `', ARGV[1]}) do
+	table.insert(args, v)
+end
+redis.call(unpack(args))
+if tonumber(ARGV[5]) > 0 then
+	redis.call('EXPIRE', KEYS[2], ARGV[5])
+end
+return 1
+`)
+
+// 转入一页待确认消息中投递次数达到上限的消息，确认和转入在一个Lua脚本中原子地完成
+func (m *RedisStreamUtils) moveToDeadLetter(ctx context.Context, paramGroup string, paramDeadLetter *RedisStreamUtils, paramMaxDeliveries int64, paramPending []redis.XPendingExt) (int, error) {
+	expire := int32(0)
+	if paramDeadLetter.auto_expire && paramDeadLetter.expire > 0 {
+		expire = paramDeadLetter.expire
+	}
+	moved := 0
+	for _, p := range paramPending {
+		if p.RetryCount < paramMaxDeliveries {
+			continue
+		}
+		// 消息已经被删除或裁剪时只确认
+		ok, err := streamDeadLetterScript.Run(ctx, m.cli, []string{m.key, paramDeadLetter.key},
+			paramGroup, p.ID, p.RetryCount, paramDeadLetter.max_len, expire).Int()
+		if err != nil {
+			return moved, err
+		}
+		moved += ok
+	}
+	return moved, nil
+}
+
+// 消费循环的配置
+type StreamConsumeOptions struct {
+	Count         int64             // 每次读取的最大数量，<=0 时为10
+	Block         time.Duration     // 没有消息时阻塞等待的时间，<=0 时为5秒
+	ClaimMinIdle  time.Duration     // 未确认超过该时间的消息会被当前消费者认领重试，0 表示不认领
+	ClaimInterval time.Duration     // 检查未确认消息的间隔，<=0 时与 ClaimMinIdle 相同
+	MaxDeliveries int64             // 投递次数达到该值后转入死信流，0 表示不处理
+	DeadLetter    *RedisStreamUtils // 死信流，为nil时不处理
+}
+
+/*
+以消费组的方式循环消费消息，直到 ctx 被取消
+
+  - paramGroup 消费组名称，需要先调用 CreateGroup 创建
+  - paramConsumer 消费者名称，同一个组内需唯一
+  - paramOptions 配置
+  - paramHandler 消息处理函数，返回nil时确认消息，返回错误时消息保持未确认，等待认领后重试
+
+redis 出错时会等待一秒后重试，ctx 取消时返回 ctx.Err()
+*/
+func (m *RedisStreamUtils) Consume(ctx context.Context, paramGroup string, paramConsumer string, paramOptions StreamConsumeOptions, paramHandler func(context.Context, redis.XMessage) error) error {
+	if m.pipe != nil {
+		return ErrBatchUnsupported
+	}
+	count := paramOptions.Count
+	if count <= 0 {
+		count = 10
+	}
+	block := paramOptions.Block
+	if block <= 0 {
+		block = 5 * time.Second
+	}
+	claimInterval := paramOptions.ClaimInterval
+	if claimInterval <= 0 {
+		claimInterval = paramOptions.ClaimMinIdle
+	}
+
+	handle := func(paramMessages []redis.XMessage) {
+		for _, msg := range paramMessages {
+			if ctx.Err() != nil {
+				return
+			}
+			if paramHandler(ctx, msg) == nil {
+				m.Ack(ctx, paramGroup, msg.ID)
+			}
+		}
+	}
+
+	var lastClaim time.Time
+	claimStart := "0-0"
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if paramOptions.ClaimMinIdle > 0 && time.Since(lastClaim) >= claimInterval {
+			lastClaim = time.Now()
+			if paramOptions.MaxDeliveries > 0 && paramOptions.DeadLetter != nil {
+				if _, err := m.MoveToDeadLetter(ctx, paramGroup, paramOptions.DeadLetter, paramOptions.MaxDeliveries, paramOptions.ClaimMinIdle, count); err != nil {
+					if !sleepContext(ctx, time.Second) {
+						return ctx.Err()
+					}
+					continue
+				}
+			}
+			claimed, next, err := m.AutoClaim(ctx, paramGroup, paramConsumer, paramOptions.ClaimMinIdle, claimStart, count)
+			if err == nil {
+				claimStart = next
+				handle(claimed)
+			}
+		}
+
+		messages, err := m.ReadGroup(ctx, paramGroup, paramConsumer, count, block)
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			if !sleepContext(ctx, time.Second) {
+				return ctx.Err()
+			}
+			continue
+		}
+		handle(messages)
+	}
+}
+
+// 等待指定时间，ctx 被取消时返回 false
+func sleepContext(ctx context.Context, paramDuration time.Duration) bool {
+	t := time.NewTimer(paramDuration)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+// 取执行命令的客户端，批量模式下为管道
+func (m *RedisStreamUtils) cmd() redis.Cmdable {
+	if m.pipe != nil {
+		return m.pipe
+	}
+	return m.cli
+}
+
+// 返回绑定到批量操作的副本，副本的命令会写入批量操作，在 RedisBatch.Exec 时一起执行
+func (m *RedisStreamUtils) Batch(paramBatch *RedisBatch) *RedisStreamUtils {
+	c := *m
+	c.pipe = paramBatch.pipe
+	return &c
+}
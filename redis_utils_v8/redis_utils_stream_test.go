@@ -0,0 +1,204 @@
+package redisv8
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	redis "github.com/go-redis/redis/v8"
+)
+
+func TestNextStreamID(t *testing.T) {
+	cases := map[string]string{
+		"1-0":                    "1-1",
+		"1700000000000-41":       "1700000000000-42",
+		"5-18446744073709551615": "6-0",
+		"7":                      "7-1",
+	}
+	for id, want := range cases {
+		if got := nextStreamID(id); got != want {
+			t.Errorf("nextStreamID(%q) = %q, want %q", id, got, want)
+		}
+	}
+}
+
+func TestStreamMoveToDeadLetterPages(t *testing.T) {
+	ctx := context.Background()
+	cli, _ := newTestClient(t)
+	stream := CreateStreamUtils(cli, "orders", 0, false)
+	dead := CreateStreamUtils(cli, "orders:dead", 0, false)
+	if err := stream.CreateGroup(ctx, "g", "0"); err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]string, 5)
+	for i := range ids {
+		id, err := stream.Add(ctx, map[string]interface{}{"n": i}).Result()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[i] = id
+	}
+	if _, err := stream.ReadGroup(ctx, "g", "c1", 10, -1); err != nil {
+		t.Fatal(err)
+	}
+	// 最早的两条还没有达到最大投递次数，后面的三条达到
+	for _, id := range ids[2:] {
+		if err := cli.Do(ctx, "XCLAIM", "orders", "g", "c1", 0, id, "RETRYCOUNT", 3).Err(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	moved, err := stream.MoveToDeadLetter(ctx, "g", dead, 3, 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved != 3 {
+		t.Fatalf("moved = %d, want 3", moved)
+	}
+	msgs, err := dead.Range(ctx, "-", "+", 10).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 3 {
+		t.Fatalf("dead letters = %d, want 3", len(msgs))
+	}
+	for i, msg := range msgs {
+		if msg.Values[STREAM_DEAD_LETTER_SOURCE_ID] != ids[i+2] || msg.Values["n"] != fmt.Sprint(i+2) {
+			t.Errorf("dead letter %d = %v", i, msg.Values)
+		}
+	}
+	pending, err := stream.Pending(ctx, "g", 0, 10).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 2 || pending[0].ID != ids[0] || pending[1].ID != ids[1] {
+		t.Errorf("pending = %v, want the first two", pending)
+	}
+}
+
+func TestStreamMoveToDeadLetterOnce(t *testing.T) {
+	ctx := context.Background()
+	cli, mr := newTestClient(t)
+	stream := CreateStreamUtils(cli, "{orders}", 0, false)
+	dead := CreateStreamUtilsMax(cli, "{orders}:dead", 60, true, 100)
+	stream.CreateGroup(ctx, "g", "0")
+	id, _ := stream.Add(ctx, map[string]interface{}{"n": 1}).Result()
+	stream.ReadGroup(ctx, "g", "c1", 10, -1)
+	pending, err := stream.Pending(ctx, "g", 0, 10).Result()
+	if err != nil || len(pending) != 1 {
+		t.Fatalf("pending = %v, %v", pending, err)
+	}
+
+	// 两个实例读到同一页待确认消息，只有一个能转入
+	for i := 0; i < 2; i++ {
+		moved, err := stream.moveToDeadLetter(ctx, "g", dead, 1, pending)
+		if err != nil || moved != 1-i {
+			t.Fatalf("moveToDeadLetter #%d = %d, %v", i, moved, err)
+		}
+	}
+	msgs, _ := dead.Range(ctx, "-", "+", 10).Result()
+	if len(msgs) != 1 || msgs[0].Values[STREAM_DEAD_LETTER_SOURCE_ID] != id ||
+		msgs[0].Values[STREAM_DEAD_LETTER_SOURCE_KEY] != "{orders}" || msgs[0].Values[STREAM_DEAD_LETTER_SOURCE_GROUP] != "g" ||
+		msgs[0].Values[STREAM_DEAD_LETTER_DELIVERIES] != "1" || msgs[0].Values["n"] != "1" {
+		t.Fatalf("dead letters = %v", msgs)
+	}
+	if mr.TTL("{orders}:dead") != 60*time.Second {
+		t.Fatal("dead letter expire not set")
+	}
+	if n, _ := stream.Count(ctx).Result(); n != 1 {
+		t.Fatalf("source len = %d", n)
+	}
+}
+
+func TestStreamAutoClaim(t *testing.T) {
+	ctx := context.Background()
+	cli, _ := newTestClient(t)
+	stream := CreateStreamUtils(cli, "orders", 0, false)
+	stream.CreateGroup(ctx, "g", "0")
+	id, _ := stream.Add(ctx, map[string]interface{}{"n": 1}).Result()
+	stream.ReadGroup(ctx, "g", "c1", 10, -1)
+
+	// 空闲时间没有达到时不认领
+	if msgs, _, err := stream.AutoClaim(ctx, "g", "c2", time.Hour, "0-0", 10); err != nil || len(msgs) != 0 {
+		t.Fatalf("AutoClaim busy = %v, %v", msgs, err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	msgs, next, err := stream.AutoClaim(ctx, "g", "c2", 10*time.Millisecond, "0-0", 10)
+	if err != nil || len(msgs) != 1 || msgs[0].ID != id || msgs[0].Values["n"] != "1" || next != "0-0" {
+		t.Fatalf("AutoClaim = %v, %q, %v", msgs, next, err)
+	}
+	pending, _ := stream.Pending(ctx, "g", 0, 10).Result()
+	if len(pending) != 1 || pending[0].Consumer != "c2" || pending[0].RetryCount != 2 {
+		t.Fatalf("pending = %+v", pending)
+	}
+
+	b := CreateBatch(cli)
+	if _, _, err := stream.Batch(b).AutoClaim(ctx, "g", "c2", 0, "0-0", 10); err != ErrBatchUnsupported {
+		t.Fatalf("batch AutoClaim err = %v", err)
+	}
+}
+
+func TestStreamConsumeRetry(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cli, _ := newTestClient(t)
+	stream := CreateStreamUtils(cli, "orders", 0, false)
+	dead := CreateStreamUtils(cli, "orders:dead", 0, false)
+	stream.CreateGroup(ctx, "g", "0")
+	okID, _ := stream.Add(ctx, map[string]interface{}{"kind": "ok"}).Result()
+	flakyID, _ := stream.Add(ctx, map[string]interface{}{"kind": "flaky"}).Result()
+	badID, _ := stream.Add(ctx, map[string]interface{}{"kind": "bad"}).Result()
+
+	var mu sync.Mutex
+	calls := make(map[string]int)
+	done := make(chan error, 1)
+	go func() {
+		done <- stream.Consume(ctx, "g", "c1", StreamConsumeOptions{
+			Block:         20 * time.Millisecond,
+			ClaimMinIdle:  30 * time.Millisecond,
+			MaxDeliveries: 3,
+			DeadLetter:    dead,
+		}, func(ctx context.Context, paramMsg redis.XMessage) error {
+			mu.Lock()
+			defer mu.Unlock()
+			calls[paramMsg.ID]++
+			switch paramMsg.Values["kind"] {
+			case "flaky":
+				// 第一次失败，认领后重试成功
+				if calls[paramMsg.ID] == 1 {
+					return errors.New("flaky")
+				}
+			case "bad":
+				return errors.New("bad")
+			}
+			return nil
+		})
+	}()
+
+	waitFor(t, "dead letter", func() bool {
+		n, _ := dead.Count(ctx).Result()
+		return n == 1
+	})
+	waitFor(t, "all acked", func() bool {
+		pending, _ := stream.Pending(ctx, "g", 0, 10).Result()
+		return len(pending) == 0
+	})
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("Consume err = %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	// 成功的消息只处理一次，失败的消息认领后重试，超过最大投递次数后转入死信流
+	if calls[okID] != 1 || calls[flakyID] != 2 || calls[badID] < 2 || calls[badID] > 3 {
+		t.Fatalf("calls = %v", calls)
+	}
+	msgs, _ := dead.Range(context.Background(), "-", "+", 10).Result()
+	if len(msgs) != 1 || msgs[0].Values[STREAM_DEAD_LETTER_SOURCE_ID] != badID {
+		t.Fatalf("dead letters = %v", msgs)
+	}
+}
@@ -0,0 +1,17 @@
+package redisv8
+
+import (
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	redis "github.com/go-redis/redis/v8"
+)
+
+// 创建测试用的 redis 客户端，连接到进程内的 miniredis，测试结束后关闭
+func newTestClient(t *testing.T) (*redis.Client, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	cli := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { cli.Close() })
+	return cli, mr
+}
@@ -17,8 +17,9 @@ const (
 type RedisZSetUtils struct {
 	key         string
 	cli         *redis.Client
-	expire      int32 // 超时时间单位秒
-	auto_expire bool  // 是否自动更新超期时间  否则手动更新，默认自动更新
+	pipe        redis.Pipeliner // 批量执行时命令写入的管道，nil 表示直接执行
+	expire      int32           // 超时时间单位秒
+	auto_expire bool            // 是否自动更新超期时间  否则手动更新，默认自动更新
 }
 
 /*
@@ -50,30 +51,30 @@ func (m *RedisZSetUtils) SetAutoExpire(paramValue bool) {
 // 设置超时 -1表示设为不过期
 func (m *RedisZSetUtils) ExpireSecond(ctx context.Context, paramSeconds int) {
 	if paramSeconds < 0 {
-		m.cli.Persist(ctx, m.key)
+		m.cmd().Persist(ctx, m.key)
 	} else {
-		m.cli.Expire(ctx, m.key, time.Duration(paramSeconds)*time.Second)
+		m.cmd().Expire(ctx, m.key, time.Duration(paramSeconds)*time.Second)
 	}
 }
 
 // 获取集合的数量
 func (m *RedisZSetUtils) Count(ctx context.Context) *redis.IntCmd {
-	return m.cli.ZCard(ctx, m.key)
+	return m.cmd().ZCard(ctx, m.key)
 }
 
 // 获取指定分数范围内成员的数量
 func (m *RedisZSetUtils) CountByScore(ctx context.Context, paramMinScore, paramMaxScore float64) *redis.IntCmd {
-	return m.cli.ZCount(ctx, m.key, commonutils.Float2Str(paramMinScore), commonutils.Float2Str(paramMaxScore))
+	return m.cmd().ZCount(ctx, m.key, commonutils.Float2Str(paramMinScore), commonutils.Float2Str(paramMaxScore))
 }
 
 // 获取指定分数范围内成员的数量（指定最小分数）
 func (m *RedisZSetUtils) CountByMinScore(ctx context.Context, paramMinScore float64) *redis.IntCmd {
-	return m.cli.ZCount(ctx, m.key, commonutils.Float2Str(paramMinScore), MAX_VALUE)
+	return m.cmd().ZCount(ctx, m.key, commonutils.Float2Str(paramMinScore), MAX_VALUE)
 }
 
 // 获取指定分数范围内成员的数量（指定最大分数）
 func (m *RedisZSetUtils) CountByMaxScore(ctx context.Context, paramMaxScore float64) *redis.IntCmd {
-	return m.cli.ZCount(ctx, m.key, MIN_VALUE, commonutils.Float2Str(paramMaxScore))
+	return m.cmd().ZCount(ctx, m.key, MIN_VALUE, commonutils.Float2Str(paramMaxScore))
 }
 
 // 获取指定分数范围内成员的数量（整数分数）
@@ -86,8 +87,11 @@ func (m *RedisZSetUtils) CountByIntMaxScore(ctx context.Context, paramMaxScore i
 	return m.CountByMaxScore(ctx, float64(paramMaxScore))
 }
 
-// 清除分数
+// 清除分数，需要先读取成员列表，批量模式下返回 ErrBatchUnsupported
 func (m *RedisZSetUtils) ZeroScore(ctx context.Context) error {
+	if m.pipe != nil {
+		return ErrBatchUnsupported
+	}
 	var r error
 
 	for range [1]int{} {
@@ -104,7 +108,7 @@ func (m *RedisZSetUtils) ZeroScore(ctx context.Context) error {
 		for _, member := range list {
 			updateList = append(updateList, &redis.Z{Member: member, Score: 0})
 			if len(updateList) >= 500 {
-				err = m.cli.ZAdd(ctx, m.key, updateList...).Err()
+				err = m.cmd().ZAdd(ctx, m.key, updateList...).Err()
 				if err != nil {
 					r = commonutils.NewError(commonutils.ERR_FAIL, "更新分数失败："+m.key+" err:"+err.Error())
 					break
@@ -116,7 +120,7 @@ func (m *RedisZSetUtils) ZeroScore(ctx context.Context) error {
 			break
 		}
 		if len(updateList) > 0 {
-			err := m.cli.ZAdd(ctx, m.key, updateList...).Err()
+			err := m.cmd().ZAdd(ctx, m.key, updateList...).Err()
 			if err != nil {
 				r = commonutils.NewError(commonutils.ERR_FAIL, "更新分数失败："+m.key+" err:"+err.Error())
 				break
@@ -133,73 +137,73 @@ func (m *RedisZSetUtils) CountByIntMinScore(ctx context.Context, paramMinScore i
 
 // 增加成员或设置成员
 func (m *RedisZSetUtils) Add(ctx context.Context, paramMembers ...*redis.Z) *redis.IntCmd {
-	return m.cli.ZAdd(ctx, m.key, paramMembers...)
+	return m.cmd().ZAdd(ctx, m.key, paramMembers...)
 }
 
 // 增加一个成员或设置成员
 func (m *RedisZSetUtils) AddOne(ctx context.Context, paramMember string, paramScore float64) *redis.IntCmd {
-	return m.cli.ZAdd(ctx, m.key, &redis.Z{Member: paramMember, Score: paramScore})
+	return m.cmd().ZAdd(ctx, m.key, &redis.Z{Member: paramMember, Score: paramScore})
 }
 
 // 增加一个成员或设置成员
 func (m *RedisZSetUtils) AddOneIntScore(ctx context.Context, paramMember string, paramScore int64) *redis.IntCmd {
-	return m.cli.ZAdd(ctx, m.key, &redis.Z{Member: paramMember, Score: float64(paramScore)})
+	return m.cmd().ZAdd(ctx, m.key, &redis.Z{Member: paramMember, Score: float64(paramScore)})
 }
 
 // 移除指定member的元素
 func (m *RedisZSetUtils) Remove(ctx context.Context, paramMembers ...interface{}) *redis.IntCmd {
-	return m.cli.ZRem(ctx, m.key, paramMembers...)
+	return m.cmd().ZRem(ctx, m.key, paramMembers...)
 }
 
 // 移除指定排名范围内的元素 排名值从0开始
 func (m *RedisZSetUtils) RemoveRangeByRank(ctx context.Context, paramStartRank, paramStopRank int64) *redis.IntCmd {
-	return m.cli.ZRemRangeByRank(ctx, m.key, paramStartRank, paramStopRank)
+	return m.cmd().ZRemRangeByRank(ctx, m.key, paramStartRank, paramStopRank)
 }
 
 // 移除指定分数范围内的元素（浮点分数）
 func (m *RedisZSetUtils) RemoveRangeByScore(ctx context.Context, paramMinScore, paramMaxScore float64) *redis.IntCmd {
-	return m.cli.ZRemRangeByScore(ctx, m.key, commonutils.Float2Str(paramMinScore), commonutils.Float2Str(paramMaxScore))
+	return m.cmd().ZRemRangeByScore(ctx, m.key, commonutils.Float2Str(paramMinScore), commonutils.Float2Str(paramMaxScore))
 }
 
 // 移除指定分数范围内的元素(整数分数)
 func (m *RedisZSetUtils) RemoveRangeByIntScore(ctx context.Context, paramMinScore, paramMaxScore int64) *redis.IntCmd {
-	return m.cli.ZRemRangeByScore(ctx, m.key, commonutils.I(paramMinScore), commonutils.I(paramMaxScore))
+	return m.cmd().ZRemRangeByScore(ctx, m.key, commonutils.I(paramMinScore), commonutils.I(paramMaxScore))
 }
 
 // 给指定成员增加分数
 func (m *RedisZSetUtils) IncrementScore(ctx context.Context, paramMember string, paramIncrement float64) *redis.FloatCmd {
-	return m.cli.ZIncrBy(ctx, m.key, paramIncrement, paramMember)
+	return m.cmd().ZIncrBy(ctx, m.key, paramIncrement, paramMember)
 }
 
 // 给指定成员增加分数
 func (m *RedisZSetUtils) IncrementScoreIntScore(ctx context.Context, paramMember string, paramIncrement int64) *redis.FloatCmd {
-	return m.cli.ZIncrBy(ctx, m.key, float64(paramIncrement), paramMember)
+	return m.cmd().ZIncrBy(ctx, m.key, float64(paramIncrement), paramMember)
 }
 
 // 获取指定成员的分数
 func (m *RedisZSetUtils) GetScore(ctx context.Context, paramMember string) *redis.FloatCmd {
-	return m.cli.ZScore(ctx, m.key, paramMember)
+	return m.cmd().ZScore(ctx, m.key, paramMember)
 }
 
 // 取指定排名范围内的成员列表 排名值从0开始(按分数重小到大， 顺序)
 // 是包括【startRank, stopRank】的
 func (m *RedisZSetUtils) MemberListByRank(ctx context.Context, paramStartRank, paramStopRank int64) *redis.StringSliceCmd {
-	return m.cli.ZRange(ctx, m.key, paramStartRank, paramStopRank)
+	return m.cmd().ZRange(ctx, m.key, paramStartRank, paramStopRank)
 }
 
 func (m *RedisZSetUtils) MemberListWithScore(ctx context.Context, paramStartRank, paramStopRank int64) *redis.ZSliceCmd {
-	return m.cli.ZRangeWithScores(ctx, m.key, paramStartRank, paramStopRank)
+	return m.cmd().ZRangeWithScores(ctx, m.key, paramStartRank, paramStopRank)
 }
 
 // 倒叙
 func (m *RedisZSetUtils) MemberListRevWithScore(ctx context.Context, limit int64) *redis.ZSliceCmd {
-	return m.cli.ZRevRangeByScoreWithScores(ctx, m.key, &redis.ZRangeBy{Offset: 0, Count: limit, Min: "-inf", Max: "+inf"})
+	return m.cmd().ZRevRangeByScoreWithScores(ctx, m.key, &redis.ZRangeBy{Offset: 0, Count: limit, Min: "-inf", Max: "+inf"})
 	//return m.cli.ZRevRangeWithScores(ctx, m.key, paramStartRank, limit)
 }
 
 // 倒叙
 func (m *RedisZSetUtils) MemberListRevWithScore2(ctx context.Context, paramStartRank, paramStopRank int64) *redis.ZSliceCmd {
-	return m.cli.ZRevRangeWithScores(ctx, m.key, paramStartRank, paramStopRank)
+	return m.cmd().ZRevRangeWithScores(ctx, m.key, paramStartRank, paramStopRank)
 }
 
 // 取指定排名范围内的成员列表 排名值从0开始(按分数重大到小， 逆序)
@@ -210,7 +214,7 @@ func (m *RedisZSetUtils) MemberListByScore(ctx context.Context, paramMinScore, p
 		Offset: 0,
 		Count:  -1,
 	}
-	return m.cli.ZRangeByScore(ctx, m.key, opt)
+	return m.cmd().ZRangeByScore(ctx, m.key, opt)
 }
 
 /*
@@ -230,7 +234,7 @@ func (m *RedisZSetUtils) MemberListByMinScore(ctx context.Context, paramMinScore
 		Offset: 0,
 		Count:  -1,
 	}
-	return m.cli.ZRangeByScore(ctx, m.key, opt)
+	return m.cmd().ZRangeByScore(ctx, m.key, opt)
 }
 
 /*
@@ -250,13 +254,13 @@ func (m *RedisZSetUtils) MemberListByMaxScore(ctx context.Context, paramMaxScore
 		Offset: 0,
 		Count:  -1,
 	}
-	return m.cli.ZRangeByScore(ctx, m.key, opt)
+	return m.cmd().ZRangeByScore(ctx, m.key, opt)
 }
 
 // 取指定排名范围内的成员列表 排名值从0开始（按分数重大到小， 逆序）
 // 是包括【startRank, stopRank】的
 func (m *RedisZSetUtils) MemberListByRankRevScore(ctx context.Context, paramStartRank, paramStopRank int64) *redis.StringSliceCmd {
-	return m.cli.ZRevRange(ctx, m.key, paramStartRank, paramStopRank)
+	return m.cmd().ZRevRange(ctx, m.key, paramStartRank, paramStopRank)
 }
 
 // 删除小于最大分数的成员
@@ -265,7 +269,7 @@ func (m *RedisZSetUtils) RemoveByMaxScore(ctx context.Context, paramMaxScore flo
 	if !paramIncludeMax {
 		MaxValue = "(" + MaxValue
 	}
-	return m.cli.ZRemRangeByScore(ctx, m.key, MIN_VALUE, MaxValue)
+	return m.cmd().ZRemRangeByScore(ctx, m.key, MIN_VALUE, MaxValue)
 }
 
 /*
@@ -273,5 +277,20 @@ func (m *RedisZSetUtils) RemoveByMaxScore(ctx context.Context, paramMaxScore flo
 - paramOtherSetKey 另一个有序集合的key
 */
 func (m *RedisZSetUtils) Intersect(ctx context.Context, paramOtherSetKey string) *redis.IntCmd {
-	return m.cli.ZInterStore(ctx, m.key, &redis.ZStore{Keys: []string{m.key, paramOtherSetKey}, Weights: []float64{1, 0}})
+	return m.cmd().ZInterStore(ctx, m.key, &redis.ZStore{Keys: []string{m.key, paramOtherSetKey}, Weights: []float64{1, 0}})
+}
+
+// 取执行命令的客户端，批量模式下为管道
+func (m *RedisZSetUtils) cmd() redis.Cmdable {
+	if m.pipe != nil {
+		return m.pipe
+	}
+	return m.cli
+}
+
+// 返回绑定到批量操作的副本，副本的命令会写入批量操作，在 RedisBatch.Exec 时一起执行
+func (m *RedisZSetUtils) Batch(paramBatch *RedisBatch) *RedisZSetUtils {
+	c := *m
+	c.pipe = paramBatch.pipe
+	return &c
 }
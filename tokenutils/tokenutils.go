@@ -0,0 +1,192 @@
+package tokenutils
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/qiuliaogit/commonutils/commonutils"
+)
+
+// 常用的令牌用途
+const (
+	TOKEN_PURPOSE_RESET_PASSWORD = "reset_password" // 重置密码
+	TOKEN_PURPOSE_VERIFY_EMAIL   = "verify_email"   // 验证邮箱
+)
+
+var (
+	ErrTokenInvalid         = commonutils.NewError(commonutils.ERR_FAIL, "令牌格式错误或签名无效")
+	ErrTokenUnknownKey      = commonutils.NewError(commonutils.ERR_FAIL, "令牌的签名密钥不存在")
+	ErrTokenExpired         = commonutils.NewError(commonutils.ERR_FAIL, "令牌已过期")
+	ErrTokenPurpose         = commonutils.NewError(commonutils.ERR_FAIL, "令牌用途不匹配")
+	ErrTokenPasswordChanged = commonutils.NewError(commonutils.ERR_FAIL, "密码已修改，令牌失效")
+	ErrTokenUsed            = commonutils.NewError(commonutils.ERR_FAIL, "令牌已被使用")
+)
+
+var tokenEncoding = base64.RawURLEncoding
+
+// 令牌中携带的数据
+type TokenClaims struct {
+	ID          string `json:"i"`           // 令牌的唯一ID，用于一次性使用的检查
+	UserID      string `json:"u"`           // 用户ID
+	Purpose     string `json:"p"`           // 用途
+	ExpiresAt   int64  `json:"e"`           // 过期时间 unix 秒
+	Fingerprint string `json:"f,omitempty"` // 签发时密码哈希的指纹，为空表示不绑定密码
+
+	keyID string // 签名使用的密钥ID，由 Parse 设置
+}
+
+// 过期时间
+func (c *TokenClaims) ExpireTime() time.Time {
+	return time.Unix(c.ExpiresAt, 0)
+}
+
+/*
+令牌签发和校验工具
+
+令牌格式为 "密钥ID.数据.签名"，均为URL安全的base64，可以直接放到链接里。
+支持多个密钥：使用当前密钥签发，使用令牌中的密钥ID对应的密钥校验，便于密钥轮换。
+*/
+type TokenSigner struct {
+	keys       map[string][]byte
+	currentKey string
+}
+
+/*
+创建令牌签发工具
+
+  - paramCurrentKeyID 用于签发新令牌的密钥ID
+  - paramKeys 所有可用于校验的密钥，密钥ID只能包含字母、数字、"-"和"_"
+*/
+func NewTokenSigner(paramCurrentKeyID string, paramKeys map[string][]byte) (*TokenSigner, error) {
+	keys := make(map[string][]byte, len(paramKeys))
+	for id, key := range paramKeys {
+		if !isValidKeyID(id) {
+			return nil, commonutils.NewError(commonutils.ERR_FAIL, "密钥ID格式错误："+id)
+		}
+		if len(key) < 16 {
+			return nil, commonutils.NewError(commonutils.ERR_FAIL, "密钥长度不能少于16字节："+id)
+		}
+		keys[id] = key
+	}
+	if _, ok := keys[paramCurrentKeyID]; !ok {
+		return nil, commonutils.NewError(commonutils.ERR_FAIL, "当前密钥不存在："+paramCurrentKeyID)
+	}
+	return &TokenSigner{keys: keys, currentKey: paramCurrentKeyID}, nil
+}
+
+func isValidKeyID(paramID string) bool {
+	if paramID == "" {
+		return false
+	}
+	for _, c := range paramID {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *TokenSigner) sign(paramKey []byte, paramData string) []byte {
+	mac := hmac.New(sha256.New, paramKey)
+	mac.Write([]byte(paramData))
+	return mac.Sum(nil)
+}
+
+// 计算密码哈希的指纹，密码修改后指纹随之改变
+func (s *TokenSigner) fingerprint(paramKey []byte, paramPasswordHash string) string {
+	if paramPasswordHash == "" {
+		return ""
+	}
+	return tokenEncoding.EncodeToString(s.sign(paramKey, "pwd:"+paramPasswordHash)[:12])
+}
+
+/*
+签发令牌
+
+  - paramUserID 用户ID
+  - paramPurpose 用途，如 TOKEN_PURPOSE_RESET_PASSWORD
+  - paramTTL 有效时长
+  - paramPasswordHash 用户当前的密码哈希，不为空时密码修改后令牌失效；为空表示不绑定
+*/
+func (s *TokenSigner) Issue(paramUserID string, paramPurpose string, paramTTL time.Duration, paramPasswordHash string) (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	key := s.keys[s.currentKey]
+	claims := TokenClaims{
+		ID:          tokenEncoding.EncodeToString(id),
+		UserID:      paramUserID,
+		Purpose:     paramPurpose,
+		ExpiresAt:   time.Now().Add(paramTTL).Unix(),
+		Fingerprint: s.fingerprint(key, paramPasswordHash),
+	}
+	data, err := json.Marshal(&claims)
+	if err != nil {
+		return "", err
+	}
+	signed := s.currentKey + "." + tokenEncoding.EncodeToString(data)
+	return signed + "." + tokenEncoding.EncodeToString(s.sign(key, signed)), nil
+}
+
+/*
+校验令牌的签名、用途和有效期，返回令牌中的数据
+
+  - paramToken 令牌
+  - paramPurpose 期望的用途
+*/
+func (s *TokenSigner) Parse(paramToken string, paramPurpose string) (*TokenClaims, error) {
+	parts := strings.Split(paramToken, ".")
+	if len(parts) != 3 {
+		return nil, ErrTokenInvalid
+	}
+	key, ok := s.keys[parts[0]]
+	if !ok {
+		return nil, ErrTokenUnknownKey
+	}
+	sig, err := tokenEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(sig, s.sign(key, parts[0]+"."+parts[1])) {
+		return nil, ErrTokenInvalid
+	}
+	data, err := tokenEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrTokenInvalid
+	}
+	claims := &TokenClaims{}
+	if err := json.Unmarshal(data, claims); err != nil {
+		return nil, ErrTokenInvalid
+	}
+	claims.keyID = parts[0]
+	if claims.Purpose != paramPurpose {
+		return nil, ErrTokenPurpose
+	}
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return nil, ErrTokenExpired
+	}
+	return claims, nil
+}
+
+/*
+检查令牌签发后用户的密码是否修改过
+
+  - paramClaims Parse 返回的数据
+  - paramPasswordHash 用户当前的密码哈希
+*/
+func (s *TokenSigner) CheckPasswordHash(paramClaims *TokenClaims, paramPasswordHash string) error {
+	if paramClaims.Fingerprint == "" {
+		return nil
+	}
+	key, ok := s.keys[paramClaims.keyID]
+	if !ok {
+		return ErrTokenUnknownKey
+	}
+	if !hmac.Equal([]byte(paramClaims.Fingerprint), []byte(s.fingerprint(key, paramPasswordHash))) {
+		return ErrTokenPasswordChanged
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package tokenutils
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func testSigner(t *testing.T, paramCurrent string) *TokenSigner {
+	t.Helper()
+	s, err := NewTokenSigner(paramCurrent, map[string][]byte{
+		"k1": []byte("0123456789abcdef0123456789abcdef"),
+		"k2": []byte("fedcba9876543210fedcba9876543210"),
+	})
+	if err != nil {
+		t.Fatalf("NewTokenSigner error: %v", err)
+	}
+	return s
+}
+
+func TestTokenIssueAndParse(t *testing.T) {
+	s := testSigner(t, "k1")
+	token, err := s.Issue("1001", TOKEN_PURPOSE_RESET_PASSWORD, time.Hour, "hash-v1")
+	if err != nil {
+		t.Fatalf("Issue error: %v", err)
+	}
+	if strings.ContainsAny(token, "+/=") {
+		t.Errorf("token is not URL safe: %s", token)
+	}
+
+	claims, err := s.Parse(token, TOKEN_PURPOSE_RESET_PASSWORD)
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if claims.UserID != "1001" || claims.ID == "" {
+		t.Errorf("unexpected claims: %+v", claims)
+	}
+	if err := s.CheckPasswordHash(claims, "hash-v1"); err != nil {
+		t.Errorf("CheckPasswordHash same hash = %v", err)
+	}
+	if err := s.CheckPasswordHash(claims, "hash-v2"); err != ErrTokenPasswordChanged {
+		t.Errorf("CheckPasswordHash changed hash = %v", err)
+	}
+
+	if _, err := s.Parse(token, TOKEN_PURPOSE_VERIFY_EMAIL); err != ErrTokenPurpose {
+		t.Errorf("Parse wrong purpose = %v", err)
+	}
+	parts := strings.Split(token, ".")
+	tampered := parts[0] + "." + parts[1] + "x." + parts[2]
+	if _, err := s.Parse(tampered, TOKEN_PURPOSE_RESET_PASSWORD); err != ErrTokenInvalid {
+		t.Errorf("Parse tampered = %v", err)
+	}
+}
+
+func TestTokenExpired(t *testing.T) {
+	s := testSigner(t, "k1")
+	token, _ := s.Issue("1001", TOKEN_PURPOSE_VERIFY_EMAIL, -time.Second, "")
+	if _, err := s.Parse(token, TOKEN_PURPOSE_VERIFY_EMAIL); err != ErrTokenExpired {
+		t.Errorf("Parse expired = %v", err)
+	}
+}
+
+func TestTokenKeyRotation(t *testing.T) {
+	old := testSigner(t, "k1")
+	token, _ := old.Issue("1001", TOKEN_PURPOSE_VERIFY_EMAIL, time.Hour, "")
+
+	// 切换到新密钥后，旧令牌仍然可以校验
+	rotated := testSigner(t, "k2")
+	if _, err := rotated.Parse(token, TOKEN_PURPOSE_VERIFY_EMAIL); err != nil {
+		t.Errorf("Parse after rotation = %v", err)
+	}
+
+	// 旧密钥移除后，旧令牌失效
+	removed, err := NewTokenSigner("k2", map[string][]byte{"k2": []byte("fedcba9876543210fedcba9876543210")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := removed.Parse(token, TOKEN_PURPOSE_VERIFY_EMAIL); err != ErrTokenUnknownKey {
+		t.Errorf("Parse with removed key = %v", err)
+	}
+
+	if _, err := NewTokenSigner("k.1", map[string][]byte{"k.1": []byte("0123456789abcdef")}); err == nil {
+		t.Error("NewTokenSigner should reject key id containing '.'")
+	}
+}
@@ -0,0 +1,70 @@
+package tokenutils
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	redis "github.com/go-redis/redis/v8"
+	redisv8 "github.com/qiuliaogit/utilsext/redis_utils_v8"
+)
+
+// 已使用令牌按过期时间分桶的大小 单位秒
+const usedTokenBucketSeconds = 3600
+
+/*
+基于Redis的令牌一次性使用记录
+
+已使用的令牌ID按过期时间分桶保存在 RedisSetUtils 中，
+每个桶的超时时间为桶内最晚的过期时间，令牌过期后记录随桶一起被删除。
+*/
+type TokenUsedStore struct {
+	cli       *redis.Client
+	keyPrefix string
+}
+
+/*
+创建一个令牌使用记录
+
+  - paramCli
+  - paramKeyPrefix redis key 前缀
+*/
+func CreateTokenUsedStore(paramCli *redis.Client, paramKeyPrefix string) *TokenUsedStore {
+	return &TokenUsedStore{
+		cli:       paramCli,
+		keyPrefix: paramKeyPrefix,
+	}
+}
+
+// 取令牌所在桶的集合
+func (s *TokenUsedStore) bucket(paramClaims *TokenClaims) *redisv8.RedisSetUtils {
+	bucket := paramClaims.ExpiresAt / usedTokenBucketSeconds
+	bucketEnd := (bucket + 1) * usedTokenBucketSeconds
+	expire := bucketEnd - time.Now().Unix() + 1
+	if expire < 1 {
+		expire = 1
+	}
+	key := s.keyPrefix + paramClaims.Purpose + ":" + strconv.FormatInt(bucket, 10)
+	return redisv8.CreateSetUtils(s.cli, key, int32(expire), true)
+}
+
+/*
+标记令牌为已使用，已经使用过时返回 ErrTokenUsed
+
+  - paramClaims TokenSigner.Parse 返回的数据
+*/
+func (s *TokenUsedStore) Consume(ctx context.Context, paramClaims *TokenClaims) error {
+	added, err := s.bucket(paramClaims).Add(ctx, paramClaims.ID)
+	if err != nil {
+		return err
+	}
+	if added == 0 {
+		return ErrTokenUsed
+	}
+	return nil
+}
+
+// 判断令牌是否已经使用过
+func (s *TokenUsedStore) IsUsed(ctx context.Context, paramClaims *TokenClaims) (bool, error) {
+	return s.bucket(paramClaims).Has(ctx, paramClaims.ID)
+}
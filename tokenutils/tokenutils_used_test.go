@@ -0,0 +1,51 @@
+package tokenutils
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	redis "github.com/go-redis/redis/v8"
+)
+
+func TestTokenUsedStoreConsume(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cli := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer cli.Close()
+	ctx := context.Background()
+	store := CreateTokenUsedStore(cli, "token:used:")
+
+	claims := &TokenClaims{ID: "t1", Purpose: "reset", ExpiresAt: time.Now().Add(10 * time.Minute).Unix()}
+	if used, err := store.IsUsed(ctx, claims); err != nil || used {
+		t.Fatalf("IsUsed before Consume = %v, %v", used, err)
+	}
+	if err := store.Consume(ctx, claims); err != nil {
+		t.Fatalf("first Consume error: %v", err)
+	}
+	if err := store.Consume(ctx, claims); err != ErrTokenUsed {
+		t.Fatalf("second Consume err = %v", err)
+	}
+	if used, err := store.IsUsed(ctx, claims); err != nil || !used {
+		t.Fatalf("IsUsed after Consume = %v, %v", used, err)
+	}
+	// 用途不同的令牌记录在不同的桶中
+	other := &TokenClaims{ID: "t1", Purpose: "verify", ExpiresAt: claims.ExpiresAt}
+	if used, _ := store.IsUsed(ctx, other); used {
+		t.Error("token used for another purpose")
+	}
+
+	// 桶在桶内最晚的过期时间之后被删除
+	bucket := claims.ExpiresAt / usedTokenBucketSeconds
+	key := "token:used:reset:" + strconv.FormatInt(bucket, 10)
+	ttl := mr.TTL(key)
+	bucketEnd := time.Unix((bucket+1)*usedTokenBucketSeconds, 0)
+	if ttl <= 0 || time.Now().Add(ttl).Before(bucketEnd) {
+		t.Fatalf("bucket ttl = %v, bucket end %v", ttl, bucketEnd)
+	}
+	mr.FastForward(ttl)
+	if mr.Exists(key) {
+		t.Fatal("bucket not expired")
+	}
+}